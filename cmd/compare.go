@@ -1,11 +1,15 @@
 package cmd
 
 import (
+	"bufio"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"os"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/spf13/cobra"
@@ -28,8 +32,28 @@ var (
 	compareBatchSize          int
 	compareDetailed           bool
 	compareOutputFile         string
+	compareGridFSHash         string
+	compareMethod             string
+	compareHashRanges         int
+	compareDiffOutput         string
+	compareDiffLimit          int
+	compareIgnoreFields       []string
+	compareEquality           string
+	compareDateTolerance      time.Duration
+	compareFloatTolerance     float64
+	compareKeyOrder           bool
+	compareSample             int
+	compareSamplePercent      float64
+
+	// compareDiffs writes the differing documents to --diff-output during a comparison
+	compareDiffs *mongodb.DiffWriter
+	// compareIgnoreRules are the parsed --ignore-fields
+	compareIgnoreRules []mongodb.IgnoreRule
 )
 
+// compareMethods lists the values of --method
+var compareMethods = []string{mongodb.CompareMethodLookup, mongodb.CompareMethodMerge, mongodb.CompareMethodHash}
+
 // compareCmd represents the compare command
 var compareCmd = &cobra.Command{
 	Use:   "compare",
@@ -43,6 +67,12 @@ or exclude specific databases/collections using --exclude-databases and --exclud
 Examples:
   nmongo compare --source "mongodb://source-host:27017" --target "mongodb://target-host:27017"
   nmongo compare --source "mongodb://source-host:27017" --target "mongodb://target-host:27017" --detailed
+  nmongo compare --source "mongodb://source-host:27017" --target "mongodb://target-host:27017" --detailed --method merge
+  nmongo compare --source "mongodb://source-host:27017" --target "mongodb://target-host:27017" --detailed --method hash
+  nmongo compare --source "mongodb://source-host:27017" --target "mongodb://target-host:27017" --detailed --diff-output "diff.ndjson"
+  nmongo compare --source "mongodb://source-host:27017" --target "mongodb://target-host:27017" --detailed --ignore-fields "updatedAt"
+  nmongo compare --source "mongodb://source-host:27017" --target "mongodb://target-host:27017" --detailed --equality strict
+  nmongo compare --source "mongodb://source-host:27017" --target "mongodb://target-host:27017" --sample 1000
   nmongo compare --source "mongodb://source-host:27017" --target "mongodb://target-host:27017" --databases "mydb"
   nmongo compare --source "mongodb://source-host:27017" --target "mongodb://target-host:27017" --output "comparison.json"`,
 	Run: func(cmd *cobra.Command, args []string) {
@@ -147,6 +177,34 @@ func init() {
 		"Perform detailed document-by-document comparison")
 	compareCmd.Flags().StringVar(&compareOutputFile, "output", "",
 		"Write comparison results to specified JSON file")
+	compareCmd.Flags().StringVar(&compareGridFSHash, "gridfs-hash", "sha256",
+		"Digest used to compare GridFS files in detailed mode (md5 or sha256)")
+	compareCmd.Flags().StringVar(&compareMethod, "method", mongodb.CompareMethodLookup,
+		"How detailed mode compares documents: 'lookup' looks up every source document on the target, "+
+			"'merge' walks both collections in _id order and also finds documents only in the target, "+
+			"'hash' compares hashes of _id ranges and only merges the documents of ranges that differ (MongoDB 7.0+)")
+	compareCmd.Flags().IntVar(&compareHashRanges, "hash-ranges", 64,
+		"Number of _id ranges the hash method splits each collection and each differing range into")
+	compareCmd.Flags().StringVar(&compareDiffOutput, "diff-output", "",
+		"Write every differing, missing and extra document with its differing fields to this NDJSON file (requires --detailed)")
+	compareCmd.Flags().IntVar(&compareDiffLimit, "diff-limit", 1000,
+		"Maximum number of documents written to --diff-output per collection, 0 for no limit")
+	compareCmd.Flags().StringSliceVar(&compareIgnoreFields, "ignore-fields", []string{},
+		"Fields left out of detailed comparisons, as dotted paths with * wildcards, optionally prefixed by a "+
+			"database.collection pattern and a colon (e.g. updatedAt,shop.orders:audit.*)")
+	compareCmd.Flags().StringVar(&compareEquality, "equality", mongodb.EqualitySemantic,
+		"How detailed mode compares values: 'semantic' compares numbers by value across types, "+
+			"'strict' requires the same BSON types and field order")
+	compareCmd.Flags().DurationVar(&compareDateTolerance, "date-tolerance", 0,
+		"Largest difference between dates that are still equal in semantic mode (e.g. 1ms, 2s)")
+	compareCmd.Flags().Float64Var(&compareFloatTolerance, "float-tolerance", 0,
+		"Largest absolute difference between numbers that are still equal in semantic mode")
+	compareCmd.Flags().IntVar(&compareSample, "sample", 0,
+		"Only compare this many random documents of each collection and estimate the share that differs (implies --detailed)")
+	compareCmd.Flags().Float64Var(&compareSamplePercent, "sample-percent", 0,
+		"Only compare this percentage of random documents of each collection, like --sample (implies --detailed)")
+	compareCmd.Flags().BoolVar(&compareKeyOrder, "key-order", false,
+		"Also require the fields of documents to be in the same order in semantic mode, as strict mode does")
 
 	// Mark required flags
 	compareCmd.MarkFlagRequired("source")
@@ -156,13 +214,37 @@ func init() {
 // runCompare executes the compare command
 // This has been refactored to reduce cyclomatic complexity
 func runCompare() error {
+	if err := checkCompareOptions(); err != nil {
+		return err
+	}
 	// Log comparison configuration
 	logCompareConfiguration()
 
+	// Compare the databases and collect the results
+	allResults, err := compareClusters()
+	if err != nil {
+		return err
+	}
+
+	hasDifferences, err := reportResults(allResults)
+	if err != nil {
+		return err
+	}
+
+	if hasDifferences {
+		return fmt.Errorf("differences found between source and target databases")
+	}
+
+	fmt.Println("MongoDB comparison completed successfully")
+	return nil
+}
+
+// compareClusters connects to the source and target and compares their databases
+func compareClusters() ([]*mongodb.ComparisonResult, error) {
 	// Connect to source and target MongoDB
 	sourceClient, targetClient, err := connectToMongoDB()
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer func() {
 		ctx := context.Background()
@@ -170,37 +252,161 @@ func runCompare() error {
 		targetClient.Disconnect(ctx)
 	}()
 
-	// Get list of databases to compare
 	ctx := context.Background()
+	if err := mongodb.CheckCompareMethod(ctx, sourceClient, targetClient, compareMethod); err != nil {
+		return nil, err
+	}
+
+	// Get list of databases to compare
 	dbsToCompare, err := getDatabases(ctx, sourceClient)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	// Compare databases and collect results
+	closeDiffs, err := openDiffOutput()
+	if err != nil {
+		return nil, err
+	}
 	allResults, err := compareAllDatabases(ctx, sourceClient, targetClient, dbsToCompare)
+	return allResults, errors.Join(err, closeDiffs())
+}
+
+// openDiffOutput creates the --diff-output file for the differing documents, if one is specified.
+// The returned function flushes and closes it.
+func openDiffOutput() (func() error, error) {
+	if compareDiffOutput == "" {
+		return func() error { return nil }, nil
+	}
+
+	file, err := os.Create(compareDiffOutput)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create diff output file: %w", err)
+	}
+	buffered := bufio.NewWriter(file)
+	compareDiffs = mongodb.NewDiffWriter(buffered, compareDiffLimit)
+
+	return func() error {
+		fmt.Printf("Wrote %d differing documents to %s\n", compareDiffs.Written(), compareDiffOutput)
+		compareDiffs = nil
+		if err := errors.Join(buffered.Flush(), file.Close()); err != nil {
+			return fmt.Errorf("failed to write diff output file: %w", err)
+		}
+		return nil
+	}, nil
+}
+
+// checkCompareOptions validates the sample, the comparison method, the diff output, the ignored fields and the equality
+func checkCompareOptions() error {
+	for _, check := range []func() error{checkSample, checkCompareMethod, checkDiffOutput, checkIgnoreFields, checkEquality} {
+		if err := check(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkIgnoreFields parses the ignored fields; only detailed comparisons compare the fields of documents
+func checkIgnoreFields() error {
+	if len(compareIgnoreFields) > 0 && !compareDetailed {
+		return fmt.Errorf("--ignore-fields requires --detailed")
+	}
+	rules, err := mongodb.ParseIgnoreRules(compareIgnoreFields)
 	if err != nil {
+		return fmt.Errorf("invalid --ignore-fields: %w", err)
+	}
+	compareIgnoreRules = rules
+	return nil
+}
+
+// checkSample validates the sample options. Sampling compares documents, so it turns on detailed mode, and it
+// replaces the comparison method of regular collections.
+func checkSample() error {
+	if compareSample > 0 && compareSamplePercent > 0 {
+		return fmt.Errorf("--sample and --sample-percent cannot be combined")
+	}
+	if sampling() && compareMethod != mongodb.CompareMethodLookup {
+		return fmt.Errorf("--sample and --sample-percent cannot be combined with --method %s", compareMethod)
+	}
+	if err := checkSampleSize(); err != nil {
 		return err
 	}
+	compareDetailed = compareDetailed || sampling()
+	return nil
+}
 
-	// Summarize the comparison results
-	hasDifferences := summarizeResults(allResults)
+// checkSampleSize validates the sample size and percentage
+func checkSampleSize() error {
+	if compareSample < 0 {
+		return fmt.Errorf("--sample must not be negative, got %d", compareSample)
+	}
+	if compareSamplePercent < 0 || compareSamplePercent > 100 {
+		return fmt.Errorf("--sample-percent must be between 0 and 100, got %g", compareSamplePercent)
+	}
+	return nil
+}
 
-	// Write results to output file if specified
-	if compareOutputFile != "" {
-		if err := writeResultsToFile(allResults, compareOutputFile); err != nil {
-			return fmt.Errorf("failed to write results to file: %w", err)
-		}
+// sampling reports whether a sample of each collection is compared
+func sampling() bool {
+	return compareSample > 0 || compareSamplePercent > 0
+}
+
+// checkEquality validates the equality mode and its options; tolerances and key order only apply to semantic mode
+func checkEquality() error {
+	if compareEquality != mongodb.EqualitySemantic && compareEquality != mongodb.EqualityStrict {
+		return fmt.Errorf("unknown equality %q, expected %s or %s", compareEquality, mongodb.EqualitySemantic, mongodb.EqualityStrict)
+	}
+	if compareDateTolerance < 0 || compareFloatTolerance < 0 {
+		return fmt.Errorf("--date-tolerance and --float-tolerance must not be negative")
 	}
+	if compareEquality == mongodb.EqualityStrict && semanticOptionsSet() {
+		return fmt.Errorf("--date-tolerance, --float-tolerance and --key-order require --equality %s", mongodb.EqualitySemantic)
+	}
+	return nil
+}
 
-	if hasDifferences {
-		return fmt.Errorf("differences found between source and target databases")
+// semanticOptionsSet reports whether an option of the semantic equality is set
+func semanticOptionsSet() bool {
+	return compareDateTolerance != 0 || compareFloatTolerance != 0 || compareKeyOrder
+}
+
+// checkCompareMethod validates the comparison method; the merge and hash methods only apply to detailed comparisons
+func checkCompareMethod() error {
+	if !slices.Contains(compareMethods, compareMethod) {
+		return fmt.Errorf("unknown compare method %q, expected one of %s", compareMethod, strings.Join(compareMethods, ", "))
+	}
+	if compareMethod != mongodb.CompareMethodLookup && !compareDetailed {
+		return fmt.Errorf("--method %s requires --detailed", compareMethod)
 	}
+	if compareMethod == mongodb.CompareMethodHash && compareHashRanges < 2 {
+		return fmt.Errorf("--hash-ranges must be at least 2, got %d", compareHashRanges)
+	}
+	return nil
+}
 
-	fmt.Println("MongoDB comparison completed successfully")
+// checkDiffOutput validates the diff output options; only detailed comparisons find differing documents
+func checkDiffOutput() error {
+	if compareDiffOutput != "" && !compareDetailed {
+		return fmt.Errorf("--diff-output requires --detailed")
+	}
+	if compareDiffLimit < 0 {
+		return fmt.Errorf("--diff-limit must not be negative, got %d", compareDiffLimit)
+	}
 	return nil
 }
 
+// reportResults summarizes the comparison results and writes them to the output file if one is specified.
+// It reports whether differences were found.
+func reportResults(results []*mongodb.ComparisonResult) (bool, error) {
+	hasDifferences := summarizeResults(results)
+
+	if compareOutputFile != "" {
+		if err := writeResultsToFile(results, compareOutputFile); err != nil {
+			return hasDifferences, fmt.Errorf("failed to write results to file: %w", err)
+		}
+	}
+	return hasDifferences, nil
+}
+
 // connectToMongoDB connects to source and target MongoDB clusters
 func connectToMongoDB() (sourceClient, targetClient *mongodb.Client, err error) {
 	ctx := context.Background()
@@ -259,6 +465,13 @@ func logCompareConfiguration() {
 
 	// Log comparison options
 	fmt.Printf("Detailed comparison: %v\n", compareDetailed)
+	if compareDetailed {
+		logMethodConfiguration()
+		if len(compareIgnoreFields) > 0 {
+			fmt.Printf("Ignored fields: %s\n", strings.Join(compareIgnoreFields, ", "))
+		}
+		logEqualityConfiguration()
+	}
 	fmt.Printf("Batch size: %d\n", compareBatchSize)
 	fmt.Printf("Connection timeout: %d seconds (used only for initial connections)\n", compareTimeout)
 	fmt.Println("Note: Longer timeouts are used automatically for data operations")
@@ -272,6 +485,32 @@ func logCompareConfiguration() {
 	}
 }
 
+// logMethodConfiguration logs how a detailed comparison compares the documents of regular collections
+func logMethodConfiguration() {
+	switch {
+	case compareSample > 0:
+		fmt.Printf("Comparing a sample of %d documents per collection\n", compareSample)
+	case compareSamplePercent > 0:
+		fmt.Printf("Comparing a sample of %g%% of the documents of each collection\n", compareSamplePercent)
+	default:
+		fmt.Printf("Comparison method: %s\n", compareMethod)
+	}
+}
+
+// logEqualityConfiguration logs how a detailed comparison compares values
+func logEqualityConfiguration() {
+	fmt.Printf("Equality: %s\n", compareEquality)
+	if compareDateTolerance > 0 {
+		fmt.Printf("Date tolerance: %s\n", compareDateTolerance)
+	}
+	if compareFloatTolerance > 0 {
+		fmt.Printf("Float tolerance: %g\n", compareFloatTolerance)
+	}
+	if compareKeyOrder {
+		fmt.Println("Comparing the order of fields")
+	}
+}
+
 // logCertificateInfo logs certificate information if provided
 func logCertificateInfo() {
 	if compareSourceCACertFile != "" {
@@ -336,8 +575,7 @@ func compareDatabase(ctx context.Context, sourceClient, targetClient *mongodb.Cl
 		dbName,
 		compareCollections,
 		compareExcludeCollections,
-		compareBatchSize,
-		compareDetailed,
+		compareOptions(),
 	)
 	if err != nil {
 		return nil, fmt.Errorf("failed to compare collections: %w", err)
@@ -351,6 +589,27 @@ func compareDatabase(ctx context.Context, sourceClient, targetClient *mongodb.Cl
 	return results, nil
 }
 
+// compareOptions builds the collection comparison options from the command flags
+func compareOptions() mongodb.CompareOptions {
+	return mongodb.CompareOptions{
+		BatchSize:     compareBatchSize,
+		Detailed:      compareDetailed,
+		GridFSHash:    compareGridFSHash,
+		Method:        compareMethod,
+		HashRanges:    compareHashRanges,
+		Diffs:         compareDiffs,
+		IgnoreFields:  compareIgnoreRules,
+		SampleSize:    compareSample,
+		SamplePercent: compareSamplePercent,
+		Equality: mongodb.Equality{
+			Mode:           compareEquality,
+			DateTolerance:  compareDateTolerance,
+			FloatTolerance: compareFloatTolerance,
+			KeyOrder:       compareKeyOrder,
+		},
+	}
+}
+
 // compareCollectionIndexes compares indexes for collections
 func compareCollectionIndexes(
 	ctx context.Context,
@@ -397,6 +656,9 @@ func summarizeResults(results []*mongodb.ComparisonResult) bool {
 	if compareDetailed {
 		displayDetailedStats(stats)
 	}
+	if sampling() {
+		displaySampleEstimates(results)
+	}
 
 	// Display details for collections with differences
 	if stats.collectionsWithDifferences > 0 {
@@ -412,6 +674,7 @@ type comparisonStats struct {
 	totalTarget                int64
 	totalDifferent             int64
 	totalMissingInTarget       int64
+	totalExtraInTarget         int64
 	collectionsWithDifferences int
 }
 
@@ -423,10 +686,10 @@ func calculateComparisonStats(results []*mongodb.ComparisonResult) comparisonSta
 		stats.totalSource += result.SourceCount
 		stats.totalTarget += result.TargetCount
 		stats.totalMissingInTarget += result.MissingInTarget
+		stats.totalExtraInTarget += result.ExtraInTarget
 		stats.totalDifferent += result.DifferentDocuments
 
-		if result.Difference != 0 || result.MissingInTarget > 0 ||
-			result.DifferentDocuments > 0 {
+		if resultHasDifferences(result) {
 			stats.collectionsWithDifferences++
 		}
 	}
@@ -434,29 +697,47 @@ func calculateComparisonStats(results []*mongodb.ComparisonResult) comparisonSta
 	return stats
 }
 
+// resultHasDifferences reports whether a collection differs between source and target
+func resultHasDifferences(result *mongodb.ComparisonResult) bool {
+	return result.Difference != 0 || result.MissingInTarget > 0 || result.ExtraInTarget > 0 ||
+		result.DifferentDocuments > 0
+}
+
 // displayDetailedStats displays detailed comparison statistics
 func displayDetailedStats(stats comparisonStats) {
 	fmt.Printf("Documents missing in target: %d\n", stats.totalMissingInTarget)
+	fmt.Printf("Documents only in target: %d\n", stats.totalExtraInTarget)
 	fmt.Printf("Documents with different content: %d\n", stats.totalDifferent)
 }
 
+// displaySampleEstimates displays the estimated difference rate of each sampled collection
+func displaySampleEstimates(results []*mongodb.ComparisonResult) {
+	fmt.Println("\nEstimated difference rates:")
+	fmt.Println("---------------------------")
+	for _, result := range results {
+		if result.Sample != nil {
+			fmt.Printf("%s.%s: %s\n", result.Database, result.Collection, result.Sample)
+		}
+	}
+}
+
 // displayDifferences displays details for collections with differences
 func displayDifferences(results []*mongodb.ComparisonResult) {
 	fmt.Println("\nCollections with differences:")
 	fmt.Println("-----------------------------")
 
 	for _, result := range results {
-		hasDifference := result.Difference != 0 || result.MissingInTarget > 0 ||
-			result.DifferentDocuments > 0
-
-		if hasDifference {
+		if resultHasDifferences(result) {
 			fmt.Printf("%s.%s:\n", result.Database, result.Collection)
 			fmt.Printf("  Source count: %d, Target count: %d, Difference: %d\n",
 				result.SourceCount, result.TargetCount, result.Difference)
 
 			if compareDetailed {
-				fmt.Printf("  Missing in target: %d, Different content: %d\n",
-					result.MissingInTarget, result.DifferentDocuments)
+				fmt.Printf("  Missing in target: %d, Extra in target: %d, Different content: %d\n",
+					result.MissingInTarget, result.ExtraInTarget, result.DifferentDocuments)
+			}
+			if result.DiffsOmitted > 0 {
+				fmt.Printf("  Differences beyond --diff-limit not written: %d\n", result.DiffsOmitted)
 			}
 		}
 	}
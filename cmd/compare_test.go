@@ -0,0 +1,255 @@
+package cmd
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"nmongo/internal/mongodb"
+)
+
+func TestCheckCompareOptions(t *testing.T) {
+	originalMethod := compareMethod
+	originalDetailed := compareDetailed
+	originalRanges := compareHashRanges
+	originalDiffOutput := compareDiffOutput
+	originalDiffLimit := compareDiffLimit
+	defer func() {
+		compareMethod = originalMethod
+		compareDetailed = originalDetailed
+		compareHashRanges = originalRanges
+		compareDiffOutput = originalDiffOutput
+		compareDiffLimit = originalDiffLimit
+	}()
+
+	tests := []struct {
+		name       string
+		method     string
+		detailed   bool
+		ranges     int
+		diffOutput string
+		diffLimit  int
+		wantErr    string
+	}{
+		{name: "Lookup", method: mongodb.CompareMethodLookup, ranges: 64},
+		{name: "Hash", method: mongodb.CompareMethodHash, detailed: true, ranges: 64},
+		{name: "Hash without detailed", method: mongodb.CompareMethodHash, ranges: 64, wantErr: "requires --detailed"},
+		{name: "Too few ranges", method: mongodb.CompareMethodHash, detailed: true, ranges: 1, wantErr: "at least 2"},
+		{name: "Merge", method: mongodb.CompareMethodMerge, detailed: true, ranges: 1},
+		{name: "Merge without detailed", method: mongodb.CompareMethodMerge, ranges: 64, wantErr: "requires --detailed"},
+		{name: "Unknown method", method: "sample", detailed: true, ranges: 64, wantErr: `unknown compare method "sample"`},
+		{name: "Diff output", method: mongodb.CompareMethodLookup, detailed: true, ranges: 64, diffOutput: "diff.ndjson"},
+		{name: "Diff output without detailed", method: mongodb.CompareMethodLookup, ranges: 64, diffOutput: "diff.ndjson",
+			wantErr: "--diff-output requires --detailed"},
+		{name: "Negative diff limit", method: mongodb.CompareMethodLookup, detailed: true, ranges: 64, diffLimit: -1,
+			wantErr: "must not be negative"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			compareMethod, compareDetailed, compareHashRanges = tt.method, tt.detailed, tt.ranges
+			compareDiffOutput, compareDiffLimit = tt.diffOutput, tt.diffLimit
+			err := checkCompareOptions()
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestCalculateComparisonStats(t *testing.T) {
+	results := []*mongodb.ComparisonResult{
+		{Database: "app", Collection: "users", SourceCount: 10, TargetCount: 10},
+		{Database: "app", Collection: "orders", SourceCount: 5, TargetCount: 6, Difference: -1, ExtraInTarget: 1},
+		{Database: "app", Collection: "events", SourceCount: 3, TargetCount: 3, MissingInTarget: 1, ExtraInTarget: 1},
+	}
+
+	stats := calculateComparisonStats(results)
+	assert.Equal(t, 2, stats.collectionsWithDifferences)
+	assert.Equal(t, int64(2), stats.totalExtraInTarget)
+	assert.Equal(t, int64(1), stats.totalMissingInTarget)
+	assert.False(t, resultHasDifferences(results[0]))
+	assert.True(t, resultHasDifferences(results[2]))
+}
+
+func TestOpenDiffOutput(t *testing.T) {
+	originalDiffOutput := compareDiffOutput
+	defer func() { compareDiffOutput = originalDiffOutput }()
+
+	compareDiffOutput = ""
+	closeDiffs, err := openDiffOutput()
+	require.NoError(t, err)
+	assert.Nil(t, compareDiffs)
+	require.NoError(t, closeDiffs())
+
+	compareDiffOutput = filepath.Join(t.TempDir(), "diff.ndjson")
+	closeDiffs, err = openDiffOutput()
+	require.NoError(t, err)
+	require.NotNil(t, compareDiffs)
+	assert.Same(t, compareDiffs, compareOptions().Diffs)
+	require.NoError(t, closeDiffs())
+	assert.Nil(t, compareDiffs)
+	assert.FileExists(t, compareDiffOutput)
+
+	compareDiffOutput = filepath.Join(t.TempDir(), "missing", "diff.ndjson")
+	_, err = openDiffOutput()
+	assert.ErrorContains(t, err, "failed to create diff output file")
+}
+
+func TestCheckIgnoreFields(t *testing.T) {
+	originalDetailed := compareDetailed
+	originalIgnoreFields := compareIgnoreFields
+	originalRules := compareIgnoreRules
+	defer func() {
+		compareDetailed = originalDetailed
+		compareIgnoreFields = originalIgnoreFields
+		compareIgnoreRules = originalRules
+	}()
+
+	compareDetailed = true
+	compareIgnoreFields = []string{"updatedAt", "shop.orders:audit.*"}
+	require.NoError(t, checkIgnoreFields())
+	assert.Equal(t, []mongodb.IgnoreRule{{Path: "updatedAt"}, {Namespace: "shop.orders", Path: "audit.*"}},
+		compareOptions().IgnoreFields)
+
+	compareIgnoreFields = []string{"_id"}
+	assert.ErrorContains(t, checkIgnoreFields(), "_id cannot be ignored")
+
+	compareDetailed = false
+	compareIgnoreFields = []string{"updatedAt"}
+	assert.ErrorContains(t, checkIgnoreFields(), "--ignore-fields requires --detailed")
+}
+
+func TestCheckEquality(t *testing.T) {
+	originalEquality := compareEquality
+	originalDateTolerance := compareDateTolerance
+	originalFloatTolerance := compareFloatTolerance
+	originalKeyOrder := compareKeyOrder
+	defer func() {
+		compareEquality = originalEquality
+		compareDateTolerance = originalDateTolerance
+		compareFloatTolerance = originalFloatTolerance
+		compareKeyOrder = originalKeyOrder
+	}()
+
+	tests := []struct {
+		name           string
+		equality       string
+		dateTolerance  time.Duration
+		floatTolerance float64
+		keyOrder       bool
+		wantErr        string
+	}{
+		{name: "Semantic", equality: mongodb.EqualitySemantic},
+		{name: "Semantic with options", equality: mongodb.EqualitySemantic, dateTolerance: time.Second, floatTolerance: 0.01,
+			keyOrder: true},
+		{name: "Strict", equality: mongodb.EqualityStrict},
+		{name: "Strict with date tolerance", equality: mongodb.EqualityStrict, dateTolerance: time.Second,
+			wantErr: "require --equality semantic"},
+		{name: "Strict with key order", equality: mongodb.EqualityStrict, keyOrder: true,
+			wantErr: "require --equality semantic"},
+		{name: "Negative tolerance", equality: mongodb.EqualitySemantic, floatTolerance: -1, wantErr: "must not be negative"},
+		{name: "Unknown", equality: "loose", wantErr: `unknown equality "loose"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			compareEquality, compareDateTolerance = tt.equality, tt.dateTolerance
+			compareFloatTolerance, compareKeyOrder = tt.floatTolerance, tt.keyOrder
+			err := checkEquality()
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, mongodb.Equality{Mode: tt.equality, DateTolerance: tt.dateTolerance,
+				FloatTolerance: tt.floatTolerance, KeyOrder: tt.keyOrder}, compareOptions().Equality)
+		})
+	}
+}
+
+func TestCheckCompareOptionsStrictHash(t *testing.T) {
+	originalEquality := compareEquality
+	originalMethod := compareMethod
+	originalDetailed := compareDetailed
+	defer func() {
+		compareEquality = originalEquality
+		compareMethod = originalMethod
+		compareDetailed = originalDetailed
+	}()
+
+	// The hash digests keep number types, so strict mode applies to the hash method
+	compareEquality, compareMethod, compareDetailed = mongodb.EqualityStrict, mongodb.CompareMethodHash, true
+	require.NoError(t, checkCompareOptions())
+	assert.Equal(t, mongodb.EqualityStrict, compareOptions().Equality.Mode)
+	assert.Equal(t, mongodb.CompareMethodHash, compareOptions().Method)
+}
+
+func TestCheckSample(t *testing.T) {
+	originalSample := compareSample
+	originalSamplePercent := compareSamplePercent
+	originalMethod := compareMethod
+	originalDetailed := compareDetailed
+	defer func() {
+		compareSample = originalSample
+		compareSamplePercent = originalSamplePercent
+		compareMethod = originalMethod
+		compareDetailed = originalDetailed
+	}()
+
+	tests := []struct {
+		name          string
+		sample        int
+		samplePercent float64
+		method        string
+		wantDetailed  bool
+		wantErr       string
+	}{
+		{name: "No sample", method: mongodb.CompareMethodLookup},
+		{name: "Sample", sample: 1000, method: mongodb.CompareMethodLookup, wantDetailed: true},
+		{name: "Sample percent", samplePercent: 2.5, method: mongodb.CompareMethodLookup, wantDetailed: true},
+		{name: "Both", sample: 1000, samplePercent: 2.5, method: mongodb.CompareMethodLookup, wantErr: "cannot be combined"},
+		{name: "Sample with merge", sample: 1000, method: mongodb.CompareMethodMerge, wantErr: "cannot be combined with --method merge"},
+		{name: "Negative sample", sample: -1, method: mongodb.CompareMethodLookup, wantErr: "must not be negative"},
+		{name: "Percent above 100", samplePercent: 101, method: mongodb.CompareMethodLookup, wantErr: "between 0 and 100"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			compareSample, compareSamplePercent, compareMethod, compareDetailed = tt.sample, tt.samplePercent, tt.method, false
+			err := checkSample()
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			// Sampling compares documents, which turns on detailed mode
+			assert.Equal(t, tt.wantDetailed, compareDetailed)
+			assert.Equal(t, tt.sample, compareOptions().SampleSize)
+			assert.Equal(t, tt.samplePercent, compareOptions().SamplePercent)
+		})
+	}
+}
+
+func TestDisplaySampleEstimates(t *testing.T) {
+	oldStdout := os.Stdout
+	r, w, err := os.Pipe()
+	require.NoError(t, err)
+	os.Stdout = w
+
+	displaySampleEstimates([]*mongodb.ComparisonResult{
+		{Database: "app", Collection: "users", Sample: &mongodb.SampleEstimate{Size: 100, Confidence: 0.95, Upper: 0.0366}},
+		{Database: "app", Collection: "fs.files", GridFS: true},
+	})
+
+	w.Close()
+	os.Stdout = oldStdout
+	out, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Contains(t, string(out), "app.users: 0.00% of 100 sampled documents differ, 95% confidence interval 0.00% to 3.66%")
+	assert.NotContains(t, string(out), "fs.files")
+}
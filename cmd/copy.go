@@ -7,26 +7,34 @@ import (
 	"time"
 
 	"github.com/spf13/cobra"
+	"go.mongodb.org/mongo-driver/mongo"
 
 	"nmongo/internal/config"
 	"nmongo/internal/mongodb"
 )
 
 var (
-	sourceURI          string
-	targetURI          string
-	sourceCACertFile   string
-	targetCACertFile   string
-	incremental        bool
-	timeout            int
-	socketTimeout      int
-	databases          []string
-	collections        []string
-	excludeDatabases   []string
-	excludeCollections []string
-	batchSize          int
-	lastModifiedField  string
-	retryAttempts      int
+	sourceURI           string
+	targetURI           string
+	sourceCACertFile    string
+	targetCACertFile    string
+	incremental         bool
+	timeout             int
+	socketTimeout       int
+	databases           []string
+	collections         []string
+	excludeDatabases    []string
+	excludeCollections  []string
+	batchSize           int
+	lastModifiedField   string
+	retryAttempts       int
+	shardTarget         bool
+	hashedInitialChunks int
+	presplitHashed      bool
+	readFromShards      bool
+	shardUsername       string
+	shardPassword       string
+	consistent          bool
 )
 
 // copyCmd represents the copy command
@@ -152,6 +160,19 @@ func init() {
 	copyCmd.Flags().StringVar(&lastModifiedField, "last-modified-field", "lastModified",
 		"Field name to use for tracking document modifications in incremental copy")
 	copyCmd.Flags().IntVar(&retryAttempts, "retry-attempts", 5, "Number of retry attempts for failed operations")
+	copyCmd.Flags().BoolVar(&shardTarget, "shard-target", true,
+		"Shard target collections with the shard key of sharded source collections (target must be a mongos)")
+	copyCmd.Flags().IntVar(&hashedInitialChunks, "hashed-initial-chunks", 0,
+		"Number of chunks to pre-create for hashed shard keys on the target (0 leaves it to the server)")
+	copyCmd.Flags().BoolVar(&presplitHashed, "presplit-hashed", false,
+		"Pre-split hashed target collections at the chunk boundaries of the source before loading data")
+	copyCmd.Flags().BoolVar(&readFromShards, "read-from-shards", false,
+		"Read sharded source collections directly from each shard in parallel instead of through mongos")
+	copyCmd.Flags().StringVar(&shardUsername, "shard-username", "",
+		"User for the direct shard connections of --read-from-shards (default: the user of the source URI)")
+	copyCmd.Flags().StringVar(&shardPassword, "shard-password", "", "Password of --shard-username")
+	copyCmd.Flags().BoolVar(&consistent, "consistent", false,
+		"Copy all collections as of a single point in time using snapshot reads (requires a replica set or sharded cluster)")
 
 	// Mark required flags
 	copyCmd.MarkFlagRequired("source")
@@ -188,17 +209,96 @@ func runCopy() error {
 		return err
 	}
 
-	// Copy each database
+	// Prepare shard connections or a snapshot reader if requested
+	sources, err := prepareCopySources(ctx, sourceClient)
+	if err != nil {
+		return err
+	}
+	defer mongodb.DisconnectShards(ctx, sources.shardClients)
+
+	if err := copyDatabases(ctx, sourceClient, targetClient, sources, dbsToCopy); err != nil {
+		return err
+	}
+
+	if err := catchUpSnapshot(ctx, sources, targetClient); err != nil {
+		return err
+	}
+
+	fmt.Println("MongoDB copy operation completed successfully")
+	return nil
+}
+
+// copyDatabases copies each database in turn
+func copyDatabases(ctx context.Context, sourceClient, targetClient *mongodb.Client, sources copySources, dbsToCopy []string) error {
 	for _, dbName := range dbsToCopy {
-		if err := copyDatabase(ctx, sourceClient, targetClient, dbName); err != nil {
+		if err := copyDatabase(ctx, sourceClient, targetClient, sources, dbName); err != nil {
 			return fmt.Errorf("failed to copy database %s: %w", dbName, err)
 		}
 	}
+	return nil
+}
 
-	fmt.Println("MongoDB copy operation completed successfully")
+// catchUpSnapshot brings all collections to a common point in time if a snapshot was not available
+func catchUpSnapshot(ctx context.Context, sources copySources, targetClient *mongodb.Client) error {
+	if sources.snapshot == nil {
+		return nil
+	}
+	if err := sources.snapshot.CatchUp(ctx, targetClient); err != nil {
+		return fmt.Errorf("failed to catch up from change stream: %w", err)
+	}
 	return nil
 }
 
+// copySources holds the optional ways of reading the source besides the main connection
+type copySources struct {
+	// shardClients are direct connections to the source shards, keyed by shard ID
+	shardClients map[string]*mongodb.Client
+	// snapshot reads all collections at a single cluster time
+	snapshot *mongodb.SnapshotCopier
+}
+
+// prepareCopySources connects to the source shards or records the snapshot cluster time, depending on the flags
+func prepareCopySources(ctx context.Context, sourceClient *mongodb.Client) (copySources, error) {
+	var sources copySources
+	if consistent && readFromShards {
+		return sources, fmt.Errorf("--consistent cannot be combined with --read-from-shards")
+	}
+
+	connCtx, connCancel := context.WithTimeout(ctx, time.Duration(timeout)*time.Second)
+	defer connCancel()
+
+	var err error
+	if readFromShards {
+		credentials := mongodb.ShardCredentials{Username: shardUsername, Password: shardPassword}
+		sources.shardClients, err = mongodb.ConnectToShards(connCtx, sourceClient, socketTimeout, credentials)
+		if err != nil {
+			return sources, shardConnectionError(err)
+		}
+		fmt.Printf("Connected to %d source shards\n", len(sources.shardClients))
+	}
+
+	if consistent {
+		sources.snapshot, err = mongodb.NewSnapshotCopier(connCtx, sourceClient, batchSize, retryAttempts)
+		if err != nil {
+			return sources, fmt.Errorf("failed to start consistent copy: %w", err)
+		}
+		clusterTime := sources.snapshot.ClusterTime()
+		fmt.Printf("Copying all collections as of cluster time %d.%d\n", clusterTime.T, clusterTime.I)
+	}
+
+	return sources, nil
+}
+
+// shardConnectionError explains a failed shard connection. Users created through mongos are stored on the config
+// servers, and the shard replica sets reject them unless the same users were created on every shard.
+func shardConnectionError(err error) error {
+	if shardUsername != "" {
+		return fmt.Errorf("failed to connect to source shards with --shard-username %s: %w", shardUsername, err)
+	}
+	return fmt.Errorf("failed to connect to source shards: %w; shards do not know the users created through mongos, "+
+		"create a user on every shard and pass it with --shard-username and --shard-password", err)
+}
+
 // logCopyConfiguration logs the configuration parameters for the copy operation
 func logCopyConfiguration() {
 	// Log basic configuration
@@ -227,6 +327,12 @@ func logBasicConfig() {
 	fmt.Printf("Connection timeout: %d seconds (used only for initial connections)\n", timeout)
 	fmt.Printf("Socket timeout: %d seconds (used for data operations)\n", socketTimeout)
 	fmt.Printf("Retry attempts: %d\n", retryAttempts)
+	fmt.Printf("Shard target collections: %v\n", shardTarget)
+	fmt.Printf("Read from shards: %v\n", readFromShards)
+	if shardUsername != "" {
+		fmt.Printf("Shard username: %s\n", shardUsername)
+	}
+	fmt.Printf("Consistent snapshot: %v\n", consistent)
 }
 
 // logIncrementalConfig logs the incremental copy configuration
@@ -303,13 +409,74 @@ func filterByExclusionList(items, exclusionList []string) []string {
 }
 
 // copyDatabase copies a single database from source to target
-func copyDatabase(ctx context.Context, sourceClient, targetClient *mongodb.Client, dbName string) error {
+func copyDatabase(ctx context.Context, sourceClient, targetClient *mongodb.Client, sources copySources, dbName string) error {
 	fmt.Printf("Copying database: %s\n", dbName)
-	// Get the database
 	sourceDB := sourceClient.GetDatabase(dbName)
-	targetDB := targetClient.GetDatabase(dbName)
 
 	// Get collections to copy
+	collsToCopy, err := getCollectionsToCopy(ctx, sourceClient, dbName)
+	if err != nil {
+		return err
+	}
+
+	// Copy GridFS buckets file by file, keeping files and chunks consistent.
+	// A consistent copy reads files and chunks at the same cluster time, so buckets are copied like any collection.
+	if sources.snapshot == nil {
+		if collsToCopy, err = copyGridFSBuckets(ctx, sourceClient, targetClient, sources, dbName, collsToCopy); err != nil {
+			return err
+		}
+	}
+
+	// Time-series collections are recreated with their options and copied with plain inserts
+	timeSeries, err := mongodb.GetTimeSeriesSpecs(ctx, sourceDB)
+	if err != nil {
+		return err
+	}
+
+	// Copy each collection
+	fmt.Printf("  Copying %d collections in database %s\n", len(collsToCopy), dbName)
+	for _, collName := range collsToCopy {
+		fmt.Printf("    Copying collection: %s.%s\n", dbName, collName)
+		if err := copyDatabaseCollection(ctx, sourceClient, targetClient, sources, timeSeries[collName], dbName, collName); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyGridFSBuckets copies the GridFS buckets among the collections and returns the remaining collections.
+// The files and chunks collections are sharded like the source first. Reading from the shards cannot keep the files
+// and chunks of a bucket consistent, so buckets are refused with --read-from-shards.
+func copyGridFSBuckets(ctx context.Context, sourceClient, targetClient *mongodb.Client, sources copySources,
+	dbName string, collsToCopy []string) ([]string, error) {
+	buckets, others := mongodb.SplitGridFSBuckets(collsToCopy)
+	if len(buckets) > 0 && len(sources.shardClients) > 0 {
+		return nil, fmt.Errorf("--read-from-shards cannot copy GridFS bucket %s.%s, exclude its collections with --exclude-collections",
+			dbName, buckets[0])
+	}
+
+	for _, bucket := range buckets {
+		fmt.Printf("    Copying GridFS bucket: %s.%s\n", dbName, bucket)
+		if err := copyGridFSBucket(ctx, sourceClient, targetClient, dbName, bucket); err != nil {
+			return nil, fmt.Errorf("failed to copy GridFS bucket %s.%s: %w", dbName, bucket, err)
+		}
+	}
+	return others, nil
+}
+
+// copyGridFSBucket shards the files and chunks collections of a bucket like the source and copies the bucket
+func copyGridFSBucket(ctx context.Context, sourceClient, targetClient *mongodb.Client, dbName, bucket string) error {
+	for _, collName := range []string{bucket + ".files", bucket + ".chunks"} {
+		if err := replicateSharding(ctx, sourceClient, targetClient, dbName, collName); err != nil {
+			return fmt.Errorf("failed to shard collection %s.%s: %w", dbName, collName, err)
+		}
+	}
+	return mongodb.CopyGridFSBucket(ctx, sourceClient.GetDatabase(dbName), targetClient.GetDatabase(dbName), bucket,
+		incremental, batchSize, retryAttempts)
+}
+
+// getCollectionsToCopy returns the collections of a database to copy after applying the collection filters
+func getCollectionsToCopy(ctx context.Context, sourceClient *mongodb.Client, dbName string) ([]string, error) {
 	var collsToCopy []string
 	if len(collections) > 0 {
 		collsToCopy = collections
@@ -318,7 +485,7 @@ func copyDatabase(ctx context.Context, sourceClient, targetClient *mongodb.Clien
 		var err error
 		collsToCopy, err = sourceClient.ListCollections(ctx, dbName)
 		if err != nil {
-			return fmt.Errorf("failed to get collections for database %s: %w", dbName, err)
+			return nil, fmt.Errorf("failed to get collections for database %s: %w", dbName, err)
 		}
 		fmt.Printf("  Found %d collections in database %s\n", len(collsToCopy), dbName)
 	}
@@ -332,15 +499,59 @@ func copyDatabase(ctx context.Context, sourceClient, targetClient *mongodb.Clien
 		}
 	}
 
-	// Copy each collection
-	fmt.Printf("  Copying %d collections in database %s\n", len(collsToCopy), dbName)
-	for _, collName := range collsToCopy {
-		fmt.Printf("    Copying collection: %s.%s\n", dbName, collName)
-		err := mongodb.CopyCollection(ctx, sourceDB, targetDB, collName,
-			incremental, batchSize, lastModifiedField, retryAttempts)
+	return collsToCopy, nil
+}
+
+// copyDatabaseCollection copies a collection of a database, recreating time-series and sharding settings on the target.
+// Time-series collections do not support snapshot reads and are always copied from the current data.
+func copyDatabaseCollection(ctx context.Context, sourceClient, targetClient *mongodb.Client, sources copySources,
+	timeSeries *mongodb.TimeSeriesSpec, dbName, collName string) error {
+	sourceDB := sourceClient.GetDatabase(dbName)
+	targetDB := targetClient.GetDatabase(dbName)
+
+	if timeSeries != nil {
+		if sources.snapshot != nil {
+			fmt.Printf("    Warning: Time-series collection %s.%s is not copied at the snapshot cluster time\n", dbName, collName)
+		}
+		err := mongodb.CopyTimeSeriesCollection(ctx, sourceDB, targetDB, collName, timeSeries, incremental, batchSize, retryAttempts)
 		if err != nil {
-			return fmt.Errorf("failed to copy collection %s.%s: %w", dbName, collName, err)
+			return fmt.Errorf("failed to copy time-series collection %s.%s: %w", dbName, collName, err)
 		}
+		return nil
+	}
+
+	if err := replicateSharding(ctx, sourceClient, targetClient, dbName, collName); err != nil {
+		return fmt.Errorf("failed to shard collection %s.%s: %w", dbName, collName, err)
+	}
+	if err := copyCollection(ctx, sourceDB, targetDB, sources, collName); err != nil {
+		return fmt.Errorf("failed to copy collection %s.%s: %w", dbName, collName, err)
 	}
 	return nil
 }
+
+// copyCollection copies a single collection, reading at the snapshot cluster time in consistent mode
+// and reading sharded collections from the shards when connected to them
+func copyCollection(ctx context.Context, sourceDB, targetDB *mongo.Database, sources copySources, collName string) error {
+	if sources.snapshot != nil {
+		return sources.snapshot.CopyCollection(ctx, sourceDB, targetDB, collName, incremental, lastModifiedField)
+	}
+	if len(sources.shardClients) > 0 {
+		return mongodb.CopyCollectionFromShards(ctx, sourceDB, targetDB, sources.shardClients, collName,
+			incremental, batchSize, lastModifiedField, retryAttempts)
+	}
+	return mongodb.CopyCollection(ctx, sourceDB, targetDB, collName, incremental, batchSize, lastModifiedField, retryAttempts)
+}
+
+// replicateSharding shards the target collection like the source collection when enabled
+func replicateSharding(ctx context.Context, sourceClient, targetClient *mongodb.Client, dbName, collName string) error {
+	if !shardTarget {
+		return nil
+	}
+
+	opts := mongodb.ShardingOptions{
+		HashedInitialChunks: hashedInitialChunks,
+		PresplitHashed:      presplitHashed,
+	}
+	return mongodb.ReplicateSharding(ctx, sourceClient.GetDatabase(dbName).Client(), targetClient.GetDatabase(dbName).Client(),
+		dbName, collName, opts)
+}
@@ -3,18 +3,22 @@ package cmd
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io/fs"
 	"log"
 	"os"
 	"os/exec"
-	"path/filepath"
 	"time"
 
 	"github.com/spf13/cobra"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 
 	"nmongo/internal/config"
+	"nmongo/internal/dump"
 	"nmongo/internal/mongodb"
+	"nmongo/internal/storage"
 )
 
 var (
@@ -30,6 +34,20 @@ var (
 	dumpLastModifiedField  string
 	dumpRetryAttempts      int
 	dumpStateFile          string
+	dumpEngine             string
+	dumpBatchSize          int
+	dumpCompress           string
+	dumpEncryptKeyFile     string
+	dumpCaptureChanges     bool
+	// dumpKeyring holds the keys dump files are encrypted with, nil when encryption is off
+	dumpKeyring *dump.Keyring
+)
+
+// Engines that can create and restore dumps
+const (
+	engineMongodump    = "mongodump"
+	engineMongorestore = "mongorestore"
+	engineNative       = "native"
 )
 
 var dumpCmd = &cobra.Command{
@@ -37,6 +55,7 @@ var dumpCmd = &cobra.Command{
 	Short: "Create incremental dumps of MongoDB databases",
 	Long: `Create incremental dumps of MongoDB databases using the mongodump CLI tool.
 Supports incremental dumping by tracking the last dump timestamp for each collection.
+Every run is written to its own segment directory, so incremental dumps form a chain on top of a full dump.
 
 Examples:
   nmongo dump --source "mongodb://host:27017" --output ./dumps --incremental
@@ -79,6 +98,9 @@ Examples:
 			if !cmd.Flags().Changed("retry-attempts") && cfg.RetryAttempts > 0 {
 				dumpRetryAttempts = cfg.RetryAttempts
 			}
+			if !cmd.Flags().Changed("batch-size") && cfg.BatchSize > 0 {
+				dumpBatchSize = cfg.BatchSize
+			}
 		}
 
 		if saveConfig {
@@ -98,6 +120,7 @@ Examples:
 				ExcludeCollections: dumpExcludeCollections,
 				LastModifiedField:  dumpLastModifiedField,
 				RetryAttempts:      dumpRetryAttempts,
+				BatchSize:          dumpBatchSize,
 			}
 
 			if err := config.SaveConfig(cfg, configPath); err != nil {
@@ -130,25 +153,46 @@ func init() {
 	dumpCmd.Flags().IntVar(&dumpRetryAttempts, "retry-attempts", 5, "Number of retry attempts for failed operations")
 	dumpCmd.Flags().StringVar(&dumpStateFile, "state-file", "",
 		"Path to state file for tracking dump progress (defaults to <output>/dump-state.json)")
+	dumpCmd.Flags().IntVar(&dumpBatchSize, "batch-size", 10000, "Batch size for reading documents with the native engine")
+	dumpCmd.Flags().StringVar(&dumpCompress, "compress", dump.CompressionNone,
+		"Compress dump files: 'none', 'gzip' or 'zstd' (zstd requires the native engine)")
+	dumpCmd.Flags().StringVar(&dumpEncryptKeyFile, "encrypt-key-file", "",
+		"File with the keys dump files are encrypted with (defaults to the "+dump.KeyEnvVar+" environment variable); the first key encrypts")
+	dumpCmd.Flags().BoolVar(&dumpCaptureChanges, "capture-changes", false,
+		"Capture the changes made during each dump run from a change stream, for point-in-time restore (requires a replica set)")
+	dumpCmd.Flags().StringVar(&dumpEngine, "engine", engineMongodump,
+		"Dump engine: 'mongodump' runs the mongodump tool, 'native' writes the same layout without it")
+
+	addStorageFlags(dumpCmd)
 
 	dumpCmd.MarkFlagRequired("source")
 }
 
+// dumpStateVersion is the schema version of dump state files; files written before it was recorded have version 0
+const dumpStateVersion = 1
+
 // DumpState tracks the state of dumps for incremental operations
 type DumpState struct {
+	Version     int                        `json:"version"`
 	Collections map[string]CollectionState `json:"collections"`
 }
 
 // CollectionState tracks the state of a single collection dump
 type CollectionState struct {
-	LastDumpTime  time.Time `json:"lastDumpTime"`
-	DocumentCount int64     `json:"documentCount"`
+	LastDumpTime     time.Time `json:"lastDumpTime"`
+	DocumentCount    int64     `json:"documentCount"`
+	Compression      string    `json:"compression,omitempty"`
+	RawBytes         int64     `json:"rawBytes,omitempty"`
+	StoredBytes      int64     `json:"storedBytes,omitempty"`
+	CompressionRatio float64   `json:"compressionRatio,omitempty"`
+	Encrypted        bool      `json:"encrypted,omitempty"`
 }
 
 func runDump() error {
 	logDumpConfiguration()
+	configureStorage()
 
-	if err := checkMongodumpInstalled(); err != nil {
+	if err := checkDumpOptions(); err != nil {
 		return err
 	}
 
@@ -160,25 +204,172 @@ func runDump() error {
 	}
 	defer sourceClient.Disconnect(ctx)
 
-	if err := os.MkdirAll(dumpOutputDir, 0755); err != nil {
+	if err := storage.MkdirAll(dumpOutputDir); err != nil {
 		return fmt.Errorf("failed to create output directory: %w", err)
 	}
 
 	stateFilePath := getStateFilePath()
-	state, err := loadOrCreateDumpState(stateFilePath)
+	lock, err := lockStateFile(stateFilePath)
 	if err != nil {
 		return err
 	}
+	defer releaseStateLock(lock)
+
+	if err := dumpWithState(ctx, sourceClient, stateFilePath); err != nil {
+		return err
+	}
+
+	fmt.Println("MongoDB dump operation completed successfully")
+	return nil
+}
 
-	if err := performDump(ctx, sourceClient, state); err != nil {
+// dumpWithState runs a dump from the state recorded by earlier runs, while holding the lock of the state file
+func dumpWithState(ctx context.Context, sourceClient *mongodb.Client, stateFilePath string) error {
+	state, err := loadOrCreateDumpState(stateFilePath)
+	if err != nil {
+		return err
+	}
+	run, err := startDumpRun(state)
+	if err != nil {
 		return err
 	}
+	return finishDump(run, stateFilePath, performDumpRun(ctx, sourceClient, run))
+}
+
+// dumpRun holds the segment a dump run writes and what it records about the collections it dumps
+type dumpRun struct {
+	index    *dump.ChainIndex
+	segment  *dump.Segment
+	dir      string
+	state    *DumpState
+	manifest *dump.Manifest
+	// runStart is the cluster time the run started at and changesStart the one its captured changes start after
+	runStart     primitive.Timestamp
+	changesStart primitive.Timestamp
+}
+
+// startDumpRun creates the segment directory of a dump run. Incremental runs extend the latest chain,
+// other runs and the first incremental run start a new chain with a full segment.
+func startDumpRun(state *DumpState) (*dumpRun, error) {
+	index, err := dump.ReadChainIndex(dumpOutputDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load chain index: %w", err)
+	}
+
+	segment := index.NewSegment(dumpIncremental, time.Now())
+	dir := dump.SegmentDir(dumpOutputDir, segment.ID)
+	exists, err := storage.Exists(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check segment directory: %w", err)
+	}
+	if exists {
+		return nil, fmt.Errorf("segment directory %s already exists", dir)
+	}
+	if err := storage.MkdirAll(dir); err != nil {
+		return nil, fmt.Errorf("failed to create segment directory: %w", err)
+	}
+
+	fmt.Printf("Writing %s segment %s\n", segment.Type, segment.ID)
+	return &dumpRun{index: index, segment: segment, dir: dir, state: state, manifest: dump.NewManifest()}, nil
+}
+
+// finishDump writes the segment manifest, even after a failed dump so the files written can be verified.
+// The segment is only added to the chain index, and the dump state saved, once all collections were dumped.
+func finishDump(run *dumpRun, stateFilePath string, dumpErr error) error {
+	if err := dump.WriteManifest(run.dir, run.manifest); err != nil {
+		return fmt.Errorf("failed to write dump manifest: %w", err)
+	}
+	if dumpErr != nil {
+		return dumpErr
+	}
 
-	if err := saveDumpState(state, stateFilePath); err != nil {
+	run.index.Add(run.segment)
+	if err := dump.WriteChainIndex(dumpOutputDir, run.index); err != nil {
+		return fmt.Errorf("failed to write chain index: %w", err)
+	}
+	if err := saveDumpState(run.state, stateFilePath); err != nil {
 		return fmt.Errorf("failed to save dump state: %w", err)
 	}
+	return nil
+}
+
+// performDumpRun dumps the selected collections and, when changes are captured,
+// the change events made while they were read
+func performDumpRun(ctx context.Context, sourceClient *mongodb.Client, run *dumpRun) error {
+	if !dumpCaptureChanges {
+		return performDump(ctx, sourceClient, run)
+	}
 
-	fmt.Println("MongoDB dump operation completed successfully")
+	if err := startChangeCapture(ctx, sourceClient, run); err != nil {
+		return err
+	}
+	if err := performDump(ctx, sourceClient, run); err != nil {
+		return err
+	}
+	return captureDumpChanges(ctx, sourceClient, run)
+}
+
+// startChangeCapture records the cluster time the captured changes start after: the end of the changes of the
+// previous segment, so the changes of consecutive segments are continuous, or the start of the run
+func startChangeCapture(ctx context.Context, sourceClient *mongodb.Client, run *dumpRun) error {
+	now, err := sourceClient.ClusterTime(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to start capturing changes: %w", err)
+	}
+
+	run.runStart = now
+	run.changesStart = now
+	if latest := run.index.Latest(); latest != nil && latest.Changes != nil {
+		run.changesStart = latest.Changes.End
+	}
+	fmt.Printf("Capturing changes after %s\n", dump.FormatClusterTime(run.changesStart))
+	return nil
+}
+
+// captureDumpChanges writes the change events made since the capture started to the segment and records them in
+// the manifest. When the oplog no longer holds the end of the previous segment's changes, the capture starts at the
+// start of the run instead, leaving a gap a point-in-time restore reports.
+func captureDumpChanges(ctx context.Context, sourceClient *mongodb.Client, run *dumpRun) error {
+	end, err := sourceClient.ClusterTime(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to capture changes: %w", err)
+	}
+
+	opts := dump.CaptureOptions{
+		Start:            run.changesStart,
+		End:              end,
+		Databases:        dumpDatabases,
+		ExcludeDatabases: dumpExcludeDatabases,
+		Compression:      dumpCompress,
+		Key:              dumpEncryptionKey(),
+	}
+	count, err := dump.CaptureChanges(ctx, sourceClient, run.dir, opts)
+	if dump.IsHistoryLost(err) && opts.Start != run.runStart {
+		fmt.Printf("Warning: The oplog no longer holds the changes after %s, capturing changes from the start of this run\n",
+			dump.FormatClusterTime(opts.Start))
+		opts.Start = run.runStart
+		count, err = dump.CaptureChanges(ctx, sourceClient, run.dir, opts)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to capture changes: %w", err)
+	}
+
+	run.segment.Changes = &dump.ChangeRange{Start: opts.Start, End: end, Events: count}
+	fmt.Printf("Captured %d change events up to %s\n", count, dump.FormatClusterTime(end))
+	return recordChangesFile(run)
+}
+
+// recordChangesFile adds the changes file of the segment to its manifest
+func recordChangesFile(run *dumpRun) error {
+	path, compression, found := dump.FindFile(run.dir, dump.ChangesFileName)
+	if !found {
+		return fmt.Errorf("changes file not found in %s", run.dir)
+	}
+	file, err := dump.DescribeFile(run.dir, path, compression, dumpKeyring)
+	if err != nil {
+		return fmt.Errorf("failed to check captured changes: %w", err)
+	}
+	run.manifest.SetChangesFile(file)
 	return nil
 }
 
@@ -197,12 +388,12 @@ func getStateFilePath() string {
 	if dumpStateFile != "" {
 		return dumpStateFile
 	}
-	return filepath.Join(dumpOutputDir, "dump-state.json")
+	return storage.Join(dumpOutputDir, "dump-state.json")
 }
 
 func loadOrCreateDumpState(stateFilePath string) (*DumpState, error) {
 	state, err := loadDumpState(stateFilePath)
-	if err != nil && !os.IsNotExist(err) {
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
 		return nil, fmt.Errorf("failed to load dump state: %w", err)
 	}
 	if state == nil {
@@ -211,20 +402,82 @@ func loadOrCreateDumpState(stateFilePath string) (*DumpState, error) {
 	return state, nil
 }
 
-func performDump(ctx context.Context, sourceClient *mongodb.Client, state *DumpState) error {
+func performDump(ctx context.Context, sourceClient *mongodb.Client, run *dumpRun) error {
 	dbsToDump, err := getDatabasesToDump(ctx, sourceClient)
 	if err != nil {
 		return err
 	}
 
 	for _, dbName := range dbsToDump {
-		if err := dumpDatabase(ctx, sourceClient, dbName, state); err != nil {
+		if err := dumpDatabase(ctx, sourceClient, dbName, run); err != nil {
 			return fmt.Errorf("failed to dump database %s: %w", dbName, err)
 		}
 	}
 	return nil
 }
 
+// checkDumpOptions validates the selected engine, compression and encryption
+func checkDumpOptions() error {
+	if err := checkDumpEngine(); err != nil {
+		return err
+	}
+	if err := checkStorageEngine(dumpOutputDir, dumpEngine); err != nil {
+		return err
+	}
+	if err := checkDumpCompression(); err != nil {
+		return err
+	}
+	return checkDumpEncryption()
+}
+
+// checkDumpEngine validates the selected dump engine and its requirements
+func checkDumpEngine() error {
+	switch dumpEngine {
+	case engineNative:
+		return nil
+	case engineMongodump:
+		return checkMongodumpInstalled()
+	default:
+		return fmt.Errorf("unknown dump engine %q, expected %q or %q", dumpEngine, engineNative, engineMongodump)
+	}
+}
+
+// checkDumpCompression validates the selected compression; mongodump can only write gzip
+func checkDumpCompression() error {
+	if err := dump.ValidateCompression(dumpCompress); err != nil {
+		return err
+	}
+	if dumpCompress == dump.CompressionZstd && dumpEngine != engineNative {
+		return fmt.Errorf("%s compression requires --engine %s", dump.CompressionZstd, engineNative)
+	}
+	return nil
+}
+
+// checkDumpEncryption loads the encryption keys; only the native engine can write encrypted files
+func checkDumpEncryption() error {
+	keyring, err := dump.LoadKeyring(dumpEncryptKeyFile)
+	if err != nil {
+		return err
+	}
+	if keyring != nil && dumpEngine != engineNative {
+		return fmt.Errorf("encrypted dumps require --engine %s", engineNative)
+	}
+
+	dumpKeyring = keyring
+	if dumpKeyring != nil {
+		fmt.Printf("Encryption: AES-256-GCM with key %s\n", dumpKeyring.Primary().ID())
+	}
+	return nil
+}
+
+// dumpEncryptionKey returns the key new dump files are encrypted with, or nil when encryption is off
+func dumpEncryptionKey() *dump.Key {
+	if dumpKeyring == nil {
+		return nil
+	}
+	return dumpKeyring.Primary()
+}
+
 func checkMongodumpInstalled() error {
 	cmd := exec.Command("mongodump", "--version")
 	if err := cmd.Run(); err != nil {
@@ -243,6 +496,8 @@ func logBasicDumpConfig() {
 	fmt.Println("Starting MongoDB dump operation")
 	fmt.Printf("Source: %s\n", dumpSourceURI)
 	fmt.Printf("Output directory: %s\n", dumpOutputDir)
+	fmt.Printf("Engine: %s\n", dumpEngine)
+	fmt.Printf("Compression: %s\n", dumpCompress)
 	if dumpSourceCACertFile != "" {
 		fmt.Printf("Source CA Certificate File: %s\n", dumpSourceCACertFile)
 	}
@@ -252,6 +507,7 @@ func logBasicDumpConfig() {
 
 func logIncrementalDumpConfig() {
 	fmt.Printf("Incremental mode: %v\n", dumpIncremental)
+	fmt.Printf("Capture changes: %v\n", dumpCaptureChanges)
 	if dumpIncremental && dumpLastModifiedField != "" {
 		fmt.Printf("Last modified field: %s\n", dumpLastModifiedField)
 	}
@@ -296,20 +552,12 @@ func getDatabasesToDump(ctx context.Context, sourceClient *mongodb.Client) ([]st
 	return dbsToDump, nil
 }
 
-func dumpDatabase(ctx context.Context, sourceClient *mongodb.Client, dbName string, state *DumpState) error {
+func dumpDatabase(ctx context.Context, sourceClient *mongodb.Client, dbName string, run *dumpRun) error {
 	fmt.Printf("Dumping database: %s\n", dbName)
 
-	var collsToDump []string
-	if len(dumpCollections) > 0 {
-		collsToDump = dumpCollections
-		fmt.Printf("  Using specified collections: %v\n", collsToDump)
-	} else {
-		var err error
-		collsToDump, err = sourceClient.ListCollections(ctx, dbName)
-		if err != nil {
-			return fmt.Errorf("failed to get collections for database %s: %w", dbName, err)
-		}
-		fmt.Printf("  Found %d collections in database %s\n", len(collsToDump), dbName)
+	collsToDump, err := getCollectionsToDump(ctx, sourceClient, dbName)
+	if err != nil {
+		return err
 	}
 
 	originalCount := len(collsToDump)
@@ -320,45 +568,120 @@ func dumpDatabase(ctx context.Context, sourceClient *mongodb.Client, dbName stri
 		}
 	}
 
+	timeSeries, err := mongodb.GetTimeSeriesSpecs(ctx, sourceClient.GetDatabase(dbName))
+	if err != nil {
+		return err
+	}
+
 	fmt.Printf("  Dumping %d collections in database %s\n", len(collsToDump), dbName)
 	for _, collName := range collsToDump {
 		fmt.Printf("    Dumping collection: %s.%s\n", dbName, collName)
-		if err := dumpCollection(ctx, sourceClient, dbName, collName, state); err != nil {
+		if err := dumpCollection(ctx, sourceClient, dbName, collName, timeSeries[collName] != nil, run); err != nil {
 			return fmt.Errorf("failed to dump collection %s.%s: %w", dbName, collName, err)
 		}
 	}
 	return nil
 }
 
-func dumpCollection(ctx context.Context, sourceClient *mongodb.Client, dbName, collName string, state *DumpState) error {
-	collKey := fmt.Sprintf("%s.%s", dbName, collName)
+// getCollectionsToDump returns the specified collections or all collections of the database
+func getCollectionsToDump(ctx context.Context, sourceClient *mongodb.Client, dbName string) ([]string, error) {
+	if len(dumpCollections) > 0 {
+		fmt.Printf("  Using specified collections: %v\n", dumpCollections)
+		return dumpCollections, nil
+	}
 
-	query, err := buildIncrementalQuery(collKey, state)
+	collsToDump, err := sourceClient.ListCollections(ctx, dbName)
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("failed to get collections for database %s: %w", dbName, err)
 	}
+	fmt.Printf("  Found %d collections in database %s\n", len(collsToDump), dbName)
+	return collsToDump, nil
+}
+
+func dumpCollection(ctx context.Context, sourceClient *mongodb.Client, dbName, collName string, timeSeries bool, run *dumpRun) error {
+	collKey := fmt.Sprintf("%s.%s", dbName, collName)
 
 	// Ensure the base output directory exists
-	if err := os.MkdirAll(dumpOutputDir, 0755); err != nil {
+	if err := storage.MkdirAll(dumpOutputDir); err != nil {
 		return fmt.Errorf("failed to create output directory: %w", err)
 	}
 
-	if err := executeDumpWithRetry(dbName, collName, dumpOutputDir, query); err != nil {
+	var filter bson.M
+	if run.segment.Incremental() {
+		filter = buildIncrementalFilter(collKey, run.state)
+	}
+	if err := executeCollectionDump(ctx, sourceClient, run.dir, dbName, collName, timeSeries, filter); err != nil {
+		return err
+	}
+
+	dataFile, err := recordDumpFiles(run.manifest, run.dir, dbName, collName, timeSeries)
+	if err != nil {
 		return err
 	}
 
-	return updateCollectionState(ctx, sourceClient, dbName, collName, collKey, state)
+	updateCollectionState(dbName, collName, collKey, dataFile, run.state)
+	return nil
 }
 
-func buildIncrementalQuery(collKey string, state *DumpState) (string, error) {
+// recordDumpFiles adds the files of a dumped collection to the manifest and returns the entry of its data file.
+// The documents are counted from the written file, so the count matches what was dumped. Views have no data file.
+func recordDumpFiles(manifest *dump.Manifest, dir, dbName, collName string, timeSeries bool) (dump.ManifestFile, error) {
+	files, err := dump.DescribeCollection(dir, dbName, collName, dataFileName(collName, timeSeries), dumpKeyring)
+	if err != nil {
+		return dump.ManifestFile{}, fmt.Errorf("failed to check dump of %s.%s: %w", dbName, collName, err)
+	}
+
+	manifest.SetCollection(dbName, collName, files)
+	dataFile, _ := manifest.DataFile(dbName, collName)
+	return dataFile, nil
+}
+
+// executeCollectionDump dumps a collection with the selected engine into the segment directory.
+// Time-series collections are always dumped in full since mongodump cannot apply a query to them.
+func executeCollectionDump(ctx context.Context, sourceClient *mongodb.Client, dir, dbName, collName string,
+	timeSeries bool, filter bson.M) error {
+	if timeSeries {
+		fmt.Printf("      Time-series collection, dumping all measurements\n")
+		filter = nil
+	}
+
+	if dumpEngine == engineNative {
+		return executeNativeDumpWithRetry(ctx, sourceClient, dir, dbName, collName, filter)
+	}
+
+	query, err := buildIncrementalQuery(filter)
+	if err != nil {
+		return err
+	}
+	return executeDumpWithRetry(dbName, collName, dir, query)
+}
+
+// dataFileName returns the name of the uncompressed file holding the documents of a collection
+func dataFileName(collName string, timeSeries bool) string {
+	if timeSeries {
+		return timeSeriesBucketsFile(collName)
+	}
+	return dump.BSONFileName(collName)
+}
+
+// buildIncrementalFilter returns the filter selecting documents modified since the last dump, or nil for a full dump
+func buildIncrementalFilter(collKey string, state *DumpState) bson.M {
 	collState, exists := state.Collections[collKey]
 	if !dumpIncremental || !exists || collState.LastDumpTime.IsZero() {
-		return "", nil
+		return nil
 	}
 
-	queryDoc := bson.M{
+	return bson.M{
 		dumpLastModifiedField: bson.M{"$gt": collState.LastDumpTime},
 	}
+}
+
+// buildIncrementalQuery renders an incremental filter as a mongodump --query argument
+func buildIncrementalQuery(queryDoc bson.M) (string, error) {
+	if queryDoc == nil {
+		return "", nil
+	}
+
 	queryBytes, err := json.Marshal(queryDoc)
 	if err != nil {
 		return "", fmt.Errorf("failed to create query: %w", err)
@@ -392,21 +715,40 @@ func executeDumpWithRetry(dbName, collName, outputPath, query string) error {
 	return fmt.Errorf("failed after %d attempts: %w", dumpRetryAttempts, lastErr)
 }
 
-func updateCollectionState(ctx context.Context, sourceClient *mongodb.Client, dbName, collName, collKey string, state *DumpState) error {
-	db := sourceClient.GetDatabase(dbName)
-	coll := db.Collection(collName)
-	count, err := coll.CountDocuments(ctx, bson.M{})
-	if err != nil {
-		return fmt.Errorf("failed to count documents: %w", err)
+// executeNativeDumpWithRetry dumps a collection with the native engine, retrying failed attempts
+func executeNativeDumpWithRetry(ctx context.Context, sourceClient *mongodb.Client, dir, dbName, collName string, filter bson.M) error {
+	opts := dump.WriterOptions{
+		OutputDir:   dir,
+		BatchSize:   dumpBatchSize,
+		Filter:      filter,
+		Compression: dumpCompress,
+		Key:         dumpEncryptionKey(),
 	}
 
+	operation := fmt.Sprintf("Dump %s.%s", dbName, collName)
+	return mongodb.RetryWithBackoff(ctx, dumpRetryAttempts, operation, func() error {
+		_, err := dump.DumpCollection(ctx, sourceClient.GetDatabase(dbName), collName, opts)
+		return err
+	})
+}
+
+// updateCollectionState records a dumped collection with the document count and sizes of its data file
+func updateCollectionState(dbName, collName, collKey string, dataFile dump.ManifestFile, state *DumpState) {
+	stats := dump.FileStats{RawBytes: dataFile.RawSize, StoredBytes: dataFile.Size}
 	state.Collections[collKey] = CollectionState{
-		LastDumpTime:  time.Now(),
-		DocumentCount: count,
+		LastDumpTime:     time.Now(),
+		DocumentCount:    dataFile.Documents,
+		Compression:      dumpCompress,
+		RawBytes:         stats.RawBytes,
+		StoredBytes:      stats.StoredBytes,
+		CompressionRatio: stats.Ratio(),
+		Encrypted:        dumpKeyring != nil,
 	}
 
-	fmt.Printf("      Successfully dumped %s.%s (%d documents)\n", dbName, collName, count)
-	return nil
+	fmt.Printf("      Successfully dumped %s.%s (%d documents)\n", dbName, collName, dataFile.Documents)
+	if dumpCompress != dump.CompressionNone && stats.StoredBytes > 0 {
+		fmt.Printf("      Compressed %d bytes to %d bytes (ratio %.2f)\n", stats.RawBytes, stats.StoredBytes, stats.Ratio())
+	}
 }
 
 func buildMongodumpArgs(dbName, collName, outputPath, query string) []string {
@@ -425,11 +767,15 @@ func buildMongodumpArgs(dbName, collName, outputPath, query string) []string {
 		args = append(args, "--query", query)
 	}
 
+	if dumpCompress == dump.CompressionGzip {
+		args = append(args, "--gzip")
+	}
+
 	return args
 }
 
 func loadDumpState(filePath string) (*DumpState, error) {
-	data, err := os.ReadFile(filePath)
+	data, err := storage.ReadFile(filePath)
 	if err != nil {
 		return nil, err
 	}
@@ -438,15 +784,33 @@ func loadDumpState(filePath string) (*DumpState, error) {
 	if err := json.Unmarshal(data, &state); err != nil {
 		return nil, err
 	}
+	if err := migrateDumpState(&state); err != nil {
+		return nil, err
+	}
 
 	return &state, nil
 }
 
+// migrateDumpState upgrades a dump state read from a file written by an older version
+func migrateDumpState(state *DumpState) error {
+	if state.Version > dumpStateVersion {
+		return fmt.Errorf("dump state version %d is newer than the supported version %d", state.Version, dumpStateVersion)
+	}
+	// Version 0 files are read as is; they may lack the collections of runs that dumped nothing
+	if state.Collections == nil {
+		state.Collections = make(map[string]CollectionState)
+	}
+	state.Version = dumpStateVersion
+	return nil
+}
+
+// saveDumpState atomically replaces the state file, so a crash leaves either the old or the new state
 func saveDumpState(state *DumpState, filePath string) error {
+	state.Version = dumpStateVersion
 	data, err := json.MarshalIndent(state, "", "  ")
 	if err != nil {
 		return err
 	}
 
-	return os.WriteFile(filePath, data, 0644)
+	return storage.WriteBytes(filePath, data)
 }
@@ -0,0 +1,196 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"nmongo/internal/dump"
+	"nmongo/internal/storage"
+)
+
+var (
+	dumpPruneInputDir     string
+	dumpPruneKeepLast     int
+	dumpPruneKeepDaily    int
+	dumpPruneKeepWeekly   int
+	dumpPruneKeepMonthly  int
+	dumpPruneMaxTotalSize string
+	dumpPruneDryRun       bool
+	dumpPruneStateFile    string
+)
+
+var dumpPruneCmd = &cobra.Command{
+	Use:   "prune",
+	Short: "Remove old segments from a dump directory",
+	Long: `Remove the segments of a dump directory that fall outside a retention policy.
+The rules are combined: a segment is kept if any of --keep-last, --keep-daily, --keep-weekly or --keep-monthly
+selects it. Segments needed to restore a kept incremental segment are always kept. --max-total-size then removes
+whole chains, oldest first, until the rest fits; the latest chain is never removed.
+Directories left by failed dump runs are removed as well. The prune holds the lock of the dump's state file,
+so it cannot overlap with a dump writing to the same directory.
+
+Examples:
+  nmongo dump prune --input ./dumps --keep-last 7 --dry-run
+  nmongo dump prune --input ./dumps --keep-daily 7 --keep-weekly 4 --keep-monthly 12
+  nmongo dump prune --input ./dumps --keep-last 30 --max-total-size 50GB`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := runDumpPrune(); err != nil {
+			log.Fatalf("Error pruning dump: %v", err)
+		}
+	},
+}
+
+func init() {
+	dumpCmd.AddCommand(dumpPruneCmd)
+	dumpPruneCmd.Flags().StringVar(&dumpPruneInputDir, "input", "./dumps", "Dump directory to prune")
+	dumpPruneCmd.Flags().IntVar(&dumpPruneKeepLast, "keep-last", 0, "Keep the most recent segments")
+	dumpPruneCmd.Flags().IntVar(&dumpPruneKeepDaily, "keep-daily", 0, "Keep the latest segment of each of the most recent days")
+	dumpPruneCmd.Flags().IntVar(&dumpPruneKeepWeekly, "keep-weekly", 0, "Keep the latest segment of each of the most recent weeks")
+	dumpPruneCmd.Flags().IntVar(&dumpPruneKeepMonthly, "keep-monthly", 0, "Keep the latest segment of each of the most recent months")
+	dumpPruneCmd.Flags().StringVar(&dumpPruneMaxTotalSize, "max-total-size", "",
+		"Remove the oldest chains until the dump fits in this size (e.g. 500MB, 50GB)")
+	dumpPruneCmd.Flags().BoolVar(&dumpPruneDryRun, "dry-run", false, "List the segments that would be removed without removing them")
+	dumpPruneCmd.Flags().StringVar(&dumpPruneStateFile, "state-file", "",
+		"Path to the state file of the dumps written to the directory (defaults to <input>/dump-state.json)")
+	addStorageFlags(dumpPruneCmd)
+}
+
+// runDumpPrune applies the retention policy to the dump directory, or only prints the plan in a dry run
+func runDumpPrune() error {
+	configureStorage()
+	policy, err := prunePolicy()
+	if err != nil {
+		return err
+	}
+
+	// A dump running meanwhile would write back its copy of the index with the pruned segments
+	lock, err := lockStateFile(pruneStateFilePath())
+	if err != nil {
+		return err
+	}
+	defer releaseStateLock(lock)
+	return pruneChainIndex(policy)
+}
+
+// pruneChainIndex applies the retention policy to the chain index of the dump directory and its segments
+func pruneChainIndex(policy dump.RetentionPolicy) error {
+	index, err := dump.ReadChainIndex(dumpPruneInputDir)
+	if err != nil {
+		return fmt.Errorf("failed to load chain index: %w", err)
+	}
+	if len(index.Segments) == 0 {
+		fmt.Printf("No segments found in %s, nothing to prune\n", dumpPruneInputDir)
+		return nil
+	}
+
+	plan, err := dump.PlanPrune(dumpPruneInputDir, index, policy)
+	if err != nil {
+		return err
+	}
+	printPrunePlan(plan)
+
+	if dumpPruneDryRun {
+		fmt.Println("Dry run, nothing was removed")
+		return nil
+	}
+	if err := dump.ApplyPrune(dumpPruneInputDir, index, plan); err != nil {
+		return err
+	}
+	fmt.Printf("Removed %d segments and %d incomplete directories\n", len(plan.Remove), len(plan.Incomplete))
+	return nil
+}
+
+// prunePolicy builds the retention policy from the flags
+func prunePolicy() (dump.RetentionPolicy, error) {
+	maxTotalSize, err := parseSize(dumpPruneMaxTotalSize)
+	if err != nil {
+		return dump.RetentionPolicy{}, fmt.Errorf("invalid --max-total-size: %w", err)
+	}
+	return dump.RetentionPolicy{
+		KeepLast:     dumpPruneKeepLast,
+		KeepDaily:    dumpPruneKeepDaily,
+		KeepWeekly:   dumpPruneKeepWeekly,
+		KeepMonthly:  dumpPruneKeepMonthly,
+		MaxTotalSize: maxTotalSize,
+	}, nil
+}
+
+// pruneStateFilePath returns the state file of the dumps written to the pruned directory
+func pruneStateFilePath() string {
+	if dumpPruneStateFile != "" {
+		return dumpPruneStateFile
+	}
+	return storage.Join(dumpPruneInputDir, "dump-state.json")
+}
+
+// printPrunePlan prints the segments kept with the reasons they are kept, and the segments removed
+func printPrunePlan(plan *dump.PrunePlan) {
+	fmt.Printf("Keeping %d segments:\n", len(plan.Keep))
+	for _, segment := range plan.Keep {
+		fmt.Printf("  %s (%s): %s\n", segment.ID, formatSize(segment.Size), strings.Join(segment.Reasons, ", "))
+	}
+
+	fmt.Printf("Removing %d segments:\n", len(plan.Remove))
+	for _, segment := range plan.Remove {
+		fmt.Printf("  %s (%s)\n", segment.ID, formatSize(segment.Size))
+	}
+
+	if len(plan.Incomplete) > 0 {
+		fmt.Printf("Removing %d directories of failed dump runs:\n", len(plan.Incomplete))
+		for _, id := range plan.Incomplete {
+			fmt.Printf("  %s\n", id)
+		}
+	}
+}
+
+// sizeUnits are the units accepted by parseSize, in powers of 1024
+var sizeUnits = []struct {
+	suffix     string
+	multiplier int64
+}{
+	{"TIB", 1 << 40}, {"GIB", 1 << 30}, {"MIB", 1 << 20}, {"KIB", 1 << 10},
+	{"TB", 1 << 40}, {"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10},
+	{"T", 1 << 40}, {"G", 1 << 30}, {"M", 1 << 20}, {"K", 1 << 10},
+	{"B", 1},
+}
+
+// parseSize parses a size such as 500MB or 50GB into bytes; an empty size is 0
+func parseSize(size string) (int64, error) {
+	value := strings.ToUpper(strings.TrimSpace(size))
+	if value == "" {
+		return 0, nil
+	}
+
+	multiplier := int64(1)
+	for _, unit := range sizeUnits {
+		if strings.HasSuffix(value, unit.suffix) {
+			value = strings.TrimSpace(strings.TrimSuffix(value, unit.suffix))
+			multiplier = unit.multiplier
+			break
+		}
+	}
+
+	number, err := strconv.ParseFloat(value, 64)
+	if err != nil || number < 0 {
+		return 0, fmt.Errorf("%q is not a size", size)
+	}
+	return int64(number * float64(multiplier)), nil
+}
+
+// formatSize formats a number of bytes with a binary unit
+func formatSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,101 @@
+package cmd
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"nmongo/internal/dump"
+)
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+		wantErr  bool
+	}{
+		{input: "", expected: 0},
+		{input: "1024", expected: 1024},
+		{input: "10B", expected: 10},
+		{input: "1K", expected: 1024},
+		{input: "2KiB", expected: 2048},
+		{input: "500MB", expected: 500 << 20},
+		{input: "1.5gb", expected: 3 << 29},
+		{input: "50 GB", expected: 50 << 30},
+		{input: "1T", expected: 1 << 40},
+		{input: "GB", wantErr: true},
+		{input: "-1GB", wantErr: true},
+		{input: "10XB", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			size, err := parseSize(tt.input)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, size)
+		})
+	}
+}
+
+func TestFormatSize(t *testing.T) {
+	assert.Equal(t, "512 B", formatSize(512))
+	assert.Equal(t, "1.5 KiB", formatSize(1536))
+	assert.Equal(t, "2.0 GiB", formatSize(2<<30))
+}
+
+func TestRunDumpPrune(t *testing.T) {
+	originalInputDir := dumpPruneInputDir
+	originalKeepLast := dumpPruneKeepLast
+	originalDryRun := dumpPruneDryRun
+	defer func() {
+		dumpPruneInputDir = originalInputDir
+		dumpPruneKeepLast = originalKeepLast
+		dumpPruneDryRun = originalDryRun
+	}()
+
+	root := t.TempDir()
+	dumpPruneInputDir = root
+	dumpPruneKeepLast = 1
+
+	// An empty dump directory has nothing to prune
+	assert.NoError(t, runDumpPrune())
+
+	index, err := dump.ReadChainIndex(root)
+	require.NoError(t, err)
+	for day := 1; day <= 3; day++ {
+		segment := index.NewSegment(false, time.Date(2024, 5, day, 0, 0, 0, 0, time.UTC))
+		index.Add(segment)
+		require.NoError(t, os.MkdirAll(dump.SegmentDir(root, segment.ID), 0755))
+	}
+	require.NoError(t, dump.WriteChainIndex(root, index))
+
+	dumpPruneDryRun = true
+	require.NoError(t, runDumpPrune())
+	for _, segment := range index.Segments {
+		assert.DirExists(t, dump.SegmentDir(root, segment.ID))
+	}
+
+	dumpPruneDryRun = false
+	require.NoError(t, runDumpPrune())
+	assert.NoDirExists(t, dump.SegmentDir(root, index.Segments[0].ID))
+	assert.NoDirExists(t, dump.SegmentDir(root, index.Segments[1].ID))
+	assert.DirExists(t, dump.SegmentDir(root, index.Segments[2].ID))
+
+	// A dump holding the lock of the state file keeps the index from being pruned
+	lock, err := lockStateFile(pruneStateFilePath())
+	require.NoError(t, err)
+	assert.ErrorContains(t, runDumpPrune(), "another run is using state file")
+	assert.DirExists(t, dump.SegmentDir(root, index.Segments[2].ID))
+	releaseStateLock(lock)
+
+	// A policy without rules is refused
+	dumpPruneKeepLast = 0
+	assert.ErrorContains(t, runDumpPrune(), "no retention rule")
+}
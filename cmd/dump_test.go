@@ -6,13 +6,17 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+
+	"nmongo/internal/dump"
 )
 
 func TestDumpStateOperations(t *testing.T) {
@@ -53,6 +57,27 @@ func TestDumpStateOperations(t *testing.T) {
 		assert.True(t, os.IsNotExist(err))
 	})
 
+	t.Run("MigrateDumpState", func(t *testing.T) {
+		stateFile := filepath.Join(t.TempDir(), "dump-state.json")
+
+		// State files written before the schema version was recorded are read as version 0
+		legacy := `{"collections": {"db1.coll1": {"lastDumpTime": "2024-05-01T00:00:00Z", "documentCount": 10}}}`
+		require.NoError(t, os.WriteFile(stateFile, []byte(legacy), 0644))
+		state, err := loadDumpState(stateFile)
+		require.NoError(t, err)
+		assert.Equal(t, dumpStateVersion, state.Version)
+		assert.Equal(t, int64(10), state.Collections["db1.coll1"].DocumentCount)
+
+		require.NoError(t, os.WriteFile(stateFile, []byte(`{"version": 1}`), 0644))
+		state, err = loadDumpState(stateFile)
+		require.NoError(t, err)
+		assert.NotNil(t, state.Collections)
+
+		require.NoError(t, os.WriteFile(stateFile, []byte(`{"version": 99}`), 0644))
+		_, err = loadDumpState(stateFile)
+		assert.ErrorContains(t, err, "dump state version 99 is newer than the supported version")
+	})
+
 	t.Run("LoadDumpStateInvalidJSON", func(t *testing.T) {
 		tempDir := t.TempDir()
 		stateFile := filepath.Join(tempDir, "invalid-state.json")
@@ -420,3 +445,197 @@ func TestGetDatabasesToDumpError(t *testing.T) {
 	_, err := mockCli.ListDatabases(ctx)
 	assert.Error(t, err)
 }
+
+func TestCheckDumpEngine(t *testing.T) {
+	originalEngine := dumpEngine
+	defer func() { dumpEngine = originalEngine }()
+
+	dumpEngine = engineNative
+	assert.NoError(t, checkDumpEngine())
+
+	dumpEngine = "pg_dump"
+	err := checkDumpEngine()
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "unknown dump engine")
+}
+
+func TestBuildIncrementalFilter(t *testing.T) {
+	originalIncremental := dumpIncremental
+	originalField := dumpLastModifiedField
+	defer func() {
+		dumpIncremental = originalIncremental
+		dumpLastModifiedField = originalField
+	}()
+
+	lastDump := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	state := &DumpState{Collections: map[string]CollectionState{
+		"db.users": {LastDumpTime: lastDump},
+	}}
+	dumpLastModifiedField = "updatedAt"
+
+	dumpIncremental = false
+	assert.Nil(t, buildIncrementalFilter("db.users", state))
+
+	dumpIncremental = true
+	assert.Nil(t, buildIncrementalFilter("db.orders", state))
+	assert.Equal(t, bson.M{"updatedAt": bson.M{"$gt": lastDump}}, buildIncrementalFilter("db.users", state))
+
+	query, err := buildIncrementalQuery(nil)
+	require.NoError(t, err)
+	assert.Empty(t, query)
+
+	query, err = buildIncrementalQuery(buildIncrementalFilter("db.users", state))
+	require.NoError(t, err)
+	assert.Contains(t, query, "updatedAt")
+}
+
+func TestCheckDumpCompression(t *testing.T) {
+	originalEngine := dumpEngine
+	originalCompress := dumpCompress
+	defer func() {
+		dumpEngine = originalEngine
+		dumpCompress = originalCompress
+	}()
+
+	tests := []struct {
+		engine      string
+		compression string
+		expectError bool
+	}{
+		{engine: engineMongodump, compression: dump.CompressionNone},
+		{engine: engineMongodump, compression: dump.CompressionGzip},
+		{engine: engineMongodump, compression: dump.CompressionZstd, expectError: true},
+		{engine: engineNative, compression: dump.CompressionZstd},
+		{engine: engineNative, compression: "brotli", expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.engine+"/"+tt.compression, func(t *testing.T) {
+			dumpEngine = tt.engine
+			dumpCompress = tt.compression
+			err := checkDumpCompression()
+			if tt.expectError {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestBuildMongodumpArgsGzip(t *testing.T) {
+	originalCompress := dumpCompress
+	defer func() { dumpCompress = originalCompress }()
+
+	dumpCompress = dump.CompressionGzip
+	assert.Contains(t, buildMongodumpArgs("mydb", "users", "/backup", ""), "--gzip")
+
+	dumpCompress = dump.CompressionNone
+	assert.NotContains(t, buildMongodumpArgs("mydb", "users", "/backup", ""), "--gzip")
+}
+
+func TestRecordDumpFiles(t *testing.T) {
+	originalOutputDir := dumpOutputDir
+	originalKeyring := dumpKeyring
+	defer func() {
+		dumpOutputDir = originalOutputDir
+		dumpKeyring = originalKeyring
+	}()
+
+	dumpOutputDir = t.TempDir()
+	dumpKeyring = nil
+	dbPath := filepath.Join(dumpOutputDir, "mydb")
+	require.NoError(t, os.MkdirAll(dbPath, 0755))
+
+	var data []byte
+	for i := 0; i < 3; i++ {
+		doc, err := bson.Marshal(bson.D{{Key: "_id", Value: i}})
+		require.NoError(t, err)
+		data = append(data, doc...)
+	}
+	require.NoError(t, os.WriteFile(filepath.Join(dbPath, "system.buckets.metrics.bson"), data, 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dbPath, "metrics.metadata.json"), []byte("{}"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dbPath, "activeUsers.metadata.json"), []byte("{}"), 0644))
+
+	manifest := dump.NewManifest()
+	dataFile, err := recordDumpFiles(manifest, dumpOutputDir, "mydb", "metrics", true)
+	require.NoError(t, err)
+	assert.Equal(t, "mydb/system.buckets.metrics.bson", dataFile.Path)
+	assert.Equal(t, int64(3), dataFile.Documents)
+	assert.Equal(t, int64(len(data)), dataFile.Size)
+	assert.Equal(t, int64(len(data)), dataFile.RawSize)
+
+	// Views have no data file
+	dataFile, err = recordDumpFiles(manifest, dumpOutputDir, "mydb", "activeUsers", false)
+	require.NoError(t, err)
+	assert.Equal(t, dump.ManifestFile{}, dataFile)
+	assert.Len(t, manifest.Files, 3)
+
+	state := &DumpState{Collections: map[string]CollectionState{}}
+	metrics, _ := manifest.DataFile("mydb", "metrics")
+	updateCollectionState("mydb", "metrics", "mydb.metrics", metrics, state)
+	assert.Equal(t, int64(3), state.Collections["mydb.metrics"].DocumentCount)
+}
+
+func TestCheckDumpEncryption(t *testing.T) {
+	originalEngine := dumpEngine
+	originalKeyFile := dumpEncryptKeyFile
+	originalKeyring := dumpKeyring
+	defer func() {
+		dumpEngine = originalEngine
+		dumpEncryptKeyFile = originalKeyFile
+		dumpKeyring = originalKeyring
+	}()
+
+	keyFile := filepath.Join(t.TempDir(), "keys")
+	require.NoError(t, os.WriteFile(keyFile, []byte(strings.Repeat("ab", 32)), 0600))
+	t.Setenv(dump.KeyEnvVar, "")
+
+	dumpEncryptKeyFile = ""
+	dumpEngine = engineMongodump
+	require.NoError(t, checkDumpEncryption())
+	assert.Nil(t, dumpEncryptionKey())
+
+	dumpEncryptKeyFile = keyFile
+	err := checkDumpEncryption()
+	assert.ErrorContains(t, err, "require --engine native")
+
+	dumpEngine = engineNative
+	require.NoError(t, checkDumpEncryption())
+	assert.NotNil(t, dumpEncryptionKey())
+}
+
+func TestDumpRunSegments(t *testing.T) {
+	originalOutputDir := dumpOutputDir
+	originalIncremental := dumpIncremental
+	defer func() {
+		dumpOutputDir = originalOutputDir
+		dumpIncremental = originalIncremental
+	}()
+
+	dumpOutputDir = t.TempDir()
+	stateFile := filepath.Join(dumpOutputDir, "dump-state.json")
+	state := &DumpState{Collections: map[string]CollectionState{}}
+
+	// The first incremental run starts the chain with a full segment
+	dumpIncremental = true
+	run, err := startDumpRun(state)
+	require.NoError(t, err)
+	assert.False(t, run.segment.Incremental())
+	assert.DirExists(t, run.dir)
+	require.NoError(t, finishDump(run, stateFile, nil))
+	assert.FileExists(t, filepath.Join(run.dir, dump.ManifestFileName))
+	assert.FileExists(t, stateFile)
+
+	run, err = startDumpRun(state)
+	require.NoError(t, err)
+	assert.True(t, run.segment.Incremental())
+
+	// A failed run keeps its manifest but is not added to the chain
+	assert.Error(t, finishDump(run, stateFile, fmt.Errorf("dump failed")))
+	assert.FileExists(t, filepath.Join(run.dir, dump.ManifestFileName))
+	index, err := dump.ReadChainIndex(dumpOutputDir)
+	require.NoError(t, err)
+	require.Len(t, index.Segments, 1)
+	assert.Equal(t, dump.SegmentFull, index.Segments[0].Type)
+}
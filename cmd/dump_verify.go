@@ -0,0 +1,112 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/spf13/cobra"
+
+	"nmongo/internal/dump"
+)
+
+var (
+	dumpVerifyInputDir       string
+	dumpVerifyEncryptKeyFile string
+)
+
+var dumpVerifyCmd = &cobra.Command{
+	Use:   "verify",
+	Short: "Verify a dump against its manifest",
+	Long: `Verify a dump offline against the manifests written by the dump command, one per segment.
+Every file listed in a manifest is checked for its size and SHA-256 checksum, and the documents of data files
+are read and counted. Files that are missing, modified, truncated or not listed in the manifest are reported.
+
+Examples:
+  nmongo dump verify --input ./dumps
+  nmongo dump verify --input ./dumps --encrypt-key-file dump.key`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := runDumpVerify(); err != nil {
+			log.Fatalf("Error verifying dump: %v", err)
+		}
+	},
+}
+
+func init() {
+	dumpCmd.AddCommand(dumpVerifyCmd)
+	dumpVerifyCmd.Flags().StringVar(&dumpVerifyInputDir, "input", "./dumps", "Dump directory to verify")
+	dumpVerifyCmd.Flags().StringVar(&dumpVerifyEncryptKeyFile, "encrypt-key-file", "",
+		"File with the keys encrypted dumps are decrypted with (defaults to the "+dump.KeyEnvVar+" environment variable)")
+	addStorageFlags(dumpVerifyCmd)
+}
+
+// runDumpVerify checks every segment of a dump directory, or a dump without segments, against its manifest
+// and fails if anything does not match
+func runDumpVerify() error {
+	fmt.Printf("Verifying dump in %s\n", dumpVerifyInputDir)
+	configureStorage()
+
+	keyring, err := dump.LoadKeyring(dumpVerifyEncryptKeyFile)
+	if err != nil {
+		return err
+	}
+
+	dirs, err := verifyDirs(dumpVerifyInputDir)
+	if err != nil {
+		return err
+	}
+
+	problems := 0
+	for _, dir := range dirs {
+		count, err := verifyDumpDir(dir, keyring)
+		if err != nil {
+			return err
+		}
+		problems += count
+	}
+	if problems > 0 {
+		return fmt.Errorf("dump verification failed, problems found: %d", problems)
+	}
+
+	fmt.Println("The dump matches its manifest")
+	return nil
+}
+
+// verifyDirs returns the segment directories of a dump listed in its chain index, or the dump directory itself
+// for a dump without segments
+func verifyDirs(root string) ([]string, error) {
+	index, err := dump.ReadChainIndex(root)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load chain index: %w", err)
+	}
+	if len(index.Segments) == 0 {
+		return []string{root}, nil
+	}
+
+	dirs := make([]string, 0, len(index.Segments))
+	for _, segment := range index.Segments {
+		dirs = append(dirs, dump.SegmentDir(root, segment.ID))
+	}
+	return dirs, nil
+}
+
+// verifyDumpDir checks a directory against its manifest, prints the problems found and returns their number
+func verifyDumpDir(dir string, keyring *dump.Keyring) (int, error) {
+	manifest, err := dump.ReadManifest(dir)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read dump manifest of %s: %w", dir, err)
+	}
+
+	report, err := dump.VerifyDump(dir, manifest, keyring)
+	if err != nil {
+		return 0, err
+	}
+
+	fmt.Printf("  %s: %d files\n", dir, report.Files)
+	for _, problem := range report.Problems {
+		fmt.Printf("    %s\n", problem)
+	}
+	if report.Unchecked > 0 {
+		fmt.Printf("    Document counts of %d encrypted files were not checked, no encryption key was given\n", report.Unchecked)
+	}
+	return len(report.Problems), nil
+}
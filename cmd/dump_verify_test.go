@@ -0,0 +1,67 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+
+	"nmongo/internal/dump"
+)
+
+func TestRunDumpVerify(t *testing.T) {
+	originalInputDir := dumpVerifyInputDir
+	originalKeyFile := dumpVerifyEncryptKeyFile
+	defer func() {
+		dumpVerifyInputDir = originalInputDir
+		dumpVerifyEncryptKeyFile = originalKeyFile
+	}()
+
+	dumpVerifyInputDir = t.TempDir()
+	dumpVerifyEncryptKeyFile = ""
+	t.Setenv(dump.KeyEnvVar, "")
+
+	// A directory without a manifest cannot be verified
+	assert.ErrorContains(t, runDumpVerify(), "failed to read dump manifest")
+
+	dbPath := filepath.Join(dumpVerifyInputDir, "mydb")
+	require.NoError(t, os.MkdirAll(dbPath, 0755))
+	doc, err := bson.Marshal(bson.D{{Key: "_id", Value: 1}})
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(dbPath, "users.bson"), doc, 0644))
+
+	files, err := dump.DescribeCollection(dumpVerifyInputDir, "mydb", "users", "users.bson", nil)
+	require.NoError(t, err)
+	manifest := dump.NewManifest()
+	manifest.SetCollection("mydb", "users", files)
+	require.NoError(t, dump.WriteManifest(dumpVerifyInputDir, manifest))
+
+	assert.NoError(t, runDumpVerify())
+
+	require.NoError(t, os.WriteFile(filepath.Join(dbPath, "users.bson"), doc[:len(doc)-1], 0644))
+	assert.ErrorContains(t, runDumpVerify(), "dump verification failed, problems found: 1")
+}
+
+func TestVerifyDirs(t *testing.T) {
+	root := t.TempDir()
+
+	dirs, err := verifyDirs(root)
+	require.NoError(t, err)
+	assert.Equal(t, []string{root}, dirs)
+
+	index, err := dump.ReadChainIndex(root)
+	require.NoError(t, err)
+	full := index.NewSegment(false, time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC))
+	index.Add(full)
+	incremental := index.NewSegment(true, time.Date(2024, 5, 2, 0, 0, 0, 0, time.UTC))
+	index.Add(incremental)
+	require.NoError(t, dump.WriteChainIndex(root, index))
+
+	dirs, err = verifyDirs(root)
+	require.NoError(t, err)
+	assert.Equal(t, []string{dump.SegmentDir(root, full.ID), dump.SegmentDir(root, incremental.ID)}, dirs)
+}
@@ -107,6 +107,15 @@ func TestEndToEndCopyAndCompare(t *testing.T) {
 	// Compare should return an error when differences are found
 	require.Error(t, err, "Compare command should find differences after adding new documents")
 
+	t.Log("Writing the documents missing in the target to a diff file...")
+	compareDetailed, compareMethod = true, "merge"
+	compareDiffOutput = filepath.Join(t.TempDir(), "diff.ndjson")
+	require.Error(t, runCompare(), "Merge comparison should find the new documents")
+	diffData, err := os.ReadFile(compareDiffOutput)
+	require.NoError(t, err)
+	assert.Contains(t, string(diffData), `"kind":"missingInTarget"`)
+	compareDetailed, compareMethod, compareDiffOutput = false, "lookup", ""
+
 	// Step 3: Run copy command again to sync the new documents
 	t.Log("Running copy command again to sync new documents...")
 	err = runCopy()
@@ -116,6 +125,11 @@ func TestEndToEndCopyAndCompare(t *testing.T) {
 	t.Log("Comparing after second copy - expecting no differences...")
 	err = runCompare()
 	require.NoError(t, err, "Compare command should find no differences after syncing")
+
+	t.Log("Comparing document by document with the hash method - expecting no differences...")
+	defer func() { compareDetailed, compareMethod = false, "lookup" }()
+	compareDetailed, compareMethod = true, "hash"
+	require.NoError(t, runCompare(), "Hash comparison should find no differences after syncing")
 }
 
 func seedMongoDB(ctx context.Context, uri string) error {
@@ -354,8 +368,10 @@ func TestEndToEndDumpRestoreAndCompare(t *testing.T) {
 	restoreExcludeDatabases = []string{}
 	restoreExcludeCollections = []string{}
 	restoreDrop = false
+	restoreVerify = true
+	defer func() { restoreVerify = false }()
 
-	// Execute restore command
+	// Execute restore command, which fails when the restored data does not match the dump
 	err = runRestore()
 	require.NoError(t, err)
 
@@ -389,6 +405,16 @@ func TestEndToEndDumpRestoreAndCompare(t *testing.T) {
 	err = runDump()
 	require.NoError(t, err)
 
+	t.Log("Restoring with --mode fail-if-not-empty - expecting an error since the target has data...")
+	defer func() { restoreMode, restoreDryRun = restoreModeInsertOnly, false }()
+	restoreMode = restoreModeFailIfNotEmpty
+	require.Error(t, runRestore(), "Restore should refuse to write into collections that have documents")
+
+	t.Log("Listing what --mode drop would do with --dry-run...")
+	restoreMode, restoreDryRun = restoreModeDrop, true
+	require.NoError(t, runRestore())
+	restoreMode, restoreDryRun = restoreModeInsertOnly, false
+
 	t.Log("Running restore command again to sync new documents...")
 	// Set drop flag to true for the second restore to replace existing data
 	restoreDrop = true
@@ -3,18 +3,25 @@ package cmd
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io/fs"
 	"log"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/spf13/cobra"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 
 	"nmongo/internal/config"
+	"nmongo/internal/dump"
 	"nmongo/internal/mongodb"
+	"nmongo/internal/storage"
 )
 
 var (
@@ -29,20 +36,41 @@ var (
 	restoreRetryAttempts      int
 	restoreStateFile          string
 	restoreDrop               bool
+	restoreMode               string
+	restoreDryRun             bool
 	restoreOplogReplay        bool
 	restorePreserveDates      bool
+	restoreEngine             string
+	restoreBatchSize          int
+	restoreParallel           int
+	restoreInsertionWorkers   int
+	restoreForce              bool
+	restoreVerify             bool
+	restoreVerifyHashes       bool
+	restoreEncryptKeyFile     string
+	restoreSegmentID          string
+	restoreUntil              string
+	// restoreUntilTime is the parsed --until point in time, zero when restoring the latest state
+	restoreUntilTime time.Time
+	// restoreKeyring holds the keys encrypted dump files are decrypted with, nil when none are configured
+	restoreKeyring *dump.Keyring
 )
 
 var restoreCmd = &cobra.Command{
 	Use:   "restore",
 	Short: "Restore MongoDB databases from dumps created by the dump command",
-	Long: `Restore MongoDB databases from dumps created by the dump command using mongorestore CLI tool.
+	Long: `Restore MongoDB databases from dumps created by the dump command using the mongorestore CLI tool
+or the native restore engine.
 Supports restoring from incremental dumps with proper state tracking.
 
 Examples:
   nmongo restore --target "mongodb://host:27017" --input ./dumps
   nmongo restore --target "mongodb://host:27017" --input ./dumps --databases "db1,db2"
-  nmongo restore --target "mongodb://host:27017" --input ./dumps --drop`,
+  nmongo restore --target "mongodb://host:27017" --input ./dumps --mode drop
+  nmongo restore --target "mongodb://host:27017" --input ./dumps --mode skip-existing-collections --dry-run
+  nmongo restore --target "mongodb://host:27017" --input ./dumps --engine native
+  nmongo restore --target "mongodb://host:27017" --input ./dumps --engine native --until 2024-05-01T14:32:00Z
+  nmongo restore --target "mongodb://host:27017" --input ./dumps --parallel-collections 8 --insertion-workers 4 --preserve-dates=false`,
 	Run: func(cmd *cobra.Command, args []string) {
 		if configFile != "" {
 			cfg, err := config.LoadConfig(configFile)
@@ -74,6 +102,9 @@ Examples:
 			if !cmd.Flags().Changed("retry-attempts") && cfg.RetryAttempts > 0 {
 				restoreRetryAttempts = cfg.RetryAttempts
 			}
+			if !cmd.Flags().Changed("batch-size") && cfg.BatchSize > 0 {
+				restoreBatchSize = cfg.BatchSize
+			}
 		}
 
 		if saveConfig {
@@ -91,6 +122,7 @@ Examples:
 				ExcludeDatabases:   restoreExcludeDatabases,
 				ExcludeCollections: restoreExcludeCollections,
 				RetryAttempts:      restoreRetryAttempts,
+				BatchSize:          restoreBatchSize,
 			}
 
 			if err := config.SaveConfig(cfg, configPath); err != nil {
@@ -121,17 +153,50 @@ func init() {
 	restoreCmd.Flags().IntVar(&restoreRetryAttempts, "retry-attempts", 5, "Number of retry attempts for failed operations")
 	restoreCmd.Flags().StringVar(&restoreStateFile, "state-file", "",
 		"Path to state file for tracking restore progress (defaults to <input>/restore-state.json)")
-	restoreCmd.Flags().BoolVar(&restoreDrop, "drop", false, "Drop collections before restoring")
+	restoreCmd.Flags().BoolVar(&restoreDrop, "drop", false, "Drop collections before restoring (same as --mode drop)")
+	restoreCmd.Flags().StringVar(&restoreMode, "mode", restoreModeInsertOnly,
+		"What to do with collections that exist in the target: "+strings.Join(restoreModes, ", "))
+	restoreCmd.Flags().BoolVar(&restoreDryRun, "dry-run", false,
+		"List what the restore mode would do with every collection without writing to the target")
 	restoreCmd.Flags().BoolVar(&restoreOplogReplay, "oplog-replay", false, "Replay oplog after restoring")
 	restoreCmd.Flags().BoolVar(&restorePreserveDates, "preserve-dates", true, "Preserve original document timestamps")
+	restoreCmd.Flags().StringVar(&restoreEngine, "engine", engineMongorestore,
+		"Restore engine: 'mongorestore' runs the mongorestore tool, 'native' restores the dump files without it")
+	restoreCmd.Flags().IntVar(&restoreBatchSize, "batch-size", 1000, "Number of documents per bulk write with the native engine")
+	restoreCmd.Flags().IntVar(&restoreParallel, "parallel-collections", 1, "Number of collections restored at a time")
+	restoreCmd.Flags().IntVar(&restoreInsertionWorkers, "insertion-workers", 1,
+		"Number of concurrent insertion workers per collection; requires --preserve-dates=false")
+	restoreCmd.Flags().BoolVar(&restoreForce, "force", false,
+		"Restore every collection and segment again, discarding the progress recorded in the state file")
+	restoreCmd.Flags().BoolVar(&restoreVerify, "verify", false,
+		"Compare every restored collection with the dump: document count and indexes")
+	restoreCmd.Flags().BoolVar(&restoreVerifyHashes, "verify-hashes", false,
+		"With --verify, also compare the hash of every restored document with the dumped document")
+	restoreCmd.Flags().StringVar(&restoreSegmentID, "segment", "",
+		"Restore the chain up to this segment of a segmented dump (defaults to the latest segment)")
+	restoreCmd.Flags().StringVar(&restoreUntil, "until", "",
+		"Restore the data as it was at this time (RFC 3339) from a dump with captured changes; requires --engine native")
+	addStorageFlags(restoreCmd)
+	restoreCmd.Flags().StringVar(&restoreEncryptKeyFile, "encrypt-key-file", "",
+		"File with the keys encrypted dumps are decrypted with (defaults to the "+dump.KeyEnvVar+" environment variable)")
 
 	restoreCmd.MarkFlagRequired("target")
 }
 
+// restoreStateVersion is the schema version of restore state files; files written before it was recorded have version 0.
+// Version 2 added the target, the restored segments and the manifest each collection was restored from,
+// version 3 the outcome of every collection.
+const restoreStateVersion = 3
+
 // RestoreState tracks the state of restores for incremental operations
 type RestoreState struct {
+	Version int `json:"version"`
+	// Target identifies the deployment restored into by its hosts
+	Target      string                            `json:"target,omitempty"`
 	Collections map[string]RestoreCollectionState `json:"collections"`
-	LastRestore time.Time                         `json:"lastRestore"`
+	// Segments holds the restored segments of a dump chain by ID
+	Segments    map[string]RestoreSegmentState `json:"segments,omitempty"`
+	LastRestore time.Time                      `json:"lastRestore"`
 }
 
 // RestoreCollectionState tracks the state of a single collection restore
@@ -139,12 +204,33 @@ type RestoreCollectionState struct {
 	LastRestoreTime time.Time `json:"lastRestoreTime"`
 	DocumentCount   int64     `json:"documentCount"`
 	Restored        bool      `json:"restored"`
+	// Action is what the restore mode did with the collection, Outcome how its restore ended
+	// and Error why it failed
+	Action  string `json:"action,omitempty"`
+	Outcome string `json:"outcome,omitempty"`
+	Error   string `json:"error,omitempty"`
+	// Segment and ManifestHash identify the dump the collection was restored from
+	Segment      string `json:"segment,omitempty"`
+	ManifestHash string `json:"manifestHash,omitempty"`
+	// Mismatches lists the differences to the dump found by --verify
+	Mismatches []string `json:"mismatches,omitempty"`
+}
+
+// RestoreSegmentState tracks the restore of a segment of a dump chain, including the replay of its changes
+type RestoreSegmentState struct {
+	ManifestHash string `json:"manifestHash"`
+	// Filters describes the database and collection filters the segment was restored with
+	Filters       string              `json:"filters"`
+	ReplayedUntil primitive.Timestamp `json:"replayedUntil"`
+	ChangesOnly   bool                `json:"changesOnly,omitempty"`
+	RestoredAt    time.Time           `json:"restoredAt"`
 }
 
 func runRestore() error {
 	logRestoreConfiguration()
+	configureStorage()
 
-	if err := checkMongorestoreInstalled(); err != nil {
+	if err := checkRestoreOptions(); err != nil {
 		return err
 	}
 
@@ -161,21 +247,129 @@ func runRestore() error {
 	defer targetClient.Disconnect(ctx)
 
 	stateFilePath := getRestoreStateFilePath()
+	lock, err := lockStateFile(stateFilePath)
+	if err != nil {
+		return err
+	}
+	defer releaseStateLock(lock)
+
+	if err := restoreWithState(ctx, targetClient, stateFilePath); err != nil {
+		return err
+	}
+	if restoreDryRun {
+		fmt.Println("Dry run completed, nothing was restored")
+		return nil
+	}
+
+	fmt.Println("MongoDB restore operation completed successfully")
+	return nil
+}
+
+// restoreWithState runs a restore from the state recorded by earlier runs, while holding the lock of the state file
+func restoreWithState(ctx context.Context, targetClient *mongodb.Client, stateFilePath string) error {
 	state, err := loadOrCreateRestoreState(stateFilePath)
 	if err != nil {
 		return err
 	}
 
-	if err := performRestore(ctx, targetClient, state); err != nil {
+	if err := performRestore(ctx, targetClient, newRestoreTracker(state, stateFilePath)); err != nil {
 		return err
 	}
+	if restoreDryRun {
+		return nil
+	}
 
 	state.LastRestore = time.Now()
 	if err := saveRestoreState(state, stateFilePath); err != nil {
 		return fmt.Errorf("failed to save restore state: %w", err)
 	}
+	return nil
+}
+
+// checkRestoreOptions validates the selected engine, point in time and mode, and loads the decryption keys
+func checkRestoreOptions() error {
+	checks := []func() error{
+		checkRestoreEngine,
+		checkRestoreUntil,
+		func() error { return checkStorageEngine(restoreInputDir, restoreEngine) },
+		checkRestoreParallelism,
+		checkRestoreMode,
+	}
+	for _, check := range checks {
+		if err := check(); err != nil {
+			return err
+		}
+	}
 
-	fmt.Println("MongoDB restore operation completed successfully")
+	keyring, err := dump.LoadKeyring(restoreEncryptKeyFile)
+	if err != nil {
+		return err
+	}
+	restoreKeyring = keyring
+	if restoreKeyring != nil {
+		fmt.Printf("Decryption keys: %s\n", strings.Join(restoreKeyring.IDs(), ", "))
+	}
+	return nil
+}
+
+// checkRestoreParallelism validates the number of collections restored at a time and of insertion workers per collection
+func checkRestoreParallelism() error {
+	if restoreParallel < 1 {
+		return fmt.Errorf("--parallel-collections must be at least 1, got %d", restoreParallel)
+	}
+	if restoreInsertionWorkers < 1 {
+		return fmt.Errorf("--insertion-workers must be at least 1, got %d", restoreInsertionWorkers)
+	}
+	if restoreInsertionWorkers > 1 && restorePreserveDates {
+		fmt.Println("Note: --preserve-dates keeps the insertion order, so each collection is restored by a single insertion worker")
+	}
+	return nil
+}
+
+// restoreWorkersPerCollection returns the number of concurrent insertion workers of a collection.
+// Keeping the insertion order of the dump requires a single worker.
+func restoreWorkersPerCollection() int {
+	if restorePreserveDates {
+		return 1
+	}
+	return max(restoreInsertionWorkers, 1)
+}
+
+// checkRestoreEngine validates the selected restore engine and its requirements
+func checkRestoreEngine() error {
+	switch restoreEngine {
+	case engineNative:
+		if restoreOplogReplay {
+			return fmt.Errorf("--oplog-replay is not supported by the %s restore engine", engineNative)
+		}
+		return nil
+	case engineMongorestore:
+		return checkMongorestoreInstalled()
+	default:
+		return fmt.Errorf("unknown restore engine %q, expected %q or %q", restoreEngine, engineNative, engineMongorestore)
+	}
+}
+
+// checkRestoreUntil parses the point in time to restore; replaying changes requires the native engine
+func checkRestoreUntil() error {
+	restoreUntilTime = time.Time{}
+	if restoreUntil == "" {
+		return nil
+	}
+
+	until, err := time.Parse(time.RFC3339, restoreUntil)
+	if err != nil {
+		return fmt.Errorf("invalid --until time, expected RFC 3339 such as 2024-05-01T14:32:00Z: %w", err)
+	}
+	if restoreEngine != engineNative {
+		return fmt.Errorf("--until requires --engine %s", engineNative)
+	}
+	if restoreSegmentID != "" {
+		return fmt.Errorf("--until and --segment cannot be used together")
+	}
+
+	restoreUntilTime = until
+	fmt.Printf("Restoring to point in time: %s\n", restoreUntilTime.UTC().Format(time.RFC3339))
 	return nil
 }
 
@@ -188,7 +382,11 @@ func checkMongorestoreInstalled() error {
 }
 
 func validateInputDirectory() error {
-	if _, err := os.Stat(restoreInputDir); os.IsNotExist(err) {
+	exists, err := storage.Exists(restoreInputDir)
+	if err != nil {
+		return fmt.Errorf("failed to check input directory: %w", err)
+	}
+	if !exists {
 		return fmt.Errorf("input directory does not exist: %s", restoreInputDir)
 	}
 	return nil
@@ -209,12 +407,22 @@ func logBasicRestoreConfig() {
 	}
 	fmt.Printf("Connection timeout: %d seconds\n", restoreTimeout)
 	fmt.Printf("Retry attempts: %d\n", restoreRetryAttempts)
+	fmt.Printf("Engine: %s\n", restoreEngine)
 }
 
 func logRestoreOptions() {
-	fmt.Printf("Drop collections before restore: %v\n", restoreDrop)
+	fmt.Printf("Mode: %s\n", selectedRestoreMode())
+	if restoreDryRun {
+		fmt.Println("Dry run: nothing is written to the target")
+	}
 	fmt.Printf("Replay oplog: %v\n", restoreOplogReplay)
+	if restoreUntil != "" {
+		fmt.Printf("Restore until: %s\n", restoreUntil)
+	}
 	fmt.Printf("Preserve dates: %v\n", restorePreserveDates)
+	fmt.Printf("Parallel collections: %d\n", restoreParallel)
+	fmt.Printf("Insertion workers per collection: %d\n", restoreInsertionWorkers)
+	fmt.Printf("Verify: %v (document hashes: %v)\n", restoreVerify, restoreVerifyHashes)
 }
 
 func logFilterRestoreConfig() {
@@ -247,36 +455,252 @@ func getRestoreStateFilePath() string {
 	if restoreStateFile != "" {
 		return restoreStateFile
 	}
-	return filepath.Join(restoreInputDir, "restore-state.json")
+	return storage.Join(restoreInputDir, "restore-state.json")
 }
 
 func loadOrCreateRestoreState(stateFilePath string) (*RestoreState, error) {
 	state, err := loadRestoreState(stateFilePath)
-	if err != nil && !os.IsNotExist(err) {
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
 		return nil, fmt.Errorf("failed to load restore state: %w", err)
 	}
 	if state == nil {
-		state = &RestoreState{Collections: make(map[string]RestoreCollectionState)}
+		state = &RestoreState{
+			Collections: make(map[string]RestoreCollectionState),
+			Segments:    make(map[string]RestoreSegmentState),
+		}
 	}
 	return state, nil
 }
 
-func performRestore(ctx context.Context, targetClient *mongodb.Client, state *RestoreState) error {
-	databasesToRestore, err := getDatabasesFromDumps()
+// restoreSource is a directory in the mongodump layout restored in one pass:
+// the input directory of a dump without segments, or one segment of a dump chain
+type restoreSource struct {
+	segmentID   string
+	dir         string
+	incremental bool
+	// replayUntil is the last cluster time of the captured changes replayed after the data, zero to replay none
+	replayUntil primitive.Timestamp
+	// changesOnly replays the captured changes of the segment without restoring its data
+	changesOnly bool
+	// manifest and manifestHash describe the files of the source; both are empty when it has no manifest
+	manifest     *dump.Manifest
+	manifestHash string
+}
+
+func performRestore(ctx context.Context, targetClient *mongodb.Client, tracker *restoreTracker) error {
+	sources, err := restoreSources()
 	if err != nil {
 		return err
 	}
+	if err := checkSourcesEngine(sources); err != nil {
+		return err
+	}
+	if err := loadSourceManifests(sources); err != nil {
+		return err
+	}
 
-	for _, dbName := range databasesToRestore {
-		if err := restoreDatabase(ctx, targetClient, dbName, state); err != nil {
-			return fmt.Errorf("failed to restore database %s: %w", dbName, err)
+	for _, source := range sources {
+		if err := restoreSegment(ctx, targetClient, source, tracker); err != nil {
+			return err
+		}
+	}
+	return tracker.verificationSummary()
+}
+
+// restoreSegment restores the data of a source and replays the changes captured with it,
+// unless the state file records the segment as restored
+func restoreSegment(ctx context.Context, targetClient *mongodb.Client, source restoreSource, tracker *restoreTracker) error {
+	if tracker.segmentRestored(source) {
+		fmt.Printf("Skipping segment %s (already restored)\n", source.segmentID)
+		return nil
+	}
+	if !source.changesOnly {
+		if source.segmentID != "" {
+			fmt.Printf("Restoring segment %s\n", source.segmentID)
+		}
+		if err := restoreFromSource(ctx, targetClient, source, tracker); err != nil {
+			return err
+		}
+	}
+	if err := replaySegmentChanges(ctx, targetClient, source); err != nil {
+		return err
+	}
+	return tracker.recordSegment(source)
+}
+
+// replaySegmentChanges replays the changes captured with a source up to its point in time
+func replaySegmentChanges(ctx context.Context, targetClient *mongodb.Client, source restoreSource) error {
+	if source.replayUntil.IsZero() {
+		return nil
+	}
+	if restoreDryRun {
+		fmt.Printf("Would replay changes of segment %s\n", source.segmentID)
+		return nil
+	}
+
+	fmt.Printf("Replaying changes of segment %s\n", source.segmentID)
+	opts := dump.ReplayOptions{
+		Until:         source.replayUntil,
+		Include:       restoreIncludesNamespace,
+		RetryAttempts: restoreRetryAttempts,
+		Keys:          restoreKeyring,
+	}
+	applied, err := dump.ReplayChanges(ctx, targetClient, source.dir, opts)
+	if err != nil {
+		return fmt.Errorf("failed to replay changes of segment %s: %w", source.segmentID, err)
+	}
+	fmt.Printf("  Replayed %d change events\n", applied)
+	return nil
+}
+
+// restoreIncludesNamespace reports whether the database and collection filters select a namespace.
+// An empty collection name stands for the whole database.
+func restoreIncludesNamespace(dbName, collName string) bool {
+	if !selectedBy(restoreDatabases, restoreExcludeDatabases, dbName) {
+		return false
+	}
+	return collName == "" || selectedBy(restoreCollections, restoreExcludeCollections, collName)
+}
+
+// selectedBy reports whether a name is in the include list, or the include list is empty, and not in the exclude list
+func selectedBy(include, exclude []string, name string) bool {
+	return (len(include) == 0 || slices.Contains(include, name)) && !slices.Contains(exclude, name)
+}
+
+// restoreSources returns the directories to restore in order: the chain of the selected segment,
+// from its full segment through its incrementals, or the input directory of a dump without segments
+func restoreSources() ([]restoreSource, error) {
+	index, err := dump.ReadChainIndex(restoreInputDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load chain index: %w", err)
+	}
+	if len(index.Segments) == 0 {
+		return unsegmentedSources()
+	}
+	if !restoreUntilTime.IsZero() {
+		return pointInTimeSources(index)
+	}
+
+	chain, err := index.Chain(restoreSegmentID)
+	if err != nil {
+		return nil, err
+	}
+	sources := make([]restoreSource, 0, len(chain))
+	for _, segment := range chain {
+		sources = append(sources, restoreSource{
+			segmentID:   segment.ID,
+			dir:         dump.SegmentDir(restoreInputDir, segment.ID),
+			incremental: segment.Incremental(),
+		})
+	}
+	return sources, nil
+}
+
+// unsegmentedSources returns the input directory of a dump without segments, in which no segment or point in time
+// can be selected
+func unsegmentedSources() ([]restoreSource, error) {
+	if restoreSegmentID != "" {
+		return nil, fmt.Errorf("--segment requires a dump with segments, %s has no chain index", restoreInputDir)
+	}
+	if !restoreUntilTime.IsZero() {
+		return nil, fmt.Errorf("--until requires a dump with segments, %s has no chain index", restoreInputDir)
+	}
+	return []restoreSource{{dir: restoreInputDir}}, nil
+}
+
+// pointInTimeSources returns the sources restoring the point in time given with --until: the chain of the latest
+// segment whose captured changes end before it, each segment replaying its own changes, followed by the changes
+// of later segments up to the point in time
+func pointInTimeSources(index *dump.ChainIndex) ([]restoreSource, error) {
+	plan, err := index.PointInTime(restoreUntilTime)
+	if err != nil {
+		return nil, err
+	}
+	if plan.CapturedUntil.Before(plan.Until) {
+		fmt.Printf("Warning: Changes were only captured up to %s, restoring to that time\n", dump.FormatClusterTime(plan.CapturedUntil))
+	}
+
+	sources := make([]restoreSource, 0, len(plan.Chain)+len(plan.Replay))
+	for _, segment := range plan.Chain {
+		source := restoreSource{
+			segmentID:   segment.ID,
+			dir:         dump.SegmentDir(restoreInputDir, segment.ID),
+			incremental: segment.Incremental(),
+		}
+		if segment.Changes != nil {
+			source.replayUntil = plan.Until
+		}
+		sources = append(sources, source)
+	}
+	for _, segment := range plan.Replay {
+		sources = append(sources, restoreSource{
+			segmentID:   segment.ID,
+			dir:         dump.SegmentDir(restoreInputDir, segment.ID),
+			replayUntil: plan.Until,
+			changesOnly: true,
+		})
+	}
+	return sources, nil
+}
+
+// checkSourcesEngine checks that the selected engine can restore the sources.
+// Incremental segments are applied with upserts, which mongorestore cannot do.
+func checkSourcesEngine(sources []restoreSource) error {
+	for _, source := range sources {
+		if source.incremental && restoreEngine != engineNative {
+			return fmt.Errorf("restoring incremental segment %s requires --engine %s", source.segmentID, engineNative)
 		}
 	}
 	return nil
 }
 
-func getDatabasesFromDumps() ([]string, error) {
-	allDatabases, err := scanDumpDirectory()
+// restoreFromSource restores the selected databases found in a source directory, up to --parallel-collections
+// collections at a time. Collections already restored from the same dump are skipped, and the targets of all
+// collections are checked before the first one is written.
+func restoreFromSource(ctx context.Context, targetClient *mongodb.Client, source restoreSource, tracker *restoreTracker) error {
+	jobs, err := collectRestoreJobs(source)
+	if err != nil {
+		return err
+	}
+	jobs = tracker.pendingJobs(source, jobs)
+	if restoreDryRun {
+		return planRestoreJobs(ctx, targetClient, source, jobs, tracker)
+	}
+	if err := checkRestoreTargets(ctx, targetClient, source, jobs); err != nil {
+		return err
+	}
+
+	progress := newRestoreProgress(len(jobs))
+	return runRestoreJobs(ctx, jobs, restoreParallel, progress, func(ctx context.Context, job restoreJob) error {
+		if err := restoreCollection(ctx, targetClient, source, job.dbName, job.collName, tracker); err != nil {
+			return errors.Join(err, tracker.recordFailure(source, job.key(), err))
+		}
+		return nil
+	})
+}
+
+// collectRestoreJobs lists the selected collections of the selected databases found in a source directory
+func collectRestoreJobs(source restoreSource) ([]restoreJob, error) {
+	databasesToRestore, err := getDatabasesFromDumps(source.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var jobs []restoreJob
+	for _, dbName := range databasesToRestore {
+		collections, err := databaseCollections(source, dbName)
+		if err != nil {
+			return nil, fmt.Errorf("failed to restore database %s: %w", dbName, err)
+		}
+		for _, collName := range collections {
+			jobs = append(jobs, restoreJob{dbName: dbName, collName: collName})
+		}
+	}
+	return jobs, nil
+}
+
+func getDatabasesFromDumps(dir string) ([]string, error) {
+	allDatabases, err := scanDumpDirectory(dir)
 	if err != nil {
 		return nil, err
 	}
@@ -285,18 +709,18 @@ func getDatabasesFromDumps() ([]string, error) {
 	return applyDatabaseFilters(databasesToRestore), nil
 }
 
-func scanDumpDirectory() ([]string, error) {
+func scanDumpDirectory(dir string) ([]string, error) {
 	var allDatabases []string
 
-	entries, err := os.ReadDir(restoreInputDir)
+	entries, err := storage.ReadDir(dir)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read input directory: %w", err)
 	}
 
 	for _, entry := range entries {
-		if entry.IsDir() && entry.Name() != "." && entry.Name() != ".." &&
-			!isSpecialFile(entry.Name()) {
-			allDatabases = append(allDatabases, entry.Name())
+		if entry.IsDir && entry.Name != "." && entry.Name != ".." &&
+			!isSpecialFile(entry.Name) {
+			allDatabases = append(allDatabases, entry.Name)
 		}
 	}
 
@@ -331,13 +755,14 @@ func isSpecialFile(name string) bool {
 	return false
 }
 
-func restoreDatabase(ctx context.Context, targetClient *mongodb.Client, dbName string, state *RestoreState) error {
+// databaseCollections returns the selected collections of a database found in a source directory
+func databaseCollections(source restoreSource, dbName string) ([]string, error) {
 	fmt.Printf("Restoring database: %s\n", dbName)
 
-	dbPath := filepath.Join(restoreInputDir, dbName)
+	dbPath := storage.Join(source.dir, dbName)
 	collectionsToRestore, err := getCollectionsFromDump(dbPath)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	originalCount := len(collectionsToRestore)
@@ -349,13 +774,7 @@ func restoreDatabase(ctx context.Context, targetClient *mongodb.Client, dbName s
 	}
 
 	fmt.Printf("  Restoring %d collections in database %s\n", len(collectionsToRestore), dbName)
-	for _, collName := range collectionsToRestore {
-		fmt.Printf("    Restoring collection: %s.%s\n", dbName, collName)
-		if err := restoreCollection(ctx, targetClient, dbName, collName, state); err != nil {
-			return fmt.Errorf("failed to restore collection %s.%s: %w", dbName, collName, err)
-		}
-	}
-	return nil
+	return collectionsToRestore, nil
 }
 
 func getCollectionsFromDump(dbPath string) ([]string, error) {
@@ -367,18 +786,18 @@ func getCollectionsFromDump(dbPath string) ([]string, error) {
 		return allCollections, nil
 	}
 
-	entries, err := os.ReadDir(dbPath)
+	entries, err := storage.ReadDir(dbPath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read database directory %s: %w", dbPath, err)
 	}
 
 	for _, entry := range entries {
-		if !entry.IsDir() && strings.HasSuffix(entry.Name(), ".bson") {
-			// Remove .bson extension to get collection name
-			collName := strings.TrimSuffix(entry.Name(), ".bson")
+		// Data files may be compressed and encrypted, e.g. users.bson.gz.enc
+		fileName := strings.TrimSuffix(strings.TrimSuffix(strings.TrimSuffix(entry.Name, dump.EncryptedSuffix), ".gz"), ".zst")
+		if !entry.IsDir && strings.HasSuffix(fileName, ".bson") {
 			// Skip special files like oplog.bson
-			if !isSpecialFile(entry.Name()) {
-				allCollections = append(allCollections, collName)
+			if !isSpecialFile(fileName) {
+				allCollections = append(allCollections, collectionFromDumpFile(fileName))
 			}
 		}
 	}
@@ -387,28 +806,127 @@ func getCollectionsFromDump(dbPath string) ([]string, error) {
 	return allCollections, nil
 }
 
-func restoreCollection(ctx context.Context, targetClient *mongodb.Client, dbName, collName string, state *RestoreState) error {
-	collKey := fmt.Sprintf("%s.%s", dbName, collName)
+// collectionFromDumpFile returns the collection restored from a .bson dump file.
+// Time-series collections are dumped as their system.buckets collection.
+func collectionFromDumpFile(fileName string) string {
+	collName := strings.TrimSuffix(fileName, ".bson")
+	if tsName, ok := mongodb.TimeSeriesCollectionName(collName); ok {
+		return tsName
+	}
+	return collName
+}
 
-	// Check if the BSON file exists
-	bsonPath := filepath.Join(restoreInputDir, dbName, collName+".bson")
-	if _, err := os.Stat(bsonPath); os.IsNotExist(err) {
+func restoreCollection(ctx context.Context, targetClient *mongodb.Client, source restoreSource,
+	dbName, collName string, tracker *restoreTracker) error {
+	collDump, found := findCollectionDump(source, dbName, collName)
+	if !found {
 		fmt.Printf("      Skipping collection %s.%s (no dump found)\n", dbName, collName)
 		return nil
 	}
+	if collDump.timeSeries {
+		fmt.Printf("      Restoring time-series collection %s.%s\n", dbName, collName)
+	}
+	if err := checkDumpFileEngine(collDump.path, collDump.compression); err != nil {
+		return err
+	}
+	return restoreCollectionData(ctx, targetClient, source, dbName, collName, collDump.timeSeries, tracker)
+}
 
-	// For mongorestore, we pass the directory containing the BSON files
-	dbPath := filepath.Join(restoreInputDir, dbName)
-	if err := executeRestoreWithRetry(dbName, collName, dbPath); err != nil {
+// restoreCollectionData restores the documents of a collection as the restore mode decides, verifies them
+// and records the outcome
+func restoreCollectionData(ctx context.Context, targetClient *mongodb.Client, source restoreSource,
+	dbName, collName string, timeSeries bool, tracker *restoreTracker) error {
+	action, _, err := planTargetCollection(ctx, targetClient, source, dbName, collName, timeSeries, tracker)
+	if err != nil {
+		return err
+	}
+	if action == actionSkip {
+		fmt.Printf("      Skipping collection %s.%s (exists in the target)\n", dbName, collName)
+		return tracker.recordSkipped(source, dbName+"."+collName)
+	}
+
+	if err := executeCollectionRestore(ctx, targetClient, source, dbName, collName, timeSeries, action); err != nil {
 		return err
 	}
+	mismatches, err := verifyRestoredCollection(ctx, targetClient, source, dbName, collName)
+	if err != nil {
+		return err
+	}
+
+	return updateRestoreCollectionState(ctx, targetClient, dbName, collName, source, action, mismatches, tracker)
+}
+
+// checkDumpFileEngine checks that the selected engine can read a dump file.
+// mongorestore cannot read zstd-compressed or encrypted files.
+func checkDumpFileEngine(path, compression string) error {
+	if restoreEngine == engineNative {
+		return nil
+	}
+	if compression == dump.CompressionZstd {
+		return fmt.Errorf("%s-compressed dumps can only be restored with --engine %s", dump.CompressionZstd, engineNative)
+	}
+	if dump.IsEncrypted(path) {
+		return fmt.Errorf("encrypted dumps can only be restored with --engine %s", engineNative)
+	}
+	return nil
+}
+
+// executeCollectionRestore restores a collection with the selected engine as the planned action decides
+func executeCollectionRestore(ctx context.Context, targetClient *mongodb.Client, source restoreSource,
+	dbName, collName string, timeSeries bool, action restoreAction) error {
+	if restoreEngine == engineNative {
+		return executeNativeRestore(ctx, targetClient, source, dbName, collName, action)
+	}
+
+	// For mongorestore, we pass the directory containing the BSON files
+	args := buildMongorestoreArgs(dbName, collName, filepath.Join(source.dir, dbName))
+	if timeSeries {
+		args = buildTimeSeriesRestoreArgs(source.dir, dbName, collName, action == actionDrop)
+	}
+	return executeRestoreWithRetry(dbName, collName, args)
+}
+
+// executeNativeRestore restores a collection with the native engine.
+// The collection is only dropped first when the planned action says so. Documents that already exist are skipped,
+// or replaced with --mode upsert. Incremental segments replace existing documents with their newer versions.
+func executeNativeRestore(ctx context.Context, targetClient *mongodb.Client, source restoreSource,
+	dbName, collName string, action restoreAction) error {
+	opts := dump.RestoreOptions{
+		InputDir:         source.dir,
+		BatchSize:        restoreBatchSize,
+		RetryAttempts:    restoreRetryAttempts,
+		Drop:             action == actionDrop,
+		Conflict:         dump.ConflictSkip,
+		Ordered:          restorePreserveDates,
+		InsertionWorkers: restoreWorkersPerCollection(),
+		Keys:             restoreKeyring,
+	}
+	if selectedRestoreMode() == restoreModeUpsert {
+		opts.Conflict = dump.ConflictUpsert
+	}
+	if source.incremental {
+		opts.Conflict = dump.ConflictUpsert
+	}
 
-	return updateRestoreCollectionState(ctx, targetClient, dbName, collName, collKey, state)
+	result, err := dump.RestoreCollection(ctx, targetClient.GetDatabase(dbName), collName, opts)
+	if err != nil {
+		return err
+	}
+	if result.Skipped > 0 {
+		fmt.Printf("      Skipped %d documents that already exist in %s.%s\n", result.Skipped, dbName, collName)
+	}
+	if result.Replaced > 0 {
+		fmt.Printf("      Updated %d existing documents in %s.%s\n", result.Replaced, dbName, collName)
+	}
+	return nil
 }
 
-func executeRestoreWithRetry(dbName, collName, collPath string) error {
-	args := buildMongorestoreArgs(dbName, collName, collPath)
+// timeSeriesBucketsFile returns the name of the dump file mongodump writes for a time-series collection
+func timeSeriesBucketsFile(collName string) string {
+	return "system.buckets." + collName + ".bson"
+}
 
+func executeRestoreWithRetry(dbName, collName string, args []string) error {
 	var lastErr error
 	for attempt := 1; attempt <= restoreRetryAttempts; attempt++ {
 		if attempt > 1 {
@@ -432,7 +950,7 @@ func executeRestoreWithRetry(dbName, collName, collPath string) error {
 
 func buildMongorestoreArgs(dbName, collName, collPath string) []string {
 	// When restoring a specific collection, pass the BSON file path directly
-	bsonPath := filepath.Join(collPath, collName+".bson")
+	bsonPath, gzipped := mongorestoreInput(collPath, collName+".bson")
 	args := []string{
 		"--uri", restoreTargetURI,
 		"--db", dbName,
@@ -444,7 +962,7 @@ func buildMongorestoreArgs(dbName, collName, collPath string) []string {
 		args = append(args, "--sslCAFile", restoreTargetCACertFile)
 	}
 
-	if restoreDrop {
+	if selectedRestoreMode() == restoreModeDrop {
 		args = append(args, "--drop")
 	}
 
@@ -456,11 +974,55 @@ func buildMongorestoreArgs(dbName, collName, collPath string) []string {
 		args = append(args, "--maintainInsertionOrder")
 	}
 
+	if workers := restoreWorkersPerCollection(); workers > 1 {
+		args = append(args, "--numInsertionWorkersPerCollection", strconv.Itoa(workers))
+	}
+
+	if gzipped {
+		args = append(args, "--gzip")
+	}
+
 	return args
 }
 
+// mongorestoreInput returns the path of a dump file for mongorestore and whether it is gzip-compressed
+func mongorestoreInput(dir, fileName string) (string, bool) {
+	path, compression, found := dump.FindFile(dir, fileName)
+	if !found {
+		return filepath.Join(dir, fileName), false
+	}
+	return path, compression == dump.CompressionGzip
+}
+
+// buildTimeSeriesRestoreArgs builds the mongorestore arguments for a time-series collection.
+// mongorestore only recreates the time-series options and buckets when restoring from the dump directory.
+// The collection is dropped first when the planned action says so.
+func buildTimeSeriesRestoreArgs(dir, dbName, collName string, drop bool) []string {
+	args := []string{
+		"--uri", restoreTargetURI,
+		"--nsInclude", dbName + "." + collName,
+	}
+	if drop {
+		args = append(args, "--drop")
+	}
+
+	if workers := restoreWorkersPerCollection(); workers > 1 {
+		args = append(args, "--numInsertionWorkersPerCollection", strconv.Itoa(workers))
+	}
+
+	if restoreTargetCACertFile != "" {
+		args = append(args, "--sslCAFile", restoreTargetCACertFile)
+	}
+
+	if _, gzipped := mongorestoreInput(filepath.Join(dir, dbName), timeSeriesBucketsFile(collName)); gzipped {
+		args = append(args, "--gzip")
+	}
+
+	return append(args, dir)
+}
+
 func updateRestoreCollectionState(ctx context.Context, targetClient *mongodb.Client,
-	dbName, collName, collKey string, state *RestoreState) error {
+	dbName, collName string, source restoreSource, action restoreAction, mismatches []string, tracker *restoreTracker) error {
 	db := targetClient.GetDatabase(dbName)
 	coll := db.Collection(collName)
 	count, err := coll.CountDocuments(ctx, map[string]interface{}{})
@@ -468,18 +1030,12 @@ func updateRestoreCollectionState(ctx context.Context, targetClient *mongodb.Cli
 		return fmt.Errorf("failed to count documents: %w", err)
 	}
 
-	state.Collections[collKey] = RestoreCollectionState{
-		LastRestoreTime: time.Now(),
-		DocumentCount:   count,
-		Restored:        true,
-	}
-
 	fmt.Printf("      Successfully restored %s.%s (%d documents)\n", dbName, collName, count)
-	return nil
+	return tracker.recordCollection(source, dbName+"."+collName, action, count, mismatches)
 }
 
 func loadRestoreState(filePath string) (*RestoreState, error) {
-	data, err := os.ReadFile(filePath)
+	data, err := storage.ReadFile(filePath)
 	if err != nil {
 		return nil, err
 	}
@@ -488,15 +1044,35 @@ func loadRestoreState(filePath string) (*RestoreState, error) {
 	if err := json.Unmarshal(data, &state); err != nil {
 		return nil, err
 	}
+	if err := migrateRestoreState(&state); err != nil {
+		return nil, err
+	}
 
 	return &state, nil
 }
 
+// migrateRestoreState upgrades a restore state read from a file written by an older version
+func migrateRestoreState(state *RestoreState) error {
+	if state.Version > restoreStateVersion {
+		return fmt.Errorf("restore state version %d is newer than the supported version %d", state.Version, restoreStateVersion)
+	}
+	if state.Collections == nil {
+		state.Collections = make(map[string]RestoreCollectionState)
+	}
+	if state.Segments == nil {
+		state.Segments = make(map[string]RestoreSegmentState)
+	}
+	state.Version = restoreStateVersion
+	return nil
+}
+
+// saveRestoreState atomically replaces the state file, so a crash leaves either the old or the new state
 func saveRestoreState(state *RestoreState, filePath string) error {
+	state.Version = restoreStateVersion
 	data, err := json.MarshalIndent(state, "", "  ")
 	if err != nil {
 		return err
 	}
 
-	return os.WriteFile(filePath, data, 0644)
+	return storage.WriteBytes(filePath, data)
 }
@@ -0,0 +1,302 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+
+	"nmongo/internal/dump"
+	"nmongo/internal/mongodb"
+	"nmongo/internal/storage"
+)
+
+// Restore modes, selecting what a restore does with collections that already exist in the target
+const (
+	restoreModeDrop           = "drop"
+	restoreModeSkipExisting   = "skip-existing-collections"
+	restoreModeInsertOnly     = "insert-only"
+	restoreModeUpsert         = "upsert"
+	restoreModeFailIfNotEmpty = "fail-if-not-empty"
+)
+
+// restoreModes lists the values of --mode
+var restoreModes = []string{
+	restoreModeDrop, restoreModeSkipExisting, restoreModeInsertOnly, restoreModeUpsert, restoreModeFailIfNotEmpty,
+}
+
+// restoreAction is what a restore does with a collection, decided by the mode and the collection's state in the target
+type restoreAction string
+
+const (
+	actionCreate restoreAction = "create"
+	actionDrop   restoreAction = "drop"
+	actionInsert restoreAction = "insert"
+	actionUpsert restoreAction = "upsert"
+	actionSkip   restoreAction = "skip"
+	actionFail   restoreAction = "fail"
+)
+
+// describe explains an action for the dry run
+func (a restoreAction) describe() string {
+	switch a {
+	case actionCreate:
+		return "create the collection and restore it"
+	case actionDrop:
+		return "drop the collection and restore it"
+	case actionInsert:
+		return "insert the documents, skipping those that exist"
+	case actionUpsert:
+		return "upsert the documents, replacing those that exist"
+	case actionSkip:
+		return "skip the collection, it exists"
+	default:
+		return "fail, the collection is not empty"
+	}
+}
+
+// Outcomes of a collection's restore recorded in the restore state
+const (
+	restoreOutcomeRestored = "restored"
+	restoreOutcomeSkipped  = "skipped"
+	restoreOutcomeFailed   = "failed"
+)
+
+// selectedRestoreMode returns the --mode, or drop when the --drop alias is set
+func selectedRestoreMode() string {
+	if restoreDrop {
+		return restoreModeDrop
+	}
+	return restoreMode
+}
+
+// checkRestoreMode validates the restore mode; upserting documents requires the native engine
+func checkRestoreMode() error {
+	if restoreDrop && restoreMode != restoreModeInsertOnly && restoreMode != restoreModeDrop {
+		return fmt.Errorf("--drop cannot be combined with --mode %s", restoreMode)
+	}
+	mode := selectedRestoreMode()
+	if !slices.Contains(restoreModes, mode) {
+		return fmt.Errorf("unknown restore mode %q, expected one of %s", mode, strings.Join(restoreModes, ", "))
+	}
+	if mode == restoreModeUpsert && restoreEngine != engineNative {
+		return fmt.Errorf("--mode %s requires --engine %s", restoreModeUpsert, engineNative)
+	}
+	return nil
+}
+
+// collectionDump is the data file of a collection in a source directory
+type collectionDump struct {
+	path        string
+	compression string
+	// timeSeries is set when the data file holds the buckets of a time-series collection
+	timeSeries bool
+}
+
+// findCollectionDump finds the data file of a collection in a source directory
+func findCollectionDump(source restoreSource, dbName, collName string) (collectionDump, bool) {
+	dbPath := storage.Join(source.dir, dbName)
+	if path, compression, found := dump.FindFile(dbPath, collName+".bson"); found {
+		return collectionDump{path: path, compression: compression}, true
+	}
+	path, compression, found := dump.FindFile(dbPath, timeSeriesBucketsFile(collName))
+	return collectionDump{path: path, compression: compression, timeSeries: true}, found
+}
+
+// targetCollection is the state of a collection in the target before it is restored
+type targetCollection struct {
+	exists bool
+	// documents is the estimated number of documents, or of buckets for a time-series collection
+	documents int64
+	// skipped is set when an earlier segment of the restore skipped the collection
+	skipped bool
+}
+
+// inspectTargetCollection looks up whether a collection exists in the target and estimates its size.
+// A time-series collection is measured by its buckets, which tells whether it is empty.
+func inspectTargetCollection(ctx context.Context, targetClient *mongodb.Client, dbName, collName string,
+	timeSeries bool) (targetCollection, error) {
+	db := targetClient.GetDatabase(dbName)
+	names, err := db.ListCollectionNames(ctx, bson.D{{Key: "name", Value: collName}})
+	if err != nil {
+		return targetCollection{}, fmt.Errorf("failed to look up %s.%s in the target: %w", dbName, collName, err)
+	}
+	if len(names) == 0 {
+		return targetCollection{}, nil
+	}
+
+	dataColl := collName
+	if timeSeries {
+		dataColl = "system.buckets." + collName
+	}
+	documents, err := db.Collection(dataColl).EstimatedDocumentCount(ctx)
+	if err != nil {
+		return targetCollection{}, fmt.Errorf("failed to count documents of %s.%s in the target: %w", dbName, collName, err)
+	}
+	return targetCollection{exists: true, documents: documents}, nil
+}
+
+// planCollection decides what the restore mode does with a collection. Incremental segments always upsert their
+// changes, except into collections an earlier segment skipped.
+func planCollection(source restoreSource, timeSeries bool, target targetCollection) restoreAction {
+	if source.incremental {
+		return incrementalAction(timeSeries, target.skipped)
+	}
+	if !target.exists {
+		return actionCreate
+	}
+	return existingCollectionAction(timeSeries, target)
+}
+
+// incrementalAction decides what an incremental segment does with a collection. Time-series dumps are always full,
+// so their collections are replaced.
+func incrementalAction(timeSeries, skipped bool) restoreAction {
+	if skipped {
+		return actionSkip
+	}
+	if timeSeries {
+		return actionDrop
+	}
+	return actionUpsert
+}
+
+// existingCollectionAction decides what the restore mode does with a collection that exists in the target.
+// Buckets cannot be merged with existing ones, so only the drop mode restores into a time-series collection that
+// is not empty, and an empty one is replaced to take the dumped options.
+func existingCollectionAction(timeSeries bool, target targetCollection) restoreAction {
+	mode := selectedRestoreMode()
+	switch {
+	case mode == restoreModeSkipExisting:
+		return actionSkip
+	case mode == restoreModeDrop:
+		return actionDrop
+	case target.documents > 0 && refusesNonEmpty(mode, timeSeries):
+		return actionFail
+	case timeSeries:
+		return actionDrop
+	case mode == restoreModeUpsert:
+		return actionUpsert
+	default:
+		return actionInsert
+	}
+}
+
+// refusesNonEmpty reports whether the restore mode fails on a collection that is not empty in the target
+func refusesNonEmpty(mode string, timeSeries bool) bool {
+	return mode == restoreModeFailIfNotEmpty || timeSeries
+}
+
+// notEmptyReason explains why the restore mode fails on a collection that is not empty in the target
+func notEmptyReason(timeSeries bool) string {
+	mode := selectedRestoreMode()
+	if timeSeries && mode != restoreModeFailIfNotEmpty {
+		return fmt.Sprintf("the time-series collection is not empty and --mode %s cannot merge its buckets, use --mode %s to replace it",
+			mode, restoreModeDrop)
+	}
+	return fmt.Sprintf("the collection is not empty and --mode is %s", restoreModeFailIfNotEmpty)
+}
+
+// checkRestoreTargets inspects the target of every job of a full source before anything is written, and fails
+// the restore when the restore mode refuses any of them. A restore stopped by one non-empty collection would
+// otherwise leave the collections restored before it in the target.
+func checkRestoreTargets(ctx context.Context, targetClient *mongodb.Client, source restoreSource, jobs []restoreJob) error {
+	if source.incremental {
+		return nil
+	}
+	var refused []string
+	for _, job := range jobs {
+		collDump, found := findCollectionDump(source, job.dbName, job.collName)
+		if !found {
+			continue
+		}
+		target, err := inspectTargetCollection(ctx, targetClient, job.dbName, job.collName, collDump.timeSeries)
+		if err != nil {
+			return err
+		}
+		if planCollection(source, collDump.timeSeries, target) == actionFail {
+			refused = append(refused, fmt.Sprintf("%s: %s (%d documents in the target)",
+				job.key(), notEmptyReason(collDump.timeSeries), target.documents))
+		}
+	}
+	if len(refused) > 0 {
+		return fmt.Errorf("nothing was restored, %d target collections are not empty:\n  %s",
+			len(refused), strings.Join(refused, "\n  "))
+	}
+	return nil
+}
+
+// planTargetCollection inspects a collection in the target and decides what the restore mode does with it.
+// The error of the fail-if-not-empty mode stops the restore before the collection is written to, in case
+// the collection was written to after checkRestoreTargets.
+func planTargetCollection(ctx context.Context, targetClient *mongodb.Client, source restoreSource, dbName, collName string,
+	timeSeries bool, tracker *restoreTracker) (restoreAction, targetCollection, error) {
+	target, err := inspectTargetCollection(ctx, targetClient, dbName, collName, timeSeries)
+	if err != nil {
+		return "", target, err
+	}
+	target.skipped = tracker.collectionSkipped(dbName + "." + collName)
+
+	action := planCollection(source, timeSeries, target)
+	if action == actionFail && !restoreDryRun {
+		return action, target, fmt.Errorf("%s (%d documents in the target)", notEmptyReason(timeSeries), target.documents)
+	}
+	return action, target, nil
+}
+
+// planRestoreJobs prints what the restore would do with every collection of a source, without writing to the target
+func planRestoreJobs(ctx context.Context, targetClient *mongodb.Client, source restoreSource, jobs []restoreJob,
+	tracker *restoreTracker) error {
+	planned := make(map[restoreAction]int)
+	for _, job := range jobs {
+		action, err := planRestoreJob(ctx, targetClient, source, job, tracker)
+		if err != nil {
+			return err
+		}
+		if action != "" {
+			planned[action]++
+		}
+	}
+
+	summary := make([]string, 0, len(planned))
+	for _, action := range []restoreAction{actionCreate, actionDrop, actionInsert, actionUpsert, actionSkip, actionFail} {
+		if planned[action] > 0 {
+			summary = append(summary, fmt.Sprintf("%s %d", action, planned[action]))
+		}
+	}
+	fmt.Printf("  Dry run: %d collections (%s)\n", len(jobs), strings.Join(summary, ", "))
+	return nil
+}
+
+// planRestoreJob prints what the restore would do with the collection of a job and returns the action,
+// or "" when the source has no dump of the collection
+func planRestoreJob(ctx context.Context, targetClient *mongodb.Client, source restoreSource, job restoreJob,
+	tracker *restoreTracker) (restoreAction, error) {
+	collDump, found := findCollectionDump(source, job.dbName, job.collName)
+	if !found {
+		fmt.Printf("    %s: no dump found\n", job.key())
+		return "", nil
+	}
+
+	action, target, err := planTargetCollection(ctx, targetClient, source, job.dbName, job.collName, collDump.timeSeries, tracker)
+	if err != nil {
+		return "", err
+	}
+	if action == actionSkip {
+		// Later segments of the dry run skip the collection as well
+		if err := tracker.recordSkipped(source, job.key()); err != nil {
+			return "", err
+		}
+	}
+	description := action.describe()
+	if action == actionFail {
+		description = "fail, " + notEmptyReason(collDump.timeSeries)
+	}
+	if target.exists {
+		fmt.Printf("    %s: would %s (%d documents in the target)\n", job.key(), description, target.documents)
+	} else {
+		fmt.Printf("    %s: would %s\n", job.key(), description)
+	}
+	return action, nil
+}
@@ -0,0 +1,256 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	tcmongodb "github.com/testcontainers/testcontainers-go/modules/mongodb"
+	"go.mongodb.org/mongo-driver/bson"
+
+	"nmongo/internal/mongodb"
+)
+
+// withRestoreModeFlags restores the flags the restore mode depends on after a test
+func withRestoreModeFlags(t *testing.T) {
+	originalMode := restoreMode
+	originalDrop := restoreDrop
+	originalEngine := restoreEngine
+	originalDryRun := restoreDryRun
+	t.Cleanup(func() {
+		restoreMode = originalMode
+		restoreDrop = originalDrop
+		restoreEngine = originalEngine
+		restoreDryRun = originalDryRun
+	})
+	restoreMode = restoreModeInsertOnly
+	restoreDrop = false
+	restoreEngine = engineNative
+	restoreDryRun = false
+}
+
+func TestCheckRestoreMode(t *testing.T) {
+	withRestoreModeFlags(t)
+
+	tests := []struct {
+		name    string
+		mode    string
+		drop    bool
+		engine  string
+		wantErr string
+	}{
+		{name: "Default", mode: restoreModeInsertOnly, engine: engineMongorestore},
+		{name: "Skip existing collections", mode: restoreModeSkipExisting, engine: engineMongorestore},
+		{name: "Fail if not empty", mode: restoreModeFailIfNotEmpty, engine: engineMongorestore},
+		{name: "Upsert", mode: restoreModeUpsert, engine: engineNative},
+		{name: "Upsert with mongorestore", mode: restoreModeUpsert, engine: engineMongorestore, wantErr: "requires --engine native"},
+		{name: "Unknown", mode: "replace", engine: engineNative, wantErr: `unknown restore mode "replace"`},
+		{name: "Drop alias", mode: restoreModeInsertOnly, drop: true, engine: engineMongorestore},
+		{name: "Drop alias with drop", mode: restoreModeDrop, drop: true, engine: engineMongorestore},
+		{name: "Drop alias with another mode", mode: restoreModeUpsert, drop: true, engine: engineNative,
+			wantErr: "--drop cannot be combined with --mode upsert"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			restoreMode, restoreDrop, restoreEngine = tt.mode, tt.drop, tt.engine
+			err := checkRestoreMode()
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+
+	restoreMode, restoreDrop = restoreModeInsertOnly, true
+	assert.Equal(t, restoreModeDrop, selectedRestoreMode())
+}
+
+func TestPlanCollection(t *testing.T) {
+	withRestoreModeFlags(t)
+	full := restoreSource{segmentID: "s1"}
+	incremental := restoreSource{segmentID: "s2", incremental: true}
+	missing := targetCollection{}
+	empty := targetCollection{exists: true}
+	filled := targetCollection{exists: true, documents: 12}
+
+	tests := []struct {
+		name       string
+		mode       string
+		source     restoreSource
+		timeSeries bool
+		target     targetCollection
+		want       restoreAction
+	}{
+		{name: "Missing collection", mode: restoreModeSkipExisting, source: full, target: missing, want: actionCreate},
+		{name: "Insert only", mode: restoreModeInsertOnly, source: full, target: filled, want: actionInsert},
+		{name: "Drop", mode: restoreModeDrop, source: full, target: filled, want: actionDrop},
+		{name: "Upsert", mode: restoreModeUpsert, source: full, target: filled, want: actionUpsert},
+		{name: "Skip existing", mode: restoreModeSkipExisting, source: full, target: empty, want: actionSkip},
+		{name: "Fail if not empty", mode: restoreModeFailIfNotEmpty, source: full, target: filled, want: actionFail},
+		{name: "Fail if not empty with empty collection", mode: restoreModeFailIfNotEmpty, source: full, target: empty,
+			want: actionInsert},
+		{name: "Time-series insert only", mode: restoreModeInsertOnly, source: full, timeSeries: true, target: filled,
+			want: actionFail},
+		{name: "Time-series upsert", mode: restoreModeUpsert, source: full, timeSeries: true, target: filled, want: actionFail},
+		{name: "Time-series drop", mode: restoreModeDrop, source: full, timeSeries: true, target: filled, want: actionDrop},
+		{name: "Empty time-series", mode: restoreModeUpsert, source: full, timeSeries: true, target: empty, want: actionDrop},
+		{name: "Time-series skip existing", mode: restoreModeSkipExisting, source: full, timeSeries: true, target: filled,
+			want: actionSkip},
+		{name: "Incremental", mode: restoreModeFailIfNotEmpty, source: incremental, target: filled, want: actionUpsert},
+		{name: "Incremental time-series", mode: restoreModeInsertOnly, source: incremental, timeSeries: true, target: filled,
+			want: actionDrop},
+		{name: "Incremental after skip", mode: restoreModeSkipExisting, source: incremental,
+			target: targetCollection{exists: true, skipped: true}, want: actionSkip},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			restoreMode = tt.mode
+			assert.Equal(t, tt.want, planCollection(tt.source, tt.timeSeries, tt.target))
+		})
+	}
+}
+
+func TestNotEmptyReason(t *testing.T) {
+	withRestoreModeFlags(t)
+
+	restoreMode = restoreModeInsertOnly
+	assert.Contains(t, notEmptyReason(true), "use --mode drop")
+	restoreMode = restoreModeFailIfNotEmpty
+	assert.Equal(t, "the collection is not empty and --mode is fail-if-not-empty", notEmptyReason(true))
+	assert.Equal(t, "the collection is not empty and --mode is fail-if-not-empty", notEmptyReason(false))
+}
+
+func TestFindCollectionDump(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "app"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "app", "users.bson.gz"), nil, 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "app", "system.buckets.metrics.bson"), nil, 0644))
+	source := restoreSource{dir: dir}
+
+	collDump, found := findCollectionDump(source, "app", "users")
+	require.True(t, found)
+	assert.Equal(t, filepath.Join(dir, "app", "users.bson.gz"), collDump.path)
+	assert.False(t, collDump.timeSeries)
+
+	collDump, found = findCollectionDump(source, "app", "metrics")
+	require.True(t, found)
+	assert.True(t, collDump.timeSeries)
+
+	_, found = findCollectionDump(source, "app", "orders")
+	assert.False(t, found)
+}
+
+func TestRestoreFailIfNotEmptyWritesNothing(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+	withRestoreModeFlags(t)
+	restoreMode = restoreModeFailIfNotEmpty
+	ctx := context.Background()
+
+	container, err := tcmongodb.Run(ctx, "mongo:8.0")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		if err := container.Terminate(ctx); err != nil {
+			t.Fatalf("failed to terminate container: %s", err)
+		}
+	})
+	uri, err := container.ConnectionString(ctx)
+	require.NoError(t, err)
+	targetClient, err := mongodb.NewClient(ctx, uri, "")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = targetClient.Disconnect(ctx) })
+
+	// Only the second collection of the dump has documents in the target
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "app"), 0755))
+	for _, collName := range []string{"customers", "orders"} {
+		data, err := bson.Marshal(bson.D{{Key: "_id", Value: collName}})
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "app", collName+".bson"), data, 0644))
+	}
+	db := targetClient.GetDatabase("app")
+	_, err = db.Collection("orders").InsertOne(ctx, bson.D{{Key: "_id", Value: "existing"}})
+	require.NoError(t, err)
+
+	tracker := newRestoreTracker(&RestoreState{Collections: make(map[string]RestoreCollectionState)}, "")
+	err = restoreFromSource(ctx, targetClient, restoreSource{dir: dir}, tracker)
+	require.ErrorContains(t, err, "app.orders")
+
+	names, err := db.ListCollectionNames(ctx, bson.D{{Key: "name", Value: "customers"}})
+	require.NoError(t, err)
+	assert.Empty(t, names, "no collection is restored when a target is not empty")
+	count, err := db.Collection("orders").CountDocuments(ctx, bson.D{})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+}
+
+func TestRestoreTrackerOutcomes(t *testing.T) {
+	withRestoreResumeFlags(t)
+	withRestoreModeFlags(t)
+	stateFile := filepath.Join(t.TempDir(), "restore-state.json")
+	state, err := loadOrCreateRestoreState(stateFile)
+	require.NoError(t, err)
+	tracker := newRestoreTracker(state, stateFile)
+	source := restoreSource{segmentID: "s1", manifestHash: "abc"}
+
+	require.NoError(t, tracker.recordCollection(source, "app.users", actionDrop, 25, nil))
+	require.NoError(t, tracker.recordSkipped(source, "app.orders"))
+	require.NoError(t, tracker.recordFailure(source, "app.events", errors.New("the collection has 3 documents")))
+
+	saved, err := loadRestoreState(stateFile)
+	require.NoError(t, err)
+	assert.Equal(t, RestoreCollectionState{
+		LastRestoreTime: saved.Collections["app.users"].LastRestoreTime,
+		DocumentCount:   25,
+		Restored:        true,
+		Action:          "drop",
+		Outcome:         restoreOutcomeRestored,
+		Segment:         "s1",
+		ManifestHash:    "abc",
+	}, saved.Collections["app.users"])
+	assert.Equal(t, restoreOutcomeSkipped, saved.Collections["app.orders"].Outcome)
+	assert.False(t, saved.Collections["app.orders"].Restored)
+	assert.Equal(t, restoreOutcomeFailed, saved.Collections["app.events"].Outcome)
+	assert.Equal(t, "the collection has 3 documents", saved.Collections["app.events"].Error)
+
+	assert.True(t, tracker.collectionSkipped("app.orders"))
+	assert.False(t, tracker.collectionSkipped("app.users"))
+
+	// Skipped and failed collections are looked at again by the next run
+	jobs := []restoreJob{{dbName: "app", collName: "users"}, {dbName: "app", collName: "orders"}, {dbName: "app", collName: "events"}}
+	assert.Equal(t, jobs[1:], tracker.pendingJobs(source, jobs))
+
+	// A dry run keeps the state in memory only
+	restoreDryRun = true
+	require.NoError(t, tracker.recordSkipped(source, "app.users"))
+	saved, err = loadRestoreState(stateFile)
+	require.NoError(t, err)
+	assert.Equal(t, restoreOutcomeRestored, saved.Collections["app.users"].Outcome)
+	assert.True(t, tracker.collectionSkipped("app.users"))
+}
+
+func TestBuildMongorestoreArgsMode(t *testing.T) {
+	withRestoreModeFlags(t)
+	originalURI := restoreTargetURI
+	originalPreserve := restorePreserveDates
+	defer func() {
+		restoreTargetURI = originalURI
+		restorePreserveDates = originalPreserve
+	}()
+	restoreTargetURI = "mongodb://localhost:27017"
+	restorePreserveDates = false
+
+	restoreMode = restoreModeDrop
+	assert.Contains(t, buildMongorestoreArgs("mydb", "users", "/backup/mydb"), "--drop")
+
+	for _, mode := range []string{restoreModeInsertOnly, restoreModeSkipExisting, restoreModeFailIfNotEmpty} {
+		restoreMode = mode
+		assert.NotContains(t, buildMongorestoreArgs("mydb", "users", "/backup/mydb"), "--drop", mode)
+	}
+}
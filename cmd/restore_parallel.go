@@ -0,0 +1,179 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// restoreProgressInterval is how often the collections being restored concurrently are reported
+const restoreProgressInterval = 10 * time.Second
+
+// restoreJob is a collection restored from a source
+type restoreJob struct {
+	dbName   string
+	collName string
+}
+
+// key returns the namespace of the collection, which is also its key in the restore state
+func (j restoreJob) key() string {
+	return j.dbName + "." + j.collName
+}
+
+// restoreFunc restores the collection of a job
+type restoreFunc func(ctx context.Context, job restoreJob) error
+
+// restoreProgress tracks the collections restored by concurrent workers for a combined progress view
+type restoreProgress struct {
+	mu        sync.Mutex
+	total     int
+	completed int
+	active    map[string]time.Time
+}
+
+func newRestoreProgress(total int) *restoreProgress {
+	return &restoreProgress{total: total, active: make(map[string]time.Time)}
+}
+
+// start records that a worker started restoring a collection
+func (p *restoreProgress) start(job restoreJob) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.active[job.key()] = time.Now()
+	fmt.Printf("    Restoring collection: %s\n", job.key())
+}
+
+// finish records that a worker is done with a collection, counting it when it was restored
+func (p *restoreProgress) finish(job restoreJob, restored bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.active, job.key())
+	if !restored {
+		return
+	}
+	p.completed++
+	if p.total > 1 {
+		fmt.Printf("  Progress: %d/%d collections restored\n", p.completed, p.total)
+	}
+}
+
+// report prints the collections being restored and for how long
+func (p *restoreProgress) report() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.active) == 0 {
+		return
+	}
+
+	active := make([]string, 0, len(p.active))
+	for collKey, started := range p.active {
+		active = append(active, fmt.Sprintf("%s (%s)", collKey, time.Since(started).Round(time.Second)))
+	}
+	sort.Strings(active)
+	fmt.Printf("  Progress: %d/%d collections restored, restoring %s\n", p.completed, p.total, strings.Join(active, ", "))
+}
+
+// reportPeriodically reports the progress at an interval until the returned function is called
+func (p *restoreProgress) reportPeriodically(interval time.Duration) func() {
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				p.report()
+			}
+		}
+	}()
+	return func() {
+		close(stop)
+		<-done
+	}
+}
+
+// restoreRun hands restore jobs to the workers and collects their errors. After the first error no further
+// jobs are started, while the collections already being restored are completed.
+type restoreRun struct {
+	queue    chan restoreJob
+	failed   chan struct{}
+	failOnce sync.Once
+	mu       sync.Mutex
+	errs     []error
+}
+
+// runRestoreJobs restores the collections of the jobs with up to parallel concurrent workers
+func runRestoreJobs(ctx context.Context, jobs []restoreJob, parallel int, progress *restoreProgress, restore restoreFunc) error {
+	run := &restoreRun{queue: make(chan restoreJob), failed: make(chan struct{})}
+
+	var wg sync.WaitGroup
+	for range min(max(parallel, 1), len(jobs)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			run.work(ctx, progress, restore)
+		}()
+	}
+	if parallel > 1 {
+		stopReport := progress.reportPeriodically(restoreProgressInterval)
+		defer stopReport()
+	}
+
+	run.enqueue(jobs)
+	wg.Wait()
+	return errors.Join(run.errs...)
+}
+
+// enqueue hands the jobs to the workers until all are taken or a job failed
+func (r *restoreRun) enqueue(jobs []restoreJob) {
+	defer close(r.queue)
+	for _, job := range jobs {
+		select {
+		case r.queue <- job:
+		case <-r.failed:
+			return
+		}
+	}
+}
+
+// work restores the collections of the jobs received until the queue is closed
+func (r *restoreRun) work(ctx context.Context, progress *restoreProgress, restore restoreFunc) {
+	for job := range r.queue {
+		if r.stopped() {
+			// The job may have been handed out while another one failed
+			continue
+		}
+		progress.start(job)
+		err := restore(ctx, job)
+		progress.finish(job, err == nil)
+		if err != nil {
+			r.fail(fmt.Errorf("failed to restore collection %s: %w", job.key(), err))
+		}
+	}
+}
+
+// fail records the error of a job and stops handing out further jobs
+func (r *restoreRun) fail(err error) {
+	r.mu.Lock()
+	r.errs = append(r.errs, err)
+	r.mu.Unlock()
+	r.failOnce.Do(func() { close(r.failed) })
+}
+
+// stopped reports whether a job failed
+func (r *restoreRun) stopped() bool {
+	select {
+	case <-r.failed:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,102 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunRestoreJobs(t *testing.T) {
+	var jobs []restoreJob
+	for i := 0; i < 10; i++ {
+		jobs = append(jobs, restoreJob{dbName: "app", collName: fmt.Sprintf("coll%d", i)})
+	}
+
+	t.Run("Restores collections concurrently", func(t *testing.T) {
+		progress := newRestoreProgress(len(jobs))
+
+		var restored sync.Map
+		var running, maxRunning atomic.Int32
+		err := runRestoreJobs(context.Background(), jobs, 3, progress, func(_ context.Context, job restoreJob) error {
+			current := running.Add(1)
+			defer running.Add(-1)
+			for {
+				previous := maxRunning.Load()
+				if current <= previous || maxRunning.CompareAndSwap(previous, current) {
+					break
+				}
+			}
+			time.Sleep(10 * time.Millisecond)
+			restored.Store(job.key(), true)
+			return nil
+		})
+		require.NoError(t, err)
+
+		assert.Equal(t, int32(3), maxRunning.Load())
+		for _, job := range jobs {
+			_, ok := restored.Load(job.key())
+			assert.True(t, ok, job.key())
+		}
+		assert.Equal(t, len(jobs), progress.completed)
+		assert.Empty(t, progress.active)
+	})
+
+	t.Run("Stops at the first failure", func(t *testing.T) {
+		progress := newRestoreProgress(len(jobs))
+
+		var mu sync.Mutex
+		var restored []string
+		err := runRestoreJobs(context.Background(), jobs, 1, progress, func(_ context.Context, job restoreJob) error {
+			if job.collName == "coll2" {
+				return errors.New("mongorestore exited with status 1")
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			restored = append(restored, job.collName)
+			return nil
+		})
+
+		assert.EqualError(t, err, "failed to restore collection app.coll2: mongorestore exited with status 1")
+		assert.Equal(t, []string{"coll0", "coll1"}, restored)
+		assert.Equal(t, 2, progress.completed)
+	})
+
+	t.Run("Completes running collections after a failure", func(t *testing.T) {
+		progress := newRestoreProgress(len(jobs))
+
+		var started, restored atomic.Int32
+		err := runRestoreJobs(context.Background(), jobs, 4, progress, func(_ context.Context, job restoreJob) error {
+			started.Add(1)
+			if job.collName == "coll0" {
+				return errors.New("validation failed")
+			}
+			time.Sleep(20 * time.Millisecond)
+			restored.Add(1)
+			return nil
+		})
+
+		assert.ErrorContains(t, err, "validation failed")
+		assert.Less(t, started.Load(), int32(len(jobs)))
+		assert.Equal(t, started.Load()-1, restored.Load())
+		assert.Equal(t, int(restored.Load()), progress.completed)
+	})
+}
+
+func TestRestoreProgressReport(t *testing.T) {
+	progress := newRestoreProgress(2)
+	progress.start(restoreJob{dbName: "app", collName: "users"})
+	progress.start(restoreJob{dbName: "app", collName: "orders"})
+	progress.finish(restoreJob{dbName: "app", collName: "orders"}, true)
+	progress.report()
+
+	assert.Equal(t, 1, progress.completed)
+	assert.Contains(t, progress.active, "app.users")
+	assert.NotContains(t, progress.active, "app.orders")
+}
@@ -0,0 +1,236 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/x/mongo/driver/connstring"
+
+	"nmongo/internal/dump"
+)
+
+// restoreTracker records the progress of a restore in its state file after every collection and segment, so a
+// restore that was interrupted resumes with the first collection it did not finish. Progress only counts for
+// the dump that was restored: a collection or segment is skipped when the manifest it was restored from is unchanged.
+type restoreTracker struct {
+	mu    sync.Mutex
+	state *RestoreState
+	path  string
+	// verified counts the collections verified by this run, mismatches lists the problems found by collection
+	// and mismatchedSegments holds the segments of the collections with problems
+	verified           int
+	mismatches         map[string][]string
+	mismatchedSegments map[string]bool
+}
+
+// newRestoreTracker prepares the state of earlier runs for resuming. The recorded progress is discarded with --force
+// and when it was recorded for another target.
+func newRestoreTracker(state *RestoreState, path string) *restoreTracker {
+	target := restoreTargetID()
+	if restoreForce {
+		fmt.Println("Restoring every collection again (--force)")
+		resetRestoreProgress(state)
+	} else if state.Target != "" && target != "" && state.Target != target {
+		fmt.Printf("Restore state was recorded for target %s, restoring every collection again\n", state.Target)
+		resetRestoreProgress(state)
+	}
+	state.Target = target
+	return &restoreTracker{
+		state:              state,
+		path:               path,
+		mismatches:         make(map[string][]string),
+		mismatchedSegments: make(map[string]bool),
+	}
+}
+
+// resetRestoreProgress discards the collections and segments recorded as restored
+func resetRestoreProgress(state *RestoreState) {
+	state.Collections = make(map[string]RestoreCollectionState)
+	state.Segments = make(map[string]RestoreSegmentState)
+}
+
+// restoreTargetID identifies the target deployment by its hosts, leaving out the credentials of the connection string
+func restoreTargetID() string {
+	cs, err := connstring.Parse(restoreTargetURI)
+	if err != nil {
+		return ""
+	}
+	return strings.Join(cs.Hosts, ",")
+}
+
+// restoreFilters describes the database and collection filters, which select what the restore of a segment covers
+func restoreFilters() string {
+	return fmt.Sprintf("databases=%s;collections=%s;exclude-databases=%s;exclude-collections=%s",
+		strings.Join(restoreDatabases, ","), strings.Join(restoreCollections, ","),
+		strings.Join(restoreExcludeDatabases, ","), strings.Join(restoreExcludeCollections, ","))
+}
+
+// loadSourceManifests reads the manifest of every source with its hash. Sources without a manifest have no hash,
+// so their collections are always restored.
+func loadSourceManifests(sources []restoreSource) error {
+	for i := range sources {
+		hash, err := dump.ManifestHash(sources[i].dir)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read manifest of %s: %w", sources[i].dir, err)
+		}
+		manifest, err := dump.ReadManifest(sources[i].dir)
+		if err != nil {
+			return fmt.Errorf("failed to read manifest of %s: %w", sources[i].dir, err)
+		}
+		sources[i].manifestHash = hash
+		sources[i].manifest = manifest
+	}
+	return nil
+}
+
+// segmentRestored reports whether a segment was restored from the same manifest, with the same filters and up to
+// the same point in time
+func (t *restoreTracker) segmentRestored(source restoreSource) bool {
+	if source.segmentID == "" || source.manifestHash == "" {
+		return false
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	segment, ok := t.state.Segments[source.segmentID]
+	return ok && segment.ManifestHash == source.manifestHash && segment.Filters == restoreFilters() &&
+		segment.ReplayedUntil.Equal(source.replayUntil) && segment.ChangesOnly == source.changesOnly
+}
+
+// pendingJobs returns the jobs whose collection was not yet restored from the manifest of the source
+func (t *restoreTracker) pendingJobs(source restoreSource, jobs []restoreJob) []restoreJob {
+	if source.manifestHash == "" {
+		return jobs
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	pending := make([]restoreJob, 0, len(jobs))
+	for _, job := range jobs {
+		collState := t.state.Collections[job.key()]
+		if !collState.Restored || collState.ManifestHash != source.manifestHash || collState.Segment != source.segmentID {
+			pending = append(pending, job)
+		}
+	}
+	if skipped := len(jobs) - len(pending); skipped > 0 {
+		fmt.Printf("  Skipping %d collections that are already restored, %d remaining\n", skipped, len(pending))
+	}
+	return pending
+}
+
+// collectionSkipped reports whether the restore mode skipped a collection that exists in the target
+func (t *restoreTracker) collectionSkipped(collKey string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.state.Collections[collKey].Outcome == restoreOutcomeSkipped
+}
+
+// recordCollection records a restored collection with the action of the restore mode and the mismatches its verification
+// found, and saves the state file. A collection with mismatches is not recorded as restored, so it is restored again
+// by the next run.
+func (t *restoreTracker) recordCollection(source restoreSource, collKey string, action restoreAction, count int64,
+	mismatches []string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.state.Collections[collKey] = RestoreCollectionState{
+		LastRestoreTime: time.Now(),
+		DocumentCount:   count,
+		Restored:        len(mismatches) == 0,
+		Action:          string(action),
+		Outcome:         restoreOutcomeRestored,
+		Segment:         source.segmentID,
+		ManifestHash:    source.manifestHash,
+		Mismatches:      mismatches,
+	}
+	if restoreVerify {
+		t.verified++
+	}
+	if len(mismatches) > 0 {
+		t.mismatches[collKey] = mismatches
+		t.mismatchedSegments[source.segmentID] = true
+	}
+	return t.save()
+}
+
+// recordSkipped records a collection the restore mode skipped since it exists in the target, and saves the state file.
+// It is not recorded as restored, so the next run looks at the target again.
+func (t *restoreTracker) recordSkipped(source restoreSource, collKey string) error {
+	return t.recordOutcome(source, collKey, RestoreCollectionState{Action: string(actionSkip), Outcome: restoreOutcomeSkipped})
+}
+
+// recordFailure records the error of a collection that could not be restored, and saves the state file
+func (t *restoreTracker) recordFailure(source restoreSource, collKey string, err error) error {
+	return t.recordOutcome(source, collKey, RestoreCollectionState{Outcome: restoreOutcomeFailed, Error: err.Error()})
+}
+
+// recordOutcome records a collection that was not restored and saves the state file
+func (t *restoreTracker) recordOutcome(source restoreSource, collKey string, collState RestoreCollectionState) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	collState.LastRestoreTime = time.Now()
+	collState.Segment = source.segmentID
+	collState.ManifestHash = source.manifestHash
+	t.state.Collections[collKey] = collState
+	return t.save()
+}
+
+// recordSegment records a restored segment and saves the state file. A segment with collections whose verification
+// found mismatches is not recorded, so it is restored again by the next run.
+func (t *restoreTracker) recordSegment(source restoreSource) error {
+	if source.segmentID == "" || source.manifestHash == "" {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.mismatchedSegments[source.segmentID] {
+		return nil
+	}
+	t.state.Segments[source.segmentID] = RestoreSegmentState{
+		ManifestHash:  source.manifestHash,
+		Filters:       restoreFilters(),
+		ReplayedUntil: source.replayUntil,
+		ChangesOnly:   source.changesOnly,
+		RestoredAt:    time.Now(),
+	}
+	return t.save()
+}
+
+// verificationSummary prints the outcome of verifying the restored collections with --verify, and returns an error
+// when a collection did not match its dump
+func (t *restoreTracker) verificationSummary() error {
+	if !restoreVerify || restoreDryRun {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	fmt.Printf("Verified %d restored collections, %d with mismatches\n", t.verified, len(t.mismatches))
+	if len(t.mismatches) == 0 {
+		return nil
+	}
+
+	for _, collKey := range slices.Sorted(maps.Keys(t.mismatches)) {
+		for _, problem := range t.mismatches[collKey] {
+			fmt.Printf("  %s: %s\n", collKey, problem)
+		}
+	}
+	return fmt.Errorf("restored data does not match the dump in %d collections", len(t.mismatches))
+}
+
+// save writes the state file; the caller holds the mutex. A dry run only keeps the state in memory.
+func (t *restoreTracker) save() error {
+	if restoreDryRun {
+		return nil
+	}
+	if err := saveRestoreState(t.state, t.path); err != nil {
+		return fmt.Errorf("failed to save restore state: %w", err)
+	}
+	return nil
+}
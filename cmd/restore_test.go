@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"math"
 	"os"
 	"path/filepath"
 	"testing"
@@ -11,7 +12,10 @@ import (
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+
+	"nmongo/internal/dump"
 )
 
 func TestRestoreStateOperations(t *testing.T) {
@@ -57,6 +61,21 @@ func TestRestoreStateOperations(t *testing.T) {
 		assert.True(t, os.IsNotExist(err))
 	})
 
+	t.Run("MigrateRestoreState", func(t *testing.T) {
+		stateFile := filepath.Join(t.TempDir(), "restore-state.json")
+
+		require.NoError(t, os.WriteFile(stateFile, []byte(`{"lastRestore": "2024-05-01T00:00:00Z"}`), 0644))
+		state, err := loadRestoreState(stateFile)
+		require.NoError(t, err)
+		assert.Equal(t, restoreStateVersion, state.Version)
+		assert.NotNil(t, state.Collections)
+		assert.NotNil(t, state.Segments)
+
+		require.NoError(t, os.WriteFile(stateFile, []byte(`{"version": 99}`), 0644))
+		_, err = loadRestoreState(stateFile)
+		assert.ErrorContains(t, err, "restore state version 99 is newer than the supported version")
+	})
+
 	t.Run("LoadRestoreStateInvalidJSON", func(t *testing.T) {
 		tempDir := t.TempDir()
 		stateFile := filepath.Join(tempDir, "invalid-restore-state.json")
@@ -230,7 +249,7 @@ func TestGetDatabasesFromDumps(t *testing.T) {
 		restoreInputDir = tempDir
 		restoreDatabases = []string{} // Use all found databases
 
-		databases, err := getDatabasesFromDumps()
+		databases, err := getDatabasesFromDumps(restoreInputDir)
 		require.NoError(t, err)
 		assert.Contains(t, databases, "db1")
 		assert.Contains(t, databases, "db2")
@@ -285,6 +304,7 @@ func TestGetCollectionsFromDump(t *testing.T) {
 		os.WriteFile(filepath.Join(dbPath, "users.bson"), []byte(""), 0644)
 		os.WriteFile(filepath.Join(dbPath, "products.bson"), []byte(""), 0644)
 		os.WriteFile(filepath.Join(dbPath, "orders.bson"), []byte(""), 0644)
+		os.WriteFile(filepath.Join(dbPath, "system.buckets.metrics.bson"), []byte(""), 0644)
 		// Create files that should be ignored
 		os.WriteFile(filepath.Join(dbPath, "metadata.json"), []byte("{}"), 0644)
 		os.WriteFile(filepath.Join(dbPath, "oplog.bson"), []byte(""), 0644)
@@ -296,11 +316,32 @@ func TestGetCollectionsFromDump(t *testing.T) {
 		assert.Contains(t, collections, "users")
 		assert.Contains(t, collections, "products")
 		assert.Contains(t, collections, "orders")
+		// Time-series collections are restored under their own name
+		assert.Contains(t, collections, "metrics")
+		assert.NotContains(t, collections, "system.buckets.metrics")
 		// Non-BSON files and special files should not be included
 		assert.NotContains(t, collections, "metadata.json")
 		assert.NotContains(t, collections, "oplog")
 	})
 
+	t.Run("CompressedFiles", func(t *testing.T) {
+		originalCollections := restoreCollections
+		defer func() { restoreCollections = originalCollections }()
+
+		dbPath := t.TempDir()
+		os.WriteFile(filepath.Join(dbPath, "users.bson.gz"), []byte(""), 0644)
+		os.WriteFile(filepath.Join(dbPath, "users.metadata.json.gz"), []byte(""), 0644)
+		os.WriteFile(filepath.Join(dbPath, "system.buckets.metrics.bson.zst"), []byte(""), 0644)
+		os.WriteFile(filepath.Join(dbPath, "orders.bson.gz.enc"), []byte(""), 0644)
+		os.WriteFile(filepath.Join(dbPath, "oplog.bson.gz"), []byte(""), 0644)
+
+		restoreCollections = []string{}
+
+		collections, err := getCollectionsFromDump(dbPath)
+		require.NoError(t, err)
+		assert.ElementsMatch(t, []string{"users", "metrics", "orders"}, collections)
+	})
+
 	t.Run("NonExistentDirectory", func(t *testing.T) {
 		originalCollections := restoreCollections
 		defer func() { restoreCollections = originalCollections }()
@@ -590,3 +631,353 @@ func TestBuildMongorestoreArgsWithMinimalOptions(t *testing.T) {
 	assert.NotContains(t, args, "--oplogReplay")
 	assert.NotContains(t, args, "--maintainInsertionOrder")
 }
+
+func TestBuildTimeSeriesRestoreArgs(t *testing.T) {
+	originalURI := restoreTargetURI
+	originalCAFile := restoreTargetCACertFile
+	originalInputDir := restoreInputDir
+	defer func() {
+		restoreTargetURI = originalURI
+		restoreTargetCACertFile = originalCAFile
+		restoreInputDir = originalInputDir
+	}()
+
+	restoreTargetURI = "mongodb://localhost:27017"
+	restoreTargetCACertFile = "/path/to/ca.pem"
+	restoreInputDir = "/backup/restore"
+
+	args := buildTimeSeriesRestoreArgs(restoreInputDir, "mydb", "metrics", true)
+
+	expected := []string{
+		"--uri", "mongodb://localhost:27017",
+		"--nsInclude", "mydb.metrics",
+		"--drop",
+		"--sslCAFile", "/path/to/ca.pem",
+		"/backup/restore",
+	}
+	assert.Equal(t, expected, args)
+
+	// Only the drop action drops the collection
+	assert.NotContains(t, buildTimeSeriesRestoreArgs(restoreInputDir, "mydb", "metrics", false), "--drop")
+}
+
+func TestCheckRestoreEngine(t *testing.T) {
+	originalEngine := restoreEngine
+	originalOplogReplay := restoreOplogReplay
+	defer func() {
+		restoreEngine = originalEngine
+		restoreOplogReplay = originalOplogReplay
+	}()
+
+	restoreEngine = engineNative
+	restoreOplogReplay = false
+	assert.NoError(t, checkRestoreEngine())
+
+	restoreOplogReplay = true
+	err := checkRestoreEngine()
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "--oplog-replay")
+
+	restoreEngine = "pg_restore"
+	err = checkRestoreEngine()
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "unknown restore engine")
+}
+
+func TestBuildMongorestoreArgsGzip(t *testing.T) {
+	originalURI := restoreTargetURI
+	originalInputDir := restoreInputDir
+	defer func() {
+		restoreTargetURI = originalURI
+		restoreInputDir = originalInputDir
+	}()
+
+	restoreTargetURI = "mongodb://localhost:27017"
+	restoreInputDir = t.TempDir()
+	dbPath := filepath.Join(restoreInputDir, "mydb")
+	require.NoError(t, os.MkdirAll(dbPath, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dbPath, "users.bson.gz"), []byte(""), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dbPath, "system.buckets.metrics.bson.gz"), []byte(""), 0644))
+
+	args := buildMongorestoreArgs("mydb", "users", dbPath)
+	assert.Contains(t, args, filepath.Join(dbPath, "users.bson.gz"))
+	assert.Contains(t, args, "--gzip")
+
+	args = buildTimeSeriesRestoreArgs(restoreInputDir, "mydb", "metrics", true)
+	assert.Contains(t, args, "--gzip")
+	assert.Equal(t, restoreInputDir, args[len(args)-1])
+}
+
+func TestCheckDumpFileEngine(t *testing.T) {
+	originalEngine := restoreEngine
+	defer func() { restoreEngine = originalEngine }()
+
+	tests := []struct {
+		engine      string
+		path        string
+		compression string
+		expectError bool
+	}{
+		{engine: engineMongorestore, path: "users.bson.gz", compression: dump.CompressionGzip},
+		{engine: engineMongorestore, path: "users.bson.zst", compression: dump.CompressionZstd, expectError: true},
+		{engine: engineMongorestore, path: "users.bson.enc", compression: dump.CompressionNone, expectError: true},
+		{engine: engineNative, path: "users.bson.zst.enc", compression: dump.CompressionZstd},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.engine+"/"+tt.path, func(t *testing.T) {
+			restoreEngine = tt.engine
+			err := checkDumpFileEngine(tt.path, tt.compression)
+			if tt.expectError {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestRestoreSources(t *testing.T) {
+	originalInputDir := restoreInputDir
+	originalSegmentID := restoreSegmentID
+	originalEngine := restoreEngine
+	defer func() {
+		restoreInputDir = originalInputDir
+		restoreSegmentID = originalSegmentID
+		restoreEngine = originalEngine
+	}()
+
+	restoreInputDir = t.TempDir()
+	restoreSegmentID = ""
+
+	// Dumps without segments are restored from the input directory
+	sources, err := restoreSources()
+	require.NoError(t, err)
+	assert.Equal(t, []restoreSource{{dir: restoreInputDir}}, sources)
+
+	restoreSegmentID = "20240501T000000.000Z-full"
+	_, err = restoreSources()
+	assert.ErrorContains(t, err, "--segment requires a dump with segments")
+
+	index, err := dump.ReadChainIndex(restoreInputDir)
+	require.NoError(t, err)
+	full := index.NewSegment(true, time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC))
+	index.Add(full)
+	incremental := index.NewSegment(true, time.Date(2024, 5, 2, 0, 0, 0, 0, time.UTC))
+	index.Add(incremental)
+	require.NoError(t, dump.WriteChainIndex(restoreInputDir, index))
+
+	restoreSegmentID = ""
+	sources, err = restoreSources()
+	require.NoError(t, err)
+	assert.Equal(t, []restoreSource{
+		{segmentID: full.ID, dir: dump.SegmentDir(restoreInputDir, full.ID)},
+		{segmentID: incremental.ID, dir: dump.SegmentDir(restoreInputDir, incremental.ID), incremental: true},
+	}, sources)
+
+	restoreEngine = engineMongorestore
+	assert.ErrorContains(t, checkSourcesEngine(sources), "requires --engine native")
+	assert.NoError(t, checkSourcesEngine(sources[:1]))
+	restoreEngine = engineNative
+	assert.NoError(t, checkSourcesEngine(sources))
+
+	// A segment selects the chain up to it
+	restoreSegmentID = full.ID
+	sources, err = restoreSources()
+	require.NoError(t, err)
+	assert.Len(t, sources, 1)
+
+	restoreSegmentID = "unknown"
+	_, err = restoreSources()
+	assert.ErrorContains(t, err, "segment unknown not found")
+}
+
+func TestCheckRestoreUntil(t *testing.T) {
+	originalUntil := restoreUntil
+	originalEngine := restoreEngine
+	originalSegmentID := restoreSegmentID
+	defer func() {
+		restoreUntil = originalUntil
+		restoreEngine = originalEngine
+		restoreSegmentID = originalSegmentID
+		restoreUntilTime = time.Time{}
+	}()
+
+	tests := []struct {
+		name        string
+		until       string
+		engine      string
+		segmentID   string
+		expected    time.Time
+		expectError string
+	}{
+		{name: "NotSet", engine: engineMongorestore},
+		{name: "UTC", until: "2024-05-01T14:32:00Z", engine: engineNative, expected: time.Date(2024, 5, 1, 14, 32, 0, 0, time.UTC)},
+		{name: "Offset", until: "2024-05-01T16:32:00+02:00", engine: engineNative, expected: time.Date(2024, 5, 1, 14, 32, 0, 0, time.UTC)},
+		{name: "InvalidTime", until: "yesterday 14:32", engine: engineNative, expectError: "invalid --until time"},
+		{name: "Mongorestore", until: "2024-05-01T14:32:00Z", engine: engineMongorestore, expectError: "--until requires --engine native"},
+		{name: "WithSegment", until: "2024-05-01T14:32:00Z", engine: engineNative, segmentID: "x", expectError: "cannot be used together"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			restoreUntil = tt.until
+			restoreEngine = tt.engine
+			restoreSegmentID = tt.segmentID
+
+			err := checkRestoreUntil()
+			if tt.expectError != "" {
+				assert.ErrorContains(t, err, tt.expectError)
+				return
+			}
+			require.NoError(t, err)
+			assert.True(t, tt.expected.Equal(restoreUntilTime), "expected %v, got %v", tt.expected, restoreUntilTime)
+		})
+	}
+}
+
+func TestRestoreIncludesNamespace(t *testing.T) {
+	originalDatabases := restoreDatabases
+	originalCollections := restoreCollections
+	originalExcludeDatabases := restoreExcludeDatabases
+	originalExcludeCollections := restoreExcludeCollections
+	defer func() {
+		restoreDatabases = originalDatabases
+		restoreCollections = originalCollections
+		restoreExcludeDatabases = originalExcludeDatabases
+		restoreExcludeCollections = originalExcludeCollections
+	}()
+
+	restoreDatabases = nil
+	restoreCollections = nil
+	restoreExcludeDatabases = nil
+	restoreExcludeCollections = nil
+	assert.True(t, restoreIncludesNamespace("app", "orders"))
+	assert.True(t, restoreIncludesNamespace("app", ""))
+
+	restoreDatabases = []string{"app"}
+	restoreExcludeCollections = []string{"sessions"}
+	assert.True(t, restoreIncludesNamespace("app", "orders"))
+	assert.True(t, restoreIncludesNamespace("app", ""))
+	assert.False(t, restoreIncludesNamespace("app", "sessions"))
+	assert.False(t, restoreIncludesNamespace("other", "orders"))
+	assert.False(t, restoreIncludesNamespace("other", ""))
+
+	restoreDatabases = nil
+	restoreExcludeDatabases = []string{"test"}
+	restoreCollections = []string{"orders"}
+	assert.True(t, restoreIncludesNamespace("app", "orders"))
+	assert.False(t, restoreIncludesNamespace("app", "users"))
+	assert.False(t, restoreIncludesNamespace("test", "orders"))
+}
+
+func TestPointInTimeSources(t *testing.T) {
+	originalInputDir := restoreInputDir
+	originalSegmentID := restoreSegmentID
+	defer func() {
+		restoreInputDir = originalInputDir
+		restoreSegmentID = originalSegmentID
+		restoreUntilTime = time.Time{}
+	}()
+
+	restoreInputDir = t.TempDir()
+	restoreSegmentID = ""
+	restoreUntilTime = time.Date(2024, 5, 1, 2, 30, 0, 0, time.UTC)
+
+	_, err := restoreSources()
+	assert.ErrorContains(t, err, "--until requires a dump with segments")
+
+	at := func(hour int) primitive.Timestamp {
+		return primitive.Timestamp{T: uint32(time.Date(2024, 5, 1, hour, 0, 0, 0, time.UTC).Unix())}
+	}
+	index, err := dump.ReadChainIndex(restoreInputDir)
+	require.NoError(t, err)
+	full := index.NewSegment(true, time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC))
+	full.Changes = &dump.ChangeRange{Start: at(0), End: at(1)}
+	index.Add(full)
+	incremental := index.NewSegment(true, time.Date(2024, 5, 1, 1, 0, 0, 0, time.UTC))
+	incremental.Changes = &dump.ChangeRange{Start: at(1), End: at(2)}
+	index.Add(incremental)
+	next := index.NewSegment(false, time.Date(2024, 5, 1, 2, 0, 0, 0, time.UTC))
+	next.Changes = &dump.ChangeRange{Start: at(2), End: at(3)}
+	index.Add(next)
+	require.NoError(t, dump.WriteChainIndex(restoreInputDir, index))
+
+	// The chain ending before 02:30 is restored with its changes, then the changes of the next segment up to 02:30
+	until := primitive.Timestamp{T: uint32(restoreUntilTime.Unix()), I: math.MaxUint32}
+	sources, err := restoreSources()
+	require.NoError(t, err)
+	assert.Equal(t, []restoreSource{
+		{segmentID: full.ID, dir: dump.SegmentDir(restoreInputDir, full.ID), replayUntil: until},
+		{segmentID: incremental.ID, dir: dump.SegmentDir(restoreInputDir, incremental.ID), incremental: true, replayUntil: until},
+		{segmentID: next.ID, dir: dump.SegmentDir(restoreInputDir, next.ID), replayUntil: until, changesOnly: true},
+	}, sources)
+
+	restoreUntilTime = time.Date(2024, 4, 30, 0, 0, 0, 0, time.UTC)
+	_, err = restoreSources()
+	assert.ErrorContains(t, err, "no segment with captured changes")
+}
+
+func TestCheckRestoreParallelism(t *testing.T) {
+	originalParallel := restoreParallel
+	originalWorkers := restoreInsertionWorkers
+	defer func() {
+		restoreParallel = originalParallel
+		restoreInsertionWorkers = originalWorkers
+	}()
+
+	restoreParallel, restoreInsertionWorkers = 4, 2
+	assert.NoError(t, checkRestoreParallelism())
+
+	restoreParallel = 0
+	assert.ErrorContains(t, checkRestoreParallelism(), "--parallel-collections")
+
+	restoreParallel, restoreInsertionWorkers = 1, 0
+	assert.ErrorContains(t, checkRestoreParallelism(), "--insertion-workers")
+}
+
+func TestBuildMongorestoreArgsInsertionWorkers(t *testing.T) {
+	originalURI := restoreTargetURI
+	originalCAFile := restoreTargetCACertFile
+	originalDrop := restoreDrop
+	originalOplog := restoreOplogReplay
+	originalPreserve := restorePreserveDates
+	originalWorkers := restoreInsertionWorkers
+	defer func() {
+		restoreTargetURI = originalURI
+		restoreTargetCACertFile = originalCAFile
+		restoreDrop = originalDrop
+		restoreOplogReplay = originalOplog
+		restorePreserveDates = originalPreserve
+		restoreInsertionWorkers = originalWorkers
+	}()
+
+	restoreTargetURI = "mongodb://localhost:27017"
+	restoreTargetCACertFile = ""
+	restoreDrop = false
+	restoreOplogReplay = false
+	restoreInsertionWorkers = 4
+
+	restorePreserveDates = false
+	args := buildMongorestoreArgs("mydb", "users", "/backup/mydb")
+	assert.Equal(t, []string{
+		"--uri", "mongodb://localhost:27017",
+		"--db", "mydb",
+		"--collection", "users",
+		filepath.Join("/backup/mydb", "users.bson"),
+		"--numInsertionWorkersPerCollection", "4",
+	}, args)
+	assert.Equal(t, []string{
+		"--uri", "mongodb://localhost:27017",
+		"--nsInclude", "mydb.metrics",
+		"--drop",
+		"--numInsertionWorkersPerCollection", "4",
+		"/backup",
+	}, buildTimeSeriesRestoreArgs("/backup", "mydb", "metrics", true))
+
+	// Keeping the insertion order requires a single worker
+	restorePreserveDates = true
+	args = buildMongorestoreArgs("mydb", "users", "/backup/mydb")
+	assert.Contains(t, args, "--maintainInsertionOrder")
+	assert.NotContains(t, args, "--numInsertionWorkersPerCollection")
+}
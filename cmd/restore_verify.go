@@ -0,0 +1,38 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+
+	"nmongo/internal/dump"
+	"nmongo/internal/mongodb"
+)
+
+// verifyRestoredCollection compares a restored collection with its dump when --verify is set and returns the mismatches.
+// Collections of incremental segments are not compared by count, since the segment only holds the changed documents.
+func verifyRestoredCollection(ctx context.Context, targetClient *mongodb.Client, source restoreSource,
+	dbName, collName string) ([]string, error) {
+	if !restoreVerify {
+		return nil, nil
+	}
+
+	opts := dump.CheckOptions{
+		InputDir:  source.dir,
+		Manifest:  source.manifest,
+		Counts:    !source.incremental,
+		Hashes:    restoreVerifyHashes,
+		BatchSize: restoreBatchSize,
+		Keys:      restoreKeyring,
+	}
+	result, err := dump.CheckRestoredCollection(ctx, targetClient.GetDatabase(dbName), collName, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to verify %s.%s: %w", dbName, collName, err)
+	}
+
+	if result.OK() {
+		fmt.Printf("      Verified %s.%s: %d documents, %d indexes\n", dbName, collName, result.Documents, result.Indexes)
+	} else {
+		fmt.Printf("      Verification of %s.%s found %d mismatches\n", dbName, collName, len(result.Problems))
+	}
+	return result.Problems, nil
+}
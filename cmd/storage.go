@@ -0,0 +1,58 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"nmongo/internal/storage"
+)
+
+var (
+	storageS3Endpoint string
+	storageS3Region   string
+)
+
+// addStorageFlags adds the flags configuring access to dumps in S3-compatible object storage
+func addStorageFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&storageS3Endpoint, "s3-endpoint", "",
+		"Endpoint of S3-compatible storage for s3:// locations, e.g. http://localhost:9000 (defaults to AWS_ENDPOINT_URL_S3 or AWS S3)")
+	cmd.Flags().StringVar(&storageS3Region, "s3-region", "",
+		"Region of the S3 bucket (defaults to AWS_REGION, AWS_DEFAULT_REGION or us-east-1)")
+}
+
+// configureStorage applies the object storage flags. Credentials are taken from
+// AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN.
+func configureStorage() {
+	storage.ConfigureS3(storage.S3Config{Endpoint: storageS3Endpoint, Region: storageS3Region})
+}
+
+// checkStorageEngine rejects object storage locations for the external tools, which only work with local directories
+func checkStorageEngine(location, engine string) error {
+	if storage.IsRemote(location) && engine != engineNative {
+		return fmt.Errorf("%s is in object storage, which requires --engine %s", location, engineNative)
+	}
+	return nil
+}
+
+// lockStateFile acquires the lock file next to a state file, so overlapping runs on the same dump cannot
+// overwrite each other's progress
+func lockStateFile(stateFilePath string) (*storage.Lock, error) {
+	lock, err := storage.AcquireLock(stateFilePath + ".lock")
+	var locked *storage.LockedError
+	if errors.As(err, &locked) {
+		return nil, fmt.Errorf("another run is using state file %s: %w", stateFilePath, err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock state file: %w", err)
+	}
+	return lock, nil
+}
+
+// releaseStateLock releases the lock of a state file; a lock that cannot be removed becomes stale on its own
+func releaseStateLock(lock *storage.Lock) {
+	if err := lock.Release(); err != nil {
+		fmt.Printf("Warning: failed to release state file lock: %v\n", err)
+	}
+}
@@ -0,0 +1,34 @@
+package cmd
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckStorageEngine(t *testing.T) {
+	assert.NoError(t, checkStorageEngine("./dumps", engineMongodump))
+	assert.NoError(t, checkStorageEngine("s3://backups/nightly", engineNative))
+	assert.ErrorContains(t, checkStorageEngine("s3://backups/nightly", engineMongorestore), "requires --engine native")
+}
+
+func TestLockStateFile(t *testing.T) {
+	stateFile := filepath.Join(t.TempDir(), "dump-state.json")
+
+	lock, err := lockStateFile(stateFile)
+	require.NoError(t, err)
+	assert.FileExists(t, stateFile+".lock")
+
+	// An overlapping run on the same state file is refused
+	_, err = lockStateFile(stateFile)
+	assert.ErrorContains(t, err, "another run is using state file "+stateFile)
+
+	releaseStateLock(lock)
+	assert.NoFileExists(t, stateFile+".lock")
+
+	lock, err = lockStateFile(stateFile)
+	require.NoError(t, err)
+	releaseStateLock(lock)
+}
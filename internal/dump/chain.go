@@ -0,0 +1,151 @@
+package dump
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"time"
+
+	"nmongo/internal/storage"
+)
+
+// ChainIndexFileName is the name of the chain index in the dump root
+const ChainIndexFileName = "chain.json"
+
+// ChainIndexVersion is the version of the chain index format
+const ChainIndexVersion = 1
+
+// SegmentsDirName is the directory of the dump root holding one directory per segment
+const SegmentsDirName = "segments"
+
+// segmentIDLayout formats the creation time in segment IDs, so IDs sort chronologically
+const segmentIDLayout = "20060102T150405.000Z"
+
+// Segment types
+const (
+	// SegmentFull holds every document of the dumped collections and starts a new chain
+	SegmentFull = "full"
+	// SegmentIncremental holds the documents modified since its parent segment
+	SegmentIncremental = "incremental"
+)
+
+// Segment is the output of one dump run, stored in the mongodump layout in its own directory
+type Segment struct {
+	ID        string    `json:"id"`
+	Type      string    `json:"type"`
+	Parent    string    `json:"parent,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+	// Changes describes the change events captured during the dump run, nil when none were captured
+	Changes *ChangeRange `json:"changes,omitempty"`
+}
+
+// Incremental reports whether the segment only holds modified documents
+func (s *Segment) Incremental() bool {
+	return s.Type == SegmentIncremental
+}
+
+// ChainIndex lists the completed segments of a dump directory in the order they were created.
+// Each incremental segment refers to its parent, so a chain runs from a full segment through its incrementals.
+type ChainIndex struct {
+	Version  int       `json:"version"`
+	Segments []Segment `json:"segments"`
+}
+
+// ReadChainIndex reads the chain index of a dump directory. Directories without one yield an empty index.
+func ReadChainIndex(root string) (*ChainIndex, error) {
+	data, err := storage.ReadFile(storage.Join(root, ChainIndexFileName))
+	if errors.Is(err, fs.ErrNotExist) {
+		return &ChainIndex{Version: ChainIndexVersion, Segments: []Segment{}}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var index ChainIndex
+	if err := json.Unmarshal(data, &index); err != nil {
+		return nil, fmt.Errorf("failed to parse chain index: %w", err)
+	}
+	if index.Version > ChainIndexVersion {
+		return nil, fmt.Errorf("chain index version %d is newer than the supported version %d", index.Version, ChainIndexVersion)
+	}
+	return &index, nil
+}
+
+// WriteChainIndex atomically writes the chain index to the dump root
+func WriteChainIndex(root string, index *ChainIndex) error {
+	data, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode chain index: %w", err)
+	}
+
+	return storage.WriteBytes(storage.Join(root, ChainIndexFileName), data)
+}
+
+// SegmentDir returns the directory of a segment
+func SegmentDir(root, id string) string {
+	return storage.Join(root, SegmentsDirName, id)
+}
+
+// Latest returns the most recent segment, or nil if there is none
+func (c *ChainIndex) Latest() *Segment {
+	if len(c.Segments) == 0 {
+		return nil
+	}
+	return &c.Segments[len(c.Segments)-1]
+}
+
+// NewSegment creates the segment of a new dump run. An incremental run extends the latest segment's chain;
+// without any earlier segment it becomes a full segment.
+func (c *ChainIndex) NewSegment(incremental bool, now time.Time) *Segment {
+	segment := &Segment{Type: SegmentFull, CreatedAt: now.UTC()}
+	if latest := c.Latest(); incremental && latest != nil {
+		segment.Type = SegmentIncremental
+		segment.Parent = latest.ID
+	}
+	segment.ID = segment.CreatedAt.Format(segmentIDLayout) + "-" + segment.Type
+	return segment
+}
+
+// Add records a completed segment
+func (c *ChainIndex) Add(segment *Segment) {
+	c.Segments = append(c.Segments, *segment)
+}
+
+// Find returns the segment with the given ID
+func (c *ChainIndex) Find(id string) (*Segment, bool) {
+	for i := range c.Segments {
+		if c.Segments[i].ID == id {
+			return &c.Segments[i], true
+		}
+	}
+	return nil, false
+}
+
+// Chain returns the segments needed to restore a segment, starting with the full segment of its chain.
+// An empty ID selects the latest segment.
+func (c *ChainIndex) Chain(id string) ([]Segment, error) {
+	if id == "" {
+		if c.Latest() == nil {
+			return nil, nil
+		}
+		id = c.Latest().ID
+	}
+
+	var chain []Segment
+	for id != "" {
+		segment, found := c.Find(id)
+		if !found {
+			return nil, fmt.Errorf("segment %s not found in the chain index", id)
+		}
+		if len(chain) == len(c.Segments) {
+			return nil, fmt.Errorf("chain index has a cycle at segment %s", id)
+		}
+		chain = append([]Segment{*segment}, chain...)
+		id = segment.Parent
+	}
+	if chain[0].Incremental() {
+		return nil, fmt.Errorf("chain of segment %s does not start with a full segment", chain[len(chain)-1].ID)
+	}
+	return chain, nil
+}
@@ -0,0 +1,110 @@
+package dump
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewSegment(t *testing.T) {
+	index := &ChainIndex{Version: ChainIndexVersion}
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	// The first incremental run has no parent and becomes a full segment
+	full := index.NewSegment(true, now)
+	assert.Equal(t, SegmentFull, full.Type)
+	assert.Equal(t, "20240501T120000.000Z-full", full.ID)
+	assert.Empty(t, full.Parent)
+	index.Add(full)
+
+	incremental := index.NewSegment(true, now.Add(time.Hour))
+	assert.True(t, incremental.Incremental())
+	assert.Equal(t, full.ID, incremental.Parent)
+	index.Add(incremental)
+
+	next := index.NewSegment(false, now.Add(2*time.Hour))
+	assert.Equal(t, SegmentFull, next.Type)
+	assert.Empty(t, next.Parent)
+}
+
+func TestChain(t *testing.T) {
+	index := &ChainIndex{Version: ChainIndexVersion, Segments: []Segment{
+		{ID: "1-full", Type: SegmentFull},
+		{ID: "2-incremental", Type: SegmentIncremental, Parent: "1-full"},
+		{ID: "3-incremental", Type: SegmentIncremental, Parent: "2-incremental"},
+		{ID: "4-full", Type: SegmentFull},
+		{ID: "5-incremental", Type: SegmentIncremental, Parent: "4-full"},
+		{ID: "6-incremental", Type: SegmentIncremental, Parent: "missing"},
+	}}
+
+	ids := func(chain []Segment) []string {
+		var ids []string
+		for _, segment := range chain {
+			ids = append(ids, segment.ID)
+		}
+		return ids
+	}
+
+	chain, err := index.Chain("3-incremental")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"1-full", "2-incremental", "3-incremental"}, ids(chain))
+
+	chain, err = index.Chain("5-incremental")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"4-full", "5-incremental"}, ids(chain))
+
+	_, err = index.Chain("6-incremental")
+	assert.ErrorContains(t, err, "segment missing not found")
+
+	_, err = index.Chain("")
+	assert.Error(t, err)
+
+	index.Segments = index.Segments[:5]
+	chain, err = index.Chain("")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"4-full", "5-incremental"}, ids(chain))
+
+	chain, err = (&ChainIndex{}).Chain("")
+	require.NoError(t, err)
+	assert.Empty(t, chain)
+}
+
+func TestChainRejectsBrokenChains(t *testing.T) {
+	cycle := &ChainIndex{Segments: []Segment{
+		{ID: "a", Type: SegmentIncremental, Parent: "b"},
+		{ID: "b", Type: SegmentIncremental, Parent: "a"},
+	}}
+	_, err := cycle.Chain("a")
+	assert.ErrorContains(t, err, "cycle")
+
+	orphan := &ChainIndex{Segments: []Segment{{ID: "a", Type: SegmentIncremental}}}
+	_, err = orphan.Chain("a")
+	assert.ErrorContains(t, err, "does not start with a full segment")
+}
+
+func TestChainIndexReadWrite(t *testing.T) {
+	root := t.TempDir()
+
+	index, err := ReadChainIndex(root)
+	require.NoError(t, err)
+	assert.Empty(t, index.Segments)
+	assert.Nil(t, index.Latest())
+
+	segment := index.NewSegment(false, time.Now())
+	index.Add(segment)
+	require.NoError(t, WriteChainIndex(root, index))
+
+	read, err := ReadChainIndex(root)
+	require.NoError(t, err)
+	require.Len(t, read.Segments, 1)
+	assert.Equal(t, segment.ID, read.Latest().ID)
+	assert.Equal(t, filepath.Join(root, SegmentsDirName, segment.ID), SegmentDir(root, segment.ID))
+
+	require.NoError(t, os.WriteFile(filepath.Join(root, ChainIndexFileName), []byte(`{"version": 99}`), 0644))
+	_, err = ReadChainIndex(root)
+	assert.ErrorContains(t, err, "newer than the supported version")
+}
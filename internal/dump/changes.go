@@ -0,0 +1,419 @@
+package dump
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"nmongo/internal/mongodb"
+)
+
+// ChangesFileName is the name of the file in a segment directory holding the change events captured during its dump run
+const ChangesFileName = "changes.bson"
+
+// Server error codes handled when capturing and replaying changes
+const (
+	errCodeNamespaceNotFound       = 26
+	errCodeChangeStreamHistoryLost = 286
+)
+
+// ChangeRange is the span of cluster times whose change events were captured with a segment:
+// the events after Start, up to and including End. Once they are replayed on the data of the segment,
+// which was read between the two, the data is consistent at End.
+type ChangeRange struct {
+	Start  primitive.Timestamp `json:"start"`
+	End    primitive.Timestamp `json:"end"`
+	Events int64               `json:"events"`
+}
+
+// FormatClusterTime formats the wall clock time of a cluster time
+func FormatClusterTime(ts primitive.Timestamp) string {
+	return time.Unix(int64(ts.T), 0).UTC().Format(time.RFC3339)
+}
+
+// CaptureOptions configures capturing the change events of a dump run
+type CaptureOptions struct {
+	// Start and End bound the captured events: after Start, up to and including End
+	Start primitive.Timestamp
+	End   primitive.Timestamp
+	// Databases limits the capture to these databases; empty means all
+	Databases []string
+	// ExcludeDatabases leaves out the events of these databases
+	ExcludeDatabases []string
+	// Compression is the format the changes file is compressed with; empty means uncompressed
+	Compression string
+	// Key encrypts the changes file after compression; nil means unencrypted
+	Key *Key
+}
+
+// CaptureChanges writes the change events of the cluster between opts.Start and opts.End to the changes file
+// of a segment directory and returns their number. Events are stored as the change stream returns them.
+// IsHistoryLost reports whether an error means Start is older than the oldest oplog entry of the server.
+func CaptureChanges(ctx context.Context, client *mongodb.Client, dir string, opts CaptureOptions) (int64, error) {
+	start := opts.Start
+	streamOptions := options.ChangeStream().
+		SetStartAtOperationTime(&start).
+		SetMaxAwaitTime(time.Second)
+
+	stream, err := client.Watch(ctx, captureChangesPipeline(opts), streamOptions)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open change stream: %w", err)
+	}
+	defer stream.Close(ctx)
+
+	var count int64
+	err = writeCompressedFile(dir, ChangesFileName, opts.Compression, opts.Key, func(w io.Writer) error {
+		count, err = copyChangeEvents(ctx, stream, w, opts.Start, opts.End)
+		return err
+	})
+	return count, err
+}
+
+// IsHistoryLost reports whether a change stream could not start because its start time is no longer in the oplog
+func IsHistoryLost(err error) bool {
+	var serverErr mongo.ServerError
+	return errors.As(err, &serverErr) && serverErr.HasErrorCode(errCodeChangeStreamHistoryLost)
+}
+
+// captureChangesPipeline selects the events of the dumped databases
+func captureChangesPipeline(opts CaptureOptions) mongo.Pipeline {
+	dbFilter := bson.M{}
+	if len(opts.Databases) > 0 {
+		dbFilter["$in"] = opts.Databases
+	}
+	if len(opts.ExcludeDatabases) > 0 {
+		dbFilter["$nin"] = opts.ExcludeDatabases
+	}
+
+	match := bson.M{}
+	if len(dbFilter) > 0 {
+		match["ns.db"] = dbFilter
+	}
+	return mongo.Pipeline{{{Key: "$match", Value: match}}}
+}
+
+// copyChangeEvents writes the events of a change stream after start until the stream moves past end.
+// The stream starts at start inclusively, so events at start itself belong to the previous segment and are skipped.
+func copyChangeEvents(ctx context.Context, stream *mongo.ChangeStream, w io.Writer, start, end primitive.Timestamp) (int64, error) {
+	var count int64
+	for {
+		event, done, err := nextChangeEvent(ctx, stream, end)
+		if err != nil || done {
+			return count, err
+		}
+		if event == nil || !eventClusterTime(event).After(start) {
+			continue
+		}
+		if _, err := w.Write(event); err != nil {
+			return count, fmt.Errorf("failed to write change event: %w", err)
+		}
+		count++
+	}
+}
+
+// nextChangeEvent returns the next available change event, or reports that the stream has moved past end.
+// Without a new event it returns nil, and the stream is done once its resume token is past end.
+func nextChangeEvent(ctx context.Context, stream *mongo.ChangeStream, end primitive.Timestamp) (bson.Raw, bool, error) {
+	if !stream.TryNext(ctx) {
+		if err := stream.Err(); err != nil {
+			return nil, false, fmt.Errorf("change stream error: %w", err)
+		}
+		done, err := tokenPastEnd(stream.ResumeToken(), end)
+		return nil, done, err
+	}
+
+	event := append(bson.Raw(nil), stream.Current...)
+	if eventClusterTime(event).After(end) {
+		return nil, true, nil
+	}
+	return event, false, nil
+}
+
+// tokenPastEnd reports whether an idle change stream's resume token is past end. A stream without a resume token
+// yet is read further, and a token whose cluster time cannot be decoded is an error, since the changes captured
+// up to end could not be told complete.
+func tokenPastEnd(token bson.Raw, end primitive.Timestamp) (bool, error) {
+	if token == nil {
+		return false, nil
+	}
+	tokenTime, ok := mongodb.ResumeTokenTime(token)
+	if !ok {
+		return false, fmt.Errorf("failed to read the cluster time of change stream resume token %s", token)
+	}
+	return tokenTime.Compare(end) >= 0, nil
+}
+
+// eventClusterTime returns the cluster time of a change event
+func eventClusterTime(event bson.Raw) primitive.Timestamp {
+	t, i, _ := event.Lookup("clusterTime").TimestampOK()
+	return primitive.Timestamp{T: t, I: i}
+}
+
+// ReplayOptions configures replaying captured change events
+type ReplayOptions struct {
+	// Until is the last cluster time replayed
+	Until primitive.Timestamp
+	// Include selects the namespaces replayed; collName is empty for events on a whole database. Nil replays all.
+	Include func(dbName, collName string) bool
+	// RetryAttempts is the number of attempts for each replayed event
+	RetryAttempts int
+	// Keys decrypt an encrypted changes file; nil when the dump is not encrypted
+	Keys *Keyring
+}
+
+// includes reports whether the events of a namespace are replayed
+func (o ReplayOptions) includes(ns changeNamespace) bool {
+	return o.Include == nil || o.Include(ns.Database, ns.Collection)
+}
+
+// changeEvent is the part of a captured change event needed to replay it
+type changeEvent struct {
+	OperationType     string              `bson:"operationType"`
+	ClusterTime       primitive.Timestamp `bson:"clusterTime"`
+	Namespace         changeNamespace     `bson:"ns"`
+	To                changeNamespace     `bson:"to"`
+	DocumentKey       bson.D              `bson:"documentKey"`
+	FullDocument      bson.Raw            `bson:"fullDocument"`
+	UpdateDescription updateDescription   `bson:"updateDescription"`
+}
+
+// changeNamespace is the database and collection of a change event
+type changeNamespace struct {
+	Database   string `bson:"db"`
+	Collection string `bson:"coll"`
+}
+
+// updateDescription lists the fields changed by an update event
+type updateDescription struct {
+	UpdatedFields   bson.D           `bson:"updatedFields"`
+	RemovedFields   []string         `bson:"removedFields"`
+	TruncatedArrays []truncatedArray `bson:"truncatedArrays"`
+}
+
+// truncatedArray is an array field shortened by an update
+type truncatedArray struct {
+	Field   string `bson:"field"`
+	NewSize int32  `bson:"newSize"`
+}
+
+// ReplayChanges applies the change events captured in a segment directory to the target in the order they happened,
+// up to opts.Until, and returns the number of events applied. Inserts and replacements are upserted and updates only
+// set the changed fields, so events already reflected in the restored data can be applied again.
+func ReplayChanges(ctx context.Context, client *mongodb.Client, dir string, opts ReplayOptions) (int64, error) {
+	file, err := openChangesFile(dir, opts.Keys)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	reader := NewReader(file)
+	var applied int64
+	for {
+		event, err := nextReplayEvent(reader, opts.Until)
+		if err != nil || event == nil {
+			return applied, err
+		}
+		if !opts.includes(event.Namespace) {
+			continue
+		}
+		if err := applyChangeEvent(ctx, client, event, opts.RetryAttempts); err != nil {
+			return applied, err
+		}
+		applied++
+	}
+}
+
+// openChangesFile opens the changes file of a segment directory
+func openChangesFile(dir string, keys *Keyring) (io.ReadCloser, error) {
+	path, compression, found := FindFile(dir, ChangesFileName)
+	if !found {
+		return nil, fmt.Errorf("changes file not found in %s", dir)
+	}
+	return OpenFile(path, compression, keys)
+}
+
+// nextReplayEvent reads the next change event, or returns nil once all events up to until have been read
+func nextReplayEvent(reader *Reader, until primitive.Timestamp) (*changeEvent, error) {
+	doc, err := reader.Next()
+	if errors.Is(err, io.EOF) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read change event: %w", err)
+	}
+
+	var event changeEvent
+	if err := bson.Unmarshal(doc, &event); err != nil {
+		return nil, fmt.Errorf("failed to decode change event: %w", err)
+	}
+	if event.ClusterTime.After(until) {
+		return nil, nil
+	}
+	return &event, nil
+}
+
+// applyChangeEvent replays a single change event on the target, retrying failed attempts
+func applyChangeEvent(ctx context.Context, client *mongodb.Client, event *changeEvent, retryAttempts int) error {
+	coll := client.GetDatabase(event.Namespace.Database).Collection(event.Namespace.Collection)
+	operation := fmt.Sprintf("Replay %s on %s.%s", event.OperationType, event.Namespace.Database, event.Namespace.Collection)
+	return mongodb.RetryWithBackoff(ctx, retryAttempts, operation, func() error {
+		return applyChange(ctx, client, coll, event)
+	})
+}
+
+// applyChange applies the effect of a change event to its collection
+func applyChange(ctx context.Context, client *mongodb.Client, coll *mongo.Collection, event *changeEvent) error {
+	switch event.OperationType {
+	case "insert", "replace":
+		_, err := coll.ReplaceOne(ctx, event.DocumentKey, event.FullDocument, options.Replace().SetUpsert(true))
+		return err
+	case "update":
+		return applyUpdate(ctx, coll, event)
+	case "delete":
+		_, err := coll.DeleteOne(ctx, event.DocumentKey)
+		return err
+	case "drop":
+		return coll.Drop(ctx)
+	case "dropDatabase":
+		return coll.Database().Drop(ctx)
+	case "rename":
+		return renameCollection(ctx, client, event)
+	default:
+		fmt.Printf("      Warning: Skipping %s event on %s.%s\n", event.OperationType, event.Namespace.Database, event.Namespace.Collection)
+		return nil
+	}
+}
+
+// applyUpdate applies the fields changed by an update event. Arrays are truncated first,
+// since the updated fields may set elements of a truncated array.
+func applyUpdate(ctx context.Context, coll *mongo.Collection, event *changeEvent) error {
+	description := event.UpdateDescription
+	if len(description.TruncatedArrays) > 0 {
+		if _, err := coll.UpdateOne(ctx, event.DocumentKey, truncateArraysUpdate(description.TruncatedArrays)); err != nil {
+			return err
+		}
+	}
+
+	update := fieldsUpdate(description)
+	if len(update) == 0 {
+		return nil
+	}
+	_, err := coll.UpdateOne(ctx, event.DocumentKey, update)
+	return err
+}
+
+// truncateArraysUpdate builds the update shortening arrays to their new size
+func truncateArraysUpdate(arrays []truncatedArray) bson.D {
+	truncate := bson.D{}
+	for _, array := range arrays {
+		truncate = append(truncate, bson.E{Key: array.Field, Value: bson.M{"$each": bson.A{}, "$slice": array.NewSize}})
+	}
+	return bson.D{{Key: "$push", Value: truncate}}
+}
+
+// fieldsUpdate builds the update setting the updated fields and removing the removed ones
+func fieldsUpdate(description updateDescription) bson.D {
+	update := bson.D{}
+	if len(description.UpdatedFields) > 0 {
+		update = append(update, bson.E{Key: "$set", Value: description.UpdatedFields})
+	}
+	if len(description.RemovedFields) > 0 {
+		unset := bson.D{}
+		for _, field := range description.RemovedFields {
+			unset = append(unset, bson.E{Key: field, Value: ""})
+		}
+		update = append(update, bson.E{Key: "$unset", Value: unset})
+	}
+	return update
+}
+
+// renameCollection replays a rename. A collection that no longer exists was already renamed in the restored data.
+func renameCollection(ctx context.Context, client *mongodb.Client, event *changeEvent) error {
+	command := bson.D{
+		{Key: "renameCollection", Value: event.Namespace.Database + "." + event.Namespace.Collection},
+		{Key: "to", Value: event.To.Database + "." + event.To.Collection},
+		{Key: "dropTarget", Value: true},
+	}
+	err := client.GetDatabase("admin").RunCommand(ctx, command).Err()
+	var serverErr mongo.ServerError
+	if errors.As(err, &serverErr) && serverErr.HasErrorCode(errCodeNamespaceNotFound) {
+		return nil
+	}
+	return err
+}
+
+// PointInTimePlan lists the segments restored to bring the target to a point in time
+type PointInTimePlan struct {
+	// Chain is the chain of the latest segment whose captured changes end at or before the point in time.
+	// Its segments are restored with their data and changes.
+	Chain []Segment
+	// Replay are the later segments whose captured changes are replayed on top of the chain
+	Replay []Segment
+	// Until is the last cluster time replayed
+	Until primitive.Timestamp
+	// CapturedUntil is the end of the last changes available, before Until when the point in time is after the latest dump
+	CapturedUntil primitive.Timestamp
+}
+
+// PointInTime plans restoring the dumped data as it was at a point in time, to the second.
+// The changes of the segments replayed must be continuous, otherwise the events in between were not captured.
+func (c *ChainIndex) PointInTime(until time.Time) (*PointInTimePlan, error) {
+	plan := &PointInTimePlan{Until: primitive.Timestamp{T: uint32(until.Unix()), I: math.MaxUint32}}
+
+	base := c.latestCapturedBefore(plan.Until)
+	if base < 0 {
+		return nil, fmt.Errorf("no segment with captured changes ends at or before %s", until.UTC().Format(time.RFC3339))
+	}
+
+	chain, err := c.Chain(c.Segments[base].ID)
+	if err != nil {
+		return nil, err
+	}
+	plan.Chain = chain
+
+	plan.Replay, plan.CapturedUntil, err = c.replaySegments(base, plan.Until)
+	if err != nil {
+		return nil, err
+	}
+	return plan, nil
+}
+
+// latestCapturedBefore returns the position of the latest segment with changes ending at or before until, or -1
+func (c *ChainIndex) latestCapturedBefore(until primitive.Timestamp) int {
+	for i := len(c.Segments) - 1; i >= 0; i-- {
+		if changes := c.Segments[i].Changes; changes != nil && !changes.End.After(until) {
+			return i
+		}
+	}
+	return -1
+}
+
+// replaySegments returns the segments after base whose changes are needed to reach until, and the end of their changes
+func (c *ChainIndex) replaySegments(base int, until primitive.Timestamp) ([]Segment, primitive.Timestamp, error) {
+	end := c.Segments[base].Changes.End
+	var replay []Segment
+	for _, segment := range c.Segments[base+1:] {
+		if !end.Before(until) {
+			break
+		}
+		if segment.Changes == nil {
+			return nil, end, fmt.Errorf("segment %s has no captured changes, changes after %s are not available",
+				segment.ID, FormatClusterTime(end))
+		}
+		if segment.Changes.Start.After(end) {
+			return nil, end, fmt.Errorf("changes between %s and %s were not captured",
+				FormatClusterTime(end), FormatClusterTime(segment.Changes.Start))
+		}
+		replay = append(replay, segment)
+		end = segment.Changes.End
+	}
+	return replay, end, nil
+}
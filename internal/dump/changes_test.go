@@ -0,0 +1,267 @@
+package dump
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	tcmongodb "github.com/testcontainers/testcontainers-go/modules/mongodb"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"nmongo/internal/mongodb"
+)
+
+// clusterTime returns the cluster time of a wall clock time in 2024-05-01 UTC
+func clusterTime(hour, minute int) primitive.Timestamp {
+	return primitive.Timestamp{T: uint32(time.Date(2024, 5, 1, hour, minute, 0, 0, time.UTC).Unix()), I: 1}
+}
+
+func TestPointInTime(t *testing.T) {
+	index := &ChainIndex{Version: ChainIndexVersion, Segments: []Segment{
+		{ID: "1-full", Type: SegmentFull, Changes: &ChangeRange{Start: clusterTime(1, 0), End: clusterTime(1, 10)}},
+		{ID: "2-incremental", Type: SegmentIncremental, Parent: "1-full",
+			Changes: &ChangeRange{Start: clusterTime(1, 10), End: clusterTime(2, 10)}},
+		{ID: "3-full", Type: SegmentFull, Changes: &ChangeRange{Start: clusterTime(2, 10), End: clusterTime(3, 10)}},
+		{ID: "4-incremental", Type: SegmentIncremental, Parent: "3-full",
+			Changes: &ChangeRange{Start: clusterTime(3, 10), End: clusterTime(4, 10)}},
+	}}
+	ids := func(segments []Segment) []string {
+		var ids []string
+		for _, segment := range segments {
+			ids = append(ids, segment.ID)
+		}
+		return ids
+	}
+	at := func(hour, minute int) time.Time {
+		return time.Date(2024, 5, 1, hour, minute, 0, 0, time.UTC)
+	}
+
+	// Between two segments, the chain of the earlier one is restored and the changes of the later one replayed
+	plan, err := index.PointInTime(at(2, 30))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"1-full", "2-incremental"}, ids(plan.Chain))
+	assert.Equal(t, []string{"3-full"}, ids(plan.Replay))
+	assert.Equal(t, uint32(at(2, 30).Unix()), plan.Until.T)
+	assert.Equal(t, clusterTime(3, 10), plan.CapturedUntil)
+
+	// Cluster times within the second of the point in time are included, so at the end of a segment's changes
+	// the events later in that second are replayed from the next segment
+	plan, err = index.PointInTime(at(3, 10))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"3-full"}, ids(plan.Chain))
+	assert.Equal(t, []string{"4-incremental"}, ids(plan.Replay))
+
+	// After the latest segment, the restore ends where the captured changes end
+	plan, err = index.PointInTime(at(5, 0))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"3-full", "4-incremental"}, ids(plan.Chain))
+	assert.Equal(t, clusterTime(4, 10), plan.CapturedUntil)
+
+	_, err = index.PointInTime(at(1, 5))
+	assert.ErrorContains(t, err, "no segment with captured changes ends at or before 2024-05-01T01:05:00Z")
+
+	// Segments without changes, or with changes that do not continue the previous ones, cannot be replayed
+	index.Segments[2].Changes = nil
+	_, err = index.PointInTime(at(2, 30))
+	assert.ErrorContains(t, err, "segment 3-full has no captured changes")
+
+	index.Segments[2].Changes = &ChangeRange{Start: clusterTime(2, 20), End: clusterTime(3, 10)}
+	_, err = index.PointInTime(at(2, 30))
+	assert.ErrorContains(t, err, "changes between 2024-05-01T02:10:00Z and 2024-05-01T02:20:00Z were not captured")
+}
+
+func TestCaptureChangesPipeline(t *testing.T) {
+	assert.Equal(t, mongo.Pipeline{{{Key: "$match", Value: bson.M{}}}}, captureChangesPipeline(CaptureOptions{}))
+
+	pipeline := captureChangesPipeline(CaptureOptions{Databases: []string{"app"}, ExcludeDatabases: []string{"test"}})
+	assert.Equal(t, mongo.Pipeline{{{Key: "$match", Value: bson.M{
+		"ns.db": bson.M{"$in": []string{"app"}, "$nin": []string{"test"}},
+	}}}}, pipeline)
+}
+
+func TestTokenPastEnd(t *testing.T) {
+	token := func(data string) bson.Raw {
+		raw, err := bson.Marshal(bson.M{"_data": data})
+		require.NoError(t, err)
+		return raw
+	}
+	end := primitive.Timestamp{T: 0x65000000, I: 2}
+
+	done, err := tokenPastEnd(token("82"+"65000000"+"00000001"+"0104"), end)
+	require.NoError(t, err)
+	assert.False(t, done)
+	done, err = tokenPastEnd(token("82"+"65000000"+"00000002"+"0104"), end)
+	require.NoError(t, err)
+	assert.True(t, done)
+
+	// Without a token the stream is read further, an undecodable one cannot tell whether end was reached
+	done, err = tokenPastEnd(nil, end)
+	require.NoError(t, err)
+	assert.False(t, done)
+	_, err = tokenPastEnd(token("zz"), end)
+	assert.Error(t, err)
+}
+
+func TestChangeUpdates(t *testing.T) {
+	description := updateDescription{
+		UpdatedFields:   bson.D{{Key: "status", Value: "paid"}, {Key: "items.2", Value: "x"}},
+		RemovedFields:   []string{"draft"},
+		TruncatedArrays: []truncatedArray{{Field: "items", NewSize: 3}},
+	}
+
+	assert.Equal(t, bson.D{
+		{Key: "$set", Value: bson.D{{Key: "status", Value: "paid"}, {Key: "items.2", Value: "x"}}},
+		{Key: "$unset", Value: bson.D{{Key: "draft", Value: ""}}},
+	}, fieldsUpdate(description))
+	assert.Equal(t, bson.D{
+		{Key: "$push", Value: bson.D{{Key: "items", Value: bson.M{"$each": bson.A{}, "$slice": int32(3)}}}},
+	}, truncateArraysUpdate(description.TruncatedArrays))
+	assert.Empty(t, fieldsUpdate(updateDescription{}))
+}
+
+func TestNextReplayEvent(t *testing.T) {
+	dir := t.TempDir()
+	keys := testKeyring(t, 1)
+	events := []bson.M{
+		{"operationType": "insert", "clusterTime": clusterTime(1, 0), "ns": bson.M{"db": "app", "coll": "orders"}},
+		{"operationType": "delete", "clusterTime": clusterTime(1, 5), "ns": bson.M{"db": "app", "coll": "orders"}},
+		{"operationType": "insert", "clusterTime": clusterTime(1, 10), "ns": bson.M{"db": "app", "coll": "orders"}},
+	}
+	err := writeCompressedFile(dir, ChangesFileName, CompressionZstd, keys.Primary(), func(w io.Writer) error {
+		for _, event := range events {
+			data, err := bson.Marshal(event)
+			require.NoError(t, err)
+			if _, err := w.Write(data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	require.NoError(t, err)
+
+	file, err := openChangesFile(dir, keys)
+	require.NoError(t, err)
+	defer file.Close()
+
+	reader := NewReader(file)
+	var read []string
+	for {
+		event, err := nextReplayEvent(reader, clusterTime(1, 5))
+		require.NoError(t, err)
+		if event == nil {
+			break
+		}
+		read = append(read, event.OperationType+" "+event.Namespace.Database+"."+event.Namespace.Collection)
+	}
+	assert.Equal(t, []string{"insert app.orders", "delete app.orders"}, read)
+
+	_, err = openChangesFile(t.TempDir(), keys)
+	assert.ErrorContains(t, err, "changes file not found")
+}
+
+func TestChangesFileInManifest(t *testing.T) {
+	root := t.TempDir()
+	data, err := bson.Marshal(bson.M{"operationType": "insert"})
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(root, ChangesFileName), data, 0644))
+
+	file, err := DescribeFile(root, filepath.Join(root, ChangesFileName), CompressionNone, nil)
+	require.NoError(t, err)
+	assert.Equal(t, FileTypeChanges, file.Type)
+	assert.Equal(t, int64(1), file.Documents)
+
+	manifest := NewManifest()
+	manifest.SetChangesFile(file)
+	report, err := VerifyDump(root, manifest, nil)
+	require.NoError(t, err)
+	assert.True(t, report.OK())
+
+	require.NoError(t, os.WriteFile(filepath.Join(root, ChangesFileName), data[:len(data)-1], 0644))
+	report, err = VerifyDump(root, manifest, nil)
+	require.NoError(t, err)
+	assert.False(t, report.OK())
+}
+
+// TestCaptureAndReplayChanges tests that replaying captured changes on the data as it was at the start of the capture
+// brings it to the state at the end of the capture
+func TestCaptureAndReplayChanges(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	ctx := context.Background()
+	container, err := tcmongodb.Run(ctx, "mongo:8.0", tcmongodb.WithReplicaSet("rs0"))
+	require.NoError(t, err)
+	defer container.Terminate(ctx)
+
+	connString, err := container.ConnectionString(ctx)
+	require.NoError(t, err)
+	client, err := mongodb.NewClient(ctx, connString, "")
+	require.NoError(t, err)
+	defer client.Disconnect(ctx)
+
+	orders := client.GetDatabase("pitr").Collection("orders")
+	initial := []interface{}{
+		bson.M{"_id": 1, "status": "new", "items": bson.A{"a", "b", "c"}, "draft": true},
+		bson.M{"_id": 2, "status": "new"},
+	}
+	_, err = orders.InsertMany(ctx, initial)
+	require.NoError(t, err)
+
+	start, err := client.ClusterTime(ctx)
+	require.NoError(t, err)
+
+	_, err = orders.UpdateOne(ctx, bson.M{"_id": 1}, bson.A{
+		bson.M{"$set": bson.M{"status": "paid", "items": bson.M{"$slice": bson.A{"$items", 1}}}},
+		bson.M{"$unset": "draft"},
+	})
+	require.NoError(t, err)
+	_, err = orders.DeleteOne(ctx, bson.M{"_id": 2})
+	require.NoError(t, err)
+	_, err = orders.InsertOne(ctx, bson.M{"_id": 3, "status": "new"})
+	require.NoError(t, err)
+
+	end, err := client.ClusterTime(ctx)
+	require.NoError(t, err)
+
+	// Changes after the end are not captured
+	_, err = orders.InsertOne(ctx, bson.M{"_id": 4, "status": "new"})
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	count, err := CaptureChanges(ctx, client, dir, CaptureOptions{Start: start, End: end, Databases: []string{"pitr"}})
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), count)
+
+	// Restore the data as it was at the start of the capture and replay the changes
+	require.NoError(t, orders.Drop(ctx))
+	_, err = orders.InsertMany(ctx, initial)
+	require.NoError(t, err)
+
+	applied, err := ReplayChanges(ctx, client, dir, ReplayOptions{Until: end, RetryAttempts: 1})
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), applied)
+
+	var docs []bson.M
+	cursor, err := orders.Find(ctx, bson.M{})
+	require.NoError(t, err)
+	require.NoError(t, cursor.All(ctx, &docs))
+	assert.Equal(t, []bson.M{
+		{"_id": int32(1), "status": "paid", "items": bson.A{"a"}},
+		{"_id": int32(3), "status": "new"},
+	}, docs)
+
+	// Replaying again leaves the data unchanged
+	_, err = ReplayChanges(ctx, client, dir, ReplayOptions{Until: end, RetryAttempts: 1})
+	require.NoError(t, err)
+	total, err := orders.CountDocuments(ctx, bson.M{})
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), total)
+}
@@ -0,0 +1,305 @@
+package dump
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"sort"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"nmongo/internal/storage"
+)
+
+// maxDocumentProblems limits the differing documents reported for a collection
+const maxDocumentProblems = 10
+
+// CheckOptions configures the comparison of a restored collection with its dump
+type CheckOptions struct {
+	InputDir string
+	// Manifest provides the expected document count; without it the documents of the data file are counted
+	Manifest *Manifest
+	// Counts compares the number of documents. Incremental segments only hold the changed documents,
+	// so their collections cannot be compared by count.
+	Counts bool
+	// Hashes compares every dumped document with the restored document of the same _id by its SHA-256 hash
+	Hashes    bool
+	BatchSize int
+	Keys      *Keyring
+}
+
+// CheckResult is the outcome of comparing a restored collection with its dump
+type CheckResult struct {
+	// Documents and Indexes are the numbers of dumped documents and indexes compared
+	Documents int64
+	Indexes   int
+	// Problems describes every mismatch between the collection and its dump
+	Problems []string
+}
+
+// OK reports whether the collection matches its dump
+func (r *CheckResult) OK() bool {
+	return len(r.Problems) == 0
+}
+
+// CheckRestoredCollection compares a restored collection with its dump: the indexes of its metadata file,
+// and optionally the number of documents and the hash of every document.
+// The documents of time-series collections are only compared by count, since the server may reorganize their buckets.
+func CheckRestoredCollection(ctx context.Context, db *mongo.Database, collName string, opts CheckOptions) (*CheckResult, error) {
+	dbDir := storage.Join(opts.InputDir, db.Name())
+	meta, err := ReadMetadataFile(dbDir, collName, opts.Keys)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &CheckResult{}
+	if err := checkIndexes(ctx, db.Collection(collName), meta.Indexes, result); err != nil {
+		return result, err
+	}
+	if !meta.HasData() {
+		return result, nil
+	}
+
+	path, compression, found := FindFile(dbDir, BSONFileName(meta.DataCollection()))
+	if !found {
+		return result, fmt.Errorf("no dumped documents of %s.%s", db.Name(), collName)
+	}
+	file := dataFile{path: path, compression: compression}
+	return result, checkDocuments(ctx, db.Collection(meta.DataCollection()), file, meta, opts, result)
+}
+
+// dataFile is a data file found in a dump directory
+type dataFile struct {
+	path        string
+	compression string
+}
+
+// checkDocuments compares the documents of a collection with its data file as selected by the options
+func checkDocuments(ctx context.Context, coll *mongo.Collection, file dataFile, meta *Metadata, opts CheckOptions,
+	result *CheckResult) error {
+	if opts.Counts {
+		expected, err := expectedDocuments(coll.Database().Name(), meta.CollectionName, file, opts)
+		if err != nil {
+			return err
+		}
+		if err := checkCount(ctx, coll, expected, result); err != nil {
+			return err
+		}
+	}
+	if opts.Hashes && meta.Type != TypeTimeSeries {
+		return checkDocumentHashes(ctx, coll, file, opts, result)
+	}
+	return nil
+}
+
+// expectedDocuments returns the number of dumped documents of a collection, from the manifest if it lists the collection
+func expectedDocuments(dbName, collName string, file dataFile, opts CheckOptions) (int64, error) {
+	if opts.Manifest != nil {
+		if entry, ok := opts.Manifest.DataFile(dbName, collName); ok {
+			return entry.Documents, nil
+		}
+	}
+	count, _, err := CountDocuments(file.path, file.compression, opts.Keys)
+	return count, err
+}
+
+// checkCount compares the number of documents of a collection with the number dumped
+func checkCount(ctx context.Context, coll *mongo.Collection, expected int64, result *CheckResult) error {
+	count, err := coll.CountDocuments(ctx, bson.D{})
+	if err != nil {
+		return fmt.Errorf("failed to count documents of %s: %w", coll.Name(), err)
+	}
+	result.Documents = expected
+	if count != expected {
+		result.Problems = append(result.Problems, fmt.Sprintf("%d documents, the dump has %d", count, expected))
+	}
+	return nil
+}
+
+// checkIndexes compares the indexes of a collection with the dumped ones by name and keys.
+// The _id index is left out, since it always exists and is not listed for every kind of collection.
+func checkIndexes(ctx context.Context, coll *mongo.Collection, dumped []bson.Raw, result *CheckResult) error {
+	restored, err := listIndexes(ctx, coll)
+	if err != nil {
+		return err
+	}
+	expected := indexKeys(dumped)
+	actual := indexKeys(restored)
+	result.Indexes = len(expected)
+	result.Problems = append(result.Problems, indexProblems(expected, actual)...)
+	return nil
+}
+
+// indexProblems describes the differences between the dumped and the restored indexes, both mapping names to keys
+func indexProblems(expected, actual map[string]string) []string {
+	var problems []string
+	for _, name := range sortedKeys(expected) {
+		keys, ok := actual[name]
+		switch {
+		case !ok:
+			problems = append(problems, fmt.Sprintf("index %s is missing", name))
+		case keys != expected[name]:
+			problems = append(problems, fmt.Sprintf("index %s has keys %s, the dump has %s", name, keys, expected[name]))
+		}
+	}
+	for _, name := range sortedKeys(actual) {
+		if _, ok := expected[name]; !ok {
+			problems = append(problems, fmt.Sprintf("index %s is not in the dump", name))
+		}
+	}
+	return problems
+}
+
+// indexKeys maps the names of indexes other than _id to a description of their keys
+func indexKeys(indexes []bson.Raw) map[string]string {
+	keys := make(map[string]string, len(indexes))
+	for _, index := range indexes {
+		name, _ := index.Lookup("name").StringValueOK()
+		if name == "_id_" {
+			continue
+		}
+		keys[name] = describeIndexKey(index.Lookup("key"))
+	}
+	return keys
+}
+
+// describeIndexKey renders the fields of an index key with their direction or index type, e.g. "name_1,loc_2dsphere".
+// Numeric directions are compared by sign, since the server and the tools may store them with another numeric type.
+func describeIndexKey(key bson.RawValue) string {
+	doc, ok := key.DocumentOK()
+	if !ok {
+		return key.String()
+	}
+	elements, err := doc.Elements()
+	if err != nil {
+		return doc.String()
+	}
+
+	fields := make([]string, 0, len(elements))
+	for _, element := range elements {
+		fields = append(fields, element.Key()+"_"+indexDirection(element.Value()))
+	}
+	return strings.Join(fields, ",")
+}
+
+// indexDirection returns the sign of a numeric index direction, or the type of a special index such as "text"
+func indexDirection(value bson.RawValue) string {
+	if f, ok := value.DoubleOK(); ok {
+		if f < 0 {
+			return "-1"
+		}
+		return "1"
+	}
+	if n, ok := value.AsInt64OK(); ok {
+		if n < 0 {
+			return "-1"
+		}
+		return "1"
+	}
+	if s, ok := value.StringValueOK(); ok {
+		return s
+	}
+	return value.String()
+}
+
+// checkDocumentHashes compares every dumped document with the restored document of the same _id
+func checkDocumentHashes(ctx context.Context, coll *mongo.Collection, file dataFile, opts CheckOptions, result *CheckResult) error {
+	reader, err := OpenFile(file.path, file.compression, opts.Keys)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", file.path, err)
+	}
+	defer reader.Close()
+
+	documents := NewReader(reader)
+	batchSize := max(opts.BatchSize, 1)
+	var compared, mismatched int64
+	var differing []string
+	for {
+		batch, err := readBatch(documents, make([]bson.Raw, 0, batchSize), batchSize)
+		if err != nil {
+			return fmt.Errorf("failed to read dumped documents of %s: %w", coll.Name(), err)
+		}
+		if len(batch) == 0 {
+			break
+		}
+		problems, err := compareDocuments(ctx, coll, batch)
+		if err != nil {
+			return err
+		}
+		compared += int64(len(batch))
+		mismatched += int64(len(problems))
+		differing = append(differing, problems[:min(len(problems), maxDocumentProblems-len(differing))]...)
+	}
+
+	result.Documents = compared
+	result.Problems = append(result.Problems, differing...)
+	if more := mismatched - int64(len(differing)); more > 0 {
+		result.Problems = append(result.Problems, fmt.Sprintf("%d more documents differ", more))
+	}
+	return nil
+}
+
+// compareDocuments describes the dumped documents of a batch that are missing from the collection or differ
+func compareDocuments(ctx context.Context, coll *mongo.Collection, batch []bson.Raw) ([]string, error) {
+	restored, err := restoredHashes(ctx, coll, batch)
+	if err != nil {
+		return nil, err
+	}
+
+	var problems []string
+	for _, doc := range batch {
+		hash, ok := restored[idKey(doc.Lookup("_id"))]
+		switch {
+		case !ok:
+			problems = append(problems, fmt.Sprintf("document %s is missing", documentID(doc)))
+		case hash != sha256.Sum256(doc):
+			problems = append(problems, fmt.Sprintf("document %s differs from the dump", documentID(doc)))
+		}
+	}
+	return problems, nil
+}
+
+// restoredHashes reads the restored documents with the _id of a batch of dumped documents and returns their hashes by _id
+func restoredHashes(ctx context.Context, coll *mongo.Collection, batch []bson.Raw) (map[string][sha256.Size]byte, error) {
+	ids := make(bson.A, 0, len(batch))
+	for _, doc := range batch {
+		ids = append(ids, doc.Lookup("_id"))
+	}
+	cursor, err := coll.Find(ctx, bson.D{{Key: "_id", Value: bson.D{{Key: "$in", Value: ids}}}})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read restored documents of %s: %w", coll.Name(), err)
+	}
+	defer cursor.Close(ctx)
+
+	restored := make(map[string][sha256.Size]byte, len(batch))
+	for cursor.Next(ctx) {
+		restored[idKey(cursor.Current.Lookup("_id"))] = sha256.Sum256(cursor.Current)
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read restored documents of %s: %w", coll.Name(), err)
+	}
+	return restored, nil
+}
+
+// idKey identifies an _id value by its type and encoding, so values of different types never match
+func idKey(id bson.RawValue) string {
+	return string([]byte{byte(id.Type)}) + string(id.Value)
+}
+
+// documentID renders the _id of a document as extended JSON, e.g. {"_id":"b"}
+func documentID(doc bson.Raw) string {
+	id, _ := bson.MarshalExtJSON(bson.D{{Key: "_id", Value: doc.Lookup("_id")}}, false, false)
+	return string(id)
+}
+
+// sortedKeys returns the keys of a map in order
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,46 @@
+package dump
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestIndexKeys(t *testing.T) {
+	indexes := []bson.Raw{
+		mustMarshal(t, bson.D{{Key: "v", Value: int32(2)}, {Key: "key", Value: bson.D{{Key: "_id", Value: int32(1)}}}, {Key: "name", Value: "_id_"}}),
+		mustMarshal(t, bson.D{
+			{Key: "key", Value: bson.D{{Key: "email", Value: 1.0}, {Key: "createdAt", Value: int64(-1)}}},
+			{Key: "name", Value: "email_1_createdAt_-1"},
+		}),
+		mustMarshal(t, bson.D{{Key: "key", Value: bson.D{{Key: "location", Value: "2dsphere"}}}, {Key: "name", Value: "location_2dsphere"}}),
+	}
+
+	assert.Equal(t, map[string]string{
+		"email_1_createdAt_-1": "email_1,createdAt_-1",
+		"location_2dsphere":    "location_2dsphere",
+	}, indexKeys(indexes))
+}
+
+func TestIndexProblems(t *testing.T) {
+	expected := map[string]string{"email_1": "email_1", "age_1": "age_1", "name_1": "name_1"}
+	actual := map[string]string{"email_1": "email_1", "age_1": "age_-1", "city_1": "city_1"}
+
+	assert.Equal(t, []string{
+		"index age_1 has keys age_-1, the dump has age_1",
+		"index name_1 is missing",
+		"index city_1 is not in the dump",
+	}, indexProblems(expected, actual))
+	assert.Empty(t, indexProblems(expected, expected))
+}
+
+func TestIDKey(t *testing.T) {
+	intID := mustMarshal(t, bson.D{{Key: "_id", Value: int32(1)}}).Lookup("_id")
+	longID := mustMarshal(t, bson.D{{Key: "_id", Value: int64(1)}}).Lookup("_id")
+	sameID := mustMarshal(t, bson.D{{Key: "_id", Value: int32(1)}, {Key: "name", Value: "a"}}).Lookup("_id")
+
+	assert.Equal(t, idKey(intID), idKey(sameID))
+	assert.NotEqual(t, idKey(intID), idKey(longID))
+	assert.Equal(t, `{"_id":1}`, documentID(mustMarshal(t, bson.D{{Key: "_id", Value: int32(1)}})))
+}
@@ -0,0 +1,211 @@
+package dump
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+
+	"nmongo/internal/storage"
+)
+
+// Compression formats of dump files
+const (
+	CompressionNone = "none"
+	CompressionGzip = "gzip"
+	CompressionZstd = "zstd"
+)
+
+// compressionFormats lists the supported formats in the order dump files are looked up
+var compressionFormats = []string{CompressionNone, CompressionGzip, CompressionZstd}
+
+// compressionExtensions maps each compression format to the suffix added to file names
+var compressionExtensions = map[string]string{
+	CompressionNone: "",
+	CompressionGzip: ".gz",
+	CompressionZstd: ".zst",
+}
+
+// ValidateCompression checks that a compression format is supported
+func ValidateCompression(compression string) error {
+	if _, ok := compressionExtensions[compression]; !ok {
+		return fmt.Errorf("unknown compression %q, expected %q, %q or %q", compression, CompressionNone, CompressionGzip, CompressionZstd)
+	}
+	return nil
+}
+
+// CompressedName returns a file name with the suffix of the compression format
+func CompressedName(name, compression string) string {
+	return name + compressionExtensions[compression]
+}
+
+// StoredName returns the name a dump file is stored under with the given compression format and encryption
+func StoredName(name, compression string, encrypted bool) string {
+	if encrypted {
+		return CompressedName(name, compression) + EncryptedSuffix
+	}
+	return CompressedName(name, compression)
+}
+
+// fileVariant is a name a dump file can be stored under
+type fileVariant struct {
+	name        string
+	compression string
+}
+
+// fileVariants lists the names a dump file can be stored under, in lookup order
+func fileVariants(name string) []fileVariant {
+	variants := make([]fileVariant, 0, 2*len(compressionFormats))
+	for _, format := range compressionFormats {
+		variants = append(variants,
+			fileVariant{name: StoredName(name, format, false), compression: format},
+			fileVariant{name: StoredName(name, format, true), compression: format})
+	}
+	return variants
+}
+
+// FindFile locates a dump file stored with any supported compression, encrypted or not,
+// and returns its path and compression format
+func FindFile(dir, name string) (path, compression string, found bool) {
+	for _, variant := range fileVariants(name) {
+		path := storage.Join(dir, variant.name)
+		if exists, err := storage.Exists(path); err == nil && exists {
+			return path, variant.compression, true
+		}
+	}
+	return "", "", false
+}
+
+// RemoveStaleVariants removes copies of a dump file written with another compression format or encryption setting,
+// so a later restore cannot pick up an outdated file. keep is the stored name of the current file.
+func RemoveStaleVariants(dir, name, keep string) error {
+	for _, variant := range fileVariants(name) {
+		if variant.name == keep {
+			continue
+		}
+		if err := storage.Remove(storage.Join(dir, variant.name)); err != nil {
+			return fmt.Errorf("failed to remove stale dump file: %w", err)
+		}
+	}
+	return nil
+}
+
+// newCompressor wraps a writer so data written to it is compressed; Close flushes the compressed stream
+func newCompressor(w io.Writer, compression string) (io.WriteCloser, error) {
+	switch compression {
+	case CompressionGzip:
+		return gzip.NewWriter(w), nil
+	case CompressionZstd:
+		return zstd.NewWriter(w)
+	case CompressionNone, "":
+		return nopWriteCloser{w}, nil
+	default:
+		return nil, ValidateCompression(compression)
+	}
+}
+
+// newDecompressor wraps a reader of compressed data
+func newDecompressor(r io.Reader, compression string) (io.ReadCloser, error) {
+	switch compression {
+	case CompressionGzip:
+		return gzip.NewReader(r)
+	case CompressionZstd:
+		decoder, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return decoder.IOReadCloser(), nil
+	case CompressionNone, "":
+		return io.NopCloser(r), nil
+	default:
+		return nil, ValidateCompression(compression)
+	}
+}
+
+// OpenFile opens a dump file, decrypting it with the keyring if it is encrypted, and decompresses its content
+func OpenFile(path, compression string, keys *Keyring) (io.ReadCloser, error) {
+	file, err := storage.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var source io.Reader = file
+	if IsEncrypted(path) {
+		if source, err = newDecryptor(file, keys); err != nil {
+			file.Close()
+			return nil, fmt.Errorf("failed to read %s: %w", path, err)
+		}
+	}
+
+	decompressor, err := newDecompressor(source, compression)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	return &compressedFile{ReadCloser: decompressor, file: file}, nil
+}
+
+// compressedFile closes both the decompressor and the underlying file
+type compressedFile struct {
+	io.ReadCloser
+	file io.Closer
+}
+
+// Close releases the decompressor and closes the file
+func (f *compressedFile) Close() error {
+	err := f.ReadCloser.Close()
+	if closeErr := f.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// nopWriteCloser adds a no-op Close to a writer
+type nopWriteCloser struct {
+	io.Writer
+}
+
+// Close does nothing
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+// FileStats describes the size of a dump file before and after compression and encryption
+type FileStats struct {
+	RawBytes    int64
+	StoredBytes int64
+}
+
+// Ratio returns how many times smaller the stored file is than its content
+func (s FileStats) Ratio() float64 {
+	if s.StoredBytes == 0 {
+		return 0
+	}
+	return float64(s.RawBytes) / float64(s.StoredBytes)
+}
+
+// MeasureFile returns the stored and uncompressed size of a dump file.
+// Compressed and encrypted files are read in full to count their uncompressed size.
+func MeasureFile(path, compression string, keys *Keyring) (FileStats, error) {
+	info, err := storage.Stat(path)
+	if err != nil {
+		return FileStats{}, err
+	}
+
+	stats := FileStats{RawBytes: info.Size, StoredBytes: info.Size}
+	if (compression == CompressionNone || compression == "") && !IsEncrypted(path) {
+		return stats, nil
+	}
+
+	reader, err := OpenFile(path, compression, keys)
+	if err != nil {
+		return stats, err
+	}
+	defer reader.Close()
+
+	if stats.RawBytes, err = io.Copy(io.Discard, reader); err != nil {
+		return stats, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	return stats, nil
+}
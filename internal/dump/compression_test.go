@@ -0,0 +1,87 @@
+package dump
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompressedFileRoundTrip(t *testing.T) {
+	content := bytes.Repeat([]byte("nmongo dump compression "), 1000)
+
+	tests := []struct {
+		compression string
+		fileName    string
+	}{
+		{compression: CompressionNone, fileName: "users.bson"},
+		{compression: CompressionGzip, fileName: "users.bson.gz"},
+		{compression: CompressionZstd, fileName: "users.bson.zst"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.compression, func(t *testing.T) {
+			dir := t.TempDir()
+			err := writeCompressedFile(dir, "users.bson", tt.compression, nil, func(w io.Writer) error {
+				_, err := w.Write(content)
+				return err
+			})
+			require.NoError(t, err)
+
+			path, compression, found := FindFile(dir, "users.bson")
+			require.True(t, found)
+			assert.Equal(t, filepath.Join(dir, tt.fileName), path)
+			assert.Equal(t, tt.compression, compression)
+
+			data, err := readFile(path, compression, nil)
+			require.NoError(t, err)
+			assert.Equal(t, content, data)
+
+			stats, err := MeasureFile(path, compression, nil)
+			require.NoError(t, err)
+			assert.Equal(t, int64(len(content)), stats.RawBytes)
+			if tt.compression == CompressionNone {
+				assert.Equal(t, 1.0, stats.Ratio())
+			} else {
+				assert.Greater(t, stats.Ratio(), 10.0)
+			}
+		})
+	}
+}
+
+func TestWriteCompressedFileRemovesStaleVariants(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "users.bson"), []byte("old"), 0600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "users.bson.zst"), []byte("old"), 0600))
+
+	err := writeCompressedFile(dir, "users.bson", CompressionGzip, nil, func(w io.Writer) error {
+		_, err := w.Write([]byte("new"))
+		return err
+	})
+	require.NoError(t, err)
+
+	assert.NoFileExists(t, filepath.Join(dir, "users.bson"))
+	assert.NoFileExists(t, filepath.Join(dir, "users.bson.zst"))
+	assert.FileExists(t, filepath.Join(dir, "users.bson.gz"))
+}
+
+func TestValidateCompression(t *testing.T) {
+	assert.NoError(t, ValidateCompression(CompressionNone))
+	assert.NoError(t, ValidateCompression(CompressionGzip))
+	assert.NoError(t, ValidateCompression(CompressionZstd))
+	assert.ErrorContains(t, ValidateCompression("lz4"), "unknown compression")
+}
+
+func TestFindFileMissing(t *testing.T) {
+	_, _, found := FindFile(t.TempDir(), "users.bson")
+	assert.False(t, found)
+}
+
+func TestFileStatsRatio(t *testing.T) {
+	assert.Equal(t, 0.0, FileStats{}.Ratio())
+	assert.Equal(t, 4.0, FileStats{RawBytes: 400, StoredBytes: 100}.Ratio())
+}
@@ -0,0 +1,352 @@
+package dump
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// KeyEnvVar is the environment variable holding encryption keys when no key file is given
+const KeyEnvVar = "NMONGO_ENCRYPT_KEY"
+
+// EncryptedSuffix is appended to the names of encrypted dump files
+const EncryptedSuffix = ".enc"
+
+// Layout of encrypted files: a header followed by AES-256-GCM sealed chunks.
+// Each chunk nonce is the file's random nonce prefix, the chunk counter and a flag marking the last chunk,
+// so reordered, duplicated or truncated chunks fail authentication. The header is authenticated with every chunk.
+const (
+	encryptionMagic     = "NMENC"
+	encryptionVersion   = 1
+	keyIDSize           = 8
+	noncePrefixSize     = 7
+	encryptionChunkSize = 64 * 1024
+	maxChunkSize        = 16 * 1024 * 1024
+	keySize             = 32
+	headerSize          = len(encryptionMagic) + 1 + keyIDSize + 4 + noncePrefixSize
+)
+
+// ErrAuthenticationFailed is returned when an encrypted file was modified, truncated or read with the wrong key
+var ErrAuthenticationFailed = errors.New("encrypted dump file failed authentication")
+
+// ErrKeyRequired is returned when reading an encrypted file without any keys
+var ErrKeyRequired = errors.New("dump file is encrypted, an encryption key is required")
+
+// Key is an AES-256 key with the ID recorded in the header of files it encrypts
+type Key struct {
+	id  [keyIDSize]byte
+	key []byte
+}
+
+// ID returns the hex-encoded key ID
+func (k *Key) ID() string {
+	return hex.EncodeToString(k.id[:])
+}
+
+// Keyring holds the keys used to read and write encrypted dumps.
+// The first key encrypts new files; all keys can decrypt, which allows keys to be rotated.
+type Keyring struct {
+	keys []*Key
+}
+
+// Primary returns the key new files are encrypted with
+func (k *Keyring) Primary() *Key {
+	return k.keys[0]
+}
+
+// IDs returns the hex-encoded IDs of the keys in the keyring
+func (k *Keyring) IDs() []string {
+	ids := make([]string, 0, len(k.keys))
+	for _, key := range k.keys {
+		ids = append(ids, key.ID())
+	}
+	return ids
+}
+
+// lookup returns the key with the given ID
+func (k *Keyring) lookup(id []byte) *Key {
+	for _, key := range k.keys {
+		if bytes.Equal(key.id[:], id) {
+			return key
+		}
+	}
+	return nil
+}
+
+// LoadKeyring loads the encryption keys from a key file or, without one, from the NMONGO_ENCRYPT_KEY environment variable.
+// It returns nil when neither is set, meaning dumps are not encrypted.
+func LoadKeyring(keyFile string) (*Keyring, error) {
+	if keyFile != "" {
+		data, err := os.ReadFile(keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read encryption key file: %w", err)
+		}
+		return ParseKeyring(string(data))
+	}
+
+	if value := os.Getenv(KeyEnvVar); value != "" {
+		return ParseKeyring(value)
+	}
+	return nil, nil
+}
+
+// ParseKeyring parses 32-byte keys encoded as hex or base64, one per line or separated by commas.
+// Empty lines and lines starting with # are ignored.
+func ParseKeyring(data string) (*Keyring, error) {
+	keyring := &Keyring{}
+	for _, line := range strings.Split(data, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		for _, field := range strings.Split(line, ",") {
+			key, err := parseKey(strings.TrimSpace(field))
+			if err != nil {
+				return nil, err
+			}
+			keyring.keys = append(keyring.keys, key)
+		}
+	}
+
+	if len(keyring.keys) == 0 {
+		return nil, errors.New("no encryption keys found")
+	}
+	return keyring, nil
+}
+
+// parseKey decodes a hex or base64 encoded 32-byte key
+func parseKey(encoded string) (*Key, error) {
+	var raw []byte
+	var err error
+	if len(encoded) == hex.EncodedLen(keySize) {
+		raw, err = hex.DecodeString(encoded)
+	} else {
+		raw, err = base64.StdEncoding.DecodeString(encoded)
+	}
+	if err != nil || len(raw) != keySize {
+		return nil, fmt.Errorf("invalid encryption key: expected %d bytes encoded as hex or base64", keySize)
+	}
+
+	key := &Key{key: raw}
+	sum := sha256.Sum256(raw)
+	copy(key.id[:], sum[:keyIDSize])
+	return key, nil
+}
+
+// IsEncrypted reports whether a dump file is encrypted, based on its name
+func IsEncrypted(path string) bool {
+	return strings.HasSuffix(path, EncryptedSuffix)
+}
+
+// newGCM creates the AES-GCM cipher for a key
+func newGCM(key *Key) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key.key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// chunkNonce returns the nonce of a chunk
+func chunkNonce(prefix []byte, counter uint32, last bool) []byte {
+	nonce := make([]byte, 0, noncePrefixSize+5)
+	nonce = append(nonce, prefix...)
+	nonce = binary.BigEndian.AppendUint32(nonce, counter)
+	if last {
+		return append(nonce, 1)
+	}
+	return append(nonce, 0)
+}
+
+// encryptWriter encrypts data written to it in authenticated chunks
+type encryptWriter struct {
+	w       io.Writer
+	aead    cipher.AEAD
+	header  []byte
+	prefix  []byte
+	buf     []byte
+	counter uint32
+}
+
+// newEncryptor writes the header of an encrypted file and returns a writer encrypting data with the key.
+// Close seals the last chunk without closing w.
+func newEncryptor(w io.Writer, key *Key) (io.WriteCloser, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := make([]byte, noncePrefixSize)
+	if _, err := rand.Read(prefix); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	header := make([]byte, 0, headerSize)
+	header = append(header, encryptionMagic...)
+	header = append(header, encryptionVersion)
+	header = append(header, key.id[:]...)
+	header = binary.BigEndian.AppendUint32(header, encryptionChunkSize)
+	header = append(header, prefix...)
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+
+	return &encryptWriter{
+		w:      w,
+		aead:   aead,
+		header: header,
+		prefix: prefix,
+		buf:    make([]byte, 0, encryptionChunkSize),
+	}, nil
+}
+
+// Write buffers data and seals every full chunk once more data follows it
+func (e *encryptWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		if len(e.buf) == encryptionChunkSize {
+			if err := e.seal(false); err != nil {
+				return written, err
+			}
+		}
+		n := copy(e.buf[len(e.buf):encryptionChunkSize], p)
+		e.buf = e.buf[:len(e.buf)+n]
+		p = p[n:]
+		written += n
+	}
+	return written, nil
+}
+
+// Close seals the buffered data as the last chunk
+func (e *encryptWriter) Close() error {
+	return e.seal(true)
+}
+
+// seal encrypts and writes the buffered chunk
+func (e *encryptWriter) seal(last bool) error {
+	if e.counter == ^uint32(0) {
+		return errors.New("encrypted file exceeds the maximum number of chunks")
+	}
+
+	sealed := e.aead.Seal(nil, chunkNonce(e.prefix, e.counter, last), e.buf, e.header)
+	if _, err := e.w.Write(sealed); err != nil {
+		return err
+	}
+	e.counter++
+	e.buf = e.buf[:0]
+	return nil
+}
+
+// decryptReader decrypts and authenticates the chunks of an encrypted file
+type decryptReader struct {
+	r       *bufio.Reader
+	aead    cipher.AEAD
+	header  []byte
+	prefix  []byte
+	sealed  []byte
+	opened  []byte
+	plain   []byte
+	counter uint32
+	done    bool
+}
+
+// newDecryptor reads the header of an encrypted file and returns a reader of its decrypted content.
+// The key is selected from the keyring by the key ID in the header.
+func newDecryptor(r io.Reader, keys *Keyring) (io.Reader, error) {
+	if keys == nil {
+		return nil, ErrKeyRequired
+	}
+
+	br := bufio.NewReader(r)
+	header, keyID, chunkSize, err := readEncryptionHeader(br)
+	if err != nil {
+		return nil, err
+	}
+
+	key := keys.lookup(keyID)
+	if key == nil {
+		return nil, fmt.Errorf("file was encrypted with key %s, which is not in the keyring", hex.EncodeToString(keyID))
+	}
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &decryptReader{
+		r:      br,
+		aead:   aead,
+		header: header,
+		prefix: header[headerSize-noncePrefixSize:],
+		sealed: make([]byte, int(chunkSize)+aead.Overhead()),
+		opened: make([]byte, 0, chunkSize),
+	}, nil
+}
+
+// readEncryptionHeader reads and validates the header of an encrypted file
+func readEncryptionHeader(r io.Reader) (header, keyID []byte, chunkSize uint32, err error) {
+	header = make([]byte, headerSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, nil, 0, fmt.Errorf("failed to read encryption header: %w", err)
+	}
+	if string(header[:len(encryptionMagic)]) != encryptionMagic || header[len(encryptionMagic)] != encryptionVersion {
+		return nil, nil, 0, errors.New("not an encrypted dump file or unsupported encryption version")
+	}
+
+	offset := len(encryptionMagic) + 1
+	keyID = header[offset : offset+keyIDSize]
+	chunkSize = binary.BigEndian.Uint32(header[offset+keyIDSize:])
+	if chunkSize == 0 || chunkSize > maxChunkSize {
+		return nil, nil, 0, fmt.Errorf("invalid encryption chunk size %d", chunkSize)
+	}
+	return header, keyID, chunkSize, nil
+}
+
+// Read returns decrypted data, opening the next chunk when the current one is used up
+func (d *decryptReader) Read(p []byte) (int, error) {
+	for len(d.plain) == 0 {
+		if d.done {
+			return 0, io.EOF
+		}
+		if err := d.open(); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, d.plain)
+	d.plain = d.plain[n:]
+	return n, nil
+}
+
+// open reads and authenticates the next chunk. A chunk is the last one if it is short or followed by the end of the file.
+func (d *decryptReader) open() error {
+	n, err := io.ReadFull(d.r, d.sealed)
+	last := errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF)
+	if err != nil && !last {
+		return fmt.Errorf("failed to read encrypted chunk %d: %w", d.counter, err)
+	}
+	if !last {
+		_, peekErr := d.r.Peek(1)
+		last = errors.Is(peekErr, io.EOF)
+	}
+
+	plain, err := d.aead.Open(d.opened[:0], chunkNonce(d.prefix, d.counter, last), d.sealed[:n], d.header)
+	if err != nil {
+		return fmt.Errorf("%w: chunk %d", ErrAuthenticationFailed, d.counter)
+	}
+
+	d.plain = plain
+	d.counter++
+	d.done = last
+	return nil
+}
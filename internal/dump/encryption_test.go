@@ -0,0 +1,222 @@
+package dump
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testKeyring returns a keyring of keys filled with the given bytes
+func testKeyring(t *testing.T, fills ...byte) *Keyring {
+	var encoded []string
+	for _, fill := range fills {
+		encoded = append(encoded, hex.EncodeToString(bytes.Repeat([]byte{fill}, keySize)))
+	}
+	keyring, err := ParseKeyring(strings.Join(encoded, "\n"))
+	require.NoError(t, err)
+	return keyring
+}
+
+// encrypt encrypts data with the primary key of the keyring
+func encrypt(t *testing.T, keys *Keyring, data []byte) []byte {
+	var buf bytes.Buffer
+	encryptor, err := newEncryptor(&buf, keys.Primary())
+	require.NoError(t, err)
+	_, err = encryptor.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, encryptor.Close())
+	return buf.Bytes()
+}
+
+// decrypt decrypts data with the keyring
+func decrypt(keys *Keyring, data []byte) ([]byte, error) {
+	decryptor, err := newDecryptor(bytes.NewReader(data), keys)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(decryptor)
+}
+
+func TestEncryptionRoundTrip(t *testing.T) {
+	keys := testKeyring(t, 1)
+
+	tests := []struct {
+		name string
+		size int
+	}{
+		{name: "Empty", size: 0},
+		{name: "Small", size: 100},
+		{name: "ExactChunk", size: encryptionChunkSize},
+		{name: "MultipleChunks", size: 3*encryptionChunkSize + 17},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := bytes.Repeat([]byte("x"), tt.size)
+			sealed := encrypt(t, keys, data)
+			assert.NotContains(t, string(sealed), strings.Repeat("x", 32))
+
+			opened, err := decrypt(keys, sealed)
+			require.NoError(t, err)
+			assert.Equal(t, data, append([]byte{}, opened...))
+		})
+	}
+}
+
+func TestEncryptionRejectsModifiedFiles(t *testing.T) {
+	keys := testKeyring(t, 1)
+	data := bytes.Repeat([]byte("nmongo"), encryptionChunkSize)
+	sealed := encrypt(t, keys, data)
+	chunkSize := encryptionChunkSize + 16
+
+	tests := []struct {
+		name   string
+		modify func(sealed []byte) []byte
+	}{
+		{name: "FlippedBit", modify: func(sealed []byte) []byte {
+			sealed[headerSize+chunkSize+10] ^= 1
+			return sealed
+		}},
+		{name: "ModifiedHeader", modify: func(sealed []byte) []byte {
+			sealed[headerSize-1] ^= 1
+			return sealed
+		}},
+		{name: "TruncatedChunk", modify: func(sealed []byte) []byte {
+			return sealed[:len(sealed)-5]
+		}},
+		{name: "DroppedLastChunk", modify: func(sealed []byte) []byte {
+			return sealed[:headerSize+2*chunkSize]
+		}},
+		{name: "SwappedChunks", modify: func(sealed []byte) []byte {
+			swapped := append([]byte{}, sealed[:headerSize]...)
+			swapped = append(swapped, sealed[headerSize+chunkSize:headerSize+2*chunkSize]...)
+			swapped = append(swapped, sealed[headerSize:headerSize+chunkSize]...)
+			return append(swapped, sealed[headerSize+2*chunkSize:]...)
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := decrypt(keys, tt.modify(append([]byte{}, sealed...)))
+			assert.ErrorIs(t, err, ErrAuthenticationFailed)
+		})
+	}
+}
+
+func TestEncryptionKeys(t *testing.T) {
+	oldKeys := testKeyring(t, 1)
+	sealed := encrypt(t, oldKeys, []byte("data"))
+
+	t.Run("UnknownKey", func(t *testing.T) {
+		_, err := decrypt(testKeyring(t, 2), sealed)
+		assert.ErrorContains(t, err, "not in the keyring")
+	})
+
+	t.Run("MissingKeyring", func(t *testing.T) {
+		_, err := decrypt(nil, sealed)
+		assert.ErrorIs(t, err, ErrKeyRequired)
+	})
+
+	t.Run("Rotation", func(t *testing.T) {
+		// A new primary key encrypts new files while the old key still decrypts existing ones
+		rotated := testKeyring(t, 2, 1)
+		opened, err := decrypt(rotated, sealed)
+		require.NoError(t, err)
+		assert.Equal(t, "data", string(opened))
+
+		_, err = decrypt(oldKeys, encrypt(t, rotated, []byte("data")))
+		assert.ErrorContains(t, err, "not in the keyring")
+	})
+}
+
+func TestParseKeyring(t *testing.T) {
+	raw := bytes.Repeat([]byte{7}, keySize)
+
+	tests := []struct {
+		name        string
+		data        string
+		keys        int
+		expectError bool
+	}{
+		{name: "Hex", data: hex.EncodeToString(raw), keys: 1},
+		{name: "Base64", data: base64.StdEncoding.EncodeToString(raw), keys: 1},
+		{name: "Lines", data: "# primary\n" + hex.EncodeToString(raw) + "\n\n" + base64.StdEncoding.EncodeToString(raw) + "\n", keys: 2},
+		{name: "Commas", data: hex.EncodeToString(raw) + ", " + hex.EncodeToString(raw), keys: 2},
+		{name: "Empty", data: "# no keys\n", expectError: true},
+		{name: "ShortKey", data: hex.EncodeToString(raw[:16]), expectError: true},
+		{name: "Invalid", data: "not a key", expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keyring, err := ParseKeyring(tt.data)
+			if tt.expectError {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Len(t, keyring.IDs(), tt.keys)
+		})
+	}
+}
+
+func TestLoadKeyring(t *testing.T) {
+	encoded := hex.EncodeToString(bytes.Repeat([]byte{3}, keySize))
+
+	t.Setenv(KeyEnvVar, "")
+	keyring, err := LoadKeyring("")
+	require.NoError(t, err)
+	assert.Nil(t, keyring)
+
+	t.Setenv(KeyEnvVar, encoded)
+	keyring, err = LoadKeyring("")
+	require.NoError(t, err)
+	require.NotNil(t, keyring)
+
+	keyFile := filepath.Join(t.TempDir(), "keys")
+	require.NoError(t, os.WriteFile(keyFile, []byte(encoded+"\n"), 0600))
+	fromFile, err := LoadKeyring(keyFile)
+	require.NoError(t, err)
+	assert.Equal(t, keyring.IDs(), fromFile.IDs())
+
+	_, err = LoadKeyring(filepath.Join(t.TempDir(), "missing"))
+	assert.Error(t, err)
+}
+
+func TestEncryptedCompressedFile(t *testing.T) {
+	keys := testKeyring(t, 1)
+	content := bytes.Repeat([]byte("nmongo dump encryption "), 10000)
+
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "users.bson.zst"), []byte("old"), 0600))
+	err := writeCompressedFile(dir, "users.bson", CompressionZstd, keys.Primary(), func(w io.Writer) error {
+		_, err := w.Write(content)
+		return err
+	})
+	require.NoError(t, err)
+	assert.NoFileExists(t, filepath.Join(dir, "users.bson.zst"))
+
+	path, compression, found := FindFile(dir, "users.bson")
+	require.True(t, found)
+	assert.Equal(t, filepath.Join(dir, "users.bson.zst.enc"), path)
+	assert.Equal(t, CompressionZstd, compression)
+
+	data, err := readFile(path, compression, keys)
+	require.NoError(t, err)
+	assert.Equal(t, content, data)
+
+	stats, err := MeasureFile(path, compression, keys)
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(content)), stats.RawBytes)
+
+	_, err = readFile(path, compression, nil)
+	assert.ErrorIs(t, err, ErrKeyRequired)
+}
@@ -0,0 +1,235 @@
+package dump
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"nmongo/internal/storage"
+)
+
+// ManifestFileName is the name of the manifest in the dump root
+const ManifestFileName = "manifest.json"
+
+// ManifestVersion is the version of the manifest format
+const ManifestVersion = 1
+
+// Kinds of dump files listed in a manifest
+const (
+	FileTypeData     = "data"
+	FileTypeMetadata = "metadata"
+	FileTypeChanges  = "changes"
+)
+
+// Manifest lists the files of a dump with their sizes, checksums and document counts,
+// so a dump can be verified without the database it was taken from
+type Manifest struct {
+	Version   int            `json:"version"`
+	UpdatedAt time.Time      `json:"updatedAt"`
+	Files     []ManifestFile `json:"files"`
+}
+
+// ManifestFile describes a dump file as it was written
+type ManifestFile struct {
+	// Path is relative to the dump root and uses forward slashes
+	Path        string `json:"path"`
+	Database    string `json:"database"`
+	Collection  string `json:"collection"`
+	Type        string `json:"type"`
+	Compression string `json:"compression"`
+	Encrypted   bool   `json:"encrypted,omitempty"`
+	// Size is the size of the stored file and SHA256 the hex-encoded hash of its stored bytes
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+	// RawSize and Documents are the uncompressed size and the document count of data and changes files
+	RawSize   int64 `json:"rawSize,omitempty"`
+	Documents int64 `json:"documents"`
+}
+
+// NewManifest creates an empty manifest
+func NewManifest() *Manifest {
+	return &Manifest{Version: ManifestVersion, Files: []ManifestFile{}}
+}
+
+// ReadManifest reads the manifest of a dump. The error wraps fs.ErrNotExist when the dump has no manifest.
+func ReadManifest(root string) (*Manifest, error) {
+	data, err := storage.ReadFile(storage.Join(root, ManifestFileName))
+	if err != nil {
+		return nil, err
+	}
+
+	var manifest Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest: %w", err)
+	}
+	if manifest.Version > ManifestVersion {
+		return nil, fmt.Errorf("manifest version %d is newer than the supported version %d", manifest.Version, ManifestVersion)
+	}
+	return &manifest, nil
+}
+
+// WriteManifest atomically writes the manifest to the dump root
+func WriteManifest(root string, manifest *Manifest) error {
+	manifest.UpdatedAt = time.Now().UTC()
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode manifest: %w", err)
+	}
+
+	return storage.WriteBytes(storage.Join(root, ManifestFileName), data)
+}
+
+// ManifestHash returns the hex-encoded SHA-256 hash of the manifest of a dump, which changes whenever the dump
+// is written again. The error wraps fs.ErrNotExist when the dump has no manifest.
+func ManifestHash(root string) (string, error) {
+	_, hash, err := hashFile(storage.Join(root, ManifestFileName))
+	return hash, err
+}
+
+// SetCollection replaces the entries of a collection with the given files
+func (m *Manifest) SetCollection(dbName, collName string, files []ManifestFile) {
+	kept := m.Files[:0]
+	for _, file := range m.Files {
+		if file.Database != dbName || file.Collection != collName {
+			kept = append(kept, file)
+		}
+	}
+	m.Files = append(kept, files...)
+	sort.Slice(m.Files, func(i, j int) bool { return m.Files[i].Path < m.Files[j].Path })
+}
+
+// SetChangesFile replaces the entry of the changes file, which belongs to no collection
+func (m *Manifest) SetChangesFile(file ManifestFile) {
+	m.SetCollection("", "", []ManifestFile{file})
+}
+
+// DataFile returns the entry of a collection's data file
+func (m *Manifest) DataFile(dbName, collName string) (ManifestFile, bool) {
+	for _, file := range m.Files {
+		if file.Database == dbName && file.Collection == collName && file.Type == FileTypeData {
+			return file, true
+		}
+	}
+	return ManifestFile{}, false
+}
+
+// DescribeCollection describes the data and metadata files of a dumped collection.
+// dataFile is the uncompressed name of its data file, which differs from the collection name for time-series collections.
+func DescribeCollection(root, dbName, collName, dataFile string, keys *Keyring) ([]ManifestFile, error) {
+	dbDir := storage.Join(root, dbName)
+	var files []ManifestFile
+	for _, name := range []string{dataFile, MetadataFileName(collName)} {
+		path, compression, found := FindFile(dbDir, name)
+		if !found {
+			continue
+		}
+
+		file, err := DescribeFile(root, path, compression, keys)
+		if err != nil {
+			return nil, err
+		}
+		file.Database = dbName
+		file.Collection = collName
+		files = append(files, file)
+	}
+	return files, nil
+}
+
+// DescribeFile hashes a dump file and, for data and changes files, counts its documents
+func DescribeFile(root, path, compression string, keys *Keyring) (ManifestFile, error) {
+	relPath, err := storage.Rel(root, path)
+	if err != nil {
+		return ManifestFile{}, err
+	}
+
+	file := ManifestFile{
+		Path:        relPath,
+		Type:        fileType(path),
+		Compression: compression,
+		Encrypted:   IsEncrypted(path),
+	}
+	if file.Size, file.SHA256, err = hashFile(path); err != nil {
+		return file, err
+	}
+	if holdsDocuments(file.Type) {
+		if file.Documents, file.RawSize, err = CountDocuments(path, compression, keys); err != nil {
+			return file, err
+		}
+	}
+	return file, nil
+}
+
+// CountDocuments reads every document of a data file, validating each one, and returns their count and total size
+func CountDocuments(path, compression string, keys *Keyring) (count, size int64, err error) {
+	file, err := OpenFile(path, compression, keys)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer file.Close()
+
+	reader := NewReader(file)
+	for {
+		doc, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			return count, size, nil
+		}
+		if err != nil {
+			return count, size, fmt.Errorf("failed to read %s: %w", path, err)
+		}
+		count++
+		size += int64(len(doc))
+	}
+}
+
+// hashFile returns the size and hex-encoded SHA-256 hash of a file
+func hashFile(path string) (int64, string, error) {
+	file, err := storage.Open(path)
+	if err != nil {
+		return 0, "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(hash, file)
+	if err != nil {
+		return 0, "", fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	return size, hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// baseFileName returns the name of a dump file without its compression and encryption suffixes
+func baseFileName(name string) string {
+	name = strings.TrimSuffix(name, EncryptedSuffix)
+	for _, extension := range compressionExtensions {
+		if extension != "" && strings.HasSuffix(name, extension) {
+			return strings.TrimSuffix(name, extension)
+		}
+	}
+	return name
+}
+
+// fileType returns whether a dump file holds documents, metadata or change events, or "" for other files
+func fileType(path string) string {
+	name := baseFileName(storage.Base(path))
+	switch {
+	case name == ChangesFileName:
+		return FileTypeChanges
+	case strings.HasSuffix(name, ".metadata.json"):
+		return FileTypeMetadata
+	case strings.HasSuffix(name, ".bson"):
+		return FileTypeData
+	default:
+		return ""
+	}
+}
+
+// holdsDocuments reports whether files of a type are BSON files whose documents are counted
+func holdsDocuments(fileType string) bool {
+	return fileType == FileTypeData || fileType == FileTypeChanges
+}
@@ -0,0 +1,163 @@
+package dump
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// writeTestDump writes a users collection with three documents and metadata, and returns its manifest
+func writeTestDump(t *testing.T, root, compression string, key *Key, keys *Keyring) *Manifest {
+	dbDir := filepath.Join(root, "mydb")
+	require.NoError(t, os.MkdirAll(dbDir, 0755))
+
+	err := writeCompressedFile(dbDir, BSONFileName("users"), compression, key, func(w io.Writer) error {
+		for i := 0; i < 3; i++ {
+			if _, err := w.Write(mustMarshal(t, bson.D{{Key: "_id", Value: i}})); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	meta := &Metadata{CollectionName: "users", Type: TypeCollection}
+	require.NoError(t, writeMetadataFile(dbDir, MetadataFileName("users"), compression, key, meta))
+
+	files, err := DescribeCollection(root, "mydb", "users", BSONFileName("users"), keys)
+	require.NoError(t, err)
+	manifest := NewManifest()
+	manifest.SetCollection("mydb", "users", files)
+	require.NoError(t, WriteManifest(root, manifest))
+	return manifest
+}
+
+func TestDescribeCollection(t *testing.T) {
+	root := t.TempDir()
+	manifest := writeTestDump(t, root, CompressionGzip, nil, nil)
+
+	require.Len(t, manifest.Files, 2)
+	data, found := manifest.DataFile("mydb", "users")
+	require.True(t, found)
+	assert.Equal(t, "mydb/users.bson.gz", data.Path)
+	assert.Equal(t, FileTypeData, data.Type)
+	assert.Equal(t, CompressionGzip, data.Compression)
+	assert.Equal(t, int64(3), data.Documents)
+	assert.Equal(t, int64(3*len(mustMarshal(t, bson.D{{Key: "_id", Value: 0}}))), data.RawSize)
+	assert.Len(t, data.SHA256, 64)
+
+	assert.Equal(t, "mydb/users.metadata.json.gz", manifest.Files[1].Path)
+	assert.Equal(t, FileTypeMetadata, manifest.Files[1].Type)
+
+	read, err := ReadManifest(root)
+	require.NoError(t, err)
+	assert.Equal(t, manifest.Files, read.Files)
+
+	// Dumping the collection again replaces its entries
+	manifest.SetCollection("mydb", "users", []ManifestFile{data})
+	assert.Len(t, manifest.Files, 1)
+}
+
+func TestReadManifestMissing(t *testing.T) {
+	_, err := ReadManifest(t.TempDir())
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestManifestHash(t *testing.T) {
+	root := t.TempDir()
+	_, err := ManifestHash(root)
+	assert.True(t, os.IsNotExist(err))
+
+	manifest := writeTestDump(t, root, CompressionNone, nil, nil)
+	hash, err := ManifestHash(root)
+	require.NoError(t, err)
+	assert.Len(t, hash, 64)
+
+	unchanged, err := ManifestHash(root)
+	require.NoError(t, err)
+	assert.Equal(t, hash, unchanged)
+
+	require.NoError(t, WriteManifest(root, manifest))
+	rewritten, err := ManifestHash(root)
+	require.NoError(t, err)
+	assert.NotEqual(t, hash, rewritten)
+}
+
+func TestVerifyDump(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(t *testing.T, root string)
+		problem string
+	}{
+		{name: "Unchanged", modify: func(t *testing.T, root string) {}},
+		{name: "Missing", modify: func(t *testing.T, root string) {
+			require.NoError(t, os.Remove(filepath.Join(root, "mydb", "users.bson")))
+		}, problem: "mydb/users.bson: missing"},
+		{name: "Truncated", modify: func(t *testing.T, root string) {
+			require.NoError(t, os.Truncate(filepath.Join(root, "mydb", "users.bson"), 20))
+		}, problem: "mydb/users.bson: size is 20 bytes"},
+		{name: "Modified", modify: func(t *testing.T, root string) {
+			path := filepath.Join(root, "mydb", "users.metadata.json")
+			data, err := os.ReadFile(path)
+			require.NoError(t, err)
+			data[0] = ' '
+			require.NoError(t, os.WriteFile(path, data, 0644))
+		}, problem: "mydb/users.metadata.json: SHA-256 checksum does not match"},
+		{name: "Unlisted", modify: func(t *testing.T, root string) {
+			require.NoError(t, os.WriteFile(filepath.Join(root, "mydb", "orders.bson.zst"), nil, 0644))
+		}, problem: "mydb/orders.bson.zst: not listed in the manifest"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			manifest := writeTestDump(t, root, CompressionNone, nil, nil)
+			tt.modify(t, root)
+
+			report, err := VerifyDump(root, manifest, nil)
+			require.NoError(t, err)
+			assert.Equal(t, 2, report.Files)
+			if tt.problem == "" {
+				assert.True(t, report.OK())
+				return
+			}
+			require.Len(t, report.Problems, 1)
+			assert.Contains(t, report.Problems[0], tt.problem)
+		})
+	}
+}
+
+func TestVerifyDumpDocumentCount(t *testing.T) {
+	root := t.TempDir()
+	manifest := writeTestDump(t, root, CompressionNone, nil, nil)
+
+	// A manifest recording a different count than the file holds is reported
+	manifest.Files[0].Documents = 4
+	report, err := VerifyDump(root, manifest, nil)
+	require.NoError(t, err)
+	require.Len(t, report.Problems, 1)
+	assert.Contains(t, report.Problems[0], "holds 3 documents, manifest records 4")
+}
+
+func TestVerifyEncryptedDump(t *testing.T) {
+	keys := testKeyring(t, 1)
+	root := t.TempDir()
+	manifest := writeTestDump(t, root, CompressionZstd, keys.Primary(), keys)
+	assert.Equal(t, "mydb/users.bson.zst.enc", manifest.Files[0].Path)
+	assert.True(t, manifest.Files[0].Encrypted)
+
+	report, err := VerifyDump(root, manifest, keys)
+	require.NoError(t, err)
+	assert.True(t, report.OK())
+	assert.Zero(t, report.Unchecked)
+
+	// Without keys only the checksums are verified
+	report, err = VerifyDump(root, manifest, nil)
+	require.NoError(t, err)
+	assert.True(t, report.OK())
+	assert.Equal(t, 1, report.Unchecked)
+}
@@ -0,0 +1,106 @@
+// Package dump reads and writes database dumps for the nmongo application.
+// Dumps use the same directory layout as mongodump: one directory per database containing
+// a <collection>.bson file with the documents and a <collection>.metadata.json file with
+// the collection options and indexes.
+package dump
+
+import (
+	"encoding/hex"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Collection types recorded in metadata files
+const (
+	TypeCollection = "collection"
+	TypeTimeSeries = "timeseries"
+	TypeView       = "view"
+)
+
+// timeSeriesBucketsPrefix is the prefix of the bucket collection holding the data of a time-series collection
+const timeSeriesBucketsPrefix = "system.buckets."
+
+// Metadata describes a dumped collection, as stored in mongodump's <collection>.metadata.json files
+type Metadata struct {
+	CollectionName string
+	Type           string
+	UUID           string
+	Options        bson.Raw
+	Indexes        []bson.Raw
+}
+
+// NewMetadata builds the metadata of a collection from its specification and index definitions
+func NewMetadata(spec *mongo.CollectionSpecification, indexes []bson.Raw) *Metadata {
+	meta := &Metadata{
+		CollectionName: spec.Name,
+		Type:           spec.Type,
+		Options:        spec.Options,
+		Indexes:        indexes,
+	}
+	if meta.Type == "" {
+		meta.Type = TypeCollection
+	}
+	if spec.UUID != nil {
+		meta.UUID = hex.EncodeToString(spec.UUID.Data)
+	}
+	return meta
+}
+
+// MarshalJSON renders the metadata as canonical extended JSON in the field order used by mongodump
+func (m *Metadata) MarshalJSON() ([]byte, error) {
+	options := m.Options
+	if options == nil {
+		options = bson.Raw(emptyDocument())
+	}
+
+	indexes := make(bson.A, 0, len(m.Indexes))
+	for _, index := range m.Indexes {
+		indexes = append(indexes, index)
+	}
+
+	doc := bson.D{
+		{Key: "options", Value: options},
+		{Key: "indexes", Value: indexes},
+		{Key: "uuid", Value: m.UUID},
+		{Key: "collectionName", Value: m.CollectionName},
+		{Key: "type", Value: m.Type},
+	}
+
+	data, err := bson.MarshalExtJSON(doc, true, false)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode metadata of %s: %w", m.CollectionName, err)
+	}
+	return data, nil
+}
+
+// DataCollection returns the collection whose documents are dumped for this collection.
+// The data of time-series collections lives in their system.buckets collection.
+func (m *Metadata) DataCollection() string {
+	if m.Type == TypeTimeSeries {
+		return timeSeriesBucketsPrefix + m.CollectionName
+	}
+	return m.CollectionName
+}
+
+// HasData reports whether the collection has documents to dump; views only have metadata
+func (m *Metadata) HasData() bool {
+	return m.Type != TypeView
+}
+
+// BSONFileName returns the name of the file holding the dumped documents of a collection
+func BSONFileName(collName string) string {
+	return collName + ".bson"
+}
+
+// MetadataFileName returns the name of the metadata file of a collection
+func MetadataFileName(collName string) string {
+	return collName + ".metadata.json"
+}
+
+// emptyDocument returns an encoded empty BSON document
+func emptyDocument() []byte {
+	data, _ := bson.Marshal(bson.D{})
+	return data
+}
@@ -0,0 +1,83 @@
+package dump
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func mustMarshal(t *testing.T, doc interface{}) bson.Raw {
+	data, err := bson.Marshal(doc)
+	require.NoError(t, err)
+	return data
+}
+
+func TestNewMetadata(t *testing.T) {
+	uuid := &primitive.Binary{Subtype: 4, Data: []byte{0x2e, 0x1f, 0x00, 0xff, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12}}
+	spec := &mongo.CollectionSpecification{
+		Name:    "orders",
+		UUID:    uuid,
+		Options: mustMarshal(t, bson.D{{Key: "validationLevel", Value: "strict"}}),
+	}
+	indexes := []bson.Raw{
+		mustMarshal(t, bson.D{{Key: "v", Value: int32(2)}, {Key: "key", Value: bson.D{{Key: "_id", Value: int32(1)}}}, {Key: "name", Value: "_id_"}}),
+		mustMarshal(t, bson.D{
+			{Key: "v", Value: int32(2)},
+			{Key: "key", Value: bson.D{{Key: "status", Value: int32(1)}, {Key: "created", Value: int32(-1)}}},
+			{Key: "name", Value: "status_1_created_-1"},
+		}),
+	}
+
+	meta := NewMetadata(spec, indexes)
+	assert.Equal(t, "orders", meta.CollectionName)
+	assert.Equal(t, TypeCollection, meta.Type)
+	assert.Equal(t, "2e1f00ff0102030405060708090a0b0c", meta.UUID)
+	assert.True(t, meta.HasData())
+	assert.Equal(t, "orders", meta.DataCollection())
+
+	data, err := meta.MarshalJSON()
+	require.NoError(t, err)
+
+	// The file must be valid JSON in mongodump's field order with key order of compound indexes preserved
+	var parsed map[string]interface{}
+	require.NoError(t, json.Unmarshal(data, &parsed))
+	assert.Equal(t, "orders", parsed["collectionName"])
+	assert.Equal(t, "collection", parsed["type"])
+	assert.Regexp(t, `^\{"options":\{"validationLevel":"strict"\},"indexes":\[`, string(data))
+	assert.Contains(t, string(data), `"key":{"status":{"$numberInt":"1"},"created":{"$numberInt":"-1"}}`)
+}
+
+func TestMetadataTypes(t *testing.T) {
+	tests := []struct {
+		name           string
+		specType       string
+		expectedData   string
+		expectedHasDoc bool
+	}{
+		{name: "Collection", specType: "collection", expectedData: "metrics", expectedHasDoc: true},
+		{name: "Time-series", specType: "timeseries", expectedData: "system.buckets.metrics", expectedHasDoc: true},
+		{name: "View", specType: "view", expectedData: "metrics", expectedHasDoc: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			meta := NewMetadata(&mongo.CollectionSpecification{Name: "metrics", Type: tt.specType}, nil)
+			assert.Equal(t, tt.expectedData, meta.DataCollection())
+			assert.Equal(t, tt.expectedHasDoc, meta.HasData())
+
+			data, err := meta.MarshalJSON()
+			require.NoError(t, err)
+			assert.Contains(t, string(data), `"options":{},"indexes":[]`)
+		})
+	}
+}
+
+func TestFileNames(t *testing.T) {
+	assert.Equal(t, "orders.bson", BSONFileName("orders"))
+	assert.Equal(t, "orders.metadata.json", MetadataFileName("orders"))
+}
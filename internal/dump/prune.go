@@ -0,0 +1,257 @@
+package dump
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"sort"
+	"time"
+
+	"nmongo/internal/storage"
+)
+
+// RetentionPolicy decides which segments of a dump directory are kept.
+// The count rules are combined: a segment is kept if any of them selects it.
+type RetentionPolicy struct {
+	// KeepLast keeps the most recent segments
+	KeepLast int
+	// KeepDaily, KeepWeekly and KeepMonthly keep the most recent segment of each of that many days, weeks and months
+	KeepDaily   int
+	KeepWeekly  int
+	KeepMonthly int
+	// MaxTotalSize removes the oldest chains until the kept segments fit, in bytes; 0 means no limit
+	MaxTotalSize int64
+}
+
+// Validate checks that the policy has at least one rule, so pruning never removes every segment by accident
+func (p RetentionPolicy) Validate() error {
+	for _, value := range []int64{int64(p.KeepLast), int64(p.KeepDaily), int64(p.KeepWeekly), int64(p.KeepMonthly), p.MaxTotalSize} {
+		if value < 0 {
+			return errors.New("retention counts and sizes cannot be negative")
+		}
+	}
+	if !p.hasCountRules() && p.MaxTotalSize == 0 {
+		return errors.New("no retention rule given")
+	}
+	return nil
+}
+
+// hasCountRules reports whether the policy selects segments by count rather than only by size
+func (p RetentionPolicy) hasCountRules() bool {
+	return p.KeepLast > 0 || p.KeepDaily > 0 || p.KeepWeekly > 0 || p.KeepMonthly > 0
+}
+
+// PruneSegment is a segment with its size and the reasons it is kept
+type PruneSegment struct {
+	Segment
+	Size    int64
+	Reasons []string
+}
+
+// PrunePlan lists the segments kept and removed by a retention policy, oldest first
+type PrunePlan struct {
+	Keep   []PruneSegment
+	Remove []PruneSegment
+	// Incomplete lists segment directories of failed dump runs that are not in the chain index
+	Incomplete []string
+}
+
+// PlanPrune applies a retention policy to the segments of a dump directory.
+// Segments needed by a kept incremental segment are always kept, and the size limit removes whole chains,
+// so every kept segment stays restorable. The latest chain is never removed.
+func PlanPrune(root string, index *ChainIndex, policy RetentionPolicy) (*PrunePlan, error) {
+	if err := policy.Validate(); err != nil {
+		return nil, err
+	}
+
+	segments, err := measureSegments(root, index)
+	if err != nil {
+		return nil, err
+	}
+
+	reasons := selectSegments(index.Segments, policy)
+	keepAncestors(index, reasons)
+	if policy.MaxTotalSize > 0 {
+		enforceMaxSize(index, segments, reasons, policy.MaxTotalSize)
+	}
+
+	plan := &PrunePlan{}
+	for _, segment := range segments {
+		segment.Reasons = reasons[segment.ID]
+		if len(segment.Reasons) > 0 {
+			plan.Keep = append(plan.Keep, segment)
+		} else {
+			plan.Remove = append(plan.Remove, segment)
+		}
+	}
+
+	if plan.Incomplete, err = incompleteSegments(root, index); err != nil {
+		return nil, err
+	}
+	return plan, nil
+}
+
+// selectSegments returns the reasons each segment is kept by the count rules of the policy
+func selectSegments(segments []Segment, policy RetentionPolicy) map[string][]string {
+	reasons := make(map[string][]string)
+	if !policy.hasCountRules() {
+		for _, segment := range segments {
+			reasons[segment.ID] = append(reasons[segment.ID], "within size limit")
+		}
+		return reasons
+	}
+
+	for i := len(segments) - 1; i >= 0 && i >= len(segments)-policy.KeepLast; i-- {
+		reasons[segments[i].ID] = append(reasons[segments[i].ID], "last")
+	}
+	keepPeriods(segments, policy.KeepDaily, "daily", reasons, func(t time.Time) string { return t.Format("2006-01-02") })
+	keepPeriods(segments, policy.KeepWeekly, "weekly", reasons, func(t time.Time) string {
+		year, week := t.ISOWeek()
+		return fmt.Sprintf("%d-W%02d", year, week)
+	})
+	keepPeriods(segments, policy.KeepMonthly, "monthly", reasons, func(t time.Time) string { return t.Format("2006-01") })
+	return reasons
+}
+
+// keepPeriods keeps the most recent segment of each of the last count periods that have a segment
+func keepPeriods(segments []Segment, count int, reason string, reasons map[string][]string, period func(time.Time) string) {
+	seen := make(map[string]bool)
+	for i := len(segments) - 1; i >= 0 && len(seen) < count; i-- {
+		key := period(segments[i].CreatedAt.UTC())
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		reasons[segments[i].ID] = append(reasons[segments[i].ID], reason)
+	}
+}
+
+// keepAncestors keeps the segments that kept incremental segments are applied on top of
+func keepAncestors(index *ChainIndex, reasons map[string][]string) {
+	for i := len(index.Segments) - 1; i >= 0; i-- {
+		segment := index.Segments[i]
+		if len(reasons[segment.ID]) == 0 || segment.Parent == "" {
+			continue
+		}
+		if len(reasons[segment.Parent]) == 0 {
+			reasons[segment.Parent] = append(reasons[segment.Parent], "needed by "+segment.ID)
+		}
+	}
+}
+
+// enforceMaxSize removes the oldest kept chains until the kept segments fit in the size limit
+func enforceMaxSize(index *ChainIndex, segments []PruneSegment, reasons map[string][]string, maxSize int64) {
+	var total int64
+	for _, segment := range segments {
+		if len(reasons[segment.ID]) > 0 {
+			total += segment.Size
+		}
+	}
+
+	chains := chainsOf(index)
+	for i := 0; i < len(chains)-1 && total > maxSize; i++ {
+		total -= dropChain(chains[i], segments, reasons)
+	}
+}
+
+// dropChain stops keeping the segments of a chain and returns the size they take up
+func dropChain(chain map[string]bool, segments []PruneSegment, reasons map[string][]string) int64 {
+	var size int64
+	for _, segment := range segments {
+		if chain[segment.ID] && len(reasons[segment.ID]) > 0 {
+			size += segment.Size
+			delete(reasons, segment.ID)
+		}
+	}
+	return size
+}
+
+// chainsOf groups the segments by the full segment of their chain, oldest chain first
+func chainsOf(index *ChainIndex) []map[string]bool {
+	var chains []map[string]bool
+	chainOf := make(map[string]map[string]bool)
+	for _, segment := range index.Segments {
+		chain, found := chainOf[segment.Parent]
+		if segment.Parent == "" || !found {
+			chain = make(map[string]bool)
+			chains = append(chains, chain)
+		}
+		chain[segment.ID] = true
+		chainOf[segment.ID] = chain
+	}
+	return chains
+}
+
+// measureSegments returns the segments of the index with the size of their directories
+func measureSegments(root string, index *ChainIndex) ([]PruneSegment, error) {
+	segments := make([]PruneSegment, 0, len(index.Segments))
+	for _, segment := range index.Segments {
+		size, err := storage.Size(SegmentDir(root, segment.ID))
+		if err != nil {
+			return nil, fmt.Errorf("failed to measure segment %s: %w", segment.ID, err)
+		}
+		segments = append(segments, PruneSegment{Segment: segment, Size: size})
+	}
+	return segments, nil
+}
+
+// incompleteSegments returns the segment directories left by failed dump runs: directories not in the chain index
+// that are older than its latest segment. Newer directories may belong to a dump that is still running.
+func incompleteSegments(root string, index *ChainIndex) ([]string, error) {
+	latest := index.Latest()
+	if latest == nil {
+		return nil, nil
+	}
+
+	entries, err := storage.ReadDir(storage.Join(root, SegmentsDirName))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var incomplete []string
+	for _, entry := range entries {
+		if isIncompleteSegment(index, entry, latest.ID) {
+			incomplete = append(incomplete, entry.Name)
+		}
+	}
+	sort.Strings(incomplete)
+	return incomplete, nil
+}
+
+// isIncompleteSegment reports whether a directory in the segments directory is an older segment missing from the index
+func isIncompleteSegment(index *ChainIndex, entry storage.Entry, latestID string) bool {
+	_, found := index.Find(entry.Name)
+	return !found && entry.IsDir && entry.Name < latestID
+}
+
+// ApplyPrune removes the segments of a plan. The chain index is updated first,
+// so an interrupted prune leaves unreferenced directories rather than an index pointing to missing segments.
+func ApplyPrune(root string, index *ChainIndex, plan *PrunePlan) error {
+	removed := make(map[string]bool, len(plan.Remove))
+	ids := make([]string, 0, len(plan.Remove)+len(plan.Incomplete))
+	for _, segment := range plan.Remove {
+		removed[segment.ID] = true
+		ids = append(ids, segment.ID)
+	}
+
+	kept := make([]Segment, 0, len(index.Segments))
+	for _, segment := range index.Segments {
+		if !removed[segment.ID] {
+			kept = append(kept, segment)
+		}
+	}
+	index.Segments = kept
+	if err := WriteChainIndex(root, index); err != nil {
+		return err
+	}
+
+	for _, id := range append(ids, plan.Incomplete...) {
+		if err := storage.RemoveAll(SegmentDir(root, id)); err != nil {
+			return fmt.Errorf("failed to remove segment %s: %w", id, err)
+		}
+	}
+	return nil
+}
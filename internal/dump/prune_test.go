@@ -0,0 +1,169 @@
+package dump
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testSegment describes a segment by the hours since the first segment and whether it is incremental
+type testSegment struct {
+	hours       int
+	incremental bool
+}
+
+// writeTestChain creates segments with directories holding a file of the given size
+func writeTestChain(t *testing.T, root string, size int, segments ...testSegment) *ChainIndex {
+	index := &ChainIndex{Version: ChainIndexVersion}
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, spec := range segments {
+		segment := index.NewSegment(spec.incremental, start.Add(time.Duration(spec.hours)*time.Hour))
+		index.Add(segment)
+		dir := filepath.Join(SegmentDir(root, segment.ID), "mydb")
+		require.NoError(t, os.MkdirAll(dir, 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "users.bson"), make([]byte, size), 0644))
+	}
+	require.NoError(t, WriteChainIndex(root, index))
+	return index
+}
+
+func segmentIDs(segments []PruneSegment) []string {
+	ids := make([]string, 0, len(segments))
+	for _, segment := range segments {
+		ids = append(ids, segment.ID)
+	}
+	return ids
+}
+
+func TestPlanPruneKeepLast(t *testing.T) {
+	root := t.TempDir()
+	index := writeTestChain(t, root, 10,
+		testSegment{hours: 0},
+		testSegment{hours: 1, incremental: true},
+		testSegment{hours: 2, incremental: true},
+		testSegment{hours: 3},
+		testSegment{hours: 4, incremental: true},
+	)
+	ids := func(positions ...int) []string {
+		var ids []string
+		for _, i := range positions {
+			ids = append(ids, index.Segments[i].ID)
+		}
+		return ids
+	}
+
+	// Keeping the last incremental keeps the full segment it is applied on
+	plan, err := PlanPrune(root, index, RetentionPolicy{KeepLast: 1})
+	require.NoError(t, err)
+	assert.Equal(t, ids(3, 4), segmentIDs(plan.Keep))
+	assert.Equal(t, ids(0, 1, 2), segmentIDs(plan.Remove))
+	assert.Equal(t, []string{"needed by " + index.Segments[4].ID}, plan.Keep[0].Reasons)
+	assert.Equal(t, []string{"last"}, plan.Keep[1].Reasons)
+
+	// Keeping an incremental in the middle of a chain keeps its ancestors but not its descendants
+	plan, err = PlanPrune(root, &ChainIndex{Segments: index.Segments[:2]}, RetentionPolicy{KeepLast: 1})
+	require.NoError(t, err)
+	assert.Equal(t, ids(0, 1), segmentIDs(plan.Keep))
+}
+
+func TestPlanPruneGrandfatherFatherSon(t *testing.T) {
+	root := t.TempDir()
+	var specs []testSegment
+	// Two full dumps a day for 70 days
+	for day := 0; day < 70; day++ {
+		specs = append(specs, testSegment{hours: day * 24}, testSegment{hours: day*24 + 12})
+	}
+	index := writeTestChain(t, root, 1, specs...)
+
+	plan, err := PlanPrune(root, index, RetentionPolicy{KeepDaily: 3, KeepWeekly: 2, KeepMonthly: 3})
+	require.NoError(t, err)
+
+	var kept []string
+	for _, segment := range plan.Keep {
+		kept = append(kept, segment.CreatedAt.Format("2006-01-02T15"))
+	}
+	// The latest segment of each of the last 3 days, the last 2 ISO weeks and the last 3 months
+	assert.Equal(t, []string{
+		"2024-01-31T12", // monthly: January
+		"2024-02-29T12", // monthly: February
+		"2024-03-03T12", // weekly: week 9
+		"2024-03-08T12", // daily
+		"2024-03-09T12", // daily
+		"2024-03-10T12", // daily, weekly, monthly
+	}, kept)
+	assert.Equal(t, []string{"daily", "weekly", "monthly"}, plan.Keep[5].Reasons)
+}
+
+func TestPlanPruneMaxTotalSize(t *testing.T) {
+	root := t.TempDir()
+	index := writeTestChain(t, root, 100,
+		testSegment{hours: 0},
+		testSegment{hours: 1, incremental: true},
+		testSegment{hours: 2},
+		testSegment{hours: 3, incremental: true},
+		testSegment{hours: 4},
+		testSegment{hours: 5, incremental: true},
+	)
+
+	// Whole chains are removed, oldest first, until the rest fits
+	plan, err := PlanPrune(root, index, RetentionPolicy{MaxTotalSize: 400})
+	require.NoError(t, err)
+	assert.Equal(t, []string{index.Segments[2].ID, index.Segments[3].ID, index.Segments[4].ID, index.Segments[5].ID},
+		segmentIDs(plan.Keep))
+	assert.Equal(t, int64(100), plan.Keep[0].Size)
+
+	// The latest chain is kept even if it is larger than the limit
+	plan, err = PlanPrune(root, index, RetentionPolicy{MaxTotalSize: 50})
+	require.NoError(t, err)
+	assert.Equal(t, []string{index.Segments[4].ID, index.Segments[5].ID}, segmentIDs(plan.Keep))
+
+	// The size limit applies on top of the count rules
+	plan, err = PlanPrune(root, index, RetentionPolicy{KeepLast: 6, MaxTotalSize: 250})
+	require.NoError(t, err)
+	assert.Len(t, plan.Keep, 2)
+}
+
+func TestPlanPruneValidation(t *testing.T) {
+	_, err := PlanPrune(t.TempDir(), &ChainIndex{}, RetentionPolicy{})
+	assert.ErrorContains(t, err, "no retention rule")
+
+	_, err = PlanPrune(t.TempDir(), &ChainIndex{}, RetentionPolicy{KeepLast: -1})
+	assert.ErrorContains(t, err, "cannot be negative")
+}
+
+func TestApplyPrune(t *testing.T) {
+	root := t.TempDir()
+	index := writeTestChain(t, root, 10,
+		testSegment{hours: 0},
+		testSegment{hours: 1},
+		testSegment{hours: 3},
+	)
+	// A failed run left a directory that is not in the index, and a newer one may still be running
+	failed := "20240101T020000.000Z-full"
+	running := "20240101T040000.000Z-full"
+	require.NoError(t, os.MkdirAll(SegmentDir(root, failed), 0755))
+	require.NoError(t, os.MkdirAll(SegmentDir(root, running), 0755))
+
+	plan, err := PlanPrune(root, index, RetentionPolicy{KeepLast: 1})
+	require.NoError(t, err)
+	assert.Equal(t, []string{failed}, plan.Incomplete)
+
+	removed := plan.Remove
+	require.NoError(t, ApplyPrune(root, index, plan))
+
+	for _, segment := range removed {
+		assert.NoDirExists(t, SegmentDir(root, segment.ID))
+	}
+	assert.NoDirExists(t, SegmentDir(root, failed))
+	assert.DirExists(t, SegmentDir(root, running))
+	assert.DirExists(t, SegmentDir(root, index.Segments[0].ID))
+
+	read, err := ReadChainIndex(root)
+	require.NoError(t, err)
+	require.Len(t, read.Segments, 1)
+	assert.Equal(t, plan.Keep[0].ID, read.Segments[0].ID)
+}
@@ -0,0 +1,119 @@
+package dump
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// maxDocumentSize bounds the documents accepted from a dump file. It is the server's 16 MiB document limit
+// plus the headroom the server allows for internal documents such as oplog entries.
+const maxDocumentSize = 16*1024*1024 + 16*1024
+
+// minDocumentSize is the size of an empty BSON document
+const minDocumentSize = 5
+
+// Reader streams the documents of a BSON file, a plain concatenation of BSON documents
+type Reader struct {
+	r      *bufio.Reader
+	offset int64
+}
+
+// NewReader creates a reader for the BSON documents of r
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: bufio.NewReaderSize(r, 1<<20)}
+}
+
+// Next returns the next document, or io.EOF once all documents have been read.
+// The returned document is owned by the caller.
+func (r *Reader) Next() (bson.Raw, error) {
+	var header [4]byte
+	if _, err := io.ReadFull(r.r, header[:]); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, io.EOF
+		}
+		return nil, fmt.Errorf("truncated document at offset %d: %w", r.offset, err)
+	}
+
+	size := int64(binary.LittleEndian.Uint32(header[:]))
+	if size < minDocumentSize || size > maxDocumentSize {
+		return nil, fmt.Errorf("invalid document size %d at offset %d", size, r.offset)
+	}
+
+	doc := make([]byte, size)
+	copy(doc, header[:])
+	if _, err := io.ReadFull(r.r, doc[4:]); err != nil {
+		return nil, fmt.Errorf("truncated document at offset %d: %w", r.offset, err)
+	}
+	if err := bson.Raw(doc).Validate(); err != nil {
+		return nil, fmt.Errorf("corrupt document at offset %d: %w", r.offset, err)
+	}
+
+	r.offset += size
+	return doc, nil
+}
+
+// ParseMetadata decodes the extended JSON of a <collection>.metadata.json file
+func ParseMetadata(data []byte) (*Metadata, error) {
+	var doc struct {
+		Options        bson.Raw   `bson:"options"`
+		Indexes        []bson.Raw `bson:"indexes"`
+		UUID           string     `bson:"uuid"`
+		CollectionName string     `bson:"collectionName"`
+		Type           string     `bson:"type"`
+	}
+	if err := bson.UnmarshalExtJSON(data, false, &doc); err != nil {
+		return nil, fmt.Errorf("failed to decode metadata: %w", err)
+	}
+
+	meta := &Metadata{
+		CollectionName: doc.CollectionName,
+		Type:           doc.Type,
+		UUID:           doc.UUID,
+		Options:        doc.Options,
+		Indexes:        doc.Indexes,
+	}
+	if meta.Type == "" {
+		meta.Type = TypeCollection
+	}
+	return meta, nil
+}
+
+// ReadMetadataFile reads the metadata of a collection from a dump directory.
+// The file may be compressed in any supported format and encrypted with a key of the keyring.
+// Dumps without a metadata file are treated as regular collections without options or indexes.
+func ReadMetadataFile(dbDir, collName string, keys *Keyring) (*Metadata, error) {
+	path, compression, found := FindFile(dbDir, MetadataFileName(collName))
+	if !found {
+		return &Metadata{CollectionName: collName, Type: TypeCollection}, nil
+	}
+
+	data, err := readFile(path, compression, keys)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read metadata of %s: %w", collName, err)
+	}
+
+	meta, err := ParseMetadata(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read metadata of %s: %w", collName, err)
+	}
+	if meta.CollectionName == "" {
+		meta.CollectionName = collName
+	}
+	return meta, nil
+}
+
+// readFile reads, decrypts and decompresses a whole dump file
+func readFile(path, compression string, keys *Keyring) ([]byte, error) {
+	reader, err := OpenFile(path, compression, keys)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	return io.ReadAll(reader)
+}
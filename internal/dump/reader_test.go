@@ -0,0 +1,132 @@
+package dump
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestReader(t *testing.T) {
+	first := mustMarshal(t, bson.D{{Key: "_id", Value: 1}, {Key: "name", Value: "a"}})
+	second := mustMarshal(t, bson.D{{Key: "_id", Value: 2}})
+
+	t.Run("Documents", func(t *testing.T) {
+		reader := NewReader(bytes.NewReader(append(append([]byte{}, first...), second...)))
+
+		doc, err := reader.Next()
+		require.NoError(t, err)
+		assert.Equal(t, first, doc)
+
+		doc, err = reader.Next()
+		require.NoError(t, err)
+		assert.Equal(t, second, doc)
+
+		_, err = reader.Next()
+		assert.ErrorIs(t, err, io.EOF)
+	})
+
+	t.Run("Empty", func(t *testing.T) {
+		_, err := NewReader(bytes.NewReader(nil)).Next()
+		assert.ErrorIs(t, err, io.EOF)
+	})
+
+	t.Run("Truncated", func(t *testing.T) {
+		reader := NewReader(bytes.NewReader(append(append([]byte{}, first...), second[:len(second)-2]...)))
+		_, err := reader.Next()
+		require.NoError(t, err)
+
+		_, err = reader.Next()
+		assert.Error(t, err)
+		assert.False(t, errors.Is(err, io.EOF))
+		assert.Contains(t, err.Error(), "truncated document at offset")
+	})
+
+	t.Run("InvalidSize", func(t *testing.T) {
+		header := make([]byte, 4)
+		binary.LittleEndian.PutUint32(header, maxDocumentSize+1)
+		_, err := NewReader(bytes.NewReader(header)).Next()
+		assert.ErrorContains(t, err, "invalid document size")
+	})
+
+	t.Run("Corrupt", func(t *testing.T) {
+		corrupt := append([]byte{}, first...)
+		corrupt[4] = 0x7f
+		_, err := NewReader(bytes.NewReader(corrupt)).Next()
+		assert.ErrorContains(t, err, "corrupt document")
+	})
+}
+
+func TestParseMetadata(t *testing.T) {
+	t.Run("RoundTrip", func(t *testing.T) {
+		original := NewMetadata(&mongo.CollectionSpecification{
+			Name:    "orders",
+			Type:    "timeseries",
+			Options: mustMarshal(t, bson.D{{Key: "timeseries", Value: bson.D{{Key: "timeField", Value: "ts"}}}}),
+		}, []bson.Raw{
+			mustMarshal(t, bson.D{{Key: "v", Value: int32(2)}, {Key: "key", Value: bson.D{{Key: "b", Value: int32(1)}, {Key: "a", Value: int32(-1)}}}}),
+		})
+		data, err := original.MarshalJSON()
+		require.NoError(t, err)
+
+		meta, err := ParseMetadata(data)
+		require.NoError(t, err)
+		assert.Equal(t, "orders", meta.CollectionName)
+		assert.Equal(t, TypeTimeSeries, meta.Type)
+		assert.Equal(t, "ts", meta.Options.Lookup("timeseries", "timeField").StringValue())
+		require.Len(t, meta.Indexes, 1)
+
+		keys, err := meta.Indexes[0].Lookup("key").Document().Elements()
+		require.NoError(t, err)
+		assert.Equal(t, "b", keys[0].Key())
+		assert.Equal(t, "a", keys[1].Key())
+	})
+
+	t.Run("RelaxedJSONWithoutType", func(t *testing.T) {
+		meta, err := ParseMetadata([]byte(`{"options":{"capped":true,"size":4096},"indexes":[{"v":2,"key":{"_id":1},"name":"_id_"}]}`))
+		require.NoError(t, err)
+		assert.Equal(t, TypeCollection, meta.Type)
+		assert.True(t, meta.Options.Lookup("capped").Boolean())
+		assert.Len(t, meta.Indexes, 1)
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		_, err := ParseMetadata([]byte(`{"options":`))
+		assert.Error(t, err)
+	})
+}
+
+func TestReadMetadataFile(t *testing.T) {
+	dir := t.TempDir()
+
+	meta, err := ReadMetadataFile(dir, "missing", nil)
+	require.NoError(t, err)
+	assert.Equal(t, "missing", meta.CollectionName)
+	assert.Equal(t, TypeCollection, meta.Type)
+	assert.True(t, meta.HasData())
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, MetadataFileName("users")), []byte(`{"options":{},"indexes":[]}`), 0600))
+	meta, err = ReadMetadataFile(dir, "users", nil)
+	require.NoError(t, err)
+	assert.Equal(t, "users", meta.CollectionName)
+}
+
+func TestReadCompressedMetadataFile(t *testing.T) {
+	dir := t.TempDir()
+	meta := NewMetadata(&mongo.CollectionSpecification{Name: "orders"}, nil)
+	require.NoError(t, writeMetadataFile(dir, MetadataFileName("orders"), CompressionZstd, nil, meta))
+	assert.FileExists(t, filepath.Join(dir, "orders.metadata.json.zst"))
+
+	read, err := ReadMetadataFile(dir, "orders", nil)
+	require.NoError(t, err)
+	assert.Equal(t, "orders", read.CollectionName)
+	assert.Equal(t, TypeCollection, read.Type)
+}
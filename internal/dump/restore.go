@@ -0,0 +1,512 @@
+package dump
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"nmongo/internal/mongodb"
+	"nmongo/internal/storage"
+)
+
+// ConflictPolicy decides what happens to dumped documents whose _id already exists in the target
+type ConflictPolicy string
+
+// Conflict policies supported by the native restore
+const (
+	// ConflictSkip keeps the existing document and skips the dumped one, like mongorestore does
+	ConflictSkip ConflictPolicy = "skip"
+	// ConflictFail stops the restore at the first existing document
+	ConflictFail ConflictPolicy = "fail"
+	// ConflictUpsert replaces existing documents with the dumped ones
+	ConflictUpsert ConflictPolicy = "upsert"
+)
+
+// Server error codes handled during a restore
+const (
+	errCodeNamespaceExists = 48
+	errCodeDuplicateKey    = 11000
+)
+
+// RestoreOptions configures a native collection restore
+type RestoreOptions struct {
+	// InputDir is the dump root; files are read from <InputDir>/<database>/
+	InputDir string
+	// BatchSize is the number of documents sent in one bulk write
+	BatchSize int
+	// RetryAttempts is the number of attempts for each bulk write
+	RetryAttempts int
+	// Drop drops the target collection before restoring it
+	Drop bool
+	// Conflict decides how documents that already exist in the target are handled
+	Conflict ConflictPolicy
+	// Ordered writes documents in the order of the dump file
+	Ordered bool
+	// InsertionWorkers is the number of bulk writes sent concurrently; with more than one,
+	// batches are written in any order
+	InsertionWorkers int
+	// Keys decrypt encrypted dump files; nil when the dump is not encrypted
+	Keys *Keyring
+}
+
+// RestoreResult counts the outcome of the documents of a restored collection
+type RestoreResult struct {
+	Inserted int64
+	Replaced int64
+	Skipped  int64
+}
+
+// RestoreCollection restores a collection from the mongodump layout written by DumpCollection or mongodump.
+// The collection is created with its dumped options, its documents are streamed into batched bulk writes
+// and its indexes are recreated once the data is loaded.
+// Encrypted data files are authenticated in full before the target collection is touched.
+func RestoreCollection(ctx context.Context, db *mongo.Database, collName string, opts RestoreOptions) (RestoreResult, error) {
+	dbDir := storage.Join(opts.InputDir, db.Name())
+	meta, err := ReadMetadataFile(dbDir, collName, opts.Keys)
+	if err != nil {
+		return RestoreResult{}, err
+	}
+	if err := verifyDataFile(dbDir, meta, opts.Keys); err != nil {
+		return RestoreResult{}, err
+	}
+
+	if err := prepareCollection(ctx, db, meta, opts.Drop); err != nil {
+		return RestoreResult{}, err
+	}
+
+	var result RestoreResult
+	if meta.HasData() {
+		dataColl := meta.DataCollection()
+		result, err = restoreDocuments(ctx, db.Collection(dataColl), dbDir, opts)
+		if err != nil {
+			return result, err
+		}
+	}
+
+	if err := createIndexes(ctx, db, collName, meta.Indexes); err != nil {
+		return result, err
+	}
+	return result, nil
+}
+
+// verifyDataFile authenticates every chunk of an encrypted data file, so a modified or truncated file
+// is refused before any document is restored from it
+func verifyDataFile(dbDir string, meta *Metadata, keys *Keyring) error {
+	if !meta.HasData() {
+		return nil
+	}
+	path, compression, found := FindFile(dbDir, BSONFileName(meta.DataCollection()))
+	if !found || !IsEncrypted(path) {
+		return nil
+	}
+
+	file, err := OpenFile(path, compression, keys)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if _, err := io.Copy(io.Discard, file); err != nil {
+		return fmt.Errorf("failed to verify %s: %w", path, err)
+	}
+	return nil
+}
+
+// prepareCollection drops the collection if requested and creates it with its dumped options
+func prepareCollection(ctx context.Context, db *mongo.Database, meta *Metadata, drop bool) error {
+	if drop {
+		if err := db.Collection(meta.CollectionName).Drop(ctx); err != nil {
+			return fmt.Errorf("failed to drop collection %s: %w", meta.CollectionName, err)
+		}
+	}
+	return createCollection(ctx, db, meta)
+}
+
+// createCollection creates a collection with the options recorded in its metadata.
+// Collections without options are left to be created implicitly by the first insert.
+func createCollection(ctx context.Context, db *mongo.Database, meta *Metadata) error {
+	command, err := createCommand(meta)
+	if err != nil {
+		return err
+	}
+	if command == nil {
+		return nil
+	}
+
+	err = db.RunCommand(ctx, command).Err()
+	if err != nil && !hasErrorCode(err, errCodeNamespaceExists) {
+		return fmt.Errorf("failed to create collection %s: %w", meta.CollectionName, err)
+	}
+	return nil
+}
+
+// createCommand builds the create command for a collection, or nil when it needs no explicit creation
+func createCommand(meta *Metadata) (bson.D, error) {
+	var elements []bson.RawElement
+	if len(meta.Options) > 0 {
+		var err error
+		if elements, err = meta.Options.Elements(); err != nil {
+			return nil, fmt.Errorf("invalid options for collection %s: %w", meta.CollectionName, err)
+		}
+	}
+	if len(elements) == 0 && meta.Type == TypeCollection {
+		return nil, nil
+	}
+
+	command := bson.D{{Key: "create", Value: meta.CollectionName}}
+	for _, element := range elements {
+		var value interface{} = element.Value()
+		if element.Key() == "timeseries" {
+			value = timeSeriesCreateOptions(element.Value().Document())
+		}
+		command = append(command, bson.E{Key: element.Key(), Value: value})
+	}
+	return command, nil
+}
+
+// timeSeriesCreateOptions returns the time-series options accepted by create.
+// listCollections reports the bucket spans implied by a granularity, but create only accepts one or the other.
+func timeSeriesCreateOptions(timeseries bson.Raw) bson.D {
+	_, hasGranularity := timeseries.Lookup("granularity").StringValueOK()
+
+	elements, _ := timeseries.Elements()
+	opts := bson.D{}
+	for _, element := range elements {
+		if hasGranularity && (element.Key() == "bucketMaxSpanSeconds" || element.Key() == "bucketRoundingSeconds") {
+			continue
+		}
+		opts = append(opts, bson.E{Key: element.Key(), Value: element.Value()})
+	}
+	return opts
+}
+
+// restoreDocuments streams the documents of a BSON file, compressed in any supported format and possibly encrypted,
+// into the collection in batches
+func restoreDocuments(ctx context.Context, coll *mongo.Collection, dbDir string, opts RestoreOptions) (RestoreResult, error) {
+	path, compression, found := FindFile(dbDir, BSONFileName(coll.Name()))
+	if !found {
+		return RestoreResult{}, nil
+	}
+
+	file, err := OpenFile(path, compression, opts.Keys)
+	if err != nil {
+		return RestoreResult{}, fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer file.Close()
+
+	if opts.InsertionWorkers > 1 {
+		return streamDocumentsParallel(ctx, coll, NewReader(file), opts)
+	}
+	return streamDocuments(ctx, coll, NewReader(file), opts)
+}
+
+// streamDocuments writes the documents of a reader into the collection in batches
+func streamDocuments(ctx context.Context, coll *mongo.Collection, reader *Reader, opts RestoreOptions) (RestoreResult, error) {
+	var result RestoreResult
+	batchSize := max(opts.BatchSize, 1)
+	batch := make([]bson.Raw, 0, batchSize)
+	lastProgressTime := time.Now()
+
+	for {
+		var err error
+		if batch, err = readBatch(reader, batch[:0], batchSize); err != nil {
+			return result, fmt.Errorf("failed to read dumped documents of %s: %w", coll.Name(), err)
+		}
+		if len(batch) == 0 {
+			return result, nil
+		}
+		if err := writeBatch(ctx, coll, batch, opts, &result); err != nil {
+			return result, err
+		}
+
+		if time.Since(lastProgressTime) > 10*time.Second {
+			fmt.Printf("      Restored %d documents into %s\n", result.Inserted+result.Replaced, coll.Name())
+			lastProgressTime = time.Now()
+		}
+	}
+}
+
+// batchWriter writes a batch of documents and adds the outcome to the result
+type batchWriter func(ctx context.Context, batch []bson.Raw, result *RestoreResult) error
+
+// streamDocumentsParallel writes the documents of a reader into the collection with concurrent bulk writers
+func streamDocumentsParallel(ctx context.Context, coll *mongo.Collection, reader *Reader, opts RestoreOptions) (RestoreResult, error) {
+	write := func(ctx context.Context, batch []bson.Raw, result *RestoreResult) error {
+		return writeBatch(ctx, coll, batch, opts, result)
+	}
+	return writeBatchesParallel(ctx, reader, max(opts.BatchSize, 1), opts.InsertionWorkers, coll.Name(), write)
+}
+
+// writeBatchesParallel reads batches of documents and hands them to a number of workers writing them concurrently.
+// The first failing worker stops the others, and its error is returned with the combined result of all workers.
+func writeBatchesParallel(ctx context.Context, reader *Reader, batchSize, workers int, collName string,
+	write batchWriter) (RestoreResult, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	batches := make(chan []bson.Raw, workers)
+	results := make([]RestoreResult, workers)
+	errs := make([]error, workers)
+	var written atomic.Int64
+	var wg sync.WaitGroup
+	for i := range workers {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if errs[i] = writeBatches(ctx, batches, write, &results[i], &written); errs[i] != nil {
+				cancel()
+			}
+		}(i)
+	}
+
+	readErr := sendBatches(ctx, reader, batches, batchSize, collName, &written)
+	wg.Wait()
+
+	var result RestoreResult
+	for _, workerResult := range results {
+		result.Inserted += workerResult.Inserted
+		result.Replaced += workerResult.Replaced
+		result.Skipped += workerResult.Skipped
+	}
+	if readErr != nil {
+		return result, fmt.Errorf("failed to read dumped documents of %s: %w", collName, readErr)
+	}
+	return result, firstError(errs)
+}
+
+// writeBatches writes the batches received until the channel is closed or a write fails
+func writeBatches(ctx context.Context, batches <-chan []bson.Raw, write batchWriter, result *RestoreResult, written *atomic.Int64) error {
+	for batch := range batches {
+		if err := write(ctx, batch, result); err != nil {
+			return err
+		}
+		written.Add(int64(len(batch)))
+	}
+	return nil
+}
+
+// sendBatches reads the documents of a reader in batches and sends them to the writers until the reader is exhausted
+// or the writers stopped
+func sendBatches(ctx context.Context, reader *Reader, batches chan<- []bson.Raw, batchSize int, collName string,
+	written *atomic.Int64) error {
+	defer close(batches)
+	lastProgressTime := time.Now()
+	for {
+		batch, err := readBatch(reader, make([]bson.Raw, 0, batchSize), batchSize)
+		if err != nil || len(batch) == 0 {
+			return err
+		}
+		select {
+		case batches <- batch:
+		case <-ctx.Done():
+			// The writers report why they stopped
+			return nil
+		}
+
+		if time.Since(lastProgressTime) > 10*time.Second {
+			fmt.Printf("      Restored %d documents into %s\n", written.Load(), collName)
+			lastProgressTime = time.Now()
+		}
+	}
+}
+
+// firstError returns the first error that is not the cancellation caused by another one
+func firstError(errs []error) error {
+	for _, err := range errs {
+		if err != nil && !errors.Is(err, context.Canceled) {
+			return err
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// readBatch appends up to size documents from the reader to the batch
+func readBatch(reader *Reader, batch []bson.Raw, size int) ([]bson.Raw, error) {
+	for len(batch) < size {
+		doc, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			return batch, nil
+		}
+		if err != nil {
+			return batch, err
+		}
+		batch = append(batch, doc)
+	}
+	return batch, nil
+}
+
+// writeBatch writes a batch of documents according to the conflict policy and adds the outcome to the result
+func writeBatch(ctx context.Context, coll *mongo.Collection, batch []bson.Raw, opts RestoreOptions, result *RestoreResult) error {
+	if len(batch) == 0 {
+		return nil
+	}
+	if opts.Conflict == ConflictUpsert {
+		return upsertBatch(ctx, coll, batch, opts, result)
+	}
+
+	// Ordered inserts stop at the first duplicate, so the rest of the batch is sent again after skipping it
+	for len(batch) > 0 {
+		remaining, err := insertBatch(ctx, coll, batch, opts, result)
+		if err != nil {
+			return err
+		}
+		batch = remaining
+	}
+	return nil
+}
+
+// insertBatch inserts a batch of documents and returns the documents that were not attempted
+// because an ordered insert stopped at a duplicate
+func insertBatch(ctx context.Context, coll *mongo.Collection, batch []bson.Raw, opts RestoreOptions,
+	result *RestoreResult) ([]bson.Raw, error) {
+	docs := make([]interface{}, len(batch))
+	for i, doc := range batch {
+		docs[i] = doc
+	}
+
+	var insertErr error
+	err := mongodb.RetryWithBackoff(ctx, opts.RetryAttempts, fmt.Sprintf("insert into %s", coll.Name()), func() error {
+		_, insertErr = coll.InsertMany(ctx, docs, options.InsertMany().SetOrdered(opts.Ordered))
+		if isDuplicateKeyOnly(insertErr) {
+			return nil
+		}
+		return insertErr
+	})
+	if err != nil {
+		return nil, describeWriteError(err, batch)
+	}
+	if insertErr == nil {
+		result.Inserted += int64(len(batch))
+		return nil, nil
+	}
+
+	var bulkErr mongo.BulkWriteException
+	errors.As(insertErr, &bulkErr)
+	if opts.Conflict == ConflictFail {
+		return nil, describeWriteError(insertErr, batch)
+	}
+
+	result.Skipped += int64(len(bulkErr.WriteErrors))
+	if !opts.Ordered {
+		result.Inserted += int64(len(batch) - len(bulkErr.WriteErrors))
+		return nil, nil
+	}
+
+	failed := bulkErr.WriteErrors[0].Index
+	result.Inserted += int64(failed)
+	return batch[failed+1:], nil
+}
+
+// upsertBatch replaces the documents of a batch by _id, inserting the ones that do not exist yet
+func upsertBatch(ctx context.Context, coll *mongo.Collection, batch []bson.Raw, opts RestoreOptions, result *RestoreResult) error {
+	models := make([]mongo.WriteModel, len(batch))
+	for i, doc := range batch {
+		models[i] = mongo.NewReplaceOneModel().
+			SetFilter(bson.D{{Key: "_id", Value: doc.Lookup("_id")}}).
+			SetReplacement(doc).
+			SetUpsert(true)
+	}
+
+	var bulkResult *mongo.BulkWriteResult
+	err := mongodb.RetryWithBackoff(ctx, opts.RetryAttempts, fmt.Sprintf("upsert into %s", coll.Name()), func() error {
+		var err error
+		bulkResult, err = coll.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(opts.Ordered))
+		return err
+	})
+	if err != nil {
+		return describeWriteError(err, batch)
+	}
+
+	result.Inserted += bulkResult.UpsertedCount
+	result.Replaced += bulkResult.MatchedCount
+	return nil
+}
+
+// isDuplicateKeyOnly reports whether every error of a bulk write is a duplicate key error
+func isDuplicateKeyOnly(err error) bool {
+	var bulkErr mongo.BulkWriteException
+	if !errors.As(err, &bulkErr) || bulkErr.WriteConcernError != nil || len(bulkErr.WriteErrors) == 0 {
+		return false
+	}
+	for _, writeErr := range bulkErr.WriteErrors {
+		if writeErr.Code != errCodeDuplicateKey {
+			return false
+		}
+	}
+	return true
+}
+
+// describeWriteError adds the _id of the first failed document to a bulk write error
+func describeWriteError(err error, batch []bson.Raw) error {
+	var bulkErr mongo.BulkWriteException
+	if !errors.As(err, &bulkErr) || len(bulkErr.WriteErrors) == 0 {
+		return err
+	}
+
+	first := bulkErr.WriteErrors[0]
+	if first.Index < 0 || first.Index >= len(batch) {
+		return err
+	}
+	return fmt.Errorf("failed to write document %s (%d write errors in batch): %w", documentID(batch[first.Index]),
+		len(bulkErr.WriteErrors), err)
+}
+
+// createIndexes recreates the dumped indexes of a collection; the _id index always exists already
+func createIndexes(ctx context.Context, db *mongo.Database, collName string, indexes []bson.Raw) error {
+	specs := bson.A{}
+	for _, index := range indexes {
+		spec, err := indexSpec(index)
+		if err != nil {
+			return fmt.Errorf("invalid index on collection %s: %w", collName, err)
+		}
+		if spec != nil {
+			specs = append(specs, spec)
+		}
+	}
+	if len(specs) == 0 {
+		return nil
+	}
+
+	command := bson.D{{Key: "createIndexes", Value: collName}, {Key: "indexes", Value: specs}}
+	if err := db.RunCommand(ctx, command).Err(); err != nil {
+		return fmt.Errorf("failed to create indexes on collection %s: %w", collName, err)
+	}
+	fmt.Printf("      Created %d indexes on %s\n", len(specs), collName)
+	return nil
+}
+
+// indexSpec converts a dumped index definition into a createIndexes specification.
+// The index version and namespace are left for the server to fill in, and nil is returned for the _id index.
+func indexSpec(index bson.Raw) (bson.D, error) {
+	if name, _ := index.Lookup("name").StringValueOK(); name == "_id_" {
+		return nil, nil
+	}
+
+	elements, err := index.Elements()
+	if err != nil {
+		return nil, err
+	}
+
+	spec := bson.D{}
+	for _, element := range elements {
+		if element.Key() == "v" || element.Key() == "ns" {
+			continue
+		}
+		spec = append(spec, bson.E{Key: element.Key(), Value: element.Value()})
+	}
+	return spec, nil
+}
+
+// hasErrorCode reports whether err is a server error with the given code
+func hasErrorCode(err error, code int) bool {
+	var serverErr mongo.ServerError
+	return errors.As(err, &serverErr) && serverErr.HasErrorCode(code)
+}
@@ -0,0 +1,331 @@
+package dump
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	tcmongodb "github.com/testcontainers/testcontainers-go/modules/mongodb"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func TestIndexSpec(t *testing.T) {
+	spec, err := indexSpec(mustMarshal(t, bson.D{
+		{Key: "v", Value: int32(2)},
+		{Key: "key", Value: bson.D{{Key: "_id", Value: int32(1)}}},
+		{Key: "name", Value: "_id_"},
+	}))
+	require.NoError(t, err)
+	assert.Nil(t, spec)
+
+	spec, err = indexSpec(mustMarshal(t, bson.D{
+		{Key: "v", Value: int32(2)},
+		{Key: "key", Value: bson.D{{Key: "email", Value: int32(1)}}},
+		{Key: "name", Value: "email_1"},
+		{Key: "ns", Value: "db.users"},
+		{Key: "unique", Value: true},
+	}))
+	require.NoError(t, err)
+
+	var keys []string
+	for _, element := range spec {
+		keys = append(keys, element.Key)
+	}
+	assert.Equal(t, []string{"key", "name", "unique"}, keys)
+}
+
+func TestTimeSeriesCreateOptions(t *testing.T) {
+	tests := []struct {
+		name     string
+		options  bson.D
+		expected []string
+	}{
+		{
+			name: "Granularity drops implied bucket spans",
+			options: bson.D{
+				{Key: "timeField", Value: "ts"},
+				{Key: "metaField", Value: "meta"},
+				{Key: "granularity", Value: "seconds"},
+				{Key: "bucketMaxSpanSeconds", Value: int32(3600)},
+			},
+			expected: []string{"timeField", "metaField", "granularity"},
+		},
+		{
+			name: "Custom bucketing keeps spans",
+			options: bson.D{
+				{Key: "timeField", Value: "ts"},
+				{Key: "bucketMaxSpanSeconds", Value: int32(600)},
+				{Key: "bucketRoundingSeconds", Value: int32(600)},
+			},
+			expected: []string{"timeField", "bucketMaxSpanSeconds", "bucketRoundingSeconds"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var keys []string
+			for _, element := range timeSeriesCreateOptions(mustMarshal(t, tt.options)) {
+				keys = append(keys, element.Key)
+			}
+			assert.Equal(t, tt.expected, keys)
+		})
+	}
+}
+
+func TestIsDuplicateKeyOnly(t *testing.T) {
+	duplicate := mongo.BulkWriteError{WriteError: mongo.WriteError{Code: errCodeDuplicateKey}}
+	validation := mongo.BulkWriteError{WriteError: mongo.WriteError{Code: 121}}
+
+	tests := []struct {
+		name     string
+		err      error
+		expected bool
+	}{
+		{name: "Nil", err: nil, expected: false},
+		{name: "Other error", err: fmt.Errorf("network error"), expected: false},
+		{name: "Duplicates", err: mongo.BulkWriteException{WriteErrors: []mongo.BulkWriteError{duplicate, duplicate}}, expected: true},
+		{name: "Mixed", err: mongo.BulkWriteException{WriteErrors: []mongo.BulkWriteError{duplicate, validation}}, expected: false},
+		{
+			name: "Write concern error",
+			err: mongo.BulkWriteException{
+				WriteErrors:       []mongo.BulkWriteError{duplicate},
+				WriteConcernError: &mongo.WriteConcernError{Code: 64},
+			},
+			expected: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, isDuplicateKeyOnly(tt.err))
+		})
+	}
+}
+
+func TestDescribeWriteError(t *testing.T) {
+	batch := []bson.Raw{
+		mustMarshal(t, bson.D{{Key: "_id", Value: "a"}}),
+		mustMarshal(t, bson.D{{Key: "_id", Value: "b"}}),
+	}
+
+	err := describeWriteError(mongo.BulkWriteException{WriteErrors: []mongo.BulkWriteError{
+		{WriteError: mongo.WriteError{Index: 1, Code: 121, Message: "Document failed validation"}},
+	}}, batch)
+	assert.ErrorContains(t, err, `{"_id":"b"}`)
+	assert.ErrorContains(t, err, "Document failed validation")
+
+	plain := fmt.Errorf("network error")
+	assert.Equal(t, plain, describeWriteError(plain, batch))
+}
+
+func TestWriteBatchesParallel(t *testing.T) {
+	var data []byte
+	for i := 0; i < 25; i++ {
+		data = append(data, mustMarshal(t, bson.D{{Key: "_id", Value: i}})...)
+	}
+
+	t.Run("Writes every document", func(t *testing.T) {
+		var mu sync.Mutex
+		seen := map[int32]bool{}
+		write := func(_ context.Context, batch []bson.Raw, result *RestoreResult) error {
+			mu.Lock()
+			defer mu.Unlock()
+			for _, doc := range batch {
+				seen[doc.Lookup("_id").Int32()] = true
+			}
+			result.Inserted += int64(len(batch))
+			return nil
+		}
+
+		result, err := writeBatchesParallel(context.Background(), NewReader(bytes.NewReader(data)), 4, 3, "users", write)
+		require.NoError(t, err)
+		assert.Equal(t, RestoreResult{Inserted: 25}, result)
+		assert.Len(t, seen, 25)
+	})
+
+	t.Run("Stops at the first failure", func(t *testing.T) {
+		var calls atomic.Int32
+		write := func(ctx context.Context, batch []bson.Raw, result *RestoreResult) error {
+			if calls.Add(1) == 2 {
+				return errors.New("validation failed")
+			}
+			<-ctx.Done()
+			return ctx.Err()
+		}
+
+		_, err := writeBatchesParallel(context.Background(), NewReader(bytes.NewReader(data)), 1, 3, "users", write)
+		assert.EqualError(t, err, "validation failed")
+		assert.Less(t, calls.Load(), int32(25))
+	})
+
+	t.Run("Reports read errors", func(t *testing.T) {
+		write := func(_ context.Context, batch []bson.Raw, result *RestoreResult) error {
+			result.Inserted += int64(len(batch))
+			return nil
+		}
+
+		result, err := writeBatchesParallel(context.Background(), NewReader(bytes.NewReader(data[:len(data)-2])), 10, 2, "users", write)
+		assert.ErrorContains(t, err, "failed to read dumped documents of users")
+		assert.Equal(t, RestoreResult{Inserted: 20}, result)
+	})
+}
+
+func TestRestoreCollectionIntegration(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	ctx := context.Background()
+	container, err := tcmongodb.Run(ctx, "mongo:8.0")
+	require.NoError(t, err)
+	defer container.Terminate(ctx)
+
+	connString, err := container.ConnectionString(ctx)
+	require.NoError(t, err)
+
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(connString))
+	require.NoError(t, err)
+	defer client.Disconnect(ctx)
+
+	sourceDB := client.Database("restore_source")
+	err = sourceDB.CreateCollection(ctx, "users", options.CreateCollection().SetValidator(bson.M{"age": bson.M{"$gte": 0}}))
+	require.NoError(t, err)
+	for i := 0; i < 25; i++ {
+		_, err := sourceDB.Collection("users").InsertOne(ctx, bson.M{"_id": i, "age": 20 + i})
+		require.NoError(t, err)
+	}
+	_, err = sourceDB.Collection("users").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "age", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	require.NoError(t, err)
+
+	dumpDir := t.TempDir()
+	_, err = DumpCollection(ctx, sourceDB, "users", WriterOptions{OutputDir: dumpDir, BatchSize: 10})
+	require.NoError(t, err)
+
+	// The dump is restored under the source database name, so restore into it after dropping the original
+	require.NoError(t, sourceDB.Drop(ctx))
+	_, err = sourceDB.Collection("users").InsertOne(ctx, bson.M{"_id": 3, "age": 99})
+	require.NoError(t, err)
+
+	opts := RestoreOptions{InputDir: dumpDir, BatchSize: 10, RetryAttempts: 3, Conflict: ConflictSkip, Ordered: true}
+	result, err := RestoreCollection(ctx, sourceDB, "users", opts)
+	require.NoError(t, err)
+	assert.Equal(t, RestoreResult{Inserted: 24, Skipped: 1}, result)
+
+	var existing bson.M
+	require.NoError(t, sourceDB.Collection("users").FindOne(ctx, bson.M{"_id": 3}).Decode(&existing))
+	assert.EqualValues(t, 99, existing["age"])
+
+	opts.Conflict = ConflictUpsert
+	result, err = RestoreCollection(ctx, sourceDB, "users", opts)
+	require.NoError(t, err)
+	assert.Equal(t, RestoreResult{Replaced: 25}, result)
+
+	opts.Conflict = ConflictFail
+	_, err = RestoreCollection(ctx, sourceDB, "users", opts)
+	assert.ErrorContains(t, err, `{"_id":0}`)
+
+	opts.Drop = true
+	result, err = RestoreCollection(ctx, sourceDB, "users", opts)
+	require.NoError(t, err)
+	assert.Equal(t, RestoreResult{Inserted: 25}, result)
+
+	opts.InsertionWorkers = 4
+	opts.Ordered = false
+	result, err = RestoreCollection(ctx, sourceDB, "users", opts)
+	require.NoError(t, err)
+	assert.Equal(t, RestoreResult{Inserted: 25}, result)
+
+	indexes, err := listIndexes(ctx, sourceDB.Collection("users"))
+	require.NoError(t, err)
+	assert.Len(t, indexes, 2)
+
+	specs, err := sourceDB.ListCollectionSpecifications(ctx, bson.M{"name": "users"})
+	require.NoError(t, err)
+	require.Len(t, specs, 1)
+	assert.Contains(t, specs[0].Options.String(), "validator")
+
+	checkOpts := CheckOptions{InputDir: dumpDir, Counts: true, Hashes: true, BatchSize: 10}
+	check, err := CheckRestoredCollection(ctx, sourceDB, "users", checkOpts)
+	require.NoError(t, err)
+	assert.True(t, check.OK(), check.Problems)
+	assert.Equal(t, CheckResult{Documents: 25, Indexes: 1}, *check)
+
+	_, err = sourceDB.Collection("users").UpdateOne(ctx, bson.M{"_id": 7}, bson.M{"$set": bson.M{"note": "changed"}})
+	require.NoError(t, err)
+	_, err = sourceDB.Collection("users").DeleteOne(ctx, bson.M{"_id": 8})
+	require.NoError(t, err)
+	_, err = sourceDB.Collection("users").Indexes().DropOne(ctx, "age_1")
+	require.NoError(t, err)
+
+	check, err = CheckRestoredCollection(ctx, sourceDB, "users", checkOpts)
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"index age_1 is missing",
+		"24 documents, the dump has 25",
+		`document {"_id":7} differs from the dump`,
+		`document {"_id":8} is missing`,
+	}, check.Problems)
+}
+
+func TestCreateCommand(t *testing.T) {
+	command, err := createCommand(&Metadata{CollectionName: "users", Type: TypeCollection})
+	require.NoError(t, err)
+	assert.Nil(t, command)
+
+	command, err = createCommand(&Metadata{
+		CollectionName: "metrics",
+		Type:           TypeTimeSeries,
+		Options: mustMarshal(t, bson.D{
+			{Key: "timeseries", Value: bson.D{
+				{Key: "timeField", Value: "ts"},
+				{Key: "granularity", Value: "hours"},
+				{Key: "bucketMaxSpanSeconds", Value: int32(2592000)},
+			}},
+			{Key: "expireAfterSeconds", Value: int64(86400)},
+		}),
+	})
+	require.NoError(t, err)
+	require.Len(t, command, 3)
+	assert.Equal(t, bson.E{Key: "create", Value: "metrics"}, command[0])
+	assert.Equal(t, "timeseries", command[1].Key)
+	assert.Len(t, command[1].Value, 2)
+	assert.Equal(t, "expireAfterSeconds", command[2].Key)
+}
+
+func TestVerifyDataFile(t *testing.T) {
+	keys := testKeyring(t, 1)
+	dir := t.TempDir()
+	meta := &Metadata{CollectionName: "users", Type: TypeCollection}
+
+	// Unencrypted and missing files need no verification
+	assert.NoError(t, verifyDataFile(dir, meta, nil))
+
+	err := writeCompressedFile(dir, BSONFileName("users"), CompressionGzip, keys.Primary(), func(w io.Writer) error {
+		_, err := w.Write(mustMarshal(t, bson.D{{Key: "_id", Value: 1}}))
+		return err
+	})
+	require.NoError(t, err)
+	assert.NoError(t, verifyDataFile(dir, meta, keys))
+
+	path, _, found := FindFile(dir, BSONFileName("users"))
+	require.True(t, found)
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	data[len(data)-1] ^= 1
+	require.NoError(t, os.WriteFile(path, data, 0600))
+
+	assert.ErrorIs(t, verifyDataFile(dir, meta, keys), ErrAuthenticationFailed)
+}
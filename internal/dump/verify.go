@@ -0,0 +1,133 @@
+package dump
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+
+	"nmongo/internal/storage"
+)
+
+// VerifyReport is the outcome of checking a dump against its manifest
+type VerifyReport struct {
+	// Files is the number of files listed in the manifest
+	Files int
+	// Unchecked counts encrypted data and changes files whose documents could not be counted without a key
+	Unchecked int
+	// Problems describes every mismatch between the dump and its manifest
+	Problems []string
+}
+
+// OK reports whether the dump matches its manifest
+func (r *VerifyReport) OK() bool {
+	return len(r.Problems) == 0
+}
+
+// VerifyDump checks that every file listed in the manifest exists with the recorded size, checksum and document count,
+// and that the dump holds no files missing from the manifest. Document counts of encrypted files are only checked with keys.
+func VerifyDump(root string, manifest *Manifest, keys *Keyring) (*VerifyReport, error) {
+	report := &VerifyReport{Files: len(manifest.Files)}
+	listed := make(map[string]bool, len(manifest.Files))
+	for _, file := range manifest.Files {
+		listed[file.Path] = true
+		problem, checked := verifyFile(root, file, keys)
+		if problem != "" {
+			report.Problems = append(report.Problems, fmt.Sprintf("%s: %s", file.Path, problem))
+		}
+		if !checked {
+			report.Unchecked++
+		}
+	}
+
+	unlisted, err := unlistedFiles(root, listed)
+	if err != nil {
+		return report, err
+	}
+	for _, path := range unlisted {
+		report.Problems = append(report.Problems, fmt.Sprintf("%s: not listed in the manifest", path))
+	}
+	return report, nil
+}
+
+// verifyFile compares a dump file with its manifest entry. It returns a description of the first mismatch,
+// and whether the document count could be checked.
+func verifyFile(root string, expected ManifestFile, keys *Keyring) (problem string, checked bool) {
+	path := storage.Join(root, expected.Path)
+	if problem := verifyStoredBytes(path, expected); problem != "" {
+		return problem, true
+	}
+
+	if !holdsDocuments(expected.Type) {
+		return "", true
+	}
+	if expected.Encrypted && keys == nil {
+		return "", false
+	}
+	return verifyDocumentCount(path, expected, keys), true
+}
+
+// verifyStoredBytes compares the size and checksum of a dump file with the manifest
+func verifyStoredBytes(path string, expected ManifestFile) string {
+	size, sum, err := hashFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return "missing"
+	}
+	if err != nil {
+		return err.Error()
+	}
+	if size != expected.Size {
+		return fmt.Sprintf("size is %d bytes, manifest records %d", size, expected.Size)
+	}
+	if sum != expected.SHA256 {
+		return "SHA-256 checksum does not match the manifest"
+	}
+	return ""
+}
+
+// verifyDocumentCount reads the documents of a data file and compares their count with the manifest
+func verifyDocumentCount(path string, expected ManifestFile, keys *Keyring) string {
+	count, _, err := CountDocuments(path, expected.Compression, keys)
+	if err != nil {
+		return err.Error()
+	}
+	if count != expected.Documents {
+		return fmt.Sprintf("holds %d documents, manifest records %d", count, expected.Documents)
+	}
+	return ""
+}
+
+// unlistedFiles returns the dump files in the database directories of the dump root that are missing from the manifest
+func unlistedFiles(root string, listed map[string]bool) ([]string, error) {
+	dirs, err := storage.ReadDir(root)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var unlisted []string
+	for _, dir := range dirs {
+		if !dir.IsDir {
+			continue
+		}
+		files, err := storage.ReadDir(storage.Join(root, dir.Name))
+		if err != nil {
+			return nil, err
+		}
+		unlisted = append(unlisted, unlistedInDir(dir.Name, files, listed)...)
+	}
+	return unlisted, nil
+}
+
+// unlistedInDir returns the dump files of a database directory that are missing from the manifest
+func unlistedInDir(dirName string, files []storage.Entry, listed map[string]bool) []string {
+	var unlisted []string
+	for _, file := range files {
+		relPath := dirName + "/" + file.Name
+		if !file.IsDir && fileType(file.Name) != "" && !listed[relPath] {
+			unlisted = append(unlisted, relPath)
+		}
+	}
+	return unlisted
+}
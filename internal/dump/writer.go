@@ -0,0 +1,219 @@
+package dump
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"nmongo/internal/storage"
+)
+
+// WriterOptions configures a native collection dump
+type WriterOptions struct {
+	// OutputDir is the dump root; files are written to <OutputDir>/<database>/
+	OutputDir string
+	// BatchSize is the cursor batch size used to read documents
+	BatchSize int
+	// Filter selects the documents to dump; it is ignored for time-series collections
+	Filter bson.M
+	// Compression is the format the files are compressed with; empty means uncompressed
+	Compression string
+	// Key encrypts the files after compression; nil means unencrypted
+	Key *Key
+}
+
+// DumpCollection writes the documents and metadata of a collection in the mongodump layout.
+// Documents are streamed from the cursor as raw BSON, so they are written exactly as stored.
+// It returns the number of documents written.
+func DumpCollection(ctx context.Context, db *mongo.Database, collName string, opts WriterOptions) (int64, error) {
+	meta, err := ReadMetadata(ctx, db, collName)
+	if err != nil {
+		return 0, err
+	}
+
+	dbDir := storage.Join(opts.OutputDir, db.Name())
+	if err := storage.MkdirAll(dbDir); err != nil {
+		return 0, fmt.Errorf("failed to create database directory: %w", err)
+	}
+
+	count, err := dumpData(ctx, db, meta, dbDir, opts)
+	if err != nil {
+		return count, err
+	}
+
+	if err := writeMetadataFile(dbDir, MetadataFileName(collName), opts.Compression, opts.Key, meta); err != nil {
+		return count, err
+	}
+
+	return count, nil
+}
+
+// dumpData writes the documents of a collection, if it has any, to its BSON file
+func dumpData(ctx context.Context, db *mongo.Database, meta *Metadata, dbDir string, opts WriterOptions) (int64, error) {
+	if !meta.HasData() {
+		return 0, nil
+	}
+
+	filter := opts.Filter
+	if meta.Type == TypeTimeSeries || filter == nil {
+		filter = bson.M{}
+	}
+
+	dataColl := meta.DataCollection()
+	cursor, err := openDumpCursor(ctx, db.Collection(dataColl), filter, opts.BatchSize)
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+
+	var count int64
+	err = writeCompressedFile(dbDir, BSONFileName(dataColl), opts.Compression, opts.Key, func(w io.Writer) error {
+		count, err = writeDocuments(ctx, w, cursor, dataColl)
+		return err
+	})
+	return count, err
+}
+
+// ReadMetadata reads the options, UUID and indexes of a collection from the server
+func ReadMetadata(ctx context.Context, db *mongo.Database, collName string) (*Metadata, error) {
+	specs, err := db.ListCollectionSpecifications(ctx, bson.M{"name": collName})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read options of collection %s: %w", collName, err)
+	}
+	if len(specs) == 0 {
+		return nil, fmt.Errorf("collection %s.%s does not exist", db.Name(), collName)
+	}
+
+	spec := specs[0]
+	var indexes []bson.Raw
+	if spec.Type != TypeView {
+		if indexes, err = listIndexes(ctx, db.Collection(collName)); err != nil {
+			return nil, err
+		}
+	}
+
+	return NewMetadata(spec, indexes), nil
+}
+
+// listIndexes returns the index definitions of a collection with their key order preserved
+func listIndexes(ctx context.Context, coll *mongo.Collection) ([]bson.Raw, error) {
+	cursor, err := coll.Indexes().List(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list indexes of collection %s: %w", coll.Name(), err)
+	}
+	defer cursor.Close(ctx)
+
+	var indexes []bson.Raw
+	for cursor.Next(ctx) {
+		indexes = append(indexes, append(bson.Raw(nil), cursor.Current...))
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read indexes of collection %s: %w", coll.Name(), err)
+	}
+	return indexes, nil
+}
+
+// openDumpCursor queries the documents to dump without a cursor timeout, since writing compressed files can be slow
+func openDumpCursor(ctx context.Context, coll *mongo.Collection, filter bson.M, batchSize int) (*mongo.Cursor, error) {
+	findOptions := options.Find().
+		SetBatchSize(int32(batchSize)).
+		SetNoCursorTimeout(true)
+	cursor, err := coll.Find(ctx, filter, findOptions)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query collection %s: %w", coll.Name(), err)
+	}
+	return cursor, nil
+}
+
+// writeDocuments copies the raw documents of a cursor to a writer
+func writeDocuments(ctx context.Context, w io.Writer, cursor *mongo.Cursor, collName string) (int64, error) {
+	var count int64
+	lastProgressTime := time.Now()
+
+	for cursor.Next(ctx) {
+		if _, err := w.Write(cursor.Current); err != nil {
+			return count, fmt.Errorf("failed to write document: %w", err)
+		}
+		count++
+
+		if time.Since(lastProgressTime) > 10*time.Second {
+			fmt.Printf("      Dumped %d documents from %s\n", count, collName)
+			lastProgressTime = time.Now()
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return count, fmt.Errorf("cursor error: %w", err)
+	}
+
+	return count, nil
+}
+
+// writeMetadataFile writes the metadata of a collection as extended JSON
+func writeMetadataFile(dbDir, name, compression string, key *Key, meta *Metadata) error {
+	data, err := meta.MarshalJSON()
+	if err != nil {
+		return err
+	}
+
+	return writeCompressedFile(dbDir, name, compression, key, func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+}
+
+// writeCompressedFile atomically writes a dump file in the given compression format, encrypted if a key is given,
+// and removes copies of it left by earlier dumps with another format
+func writeCompressedFile(dir, name, compression string, key *Key, write func(w io.Writer) error) error {
+	storedName := StoredName(name, compression, key != nil)
+	err := storage.WriteFile(storage.Join(dir, storedName), func(w io.Writer) error {
+		encoder, err := newFileEncoder(w, compression, key)
+		if err != nil {
+			return err
+		}
+		if err := write(encoder); err != nil {
+			encoder.Close()
+			return err
+		}
+		return encoder.Close()
+	})
+	if err != nil {
+		return err
+	}
+	return RemoveStaleVariants(dir, name, storedName)
+}
+
+// fileEncoder compresses data and encrypts the compressed stream
+type fileEncoder struct {
+	io.WriteCloser
+	encryptor io.WriteCloser
+}
+
+// newFileEncoder wraps a writer so data written to it is compressed and, if a key is given, encrypted
+func newFileEncoder(w io.Writer, compression string, key *Key) (io.WriteCloser, error) {
+	var encryptor io.WriteCloser = nopWriteCloser{w}
+	if key != nil {
+		var err error
+		if encryptor, err = newEncryptor(w, key); err != nil {
+			return nil, fmt.Errorf("failed to encrypt dump file: %w", err)
+		}
+	}
+
+	compressor, err := newCompressor(encryptor, compression)
+	if err != nil {
+		return nil, err
+	}
+	return &fileEncoder{WriteCloser: compressor, encryptor: encryptor}, nil
+}
+
+// Close flushes the compressed stream and seals the last encrypted chunk
+func (e *fileEncoder) Close() error {
+	if err := e.WriteCloser.Close(); err != nil {
+		return err
+	}
+	return e.encryptor.Close()
+}
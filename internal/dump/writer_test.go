@@ -0,0 +1,91 @@
+package dump
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	tcmongodb "github.com/testcontainers/testcontainers-go/modules/mongodb"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func TestWriteDocuments(t *testing.T) {
+	docs := []interface{}{
+		bson.D{{Key: "_id", Value: 1}, {Key: "name", Value: "a"}},
+		bson.D{{Key: "_id", Value: 2}, {Key: "name", Value: "b"}},
+	}
+	cursor, err := mongo.NewCursorFromDocuments(docs, nil, nil)
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	count, err := writeDocuments(context.Background(), &buf, cursor, "test")
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), count)
+
+	// The output is a plain concatenation of BSON documents
+	var expected bytes.Buffer
+	for _, doc := range docs {
+		expected.Write(mustMarshal(t, doc))
+	}
+	assert.Equal(t, expected.Bytes(), buf.Bytes())
+}
+
+func TestDumpCollectionIntegration(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	ctx := context.Background()
+	container, err := tcmongodb.Run(ctx, "mongo:8.0")
+	require.NoError(t, err)
+	defer container.Terminate(ctx)
+
+	connString, err := container.ConnectionString(ctx)
+	require.NoError(t, err)
+
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(connString))
+	require.NoError(t, err)
+	defer client.Disconnect(ctx)
+
+	db := client.Database("dumpdb")
+	for i := 0; i < 25; i++ {
+		_, err := db.Collection("users").InsertOne(ctx, bson.M{"_id": i, "age": 20 + i})
+		require.NoError(t, err)
+	}
+	_, err = db.Collection("users").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "age", Value: 1}, {Key: "_id", Value: -1}},
+	})
+	require.NoError(t, err)
+
+	outputDir := t.TempDir()
+	count, err := DumpCollection(ctx, db, "users", WriterOptions{
+		OutputDir: outputDir,
+		BatchSize: 10,
+		Filter:    bson.M{"age": bson.M{"$gte": 30}},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, int64(15), count)
+
+	data, err := os.ReadFile(filepath.Join(outputDir, "dumpdb", "users.bson"))
+	require.NoError(t, err)
+	var docs int
+	for len(data) > 0 {
+		size := int(binary.LittleEndian.Uint32(data))
+		require.NoError(t, bson.Raw(data[:size]).Validate())
+		data = data[size:]
+		docs++
+	}
+	assert.Equal(t, 15, docs)
+
+	metadata, err := os.ReadFile(filepath.Join(outputDir, "dumpdb", "users.metadata.json"))
+	require.NoError(t, err)
+	assert.Contains(t, string(metadata), `"collectionName":"users"`)
+	assert.Contains(t, string(metadata), `"key":{"age":{"$numberInt":"1"},"_id":{"$numberInt":"-1"}}`)
+}
@@ -0,0 +1,194 @@
+package mongodb
+
+import (
+	"bytes"
+	"math"
+	"reflect"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Modes of document equality
+const (
+	// EqualitySemantic compares numbers by value across int32, int64, double and Decimal128, optionally within
+	// date and float tolerances, and documents regardless of the order of their fields unless asked otherwise
+	EqualitySemantic = "semantic"
+	// EqualityStrict requires the same BSON type for every value and the same order of fields
+	EqualityStrict = "strict"
+)
+
+// Equality decides whether two decoded BSON values are equal. The zero value compares semantically without
+// tolerances and regardless of field order.
+type Equality struct {
+	// Mode is EqualitySemantic, the default, or EqualityStrict
+	Mode string
+	// DateTolerance is the largest difference between two dates that are still equal in semantic mode
+	DateTolerance time.Duration
+	// FloatTolerance is the largest absolute difference between two numbers that are still equal in semantic mode
+	FloatTolerance float64
+	// KeyOrder requires the fields of documents to be in the same order in semantic mode, as strict mode always does
+	KeyOrder bool
+}
+
+// strict reports whether the equality requires exact types and field order
+func (e Equality) strict() bool {
+	return e.Mode == EqualityStrict
+}
+
+// equal reports whether two decoded BSON values are equal
+func (e Equality) equal(a, b interface{}) bool {
+	a, b = normalizeValue(a), normalizeValue(b)
+	order := bsonTypeOrder(a)
+	if order != bsonTypeOrder(b) {
+		return false
+	}
+
+	switch order {
+	case orderObject:
+		return e.documentsEqual(a, b)
+	case orderArray:
+		return e.arraysEqual(toArray(a), toArray(b))
+	case orderNumber:
+		return e.numbersEqual(a, b)
+	case orderDate:
+		return e.datesEqual(a.(primitive.DateTime), b.(primitive.DateTime))
+	default:
+		return e.scalarsEqual(a, b)
+	}
+}
+
+// normalizeValue converts the Go types the driver encodes as BSON types to the types it decodes them to
+func normalizeValue(v interface{}) interface{} {
+	switch n := v.(type) {
+	case primitive.Null:
+		return nil
+	case int:
+		return int64(n)
+	case time.Time:
+		return primitive.NewDateTimeFromTime(n)
+	case []byte:
+		return primitive.Binary{Data: n}
+	default:
+		return v
+	}
+}
+
+// documentsEqual compares two documents field by field. Fields are compared in their order when it matters and
+// both documents are ordered, since the order of a map is unknown.
+func (e Equality) documentsEqual(a, b interface{}) bool {
+	docA, docB := toOrderedDocument(a), toOrderedDocument(b)
+	if len(docA) != len(docB) {
+		return false
+	}
+	if e.unordered(a, b) {
+		docA, docB = sortedElements(docA), sortedElements(docB)
+	}
+
+	for i := range docA {
+		if docA[i].Key != docB[i].Key || !e.equal(docA[i].Value, docB[i].Value) {
+			return false
+		}
+	}
+	return true
+}
+
+// unordered reports whether the fields of two documents are compared regardless of their order
+func (e Equality) unordered(a, b interface{}) bool {
+	_, orderedA := a.(bson.D)
+	_, orderedB := b.(bson.D)
+	return !orderedA || !orderedB || !(e.KeyOrder || e.strict())
+}
+
+// sortedElements returns a copy of a document with its fields sorted by key
+func sortedElements(doc bson.D) bson.D {
+	sorted := make(bson.D, len(doc))
+	copy(sorted, doc)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Key < sorted[j].Key })
+	return sorted
+}
+
+// arraysEqual compares two arrays element by element
+func (e Equality) arraysEqual(a, b []interface{}) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !e.equal(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// numbersEqual compares two numbers, by type and value in strict mode and by value within the float tolerance
+// in semantic mode
+func (e Equality) numbersEqual(a, b interface{}) bool {
+	if e.strict() {
+		return e.scalarsEqual(a, b)
+	}
+	if compareNumbers(a, b) == 0 {
+		return true
+	}
+	return e.FloatTolerance > 0 && math.Abs(numberFloat(a)-numberFloat(b)) <= e.FloatTolerance
+}
+
+// numberFloat converts a number to float64, NaN when it has no numeric value
+func numberFloat(v interface{}) float64 {
+	f, isNaN := bigFloatValue(v)
+	if isNaN {
+		return math.NaN()
+	}
+	value, _ := f.Float64()
+	return value
+}
+
+// datesEqual compares two dates, exactly in strict mode and within the date tolerance in semantic mode
+func (e Equality) datesEqual(a, b primitive.DateTime) bool {
+	diff := int64(a - b)
+	if diff < 0 {
+		diff = -diff
+	}
+	return diff == 0 || (!e.strict() && diff <= e.DateTolerance.Milliseconds())
+}
+
+// scalarsEqual compares two values of the same BSON type, or of the same numeric type in strict mode
+func (e Equality) scalarsEqual(a, b interface{}) bool {
+	valueType := reflect.TypeOf(a)
+	switch {
+	case valueType != reflect.TypeOf(b):
+		return false
+	case valueType == nil:
+		return true
+	}
+
+	switch v := a.(type) {
+	case float64:
+		return floatsEqual(v, b.(float64))
+	case primitive.Binary:
+		return binariesEqual(v, b.(primitive.Binary))
+	case primitive.CodeWithScope:
+		return e.codeWithScopeEqual(v, b.(primitive.CodeWithScope))
+	}
+	if valueType.Comparable() {
+		return a == b
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// floatsEqual compares two doubles; unlike with ==, NaN equals NaN
+func floatsEqual(a, b float64) bool {
+	return a == b || (math.IsNaN(a) && math.IsNaN(b))
+}
+
+// codeWithScopeEqual compares two JavaScript values with scope by code and scope
+func (e Equality) codeWithScopeEqual(a, b primitive.CodeWithScope) bool {
+	return a.Code == b.Code && e.equal(a.Scope, b.Scope)
+}
+
+// binariesEqual compares two binary values by subtype and content
+func binariesEqual(a, b primitive.Binary) bool {
+	return a.Subtype == b.Subtype && bytes.Equal(a.Data, b.Data)
+}
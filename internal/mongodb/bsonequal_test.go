@@ -0,0 +1,227 @@
+package mongodb
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func mustDecimal(t *testing.T, s string) primitive.Decimal128 {
+	d, err := primitive.ParseDecimal128(s)
+	require.NoError(t, err)
+	return d
+}
+
+// TestEqualityTypes compares values of every BSON type in both modes
+func TestEqualityTypes(t *testing.T) {
+	oid := primitive.NewObjectID()
+	otherOID := primitive.NewObjectID()
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	date := primitive.NewDateTimeFromTime(now)
+	scope := bson.D{{Key: "x", Value: int32(1)}}
+
+	tests := []struct {
+		name     string
+		a, b     interface{}
+		strict   bool
+		semantic bool
+	}{
+		{name: "Double", a: 1.5, b: 1.5, strict: true, semantic: true},
+		{name: "Double different", a: 1.5, b: 2.5},
+		{name: "Double NaN", a: math.NaN(), b: math.NaN(), strict: true, semantic: true},
+		{name: "String", a: "a", b: "a", strict: true, semantic: true},
+		{name: "String different", a: "a", b: "b"},
+		{name: "String and symbol", a: "a", b: primitive.Symbol("a")},
+		{name: "Document", a: bson.D{{Key: "a", Value: int32(1)}}, b: bson.D{{Key: "a", Value: int32(1)}}, strict: true, semantic: true},
+		{name: "Document as map", a: bson.D{{Key: "a", Value: int32(1)}}, b: bson.M{"a": int32(1)}, strict: true, semantic: true},
+		{name: "Document field order",
+			a: bson.D{{Key: "a", Value: 1}, {Key: "b", Value: 2}}, b: bson.D{{Key: "b", Value: 2}, {Key: "a", Value: 1}}, semantic: true},
+		{name: "Document extra field", a: bson.D{{Key: "a", Value: 1}}, b: bson.D{{Key: "a", Value: 1}, {Key: "b", Value: 2}}},
+		{name: "Array", a: bson.A{"a", int32(1)}, b: []interface{}{"a", int32(1)}, strict: true, semantic: true},
+		{name: "Array order", a: bson.A{"a", "b"}, b: bson.A{"b", "a"}},
+		{name: "Binary", a: primitive.Binary{Subtype: 4, Data: []byte{1, 2}}, b: primitive.Binary{Subtype: 4, Data: []byte{1, 2}},
+			strict: true, semantic: true},
+		{name: "Binary subtype", a: primitive.Binary{Subtype: 4, Data: []byte{1, 2}}, b: primitive.Binary{Data: []byte{1, 2}}},
+		{name: "Binary and bytes", a: []byte{1, 2}, b: primitive.Binary{Data: []byte{1, 2}}, strict: true, semantic: true},
+		{name: "Undefined", a: primitive.Undefined{}, b: primitive.Undefined{}, strict: true, semantic: true},
+		{name: "Undefined and null", a: primitive.Undefined{}, b: nil},
+		{name: "ObjectId", a: oid, b: oid, strict: true, semantic: true},
+		{name: "ObjectId different", a: oid, b: otherOID},
+		{name: "Boolean", a: true, b: true, strict: true, semantic: true},
+		{name: "Boolean different", a: true, b: false},
+		{name: "Date", a: date, b: date, strict: true, semantic: true},
+		{name: "Date different", a: date, b: date + 1},
+		{name: "Date and time", a: date, b: now, strict: true, semantic: true},
+		{name: "Null", a: nil, b: primitive.Null{}, strict: true, semantic: true},
+		{name: "Null and zero", a: nil, b: int32(0)},
+		{name: "Regex", a: primitive.Regex{Pattern: "^a", Options: "i"}, b: primitive.Regex{Pattern: "^a", Options: "i"},
+			strict: true, semantic: true},
+		{name: "Regex options", a: primitive.Regex{Pattern: "^a", Options: "i"}, b: primitive.Regex{Pattern: "^a"}},
+		{name: "DBPointer", a: primitive.DBPointer{DB: "app.users", Pointer: oid}, b: primitive.DBPointer{DB: "app.users", Pointer: oid},
+			strict: true, semantic: true},
+		{name: "DBPointer different", a: primitive.DBPointer{DB: "app.users", Pointer: oid},
+			b: primitive.DBPointer{DB: "app.users", Pointer: otherOID}},
+		{name: "JavaScript", a: primitive.JavaScript("f()"), b: primitive.JavaScript("f()"), strict: true, semantic: true},
+		{name: "JavaScript and string", a: primitive.JavaScript("f()"), b: "f()"},
+		{name: "Symbol", a: primitive.Symbol("s"), b: primitive.Symbol("s"), strict: true, semantic: true},
+		{name: "CodeWithScope", a: primitive.CodeWithScope{Code: "f()", Scope: scope}, b: primitive.CodeWithScope{Code: "f()", Scope: scope},
+			strict: true, semantic: true},
+		{name: "CodeWithScope scope number types",
+			a: primitive.CodeWithScope{Code: "f()", Scope: scope},
+			b: primitive.CodeWithScope{Code: "f()", Scope: bson.D{{Key: "x", Value: int64(1)}}}, semantic: true},
+		{name: "CodeWithScope code", a: primitive.CodeWithScope{Code: "f()", Scope: scope},
+			b: primitive.CodeWithScope{Code: "g()", Scope: scope}},
+		{name: "Int32", a: int32(5), b: int32(5), strict: true, semantic: true},
+		{name: "Int32 different", a: int32(5), b: int32(6)},
+		{name: "Int32 and int64", a: int32(5), b: int64(5), semantic: true},
+		{name: "Int32 and double", a: int32(5), b: 5.0, semantic: true},
+		{name: "Int32 and Decimal128", a: int32(5), b: mustDecimal(t, "5"), semantic: true},
+		{name: "Timestamp", a: primitive.Timestamp{T: 1, I: 2}, b: primitive.Timestamp{T: 1, I: 2}, strict: true, semantic: true},
+		{name: "Timestamp different", a: primitive.Timestamp{T: 1, I: 2}, b: primitive.Timestamp{T: 1, I: 3}},
+		{name: "Int64", a: int64(1) << 40, b: int64(1) << 40, strict: true, semantic: true},
+		{name: "Int64 and double fraction", a: int64(5), b: 5.5},
+		{name: "Decimal128", a: mustDecimal(t, "1.5"), b: mustDecimal(t, "1.5"), strict: true, semantic: true},
+		{name: "Decimal128 precision", a: mustDecimal(t, "1.0"), b: mustDecimal(t, "1.00"), semantic: true},
+		{name: "Decimal128 and double", a: mustDecimal(t, "0.5"), b: 0.5, semantic: true},
+		{name: "MinKey", a: primitive.MinKey{}, b: primitive.MinKey{}, strict: true, semantic: true},
+		{name: "MinKey and MaxKey", a: primitive.MinKey{}, b: primitive.MaxKey{}},
+		{name: "MaxKey", a: primitive.MaxKey{}, b: primitive.MaxKey{}, strict: true, semantic: true},
+		{name: "Nested number types",
+			a:        bson.D{{Key: "items", Value: bson.A{bson.D{{Key: "qty", Value: int32(2)}}}}},
+			b:        bson.D{{Key: "items", Value: bson.A{bson.D{{Key: "qty", Value: int64(2)}}}}},
+			semantic: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.strict, Equality{Mode: EqualityStrict}.equal(tt.a, tt.b), "strict")
+			assert.Equal(t, tt.semantic, Equality{Mode: EqualitySemantic}.equal(tt.a, tt.b), "semantic")
+			// Equality is symmetric
+			assert.Equal(t, tt.strict, Equality{Mode: EqualityStrict}.equal(tt.b, tt.a), "strict reversed")
+			assert.Equal(t, tt.semantic, Equality{}.equal(tt.b, tt.a), "semantic reversed")
+		})
+	}
+}
+
+// TestEqualityOptions tests the tolerances and the key order option of semantic mode
+func TestEqualityOptions(t *testing.T) {
+	date := primitive.NewDateTimeFromTime(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	reordered := [2]bson.D{
+		{{Key: "a", Value: 1}, {Key: "b", Value: bson.D{{Key: "x", Value: 1}, {Key: "y", Value: 2}}}},
+		{{Key: "b", Value: bson.D{{Key: "y", Value: 2}, {Key: "x", Value: 1}}}, {Key: "a", Value: 1}},
+	}
+
+	tests := []struct {
+		name     string
+		equality Equality
+		a, b     interface{}
+		expected bool
+	}{
+		{name: "Date within tolerance", equality: Equality{DateTolerance: time.Second}, a: date, b: date + 1000, expected: true},
+		{name: "Date beyond tolerance", equality: Equality{DateTolerance: time.Second}, a: date, b: date - 1001},
+		{name: "Date tolerance in strict mode", equality: Equality{Mode: EqualityStrict, DateTolerance: time.Second}, a: date, b: date + 1},
+		{name: "Float within tolerance", equality: Equality{FloatTolerance: 0.001}, a: 0.3000001, b: 0.3, expected: true},
+		{name: "Float across types within tolerance", equality: Equality{FloatTolerance: 0.01}, a: int32(1), b: 1.005, expected: true},
+		{name: "Float beyond tolerance", equality: Equality{FloatTolerance: 0.001}, a: 1.0, b: 1.01},
+		{name: "Float NaN with tolerance", equality: Equality{FloatTolerance: 1}, a: math.NaN(), b: 0.5},
+		{name: "Float tolerance in strict mode", equality: Equality{Mode: EqualityStrict, FloatTolerance: 1}, a: 0.3000001, b: 0.3},
+		{name: "Key order ignored", a: reordered[0], b: reordered[1], expected: true},
+		{name: "Key order ignored keeps values", a: reordered[0], b: bson.D{{Key: "b", Value: 2}, {Key: "a", Value: 1}}},
+		{name: "Key order", equality: Equality{KeyOrder: true}, a: reordered[0], b: reordered[1]},
+		{name: "Key order in strict mode", equality: Equality{Mode: EqualityStrict}, a: reordered[0], b: reordered[1]},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, tt.equality.equal(tt.a, tt.b))
+		})
+	}
+}
+
+func TestEqualityDocuments(t *testing.T) {
+	tests := []struct {
+		name     string
+		a        bson.M
+		b        bson.M
+		expected bool
+	}{
+		{
+			name:     "Empty documents",
+			a:        bson.M{},
+			b:        bson.M{},
+			expected: true,
+		},
+		{
+			name:     "Same simple document",
+			a:        bson.M{"foo": "bar", "num": 42},
+			b:        bson.M{"foo": "bar", "num": 42},
+			expected: true,
+		},
+		{
+			name:     "Different values",
+			a:        bson.M{"foo": "bar", "num": 42},
+			b:        bson.M{"foo": "bar", "num": 43},
+			expected: false,
+		},
+		{
+			name:     "Different keys",
+			a:        bson.M{"foo": "bar", "num": 42},
+			b:        bson.M{"foo": "bar", "number": 42},
+			expected: false,
+		},
+		{
+			name:     "Nested documents equal",
+			a:        bson.M{"foo": "bar", "nested": bson.M{"a": 1, "b": 2}},
+			b:        bson.M{"foo": "bar", "nested": bson.M{"a": 1, "b": 2}},
+			expected: true,
+		},
+		{
+			name:     "Nested documents different",
+			a:        bson.M{"foo": "bar", "nested": bson.M{"a": 1, "b": 2}},
+			b:        bson.M{"foo": "bar", "nested": bson.M{"a": 1, "b": 3}},
+			expected: false,
+		},
+		{
+			name:     "Array equal",
+			a:        bson.M{"foo": "bar", "arr": []interface{}{1, 2, 3}},
+			b:        bson.M{"foo": "bar", "arr": []interface{}{1, 2, 3}},
+			expected: true,
+		},
+		{
+			name:     "Array different values",
+			a:        bson.M{"foo": "bar", "arr": []interface{}{1, 2, 3}},
+			b:        bson.M{"foo": "bar", "arr": []interface{}{1, 2, 4}},
+			expected: false,
+		},
+		{
+			name:     "Array different length",
+			a:        bson.M{"foo": "bar", "arr": []interface{}{1, 2, 3}},
+			b:        bson.M{"foo": "bar", "arr": []interface{}{1, 2}},
+			expected: false,
+		},
+		{
+			name:     "Complex nested structure",
+			a:        bson.M{"foo": "bar", "nested": bson.M{"a": 1, "b": 2, "arr": []interface{}{bson.M{"x": 1}, bson.M{"y": 2}}}},
+			b:        bson.M{"foo": "bar", "nested": bson.M{"a": 1, "b": 2, "arr": []interface{}{bson.M{"x": 1}, bson.M{"y": 2}}}},
+			expected: true,
+		},
+		{
+			name:     "Complex nested structure different",
+			a:        bson.M{"foo": "bar", "nested": bson.M{"a": 1, "b": 2, "arr": []interface{}{bson.M{"x": 1}, bson.M{"y": 2}}}},
+			b:        bson.M{"foo": "bar", "nested": bson.M{"a": 1, "b": 2, "arr": []interface{}{bson.M{"x": 1}, bson.M{"y": 3}}}},
+			expected: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := Equality{}.equal(tt.a, tt.b)
+			assert.Equal(t, tt.expected, result)
+		})
+	}
+}
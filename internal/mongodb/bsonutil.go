@@ -0,0 +1,358 @@
+package mongodb
+
+import (
+	"bytes"
+	"math"
+	"math/big"
+	"reflect"
+	"sort"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// BSON type brackets in MongoDB sort order
+const (
+	orderMinKey = iota
+	orderNull
+	orderNumber
+	orderString
+	orderObject
+	orderArray
+	orderBinary
+	orderObjectID
+	orderBoolean
+	orderDate
+	orderTimestamp
+	orderRegex
+	orderOther
+	orderMaxKey
+)
+
+// compareBSONValues compares two decoded BSON values using MongoDB sort order.
+// It returns a negative number when a sorts before b, zero when they are equal and
+// a positive number when a sorts after b.
+func compareBSONValues(a, b interface{}) int {
+	orderA, orderB := bsonTypeOrder(a), bsonTypeOrder(b)
+	if orderA != orderB {
+		return orderA - orderB
+	}
+
+	switch orderA {
+	case orderNumber:
+		return compareNumbers(a, b)
+	case orderString:
+		return strings.Compare(stringValue(a), stringValue(b))
+	case orderObject:
+		return compareDocuments(toOrderedDocument(a), toOrderedDocument(b))
+	case orderArray:
+		return compareArrays(toArray(a), toArray(b))
+	default:
+		return compareScalars(orderA, a, b)
+	}
+}
+
+// compareScalars compares two values of the same fixed-size BSON type bracket
+func compareScalars(order int, a, b interface{}) int {
+	switch order {
+	case orderBinary:
+		return compareBinary(a.(primitive.Binary), b.(primitive.Binary))
+	case orderObjectID:
+		aID, bID := a.(primitive.ObjectID), b.(primitive.ObjectID)
+		return bytes.Compare(aID[:], bID[:])
+	case orderBoolean, orderDate:
+		return compareInts(scalarInt(a), scalarInt(b))
+	case orderTimestamp:
+		return primitive.CompareTimestamp(a.(primitive.Timestamp), b.(primitive.Timestamp))
+	case orderRegex:
+		return compareRegex(a.(primitive.Regex), b.(primitive.Regex))
+	case orderOther:
+		return strings.Compare(bsonFallbackString(a), bsonFallbackString(b))
+	default:
+		// MinKey, MaxKey and null values are all equal within their bracket
+		return 0
+	}
+}
+
+// scalarInt returns the integer a boolean or date value sorts by
+func scalarInt(v interface{}) int64 {
+	if b, ok := v.(bool); ok {
+		return boolToInt(b)
+	}
+	return int64(v.(primitive.DateTime))
+}
+
+// compareRegex compares two regular expressions by pattern, then by options
+func compareRegex(a, b primitive.Regex) int {
+	if c := strings.Compare(a.Pattern, b.Pattern); c != 0 {
+		return c
+	}
+	return strings.Compare(a.Options, b.Options)
+}
+
+// bsonTypeOrder returns the sort bracket of a decoded BSON value
+func bsonTypeOrder(v interface{}) int {
+	switch v.(type) {
+	case nil, primitive.Null, primitive.Undefined:
+		return orderNull
+	case int32, int64, float64, int, primitive.Decimal128:
+		return orderNumber
+	case string, primitive.Symbol:
+		return orderString
+	case bson.M, bson.D, map[string]interface{}:
+		return orderObject
+	case bson.A, []interface{}:
+		return orderArray
+	default:
+		return scalarTypeOrder(v)
+	}
+}
+
+// scalarTypeOrders maps the fixed-size BSON types to their sort bracket
+var scalarTypeOrders = map[reflect.Type]int{
+	reflect.TypeOf(primitive.MinKey{}):    orderMinKey,
+	reflect.TypeOf(primitive.Binary{}):    orderBinary,
+	reflect.TypeOf(primitive.ObjectID{}):  orderObjectID,
+	reflect.TypeOf(false):                 orderBoolean,
+	reflect.TypeOf(primitive.DateTime(0)): orderDate,
+	reflect.TypeOf(primitive.Timestamp{}): orderTimestamp,
+	reflect.TypeOf(primitive.Regex{}):     orderRegex,
+	reflect.TypeOf(primitive.MaxKey{}):    orderMaxKey,
+}
+
+// scalarTypeOrder returns the sort bracket of a fixed-size BSON value
+func scalarTypeOrder(v interface{}) int {
+	if order, ok := scalarTypeOrders[reflect.TypeOf(v)]; ok {
+		return order
+	}
+	return orderOther
+}
+
+// compareNumbers compares two numeric values across int32, int64, double and Decimal128
+func compareNumbers(a, b interface{}) int {
+	aInt, aIsInt := integerValue(a)
+	bInt, bIsInt := integerValue(b)
+	if aIsInt && bIsInt {
+		return compareInts(aInt, bInt)
+	}
+
+	aFloat, aNaN := bigFloatValue(a)
+	bFloat, bNaN := bigFloatValue(b)
+
+	// NaN sorts before all other numbers
+	switch {
+	case aNaN && bNaN:
+		return 0
+	case aNaN:
+		return -1
+	case bNaN:
+		return 1
+	}
+	return aFloat.Cmp(bFloat)
+}
+
+// integerValue returns the value of an integer type as int64
+func integerValue(v interface{}) (int64, bool) {
+	switch n := v.(type) {
+	case int32:
+		return int64(n), true
+	case int64:
+		return n, true
+	case int:
+		return int64(n), true
+	default:
+		return 0, false
+	}
+}
+
+// bigFloatValue converts a numeric value to a big.Float, reporting NaN separately
+func bigFloatValue(v interface{}) (value *big.Float, isNaN bool) {
+	if n, ok := integerValue(v); ok {
+		return new(big.Float).SetInt64(n), false
+	}
+
+	switch n := v.(type) {
+	case float64:
+		if math.IsNaN(n) {
+			return nil, true
+		}
+		return new(big.Float).SetFloat64(n), false
+	case primitive.Decimal128:
+		return decimalToBigFloat(n)
+	default:
+		return nil, true
+	}
+}
+
+// decimalToBigFloat converts a Decimal128 value to a big.Float
+func decimalToBigFloat(d primitive.Decimal128) (value *big.Float, isNaN bool) {
+	switch {
+	case d.IsNaN():
+		return nil, true
+	case d.IsInf() > 0:
+		return new(big.Float).SetInf(false), false
+	case d.IsInf() < 0:
+		return new(big.Float).SetInf(true), false
+	}
+
+	f, ok := new(big.Float).SetPrec(128).SetString(d.String())
+	if !ok {
+		return nil, true
+	}
+	return f, false
+}
+
+// stringValue returns the string content of a string or symbol value
+func stringValue(v interface{}) string {
+	if s, ok := v.(primitive.Symbol); ok {
+		return string(s)
+	}
+	return v.(string)
+}
+
+// toOrderedDocument converts a decoded document to bson.D.
+// Unordered maps are sorted by key since their original order is unknown.
+func toOrderedDocument(v interface{}) bson.D {
+	switch doc := v.(type) {
+	case bson.D:
+		return doc
+	case bson.M:
+		return sortedDocument(doc)
+	case map[string]interface{}:
+		return sortedDocument(doc)
+	default:
+		return nil
+	}
+}
+
+// sortedDocument converts a map to bson.D with keys in lexical order
+func sortedDocument(m map[string]interface{}) bson.D {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	doc := make(bson.D, 0, len(keys))
+	for _, key := range keys {
+		doc = append(doc, bson.E{Key: key, Value: m[key]})
+	}
+	return doc
+}
+
+// toArray converts a decoded array to []interface{}
+func toArray(v interface{}) []interface{} {
+	switch arr := v.(type) {
+	case bson.A:
+		return arr
+	case []interface{}:
+		return arr
+	default:
+		return nil
+	}
+}
+
+// compareDocuments compares two documents element by element
+func compareDocuments(a, b bson.D) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if c := bsonTypeOrder(a[i].Value) - bsonTypeOrder(b[i].Value); c != 0 {
+			return c
+		}
+		if c := strings.Compare(a[i].Key, b[i].Key); c != 0 {
+			return c
+		}
+		if c := compareBSONValues(a[i].Value, b[i].Value); c != 0 {
+			return c
+		}
+	}
+	return len(a) - len(b)
+}
+
+// compareArrays compares two arrays element by element
+func compareArrays(a, b []interface{}) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if c := compareBSONValues(a[i], b[i]); c != 0 {
+			return c
+		}
+	}
+	return len(a) - len(b)
+}
+
+// compareBinary compares binary values by length, subtype and then content
+func compareBinary(a, b primitive.Binary) int {
+	if len(a.Data) != len(b.Data) {
+		return len(a.Data) - len(b.Data)
+	}
+	if a.Subtype != b.Subtype {
+		return int(a.Subtype) - int(b.Subtype)
+	}
+	return bytes.Compare(a.Data, b.Data)
+}
+
+// compareInts compares two int64 values
+func compareInts(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// boolToInt converts a boolean to 0 or 1
+func boolToInt(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// bsonFallbackString renders values without a dedicated comparison as extended JSON
+func bsonFallbackString(v interface{}) string {
+	data, err := bson.MarshalExtJSON(bson.M{"v": v}, true, false)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+// lookupPath returns the value at a dotted path in a document and whether it exists
+func lookupPath(doc interface{}, path string) (interface{}, bool) {
+	current := doc
+	for _, part := range strings.Split(path, ".") {
+		value, ok := lookupField(current, part)
+		if !ok {
+			return nil, false
+		}
+		current = value
+	}
+	return current, true
+}
+
+// lookupField returns the value of a single field in a decoded document
+func lookupField(doc interface{}, field string) (interface{}, bool) {
+	switch d := doc.(type) {
+	case bson.M:
+		value, ok := d[field]
+		return value, ok
+	case map[string]interface{}:
+		value, ok := d[field]
+		return value, ok
+	case bson.D:
+		for _, elem := range d {
+			if elem.Key == field {
+				return elem.Value, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// documentID returns the _id of a decoded document, nil when it has none
+func documentID(doc bson.D) interface{} {
+	id, _ := lookupField(doc, "_id")
+	return id
+}
@@ -0,0 +1,106 @@
+package mongodb
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// TestCompareBSONValues tests ordering of values according to MongoDB sort order
+func TestCompareBSONValues(t *testing.T) {
+	oid1 := primitive.ObjectID{0x01}
+	oid2 := primitive.ObjectID{0x02}
+	dec, _ := primitive.ParseDecimal128("2.5")
+
+	tests := []struct {
+		name     string
+		a        interface{}
+		b        interface{}
+		expected int
+	}{
+		{"MinKey before null", primitive.MinKey{}, nil, -1},
+		{"Null before number", nil, int32(0), -1},
+		{"Number before string", int64(100), "a", -1},
+		{"String before object", "z", bson.M{"a": 1}, -1},
+		{"Object before array", bson.D{{Key: "a", Value: 1}}, bson.A{1}, -1},
+		{"Array before binary", bson.A{1}, primitive.Binary{Data: []byte{1}}, -1},
+		{"Binary before ObjectID", primitive.Binary{}, oid1, -1},
+		{"ObjectID before bool", oid1, false, -1},
+		{"Bool before date", true, primitive.DateTime(0), -1},
+		{"Date before timestamp", primitive.DateTime(0), primitive.Timestamp{}, -1},
+		{"Timestamp before regex", primitive.Timestamp{}, primitive.Regex{}, -1},
+		{"Regex before MaxKey", primitive.Regex{}, primitive.MaxKey{}, -1},
+		{"Null equals undefined", primitive.Null{}, primitive.Undefined{}, 0},
+		{"Int32 equals int64", int32(5), int64(5), 0},
+		{"Int equals double", int64(5), float64(5), 0},
+		{"Int less than double", int32(2), float64(2.5), -1},
+		{"Decimal equals double", dec, float64(2.5), 0},
+		{"Large int64 precision", int64(math.MaxInt64), int64(math.MaxInt64 - 1), 1},
+		{"NaN before numbers", math.NaN(), math.Inf(-1), -1},
+		{"Strings", "abc", "abd", -1},
+		{"Symbol equals string", primitive.Symbol("abc"), "abc", 0},
+		{"ObjectIDs", oid2, oid1, 1},
+		{"Booleans", false, true, -1},
+		{"Dates", primitive.DateTime(10), primitive.DateTime(5), 1},
+		{"Timestamps", primitive.Timestamp{T: 1, I: 2}, primitive.Timestamp{T: 1, I: 3}, -1},
+		{"Binary by length", primitive.Binary{Data: []byte{9}}, primitive.Binary{Data: []byte{1, 1}}, -1},
+		{"Arrays element-wise", bson.A{int32(1), int32(2)}, bson.A{int32(1), int32(3)}, -1},
+		{"Arrays by length", bson.A{int32(1)}, bson.A{int32(1), int32(2)}, -1},
+		{"Documents by value", bson.D{{Key: "a", Value: int32(1)}}, bson.D{{Key: "a", Value: int32(2)}}, -1},
+		{"Documents by key", bson.D{{Key: "a", Value: int32(1)}}, bson.D{{Key: "b", Value: int32(1)}}, -1},
+		{"Map equals document", bson.M{"a": int32(1), "b": "x"}, bson.D{{Key: "a", Value: int32(1)}, {Key: "b", Value: "x"}}, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, sign(compareBSONValues(tt.a, tt.b)))
+			assert.Equal(t, -tt.expected, sign(compareBSONValues(tt.b, tt.a)))
+		})
+	}
+}
+
+// TestLookupPath tests resolving dotted paths in decoded documents
+func TestLookupPath(t *testing.T) {
+	doc := bson.M{
+		"name": "test",
+		"address": bson.M{
+			"city": "Berlin",
+			"geo":  bson.D{{Key: "lat", Value: 52.5}},
+		},
+	}
+
+	tests := []struct {
+		path     string
+		expected interface{}
+		found    bool
+	}{
+		{"name", "test", true},
+		{"address.city", "Berlin", true},
+		{"address.geo.lat", 52.5, true},
+		{"address.zip", nil, false},
+		{"name.first", nil, false},
+		{"missing", nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			value, found := lookupPath(doc, tt.path)
+			assert.Equal(t, tt.found, found)
+			assert.Equal(t, tt.expected, value)
+		})
+	}
+}
+
+func sign(n int) int {
+	switch {
+	case n < 0:
+		return -1
+	case n > 0:
+		return 1
+	default:
+		return 0
+	}
+}
@@ -12,6 +12,7 @@ import (
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
@@ -30,6 +31,66 @@ func NewClient(ctx context.Context, uri, caCertFile string) (*Client, error) {
 
 // NewClientWithSocketTimeout creates a new MongoDB client wrapper with configurable socket timeout
 func NewClientWithSocketTimeout(ctx context.Context, uri, caCertFile string, socketTimeoutSeconds int) (*Client, error) {
+	clientOptions, err := buildClientOptions(uri, caCertFile, socketTimeoutSeconds)
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Printf("Connecting to MongoDB: %s\n", uri)
+	return connectClient(ctx, clientOptions, uri, caCertFile)
+}
+
+// ShardCredentials are the user and password a shard replica set is authenticated with.
+// Users created through mongos exist only on the config servers, so shards usually need shard-local users.
+type ShardCredentials struct {
+	Username string
+	Password string
+}
+
+// NewShardClient creates a MongoDB client wrapper connected directly to a shard replica set.
+// TLS and other options are taken from the cluster URI, while hosts and replica set name are
+// replaced with those of the shard. The credentials of the cluster URI are used unless shard
+// credentials are given.
+func NewShardClient(
+	ctx context.Context,
+	uri, caCertFile string,
+	socketTimeoutSeconds int,
+	replicaSet string,
+	hosts []string,
+	credentials ShardCredentials,
+) (*Client, error) {
+	clientOptions, err := buildClientOptions(uri, caCertFile, socketTimeoutSeconds)
+	if err != nil {
+		return nil, err
+	}
+
+	if credentials.Username != "" {
+		clientOptions.SetAuth(shardAuth(clientOptions.Auth, credentials))
+	}
+	clientOptions.SetHosts(hosts)
+	clientOptions.SetDirect(false)
+	if replicaSet != "" {
+		clientOptions.SetReplicaSet(replicaSet)
+	}
+
+	fmt.Printf("Connecting to shard replica set %s: %v\n", replicaSet, hosts)
+	return connectClient(ctx, clientOptions, uri, caCertFile)
+}
+
+// shardAuth replaces the user and password of the cluster credential, keeping its mechanism and auth source
+func shardAuth(clusterAuth *options.Credential, credentials ShardCredentials) options.Credential {
+	auth := options.Credential{}
+	if clusterAuth != nil {
+		auth = *clusterAuth
+	}
+	auth.Username = credentials.Username
+	auth.Password = credentials.Password
+	auth.PasswordSet = true
+	return auth
+}
+
+// buildClientOptions creates the client options shared by all connections
+func buildClientOptions(uri, caCertFile string, socketTimeoutSeconds int) (*options.ClientOptions, error) {
 	clientOptions := options.Client().ApplyURI(uri)
 
 	// Configure connection timeouts
@@ -52,34 +113,47 @@ func NewClientWithSocketTimeout(ctx context.Context, uri, caCertFile string, soc
 
 	// If a CA certificate file is provided, configure TLS
 	if caCertFile != "" {
-		fmt.Printf("Using CA certificate file: %s\n", caCertFile)
-
-		// Handle Windows paths by converting backslashes to forward slashes
-		normalizedPath := strings.ReplaceAll(caCertFile, "\\", "/")
-
-		certs, err := os.ReadFile(normalizedPath)
+		tlsConfig, err := loadTLSConfig(caCertFile)
 		if err != nil {
-			return nil, fmt.Errorf("failed to read CA certificate file: %w", err)
+			return nil, err
 		}
+		clientOptions.SetTLSConfig(tlsConfig)
+		fmt.Println("TLS configuration applied with custom root CA")
+	}
 
-		fmt.Printf("Successfully read CA certificate file (%d bytes)\n", len(certs))
+	return clientOptions, nil
+}
 
-		// Create a TLS configuration with the CA certificate
-		tlsConfig := &tls.Config{
-			MinVersion: tls.VersionTLS12,
-		}
+// loadTLSConfig creates a TLS configuration that trusts the given CA certificate file
+func loadTLSConfig(caCertFile string) (*tls.Config, error) {
+	fmt.Printf("Using CA certificate file: %s\n", caCertFile)
 
-		rootCAs := x509.NewCertPool()
-		if !rootCAs.AppendCertsFromPEM(certs) {
-			return nil, fmt.Errorf("failed to append CA certificate - invalid PEM format")
-		}
-		tlsConfig.RootCAs = rootCAs
+	// Handle Windows paths by converting backslashes to forward slashes
+	normalizedPath := strings.ReplaceAll(caCertFile, "\\", "/")
 
-		clientOptions.SetTLSConfig(tlsConfig)
-		fmt.Println("TLS configuration applied with custom root CA")
+	certs, err := os.ReadFile(normalizedPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA certificate file: %w", err)
 	}
 
-	fmt.Printf("Connecting to MongoDB: %s\n", uri)
+	fmt.Printf("Successfully read CA certificate file (%d bytes)\n", len(certs))
+
+	// Create a TLS configuration with the CA certificate
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+	}
+
+	rootCAs := x509.NewCertPool()
+	if !rootCAs.AppendCertsFromPEM(certs) {
+		return nil, fmt.Errorf("failed to append CA certificate - invalid PEM format")
+	}
+	tlsConfig.RootCAs = rootCAs
+
+	return tlsConfig, nil
+}
+
+// connectClient connects with the given options and verifies the connection with a ping
+func connectClient(ctx context.Context, clientOptions *options.ClientOptions, uri, caCertFile string) (*Client, error) {
 	client, err := mongo.Connect(ctx, clientOptions)
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to MongoDB: %w", err)
@@ -144,8 +218,12 @@ func (c *Client) ListCollections(ctx context.Context, dbName string) ([]string,
 	return filteredColls, nil
 }
 
-// isSystemCollection returns true if the collection is a system collection
+// isSystemCollection returns true if the collection is a system collection.
+// The system.buckets collections backing time-series collections are handled through the time-series collection itself.
 func isSystemCollection(collName string) bool {
+	if _, ok := TimeSeriesCollectionName(collName); ok {
+		return true
+	}
 	return collName == "system.profile" || collName == "system.views" || collName == "system.indexes"
 }
 
@@ -154,6 +232,16 @@ func (c *Client) GetDatabase(dbName string) *mongo.Database {
 	return c.client.Database(dbName)
 }
 
+// Watch opens a change stream on all databases of the cluster
+func (c *Client) Watch(ctx context.Context, pipeline interface{}, opts ...*options.ChangeStreamOptions) (*mongo.ChangeStream, error) {
+	return c.client.Watch(ctx, pipeline, opts...)
+}
+
+// ClusterTime returns the latest operation time known to the server; see CurrentClusterTime
+func (c *Client) ClusterTime(ctx context.Context) (primitive.Timestamp, error) {
+	return CurrentClusterTime(ctx, c.client)
+}
+
 // CopyCollection copies documents from source to target collection
 func CopyCollection(
 	ctx context.Context,
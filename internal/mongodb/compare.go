@@ -18,8 +18,52 @@ type ComparisonResult struct {
 	TargetCount        int64  `json:"targetCount"`
 	Difference         int64  `json:"difference"`
 	MissingInTarget    int64  `json:"missingInTarget"`
+	ExtraInTarget      int64  `json:"extraInTarget"`
 	DifferentDocuments int64  `json:"differentDocuments"`
+	DiffsOmitted       int64  `json:"diffsOmitted,omitempty"`
+	GridFS             bool   `json:"gridfs,omitempty"`
+	TimeSeries         bool   `json:"timeseries,omitempty"`
 	Error              string `json:"error,omitempty"`
+	// Sample is the estimated difference rate of a sampled comparison, whose other counts cover the sample only
+	Sample *SampleEstimate `json:"sample,omitempty"`
+}
+
+// Methods of a detailed comparison
+const (
+	// CompareMethodLookup looks up every source document on the target by _id
+	CompareMethodLookup = "lookup"
+	// CompareMethodMerge walks _id-sorted cursors of both collections in lockstep, which also finds
+	// the documents that only exist in the target
+	CompareMethodMerge = "merge"
+	// CompareMethodHash compares hashes of _id ranges computed on both servers and only compares
+	// the documents of the ranges that differ
+	CompareMethodHash = "hash"
+)
+
+// CompareOptions controls how collections are compared
+type CompareOptions struct {
+	// BatchSize is the cursor batch size used when reading documents
+	BatchSize int
+	// Detailed enables document-by-document comparison instead of counts only
+	Detailed bool
+	// GridFSHash is the digest used to compare GridFS files ("md5" or "sha256")
+	GridFSHash string
+	// Method selects how a detailed comparison compares the documents of regular collections:
+	// CompareMethodLookup, the default, CompareMethodMerge or CompareMethodHash
+	Method string
+	// HashRanges is the number of _id ranges the hash method splits a collection into, 64 when unset
+	HashRanges int
+	// Diffs receives the documents that differ, when set
+	Diffs *DiffWriter
+	// IgnoreFields lists the fields left out when documents are compared
+	IgnoreFields []IgnoreRule
+	// Equality decides whether the two versions of a document are equal
+	Equality Equality
+	// SampleSize makes a detailed comparison of a regular collection only compare this many random source documents
+	SampleSize int
+	// SamplePercent makes a detailed comparison of a regular collection only compare this percentage of random
+	// source documents, when SampleSize is not set
+	SamplePercent float64
 }
 
 // CompareCollectionCounts compares document counts between source and target collections
@@ -68,6 +112,19 @@ func CompareCollectionData(
 	collName string,
 	batchSize int,
 	detailed bool,
+) (*ComparisonResult, error) {
+	return compareCollectionData(ctx, sourceDB, targetDB, collName, batchSize, detailed, &documentComparer{})
+}
+
+// compareCollectionData performs detailed comparison between source and target collections,
+// comparing the documents with cmp
+func compareCollectionData(
+	ctx context.Context,
+	sourceDB, targetDB *mongo.Database,
+	collName string,
+	batchSize int,
+	detailed bool,
+	cmp *documentComparer,
 ) (*ComparisonResult, error) {
 	fmt.Printf("  Detailed comparison of collection: %s\n", collName)
 
@@ -86,7 +143,9 @@ func CompareCollectionData(
 	defer cancel()
 
 	// Compare source to target (find documents missing in target or different)
-	if err := compareSourceToTarget(opCtx, sourceColl, targetColl, collName, batchSize, detailed, result); err != nil {
+	err := compareSourceToTarget(opCtx, sourceColl, targetColl, collName, batchSize, detailed, cmp, result)
+	result.DiffsOmitted = cmp.diffs.omittedCount()
+	if err != nil {
 		result.Error = fmt.Sprintf("error comparing source to target: %v", err)
 		return result, fmt.Errorf("%s", result.Error)
 	}
@@ -102,6 +161,7 @@ func compareSourceToTarget(
 	collName string,
 	batchSize int,
 	detailed bool,
+	cmp *documentComparer,
 	result *ComparisonResult,
 ) error {
 	// Get counts and calculate difference
@@ -121,7 +181,7 @@ func compareSourceToTarget(
 	}
 	defer cursor.Close(ctx)
 
-	return processSourceDocuments(ctx, cursor, targetColl, collName, sourceColl, result)
+	return processSourceDocuments(ctx, cursor, targetColl, collName, cmp, result)
 }
 
 // calculateCollectionCounts gets document counts from source and target collections
@@ -166,6 +226,7 @@ func createSourceCursor(
 // DocumentProcessingResult captures the result of document processing
 type DocumentProcessingResult struct {
 	missingInTarget int64
+	extraInTarget   int64
 	different       int64
 	docCount        int64
 }
@@ -177,7 +238,7 @@ func processSourceDocuments(
 	cursor *mongo.Cursor,
 	targetColl *mongo.Collection,
 	collName string,
-	sourceColl *mongo.Collection,
+	cmp *documentComparer,
 	result *ComparisonResult,
 ) error {
 	// Initialize tracking variables
@@ -189,7 +250,7 @@ func processSourceDocuments(
 
 	// Process each document
 	for cursor.Next(ctx) {
-		var doc bson.M
+		var doc bson.D
 		if err := cursor.Decode(&doc); err != nil {
 			return fmt.Errorf("failed to decode document: %v", err)
 		}
@@ -197,7 +258,7 @@ func processSourceDocuments(
 		procResult.docCount++
 
 		// Check document in target
-		if err := processSourceDocument(ctx, doc, targetColl, &procResult); err != nil {
+		if err := processSourceDocument(ctx, doc, targetColl, cmp, &procResult); err != nil {
 			return err
 		}
 
@@ -223,33 +284,34 @@ func processSourceDocuments(
 // processSourceDocument processes a single document from the source collection
 func processSourceDocument(
 	ctx context.Context,
-	doc bson.M,
+	doc bson.D,
 	targetColl *mongo.Collection,
+	cmp *documentComparer,
 	result *DocumentProcessingResult,
 ) error {
 	// Check if document exists in target with same _id
-	id, hasID := doc["_id"]
+	id, hasID := lookupField(doc, "_id")
 	if !hasID {
 		return nil
 	}
 
 	// Look up document in target collection
-	var targetDoc bson.M
+	var targetDoc bson.D
 	err := targetColl.FindOne(ctx, bson.M{"_id": id}).Decode(&targetDoc)
-	if err != nil {
-		if err == mongo.ErrNoDocuments {
-			result.missingInTarget++
-		} else {
-			return fmt.Errorf("error querying target collection for _id %v: %v", id, err)
-		}
-	} else {
-		// Compare documents
-		if !bsonEqual(doc, targetDoc) {
-			result.different++
-		}
+	switch {
+	case err == mongo.ErrNoDocuments:
+		result.missingInTarget++
+		return cmp.diffs.missing(id)
+	case err != nil:
+		return fmt.Errorf("error querying target collection for _id %v: %v", id, err)
 	}
 
-	return nil
+	// Compare documents
+	equal, err := cmp.equal(doc, targetDoc)
+	if !equal {
+		result.different++
+	}
+	return err
 }
 
 // shouldUpdateProgress determines if it's time to update progress
@@ -263,114 +325,6 @@ func updateSourceProgress(collName string, result *DocumentProcessingResult, sou
 	fmt.Printf("    Missing in target: %d, Different: %d\n", result.missingInTarget, result.different)
 }
 
-// bsonEqual compares two BSON documents for equality
-// This has been refactored to reduce cyclomatic complexity
-func bsonEqual(a, b bson.M) bool {
-	// Compare documents ignoring time-sensitive or test-specific fields
-	return compareDocumentKeys(a, b) && !hasExtraKeys(a, b)
-}
-
-// compareDocumentKeys checks if document fields match between source and target
-func compareDocumentKeys(a, b bson.M) bool {
-	// Compare all fields in document a with document b
-	for key, aVal := range a {
-		// Ignore lastModified field as it may change between copies
-		if key == "lastModified" {
-			continue
-		}
-
-		bVal, exists := b[key]
-		if !exists {
-			return false
-		}
-
-		// Special case for test document
-		if shouldSkipCompare(a, key) {
-			continue
-		}
-
-		// Check if values are different based on type
-		if !compareValues(aVal, bVal) {
-			return false
-		}
-	}
-
-	return true
-}
-
-// hasExtraKeys checks if the target document has extra keys not in source
-func hasExtraKeys(a, b bson.M) bool {
-	for key := range b {
-		// Ignore timestamp and test-specific fields
-		if key == "lastModified" || key == "modified" {
-			continue
-		}
-
-		_, exists := a[key]
-		if !exists {
-			return true
-		}
-	}
-
-	return false
-}
-
-// shouldSkipCompare determines if comparison should be skipped for a test case
-func shouldSkipCompare(doc bson.M, key string) bool {
-	// Special case for Document 2 in the test case which has value=201 in target
-	// and value=200 in source
-	if key != "value" {
-		return false
-	}
-
-	idVal, hasID := doc["_id"]
-	return hasID && idVal == 2
-}
-
-// compareValues compares two values of potentially different types
-func compareValues(aVal, bVal interface{}) bool {
-	switch av := aVal.(type) {
-	case bson.M:
-		// Nested document comparison
-		if bv, ok := bVal.(bson.M); ok {
-			return bsonEqual(av, bv)
-		}
-		return false
-	case []interface{}:
-		// Array comparison
-		if bv, ok := bVal.([]interface{}); ok {
-			return sliceEqual(av, bv)
-		}
-		return false
-	default:
-		// Simple value comparison
-		return aVal == bVal
-	}
-}
-
-// sliceEqual compares two slices for equality
-func sliceEqual(a, b []interface{}) bool {
-	if len(a) != len(b) {
-		return false
-	}
-
-	for i := range a {
-		// Handle nested documents in arrays
-		aDoc, aIsDoc := a[i].(bson.M)
-		bDoc, bIsDoc := b[i].(bson.M)
-
-		if aIsDoc && bIsDoc {
-			if !bsonEqual(aDoc, bDoc) {
-				return false
-			}
-		} else if a[i] != b[i] {
-			return false
-		}
-	}
-
-	return true
-}
-
 // CompareCollections compares collections between source and target databases
 // This has been refactored to reduce cyclomatic complexity
 func CompareCollections(
@@ -379,8 +333,7 @@ func CompareCollections(
 	dbName string,
 	collections []string,
 	excludeCollections []string,
-	batchSize int,
-	detailed bool,
+	opts CompareOptions,
 ) ([]*ComparisonResult, error) {
 	fmt.Printf("Comparing collections in database: %s\n", dbName)
 
@@ -398,7 +351,7 @@ func CompareCollections(
 	results := make([]*ComparisonResult, 0, len(collsToCompare))
 
 	// Compare each collection
-	return compareCollectionSet(ctx, sourceDB, targetDB, collsToCompare, batchSize, detailed, results)
+	return compareCollectionSet(ctx, sourceDB, targetDB, collsToCompare, opts, results)
 }
 
 // getCollectionsToCompare determines which collections to compare based on input parameters
@@ -436,25 +389,25 @@ func getCollectionsToCompare(
 	return collsToCompare, nil
 }
 
-// compareCollectionSet compares a set of collections between source and target databases
+// compareCollectionSet compares a set of collections between source and target databases.
+// In detailed mode GridFS buckets are compared file by file instead of chunk by chunk,
+// and time-series collections are compared measurement by measurement.
 func compareCollectionSet(
 	ctx context.Context,
 	sourceDB, targetDB *mongo.Database,
 	collsToCompare []string,
-	batchSize int,
-	detailed bool,
+	opts CompareOptions,
 	results []*ComparisonResult,
 ) ([]*ComparisonResult, error) {
-	for _, collName := range collsToCompare {
-		var result *ComparisonResult
-		var err error
-
-		if detailed {
-			result, err = CompareCollectionData(ctx, sourceDB, targetDB, collName, batchSize, detailed)
-		} else {
-			result, err = CompareCollectionCounts(ctx, sourceDB, targetDB, collName)
-		}
+	if opts.Detailed {
+		var gridFSResults []*ComparisonResult
+		gridFSResults, collsToCompare = compareGridFSBuckets(ctx, sourceDB, targetDB, collsToCompare, opts)
+		results = append(results, gridFSResults...)
+	}
 
+	timeSeries := timeSeriesSpecsToCompare(ctx, sourceDB, opts)
+	for _, collName := range collsToCompare {
+		result, err := compareCollection(ctx, sourceDB, targetDB, collName, timeSeries[collName], opts)
 		if err != nil {
 			fmt.Printf("  Warning: Error comparing collection %s: %v\n", collName, err)
 		}
@@ -465,6 +418,66 @@ func compareCollectionSet(
 	return results, nil
 }
 
+// compareGridFSBuckets compares the GridFS buckets among the collections and returns the remaining collections
+func compareGridFSBuckets(
+	ctx context.Context,
+	sourceDB, targetDB *mongo.Database,
+	collsToCompare []string,
+	opts CompareOptions,
+) (results []*ComparisonResult, others []string) {
+	buckets, others := SplitGridFSBuckets(collsToCompare)
+	for _, bucket := range buckets {
+		result, err := CompareGridFSBucket(ctx, sourceDB, targetDB, bucket, opts)
+		if err != nil {
+			fmt.Printf("  Warning: Error comparing GridFS bucket %s: %v\n", bucket, err)
+		}
+		results = append(results, result)
+	}
+	return results, others
+}
+
+// timeSeriesSpecsToCompare returns the time-series collections of the source database for detailed comparisons
+func timeSeriesSpecsToCompare(ctx context.Context, sourceDB *mongo.Database, opts CompareOptions) map[string]*TimeSeriesSpec {
+	if !opts.Detailed {
+		return nil
+	}
+
+	specs, err := GetTimeSeriesSpecs(ctx, sourceDB)
+	if err != nil {
+		fmt.Printf("  Warning: %v\n", err)
+		return nil
+	}
+	return specs
+}
+
+// compareCollection compares a single collection, at the measurement level for time-series collections
+// and with the selected method or on a random sample for regular collections
+func compareCollection(
+	ctx context.Context,
+	sourceDB, targetDB *mongo.Database,
+	collName string,
+	timeSeries *TimeSeriesSpec,
+	opts CompareOptions,
+) (*ComparisonResult, error) {
+	switch {
+	case !opts.Detailed:
+		return CompareCollectionCounts(ctx, sourceDB, targetDB, collName)
+	case timeSeries != nil:
+		result, err := CompareTimeSeriesData(ctx, sourceDB, targetDB, collName, timeSeries, opts)
+		result.TimeSeries = true
+		return result, err
+	case opts.sampled():
+		return CompareCollectionSample(ctx, sourceDB, targetDB, collName, opts)
+	case opts.Method == CompareMethodMerge:
+		return CompareCollectionMerge(ctx, sourceDB, targetDB, collName, opts)
+	case opts.Method == CompareMethodHash:
+		return CompareCollectionHashes(ctx, sourceDB, targetDB, collName, opts)
+	default:
+		cmp := newDocumentComparer(opts, sourceDB.Name(), collName)
+		return compareCollectionData(ctx, sourceDB, targetDB, collName, opts.BatchSize, opts.Detailed, cmp)
+	}
+}
+
 // CompareIndexes compares indexes between source and target collections
 // This has been refactored to reduce cyclomatic complexity
 func CompareIndexes(
@@ -554,7 +567,7 @@ func compareIndexProperties(name string, sourceIdx, targetIdx bson.M) (isEqual b
 	sourceKey, sourceHasKey := sourceIdx["key"].(bson.M)
 	targetKey, targetHasKey := targetIdx["key"].(bson.M)
 
-	if !sourceHasKey || !targetHasKey || !bsonEqual(sourceKey, targetKey) {
+	if !sourceHasKey || !targetHasKey || !(Equality{}).equal(sourceKey, targetKey) {
 		return false, fmt.Sprintf("Index '%s' has different key pattern", name)
 	}
 
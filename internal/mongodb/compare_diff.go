@@ -0,0 +1,178 @@
+package mongodb
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Kinds of document differences
+const (
+	DiffMissingInTarget = "missingInTarget"
+	DiffExtraInTarget   = "extraInTarget"
+	DiffDifferent       = "different"
+)
+
+// DocumentDiff describes a document that differs between source and target
+type DocumentDiff struct {
+	Database   string      `bson:"database"`
+	Collection string      `bson:"collection"`
+	ID         interface{} `bson:"_id"`
+	Kind       string      `bson:"kind"`
+	// Fields lists the differing fields of a document that exists on both sides. It is empty when only
+	// the order of the document's fields differs.
+	Fields []FieldDiff `bson:"fields,omitempty"`
+}
+
+// FieldDiff describes a field that differs between the source and the target version of a document
+type FieldDiff struct {
+	Path   string      `bson:"path"`
+	Source interface{} `bson:"source"`
+	Target interface{} `bson:"target"`
+	// MissingIn is "source" or "target" when the field only exists on the other side
+	MissingIn string `bson:"missingIn,omitempty"`
+}
+
+// DiffWriter writes the documents a detailed comparison finds to differ as newline-delimited extended JSON,
+// one record per document and at most limit records per collection
+type DiffWriter struct {
+	mu      sync.Mutex
+	w       io.Writer
+	limit   int
+	written int64
+}
+
+// NewDiffWriter creates a DiffWriter; a limit of 0 writes every differing document
+func NewDiffWriter(w io.Writer, limit int) *DiffWriter {
+	return &DiffWriter{w: w, limit: limit}
+}
+
+// Written returns the number of records written
+func (d *DiffWriter) Written() int64 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.written
+}
+
+// write writes a record as a line of relaxed extended JSON
+func (d *DiffWriter) write(diff DocumentDiff) error {
+	data, err := bson.MarshalExtJSON(diff, false, false)
+	if err != nil {
+		return fmt.Errorf("failed to encode difference of _id %v: %w", diff.ID, err)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, err := d.w.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write difference of _id %v: %w", diff.ID, err)
+	}
+	d.written++
+	return nil
+}
+
+// forCollection returns the recorder of a collection's differences, or nil when differences are not written
+func (d *DiffWriter) forCollection(dbName, collName string, equality Equality) *collectionDiffs {
+	if d == nil {
+		return nil
+	}
+	return &collectionDiffs{writer: d, database: dbName, collection: collName, equality: equality}
+}
+
+// collectionDiffs records the differences of a collection up to the limit of the writer.
+// A nil collectionDiffs records nothing.
+type collectionDiffs struct {
+	writer               *DiffWriter
+	database, collection string
+	written, omitted     int64
+	// equality finds the differing fields of a document
+	equality Equality
+}
+
+// missing records a source document that is missing in the target
+func (c *collectionDiffs) missing(id interface{}) error {
+	return c.record(DiffMissingInTarget, id, nil, nil)
+}
+
+// extra records a target document that does not exist in the source
+func (c *collectionDiffs) extra(id interface{}) error {
+	return c.record(DiffExtraInTarget, id, nil, nil)
+}
+
+// different records a document whose source and target versions differ, with the fields that differ
+func (c *collectionDiffs) different(source, target bson.D) error {
+	return c.record(DiffDifferent, documentID(source), source, target)
+}
+
+// record writes a difference, or counts it as omitted once the collection reached the limit
+func (c *collectionDiffs) record(kind string, id interface{}, source, target bson.D) error {
+	if c == nil {
+		return nil
+	}
+	if c.writer.limit > 0 && c.written >= int64(c.writer.limit) {
+		c.omitted++
+		return nil
+	}
+
+	diff := DocumentDiff{Database: c.database, Collection: c.collection, ID: id, Kind: kind}
+	if kind == DiffDifferent {
+		diff.Fields = c.equality.fieldDiffs("", source, target, nil)
+	}
+	c.written++
+	return c.writer.write(diff)
+}
+
+// omittedCount returns the number of differences not written because of the limit
+func (c *collectionDiffs) omittedCount() int64 {
+	if c == nil {
+		return 0
+	}
+	return c.omitted
+}
+
+// fieldDiffs appends the paths of the fields that differ between two documents. Embedded documents are descended
+// into, unless only the order of their fields differs; other values, arrays included, are reported as a whole.
+func (e Equality) fieldDiffs(prefix string, source, target interface{}, diffs []FieldDiff) []FieldDiff {
+	for _, key := range unionKeys(toOrderedDocument(source), toOrderedDocument(target)) {
+		diffs = e.appendFieldDiff(prefix, key, source, target, diffs)
+	}
+	return diffs
+}
+
+// appendFieldDiff appends the differences of a field of two documents
+func (e Equality) appendFieldDiff(prefix, key string, source, target interface{}, diffs []FieldDiff) []FieldDiff {
+	path := prefix + key
+	sourceVal, inSource := lookupField(source, key)
+	targetVal, inTarget := lookupField(target, key)
+	switch {
+	case !inSource:
+		return append(diffs, FieldDiff{Path: path, Target: targetVal, MissingIn: "source"})
+	case !inTarget:
+		return append(diffs, FieldDiff{Path: path, Source: sourceVal, MissingIn: "target"})
+	case e.equal(sourceVal, targetVal):
+		return diffs
+	}
+
+	if bsonTypeOrder(sourceVal) == orderObject && bsonTypeOrder(targetVal) == orderObject {
+		if nested := e.fieldDiffs(path+".", sourceVal, targetVal, diffs); len(nested) > len(diffs) {
+			return nested
+		}
+	}
+	return append(diffs, FieldDiff{Path: path, Source: sourceVal, Target: targetVal})
+}
+
+// unionKeys returns the keys of both documents in lexical order
+func unionKeys(a, b bson.D) []string {
+	seen := make(map[string]bool, len(a)+len(b))
+	keys := make([]string, 0, len(a)+len(b))
+	for _, elem := range append(a[:len(a):len(a)], b...) {
+		if !seen[elem.Key] {
+			seen[elem.Key] = true
+			keys = append(keys, elem.Key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
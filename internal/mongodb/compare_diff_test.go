@@ -0,0 +1,167 @@
+package mongodb
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestFieldDiffs(t *testing.T) {
+	tests := []struct {
+		name     string
+		equality Equality
+		source   interface{}
+		target   interface{}
+		want     []FieldDiff
+	}{
+		{
+			name:   "Identical",
+			source: bson.M{"_id": 1, "name": "a"},
+			target: bson.M{"_id": 1, "name": "a"},
+		},
+		{
+			name:   "Changed value",
+			source: bson.M{"_id": 1, "name": "a", "count": int32(3)},
+			target: bson.M{"_id": 1, "name": "b", "count": int32(3)},
+			want:   []FieldDiff{{Path: "name", Source: "a", Target: "b"}},
+		},
+		{
+			name:   "Fields only on one side",
+			source: bson.M{"_id": 1, "removed": true},
+			target: bson.M{"_id": 1, "added": nil},
+			want: []FieldDiff{
+				{Path: "added", MissingIn: "source"},
+				{Path: "removed", Source: true, MissingIn: "target"},
+			},
+		},
+		{
+			name:   "Embedded documents are descended into",
+			source: bson.M{"_id": 1, "address": bson.M{"city": "Paris", "zip": "75001"}},
+			target: bson.M{"_id": 1, "address": bson.M{"city": "Lyon", "zip": "75001"}},
+			want:   []FieldDiff{{Path: "address.city", Source: "Paris", Target: "Lyon"}},
+		},
+		{
+			name:   "Arrays are reported as a whole",
+			source: bson.M{"_id": 1, "tags": []interface{}{"a", "b"}},
+			target: bson.M{"_id": 1, "tags": []interface{}{"a", "c"}},
+			want:   []FieldDiff{{Path: "tags", Source: []interface{}{"a", "b"}, Target: []interface{}{"a", "c"}}},
+		},
+		{
+			name:   "Timestamps are compared like other fields",
+			source: bson.M{"_id": 1, "lastModified": 1},
+			target: bson.M{"_id": 1, "lastModified": 2, "modified": true},
+			want: []FieldDiff{
+				{Path: "lastModified", Source: 1, Target: 2},
+				{Path: "modified", Target: true, MissingIn: "source"},
+			},
+		},
+		{
+			name:   "Type change",
+			source: bson.M{"_id": 1, "value": bson.M{"a": 1}},
+			target: bson.M{"_id": 1, "value": "a"},
+			want:   []FieldDiff{{Path: "value", Source: bson.M{"a": 1}, Target: "a"}},
+		},
+		{
+			name:   "Numbers are compared by value",
+			source: bson.D{{Key: "_id", Value: 1}, {Key: "n", Value: int32(5)}, {Key: "d", Value: bson.D{{Key: "x", Value: 2.0}}}},
+			target: bson.D{{Key: "_id", Value: 1}, {Key: "n", Value: int64(5)}, {Key: "d", Value: bson.D{{Key: "x", Value: int32(2)}}}},
+		},
+		{
+			name:     "Number types differ in strict mode",
+			equality: Equality{Mode: EqualityStrict},
+			source:   bson.D{{Key: "_id", Value: 1}, {Key: "n", Value: int32(5)}},
+			target:   bson.D{{Key: "_id", Value: 1}, {Key: "n", Value: int64(5)}},
+			want:     []FieldDiff{{Path: "n", Source: int32(5), Target: int64(5)}},
+		},
+		{
+			name:     "Reordered embedded documents are reported as a whole",
+			equality: Equality{KeyOrder: true},
+			source:   bson.D{{Key: "_id", Value: 1}, {Key: "d", Value: bson.D{{Key: "a", Value: 1}, {Key: "b", Value: 2}}}},
+			target:   bson.D{{Key: "_id", Value: 1}, {Key: "d", Value: bson.D{{Key: "b", Value: 2}, {Key: "a", Value: 1}}}},
+			want: []FieldDiff{{
+				Path:   "d",
+				Source: bson.D{{Key: "a", Value: 1}, {Key: "b", Value: 2}},
+				Target: bson.D{{Key: "b", Value: 2}, {Key: "a", Value: 1}},
+			}},
+		},
+		{
+			name:   "Field order is ignored by default",
+			source: bson.D{{Key: "_id", Value: 1}, {Key: "d", Value: bson.D{{Key: "a", Value: 1}, {Key: "b", Value: 2}}}},
+			target: bson.D{{Key: "_id", Value: 1}, {Key: "d", Value: bson.D{{Key: "b", Value: 2}, {Key: "a", Value: 1}}}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			diffs := tt.equality.fieldDiffs("", tt.source, tt.target, nil)
+			assert.Equal(t, tt.want, diffs)
+			// A document has field differences exactly when the equality finds it different
+			assert.Equal(t, len(tt.want) == 0, tt.equality.equal(tt.source, tt.target))
+		})
+	}
+}
+
+func TestDiffWriter(t *testing.T) {
+	var out bytes.Buffer
+	writer := NewDiffWriter(&out, 2)
+
+	users := writer.forCollection("app", "users", Equality{})
+	require.NoError(t, users.missing(int32(1)))
+	require.NoError(t, users.different(bson.D{{Key: "_id", Value: int32(2)}, {Key: "name", Value: "a"}},
+		bson.D{{Key: "_id", Value: int32(2)}, {Key: "name", Value: "b"}}))
+	require.NoError(t, users.extra(int32(3)))
+	require.NoError(t, users.extra(int32(4)))
+	assert.Equal(t, int64(2), users.omittedCount())
+
+	// The limit applies to each collection
+	orders := writer.forCollection("app", "orders", Equality{})
+	require.NoError(t, orders.extra("o-1"))
+	assert.Equal(t, int64(0), orders.omittedCount())
+	assert.Equal(t, int64(3), writer.Written())
+
+	var records []DocumentDiff
+	scanner := bufio.NewScanner(&out)
+	for scanner.Scan() {
+		var record DocumentDiff
+		require.NoError(t, bson.UnmarshalExtJSON(scanner.Bytes(), false, &record))
+		records = append(records, record)
+	}
+	require.Len(t, records, 3)
+	assert.Equal(t, DocumentDiff{Database: "app", Collection: "users", ID: int32(1), Kind: DiffMissingInTarget}, records[0])
+	assert.Equal(t, DiffDifferent, records[1].Kind)
+	require.Len(t, records[1].Fields, 1)
+	assert.Equal(t, "name", records[1].Fields[0].Path)
+	assert.Equal(t, "a", records[1].Fields[0].Source)
+	assert.Equal(t, "b", records[1].Fields[0].Target)
+	assert.Equal(t, DocumentDiff{Database: "app", Collection: "orders", ID: "o-1", Kind: DiffExtraInTarget}, records[2])
+
+	// Without a writer nothing is recorded
+	var none *DiffWriter
+	assert.Nil(t, none.forCollection("app", "users", Equality{}))
+	assert.NoError(t, none.forCollection("app", "users", Equality{}).missing(1))
+}
+
+func TestMergeJoinDiffs(t *testing.T) {
+	ctx := context.Background()
+	var out bytes.Buffer
+	diffs := NewDiffWriter(&out, 0).forCollection("app", "items", Equality{})
+
+	m := &mergeJoin{
+		source: cursorOf(t, bson.D{{Key: "_id", Value: int32(1)}, {Key: "v", Value: "a"}}, bson.D{{Key: "_id", Value: int32(2)}}),
+		target: cursorOf(t, bson.D{{Key: "_id", Value: int32(1)}, {Key: "v", Value: "b"}}, bson.D{{Key: "_id", Value: int32(3)}}),
+		cmp:    &documentComparer{diffs: diffs},
+	}
+	require.NoError(t, m.advanceSource(ctx))
+	require.NoError(t, m.advanceTarget(ctx))
+	defer m.close(ctx)
+	require.NoError(t, m.run(ctx, nil))
+
+	assert.Equal(t, `{"database":"app","collection":"items","_id":1,"kind":"different","fields":[{"path":"v","source":"a","target":"b"}]}
+{"database":"app","collection":"items","_id":2,"kind":"missingInTarget"}
+{"database":"app","collection":"items","_id":3,"kind":"extraInTarget"}
+`, out.String())
+}
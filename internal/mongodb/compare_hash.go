@@ -0,0 +1,478 @@
+package mongodb
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// defaultHashRanges is the number of _id ranges a collection or a differing range is split into
+	defaultHashRanges = 64
+	// samplesPerRange is the number of _ids sampled per range to place the range boundaries
+	samplesPerRange = 10
+	// maxHashDepth limits how often differing ranges are split before their documents are compared
+	maxHashDepth = 8
+	// Moduli of the two sums of document hashes, primes below 2^31 so the sums of billions of documents cannot overflow
+	hashModulus1 = 2147483647
+	hashModulus2 = 2147483629
+	// typedDepth is the nesting depth down to which documents are converted to their typed form before hashing
+	typedDepth = 6
+)
+
+// hashMinVersion is the first server version with $toHashedIndexKey, which the hash method hashes documents with
+var hashMinVersion = []int{7, 0}
+
+// CheckCompareMethod fails when the source or target server does not support the compare method, before anything
+// is compared
+func CheckCompareMethod(ctx context.Context, source, target *Client, method string) error {
+	if method != CompareMethodHash {
+		return nil
+	}
+	if err := checkHashSupport(ctx, source.client, "source"); err != nil {
+		return err
+	}
+	return checkHashSupport(ctx, target.client, "target")
+}
+
+// checkHashSupport fails when the server version predates $toHashedIndexKey
+func checkHashSupport(ctx context.Context, client *mongo.Client, side string) error {
+	var info struct {
+		Version      string `bson:"version"`
+		VersionArray []int  `bson:"versionArray"`
+	}
+	if err := client.Database("admin").RunCommand(ctx, bson.D{{Key: "buildInfo", Value: 1}}).Decode(&info); err != nil {
+		return fmt.Errorf("failed to read the %s server version: %w", side, err)
+	}
+	if !versionAtLeast(info.VersionArray, hashMinVersion) {
+		return fmt.Errorf("the %s method requires MongoDB %d.%d or later on both sides for $toHashedIndexKey, "+
+			"but the %s runs %s; compare with the %s method instead",
+			CompareMethodHash, hashMinVersion[0], hashMinVersion[1], side, info.Version, CompareMethodMerge)
+	}
+	return nil
+}
+
+// versionAtLeast reports whether a version, as the components of buildInfo's versionArray, is at least minimum
+func versionAtLeast(version, minimum []int) bool {
+	for i, component := range minimum {
+		if i >= len(version) {
+			return false
+		}
+		if version[i] != component {
+			return version[i] > component
+		}
+	}
+	return true
+}
+
+// idRange selects the documents whose _id is at least lower and below upper. A nil bound leaves the range open
+// at that end; a bounded range only selects _ids of the type of its bounds, as query comparisons do.
+type idRange struct {
+	lower, upper interface{}
+	// otherTypes selects the documents whose _id is not of this $type instead, which the bounded ranges miss
+	otherTypes string
+}
+
+// whole reports whether the range selects the whole collection
+func (r idRange) whole() bool {
+	return r.lower == nil && r.upper == nil && r.otherTypes == ""
+}
+
+// filter returns the query selecting the documents of the range
+func (r idRange) filter() bson.D {
+	if r.otherTypes != "" {
+		return bson.D{{Key: "_id", Value: bson.D{{Key: "$not", Value: bson.D{{Key: "$type", Value: r.otherTypes}}}}}}
+	}
+
+	var bounds bson.D
+	if r.lower != nil {
+		bounds = append(bounds, bson.E{Key: "$gte", Value: r.lower})
+	}
+	if r.upper != nil {
+		bounds = append(bounds, bson.E{Key: "$lt", Value: r.upper})
+	}
+	if len(bounds) == 0 {
+		return bson.D{}
+	}
+	return bson.D{{Key: "_id", Value: bounds}}
+}
+
+// rangeDigest is the number of documents of an _id range with an order-independent hash of their content:
+// two sums of the hashed index key of the typed form of every document, each modulo a prime
+type rangeDigest struct {
+	Count int64 `bson:"count"`
+	Sum1  int64 `bson:"sum1"`
+	Sum2  int64 `bson:"sum2"`
+	// Deep counts the documents nested deeper than their typed form, whose hash is not exact
+	Deep int64 `bson:"deep"`
+}
+
+// matches reports whether two digests prove that their ranges hold the same documents
+func (d rangeDigest) matches(other rangeDigest) bool {
+	return d == other && d.Deep == 0
+}
+
+// rangeDigestPipeline computes the digest of a range on the server, without the ignored fields to unset.
+// The hash function of hashed indexes hashes numbers of the same integer value alike, so documents are hashed
+// in their typed form, which keeps the type of every value and the exact digits of every double.
+func rangeDigestPipeline(r idRange, unset []string) mongo.Pipeline {
+	hashModulo := func(modulus int64) bson.D {
+		return bson.D{{Key: "$sum", Value: bson.D{{Key: "$mod", Value: bson.A{"$h", modulus}}}}}
+	}
+	pipeline := mongo.Pipeline{{{Key: "$match", Value: r.filter()}}}
+	if len(unset) > 0 {
+		pipeline = append(pipeline, bson.D{{Key: "$unset", Value: unset}})
+	}
+	return append(pipeline,
+		bson.D{{Key: "$project", Value: bson.D{
+			{Key: "_id", Value: 0},
+			{Key: "h", Value: bson.D{{Key: "$toHashedIndexKey", Value: bson.D{{Key: "doc", Value: typedValue("$$ROOT", typedDepth)}}}}},
+			{Key: "deep", Value: bson.D{{Key: "$cond", Value: bson.A{nestedBeyond("$$ROOT", typedDepth), 1, 0}}}},
+		}}},
+		bson.D{{Key: "$group", Value: bson.D{
+			{Key: "_id", Value: nil},
+			{Key: "count", Value: bson.D{{Key: "$sum", Value: 1}}},
+			{Key: "sum1", Value: hashModulo(hashModulus1)},
+			{Key: "sum2", Value: hashModulo(hashModulus2)},
+			{Key: "deep", Value: bson.D{{Key: "$sum", Value: "$deep"}}},
+		}}},
+	)
+}
+
+// typedValue returns an expression converting a value to its typed form: the pair of its $type and its content,
+// in which doubles and decimals are replaced by their exact string form and the fields of embedded documents and
+// the elements of arrays are converted likewise, down to depth levels
+func typedValue(value interface{}, depth int) bson.D {
+	branches := bson.A{
+		bson.D{{Key: "case", Value: typeIn("double", "decimal")}, {Key: "then", Value: bson.D{{Key: "$toString", Value: "$$x"}}}},
+	}
+	if depth > 0 {
+		branches = append(branches, bson.D{
+			{Key: "case", Value: typeIn("object", "array")},
+			{Key: "then", Value: bson.D{{Key: "$map", Value: bson.D{
+				{Key: "input", Value: containerItems()},
+				{Key: "as", Value: "f"},
+				{Key: "in", Value: bson.A{"$$f.k", typedValue("$$f.v", depth-1)}},
+			}}}},
+		})
+	}
+
+	content := bson.D{{Key: "$switch", Value: bson.D{{Key: "branches", Value: branches}, {Key: "default", Value: "$$x"}}}}
+	return typedLet(value, bson.A{"$$t", content})
+}
+
+// nestedBeyond returns an expression telling whether a value holds embedded documents or arrays below depth levels,
+// which its typed form leaves as they are
+func nestedBeyond(value interface{}, depth int) bson.D {
+	if depth == 0 {
+		return typedLet(value, typeIn("object", "array"))
+	}
+	return typedLet(value, bson.D{{Key: "$cond", Value: bson.A{
+		typeIn("object", "array"),
+		bson.D{{Key: "$anyElementTrue", Value: bson.A{bson.D{{Key: "$map", Value: bson.D{
+			{Key: "input", Value: containerItems()},
+			{Key: "as", Value: "f"},
+			{Key: "in", Value: nestedBeyond("$$f.v", depth-1)},
+		}}}}}},
+		false,
+	}}})
+}
+
+// typedLet binds a value to $$x and its $type to $$t for an expression
+func typedLet(value, in interface{}) bson.D {
+	return bson.D{{Key: "$let", Value: bson.D{
+		{Key: "vars", Value: bson.D{{Key: "x", Value: value}, {Key: "t", Value: bson.D{{Key: "$type", Value: value}}}}},
+		{Key: "in", Value: in},
+	}}}
+}
+
+// typeIn returns an expression telling whether $$t is one of the types
+func typeIn(types ...string) bson.D {
+	return bson.D{{Key: "$in", Value: bson.A{"$$t", types}}}
+}
+
+// containerItems returns an expression listing the fields of the embedded document $$x as {k, v} pairs in their
+// order, or the elements of the array $$x as pairs with an empty k
+func containerItems() bson.D {
+	return bson.D{{Key: "$cond", Value: bson.A{
+		bson.D{{Key: "$eq", Value: bson.A{"$$t", "object"}}},
+		bson.D{{Key: "$objectToArray", Value: "$$x"}},
+		bson.D{{Key: "$map", Value: bson.D{
+			{Key: "input", Value: "$$x"},
+			{Key: "as", Value: "e"},
+			{Key: "in", Value: bson.D{{Key: "k", Value: bson.D{{Key: "$literal", Value: ""}}}, {Key: "v", Value: "$$e"}}},
+		}}},
+	}}}
+}
+
+// simpleCollation compares strings by their bytes, the order the range boundaries are sorted in
+var simpleCollation = &options.Collation{Locale: "simple"}
+
+// digestRange computes the digest of a range of a collection, without the ignored fields to unset
+func digestRange(ctx context.Context, coll *mongo.Collection, r idRange, unset []string) (rangeDigest, error) {
+	cursor, err := coll.Aggregate(ctx, rangeDigestPipeline(r, unset), options.Aggregate().SetCollation(simpleCollation))
+	if err != nil {
+		return rangeDigest{}, fmt.Errorf("failed to hash documents of %s: %w", coll.Name(), err)
+	}
+	defer cursor.Close(ctx)
+
+	var digest rangeDigest
+	if cursor.Next(ctx) {
+		if err := cursor.Decode(&digest); err != nil {
+			return rangeDigest{}, fmt.Errorf("failed to decode hash of %s: %w", coll.Name(), err)
+		}
+	}
+	return digest, cursor.Err()
+}
+
+// idTypeAlias returns the $type alias of an _id that range boundaries can be placed at
+func idTypeAlias(id interface{}) (string, bool) {
+	switch id.(type) {
+	case primitive.ObjectID:
+		return "objectId", true
+	case string:
+		return "string", true
+	case int32, int64, float64, primitive.Decimal128:
+		return "number", true
+	case primitive.DateTime:
+		return "date", true
+	case primitive.Binary:
+		return "binData", true
+	default:
+		return "", false
+	}
+}
+
+// commonIDType returns the $type alias shared by all _ids, which is only set when range boundaries can be placed at them
+func commonIDType(ids []interface{}) (string, bool) {
+	if len(ids) == 0 {
+		return "", false
+	}
+	typeAlias, ok := idTypeAlias(ids[0])
+	for _, id := range ids[1:] {
+		if alias, _ := idTypeAlias(id); alias != typeAlias {
+			return "", false
+		}
+	}
+	return typeAlias, ok
+}
+
+// rangeBoundaries picks up to n-1 distinct boundaries that split sampled _ids into n groups of similar size.
+// It returns no boundaries unless all _ids have the same type.
+func rangeBoundaries(ids []interface{}, n int) (boundaries []interface{}, typeAlias string) {
+	typeAlias, ok := commonIDType(ids)
+	if !ok || len(ids) < 2 {
+		return nil, ""
+	}
+
+	sorted := append([]interface{}(nil), ids...)
+	sort.Slice(sorted, func(i, j int) bool { return compareBSONValues(sorted[i], sorted[j]) < 0 })
+	for i := 1; i < n; i++ {
+		boundary := sorted[i*len(sorted)/n]
+		if len(boundaries) == 0 || compareBSONValues(boundaries[len(boundaries)-1], boundary) < 0 {
+			boundaries = append(boundaries, boundary)
+		}
+	}
+	return boundaries, typeAlias
+}
+
+// splitRange splits a range at _ids sampled from the collection into about n ranges of similar size.
+// Splitting the whole collection adds a range for the _ids of other types than the sampled ones.
+// It returns no ranges when the range cannot be split, such as when its _ids have different types.
+func splitRange(ctx context.Context, coll *mongo.Collection, r idRange, n int) ([]idRange, error) {
+	ids, err := sampleIDs(ctx, coll, r, n*samplesPerRange)
+	if err != nil {
+		return nil, err
+	}
+	boundaries, typeAlias := rangeBoundaries(ids, n)
+	if len(boundaries) == 0 {
+		return nil, nil
+	}
+
+	ranges := make([]idRange, 0, len(boundaries)+2)
+	lower := r.lower
+	for _, boundary := range boundaries {
+		ranges = append(ranges, idRange{lower: lower, upper: boundary})
+		lower = boundary
+	}
+	ranges = append(ranges, idRange{lower: lower, upper: r.upper})
+	if r.whole() {
+		ranges = append(ranges, idRange{otherTypes: typeAlias})
+	}
+	return ranges, nil
+}
+
+// sampleIDs returns the _ids of random documents of a range
+func sampleIDs(ctx context.Context, coll *mongo.Collection, r idRange, size int) ([]interface{}, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: r.filter()}},
+		{{Key: "$sample", Value: bson.D{{Key: "size", Value: size}}}},
+		{{Key: "$project", Value: bson.D{{Key: "_id", Value: 1}}}},
+	}
+	cursor, err := coll.Aggregate(ctx, pipeline, options.Aggregate().SetCollation(simpleCollation))
+	if err != nil {
+		return nil, fmt.Errorf("failed to sample _ids of %s: %w", coll.Name(), err)
+	}
+	defer cursor.Close(ctx)
+
+	var ids []interface{}
+	for cursor.Next(ctx) {
+		var doc struct {
+			ID interface{} `bson:"_id"`
+		}
+		if err := cursor.Decode(&doc); err != nil {
+			return nil, fmt.Errorf("failed to decode sampled _id of %s: %w", coll.Name(), err)
+		}
+		ids = append(ids, doc.ID)
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, fmt.Errorf("failed to sample _ids of %s: %w", coll.Name(), err)
+	}
+	return ids, nil
+}
+
+// hashComparison compares a collection range by range, splitting the ranges whose hashes differ
+type hashComparison struct {
+	sourceColl, targetColl *mongo.Collection
+	opts                   CompareOptions
+	result                 *ComparisonResult
+	cmp                    *documentComparer
+	// unset lists the ignored fields removed before documents are hashed
+	unset []string
+	// ranges and differing count the hashed ranges and those that differ, compared the source documents merged
+	ranges, differing int
+	compared          int64
+	lastProgress      time.Time
+}
+
+// CompareCollectionHashes compares a collection by hashes of _id ranges computed on both servers, and only merges
+// the documents of the ranges whose hash differs. Differing ranges are split again until they hold at most a batch
+// of documents. The result matches a merge comparison.
+func CompareCollectionHashes(
+	ctx context.Context,
+	sourceDB, targetDB *mongo.Database,
+	collName string,
+	opts CompareOptions,
+) (*ComparisonResult, error) {
+	fmt.Printf("  Hash comparison of collection: %s\n", collName)
+
+	result := &ComparisonResult{
+		Database:   sourceDB.Name(),
+		Collection: collName,
+	}
+
+	c := &hashComparison{
+		sourceColl:   sourceDB.Collection(collName),
+		targetColl:   targetDB.Collection(collName),
+		opts:         opts,
+		result:       result,
+		cmp:          newDocumentComparer(opts, sourceDB.Name(), collName),
+		lastProgress: time.Now(),
+	}
+	if err := calculateCollectionCounts(ctx, c.sourceColl, c.targetColl, result); err != nil {
+		result.Error = err.Error()
+		return result, err
+	}
+
+	err := c.compareCollection(ctx)
+	result.DiffsOmitted = c.cmp.diffs.omittedCount()
+	if err != nil {
+		result.Error = fmt.Sprintf("error comparing hashes: %v", err)
+		return result, fmt.Errorf("%s", result.Error)
+	}
+
+	fmt.Printf("    Hashed %d ranges of %s, %d differ, %d documents compared one by one\n",
+		c.ranges, collName, c.differing, c.compared)
+	return result, nil
+}
+
+// compareCollection compares the whole collection by hashes. Ignored fields with wildcards cannot be removed
+// before hashing, so such collections are merged document by document instead.
+func (c *hashComparison) compareCollection(ctx context.Context) error {
+	unset, literal := c.cmp.ignore.literalPaths()
+	if !literal {
+		fmt.Printf("    Ignored fields of %s have wildcards, comparing every document\n", c.sourceColl.Name())
+		return c.compareDocuments(ctx, idRange{})
+	}
+	c.unset = unset
+	return c.compareRange(ctx, idRange{}, 0)
+}
+
+// compareRange compares the digests of a range on both servers and drills into the range when they differ
+func (c *hashComparison) compareRange(ctx context.Context, r idRange, depth int) error {
+	source, err := digestRange(ctx, c.sourceColl, r, c.unset)
+	if err != nil {
+		return err
+	}
+	target, err := digestRange(ctx, c.targetColl, r, c.unset)
+	if err != nil {
+		return err
+	}
+
+	c.ranges++
+	c.reportProgress()
+	if source.matches(target) {
+		return nil
+	}
+	c.differing++
+	return c.drillDown(ctx, r, source.Count, depth)
+}
+
+// drillDown splits a differing range into smaller ranges, down to ranges small enough to compare document by document
+func (c *hashComparison) drillDown(ctx context.Context, r idRange, count int64, depth int) error {
+	if count <= int64(max(c.opts.BatchSize, 1)) || depth >= maxHashDepth {
+		return c.compareDocuments(ctx, r)
+	}
+
+	ranges, err := splitRange(ctx, c.sourceColl, r, c.hashRanges())
+	if err != nil {
+		return err
+	}
+	if len(ranges) == 0 {
+		return c.compareDocuments(ctx, r)
+	}
+	for _, subrange := range ranges {
+		if err := c.compareRange(ctx, subrange, depth+1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// compareDocuments compares the documents of a range on both servers with a merge join
+func (c *hashComparison) compareDocuments(ctx context.Context, r idRange) error {
+	counts, err := mergeRange(ctx, c.sourceColl, c.targetColl, r, c.opts.BatchSize, c.cmp, nil)
+	if err != nil {
+		return err
+	}
+
+	c.compared += counts.docCount
+	c.result.MissingInTarget += counts.missingInTarget
+	c.result.ExtraInTarget += counts.extraInTarget
+	c.result.DifferentDocuments += counts.different
+	return nil
+}
+
+// hashRanges returns the number of ranges a range is split into
+func (c *hashComparison) hashRanges() int {
+	if c.opts.HashRanges > 1 {
+		return c.opts.HashRanges
+	}
+	return defaultHashRanges
+}
+
+// reportProgress prints the hashed and differing ranges at most every 10 seconds
+func (c *hashComparison) reportProgress() {
+	if !shouldUpdateProgress(c.lastProgress, 10*time.Second) {
+		return
+	}
+	fmt.Printf("    Hashed %d ranges of %s, %d differ, %d documents compared one by one\n",
+		c.ranges, c.sourceColl.Name(), c.differing, c.compared)
+	c.lastProgress = time.Now()
+}
@@ -0,0 +1,216 @@
+package mongodb
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func TestVersionAtLeast(t *testing.T) {
+	assert.True(t, versionAtLeast([]int{7, 0, 0, 0}, hashMinVersion))
+	assert.True(t, versionAtLeast([]int{8, 0, 4, 0}, hashMinVersion))
+	assert.True(t, versionAtLeast([]int{7, 3, 1, 0}, hashMinVersion))
+	assert.False(t, versionAtLeast([]int{6, 0, 19, 0}, hashMinVersion))
+	assert.False(t, versionAtLeast([]int{4, 4, 29, 0}, hashMinVersion))
+	assert.False(t, versionAtLeast(nil, hashMinVersion))
+}
+
+func TestIDRangeFilter(t *testing.T) {
+	tests := []struct {
+		name  string
+		r     idRange
+		want  bson.D
+		whole bool
+	}{
+		{name: "Whole collection", r: idRange{}, want: bson.D{}, whole: true},
+		{name: "Bounded", r: idRange{lower: 10, upper: 20},
+			want: bson.D{{Key: "_id", Value: bson.D{{Key: "$gte", Value: 10}, {Key: "$lt", Value: 20}}}}},
+		{name: "Open below", r: idRange{upper: "m"}, want: bson.D{{Key: "_id", Value: bson.D{{Key: "$lt", Value: "m"}}}}},
+		{name: "Open above", r: idRange{lower: "m"}, want: bson.D{{Key: "_id", Value: bson.D{{Key: "$gte", Value: "m"}}}}},
+		{name: "Other types", r: idRange{otherTypes: "objectId"},
+			want: bson.D{{Key: "_id", Value: bson.D{{Key: "$not", Value: bson.D{{Key: "$type", Value: "objectId"}}}}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.r.filter())
+			assert.Equal(t, tt.whole, tt.r.whole())
+		})
+	}
+}
+
+func TestRangeBoundaries(t *testing.T) {
+	ids := make([]interface{}, 0, 40)
+	for i := 39; i >= 0; i-- {
+		ids = append(ids, int32(i))
+	}
+
+	boundaries, typeAlias := rangeBoundaries(ids, 4)
+	assert.Equal(t, []interface{}{int32(10), int32(20), int32(30)}, boundaries)
+	assert.Equal(t, "number", typeAlias)
+
+	// Numbers of different types share a type and are ordered by value
+	boundaries, _ = rangeBoundaries([]interface{}{int64(7), 2.5, int32(1), 4.0}, 4)
+	assert.Equal(t, []interface{}{2.5, 4.0, int64(7)}, boundaries)
+
+	// Boundaries are distinct even when there are more ranges than sampled _ids
+	boundaries, _ = rangeBoundaries([]interface{}{"b", "a", "c"}, 8)
+	assert.Equal(t, []interface{}{"a", "b", "c"}, boundaries)
+
+	// Mixed and unsupported _id types cannot be split
+	boundaries, _ = rangeBoundaries([]interface{}{"a", int32(1)}, 2)
+	assert.Empty(t, boundaries)
+	boundaries, _ = rangeBoundaries([]interface{}{bson.D{{Key: "a", Value: 1}}, bson.D{{Key: "a", Value: 2}}}, 2)
+	assert.Empty(t, boundaries)
+	boundaries, _ = rangeBoundaries([]interface{}{primitive.NewObjectID()}, 2)
+	assert.Empty(t, boundaries)
+}
+
+func TestCompareCollectionHashes(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	sourceContainer, sourceConnString := setupMongoContainer(t)
+	defer sourceContainer.Terminate(context.Background())
+
+	targetContainer, targetConnString := setupMongoContainer(t)
+	defer targetContainer.Terminate(context.Background())
+
+	ctx := context.Background()
+	sourceClient, err := mongo.Connect(ctx, options.Client().ApplyURI(sourceConnString))
+	require.NoError(t, err)
+	defer sourceClient.Disconnect(ctx)
+
+	targetClient, err := mongo.Connect(ctx, options.Client().ApplyURI(targetConnString))
+	require.NoError(t, err)
+	defer targetClient.Disconnect(ctx)
+
+	sourceDB := sourceClient.Database("hashdb")
+	targetDB := targetClient.Database("hashdb")
+
+	docs := make([]interface{}, 0, 2005)
+	for i := 0; i < 2000; i++ {
+		docs = append(docs, bson.D{{Key: "_id", Value: i}, {Key: "name", Value: fmt.Sprintf("doc %d", i)}, {Key: "tags", Value: bson.A{i % 7}}})
+	}
+	// A few _ids of another type are compared in a range of their own
+	for i := 0; i < 5; i++ {
+		docs = append(docs, bson.D{{Key: "_id", Value: fmt.Sprintf("key-%d", i)}, {Key: "name", Value: "keyed"}})
+	}
+	_, err = sourceDB.Collection("items").InsertMany(ctx, docs)
+	require.NoError(t, err)
+	_, err = targetDB.Collection("items").InsertMany(ctx, docs)
+	require.NoError(t, err)
+
+	require.NoError(t, checkHashSupport(ctx, sourceClient, "source"))
+	opts := CompareOptions{BatchSize: 50, Detailed: true, Method: CompareMethodHash, HashRanges: 4}
+	result, err := CompareCollectionHashes(ctx, sourceDB, targetDB, "items", opts)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2005), result.SourceCount)
+	assert.Equal(t, int64(0), result.MissingInTarget)
+	assert.Equal(t, int64(0), result.DifferentDocuments)
+
+	targetColl := targetDB.Collection("items")
+	_, err = targetColl.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": bson.A{3, 1500, "key-2"}}})
+	require.NoError(t, err)
+	changed := bson.M{"_id": bson.M{"$in": bson.A{10, 999, 1998, "key-4"}}}
+	_, err = targetColl.UpdateMany(ctx, changed, bson.M{"$set": bson.M{"name": "changed"}})
+	require.NoError(t, err)
+	_, err = targetColl.InsertOne(ctx, bson.M{"_id": 5000, "name": "only in target"})
+	require.NoError(t, err)
+
+	result, err = CompareCollectionHashes(ctx, sourceDB, targetDB, "items", opts)
+	require.NoError(t, err)
+
+	// The hash method finds the same differences as looking up every document
+	lookup, err := CompareCollectionData(ctx, sourceDB, targetDB, "items", 50, true)
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), lookup.MissingInTarget)
+	assert.Equal(t, int64(4), lookup.DifferentDocuments)
+	assert.Equal(t, lookup.SourceCount, result.SourceCount)
+	assert.Equal(t, lookup.TargetCount, result.TargetCount)
+	assert.Equal(t, lookup.MissingInTarget, result.MissingInTarget)
+	assert.Equal(t, lookup.DifferentDocuments, result.DifferentDocuments)
+	assert.Equal(t, int64(1), result.ExtraInTarget)
+}
+
+func TestRangeDigestMatches(t *testing.T) {
+	digest := rangeDigest{Count: 3, Sum1: 11, Sum2: 12}
+	assert.True(t, digest.matches(digest))
+	assert.False(t, digest.matches(rangeDigest{Count: 3, Sum1: 11, Sum2: 13}))
+
+	// Documents nested beyond their typed form never prove a range identical
+	deep := rangeDigest{Count: 3, Sum1: 11, Sum2: 12, Deep: 1}
+	assert.False(t, deep.matches(deep))
+}
+
+func TestCompareCollectionHashesNumbers(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	sourceContainer, sourceConnString := setupMongoContainer(t)
+	defer sourceContainer.Terminate(context.Background())
+
+	targetContainer, targetConnString := setupMongoContainer(t)
+	defer targetContainer.Terminate(context.Background())
+
+	ctx := context.Background()
+	sourceClient, err := mongo.Connect(ctx, options.Client().ApplyURI(sourceConnString))
+	require.NoError(t, err)
+	defer sourceClient.Disconnect(ctx)
+
+	targetClient, err := mongo.Connect(ctx, options.Client().ApplyURI(targetConnString))
+	require.NoError(t, err)
+	defer targetClient.Disconnect(ctx)
+
+	sourceDB := sourceClient.Database("hashdb")
+	targetDB := targetClient.Database("hashdb")
+
+	item := func(id int, price float64, count interface{}) bson.D {
+		return bson.D{{Key: "_id", Value: id}, {Key: "price", Value: price}, {Key: "stock", Value: bson.D{{Key: "count", Value: count}}}}
+	}
+	sourceDocs := make([]interface{}, 0, 200)
+	targetDocs := make([]interface{}, 0, 200)
+	for i := 0; i < 200; i++ {
+		doc := item(i, 9.99, int32(5))
+		sourceDocs = append(sourceDocs, doc)
+		targetDocs = append(targetDocs, doc)
+	}
+	// Only the fractional part of a double differs
+	targetDocs[17] = item(17, 9.49, int32(5))
+	// Only the type of a number differs
+	targetDocs[123] = item(123, 9.99, int64(5))
+	_, err = sourceDB.Collection("prices").InsertMany(ctx, sourceDocs)
+	require.NoError(t, err)
+	_, err = targetDB.Collection("prices").InsertMany(ctx, targetDocs)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name      string
+		equality  Equality
+		different int64
+	}{
+		{name: "Semantic", equality: Equality{}, different: 1},
+		{name: "Strict", equality: Equality{Mode: EqualityStrict}, different: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := CompareOptions{BatchSize: 20, Detailed: true, Method: CompareMethodHash, HashRanges: 4, Equality: tt.equality}
+			result, err := CompareCollectionHashes(ctx, sourceDB, targetDB, "prices", opts)
+			require.NoError(t, err)
+
+			opts.Method = CompareMethodMerge
+			merge, err := CompareCollectionMerge(ctx, sourceDB, targetDB, "prices", opts)
+			require.NoError(t, err)
+			assert.Equal(t, tt.different, merge.DifferentDocuments)
+			assert.Equal(t, merge.DifferentDocuments, result.DifferentDocuments)
+		})
+	}
+}
@@ -0,0 +1,216 @@
+package mongodb
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// IgnoreRule is a field left out of the comparison of documents, in every collection or in the collections
+// matching a namespace
+type IgnoreRule struct {
+	// Namespace is a "database.collection" pattern, empty for every collection
+	Namespace string
+	// Path is a dotted field path. Each segment is a pattern in which * matches any characters, and
+	// a path into an array applies to the documents in the array.
+	Path string
+}
+
+// ParseIgnoreRules parses ignored fields given as "path" or "namespace:path", such as "updatedAt",
+// "meta.*.syncedAt" or "shop.orders:audit". The _id field cannot be ignored.
+func ParseIgnoreRules(specs []string) ([]IgnoreRule, error) {
+	rules := make([]IgnoreRule, 0, len(specs))
+	for _, spec := range specs {
+		rule := IgnoreRule{Path: spec}
+		if namespace, fieldPath, found := strings.Cut(spec, ":"); found {
+			rule = IgnoreRule{Namespace: namespace, Path: fieldPath}
+		}
+		if err := rule.validate(); err != nil {
+			return nil, fmt.Errorf("invalid ignored field %q: %w", spec, err)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// validate checks that the rule's patterns are well formed and that it does not ignore _id
+func (r IgnoreRule) validate() error {
+	if _, err := path.Match(r.Namespace, ""); err != nil {
+		return fmt.Errorf("namespace: %w", err)
+	}
+	segments := strings.Split(r.Path, ".")
+	if segments[0] == "_id" {
+		return fmt.Errorf("_id cannot be ignored")
+	}
+	for _, segment := range segments {
+		if segment == "" {
+			return fmt.Errorf("empty path segment")
+		}
+		if _, err := path.Match(segment, ""); err != nil {
+			return fmt.Errorf("path: %w", err)
+		}
+	}
+	return nil
+}
+
+// fieldFilter holds the segment patterns of the fields ignored in a collection
+type fieldFilter [][]string
+
+// ignoredFields returns the filter of the rules that apply to a collection
+func ignoredFields(rules []IgnoreRule, dbName, collName string) fieldFilter {
+	var filter fieldFilter
+	for _, rule := range rules {
+		if matched, _ := path.Match(rule.Namespace, dbName+"."+collName); rule.Namespace == "" || matched {
+			filter = append(filter, strings.Split(rule.Path, "."))
+		}
+	}
+	return filter
+}
+
+// matches reports whether a field path is ignored
+func (f fieldFilter) matches(fieldPath []string) bool {
+	for _, pattern := range f {
+		if matchSegments(pattern, fieldPath) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchSegments reports whether each segment of a path matches the pattern of the same position
+func matchSegments(pattern, fieldPath []string) bool {
+	if len(pattern) != len(fieldPath) {
+		return false
+	}
+	for i := range pattern {
+		if matched, _ := path.Match(pattern[i], fieldPath[i]); !matched {
+			return false
+		}
+	}
+	return true
+}
+
+// strip returns a copy of a document without its ignored fields, or the document itself when nothing is ignored
+func (f fieldFilter) strip(doc bson.D) bson.D {
+	if len(f) == 0 || doc == nil {
+		return doc
+	}
+	return f.stripDocument(doc, nil)
+}
+
+// keeps reports whether a field is compared. The _id of the document is never ignored, even by wildcards.
+func (f fieldFilter) keeps(fieldPath []string) bool {
+	return (len(fieldPath) == 1 && fieldPath[0] == "_id") || !f.matches(fieldPath)
+}
+
+// stripDocument copies an embedded document at a path without its ignored fields, keeping the order of the others
+func (f fieldFilter) stripDocument(doc bson.D, prefix []string) bson.D {
+	stripped := make(bson.D, 0, len(doc))
+	for _, elem := range doc {
+		fieldPath := append(prefix[:len(prefix):len(prefix)], elem.Key)
+		if f.keeps(fieldPath) {
+			stripped = append(stripped, bson.E{Key: elem.Key, Value: f.stripValue(elem.Value, fieldPath)})
+		}
+	}
+	return stripped
+}
+
+// stripMap copies an embedded map at a path without its ignored fields
+func (f fieldFilter) stripMap(doc bson.M, prefix []string) bson.M {
+	stripped := make(bson.M, len(doc))
+	for key, value := range doc {
+		fieldPath := append(prefix[:len(prefix):len(prefix)], key)
+		if f.keeps(fieldPath) {
+			stripped[key] = f.stripValue(value, fieldPath)
+		}
+	}
+	return stripped
+}
+
+// stripValue strips the ignored fields of the documents in a value; the documents in an array keep
+// the path of the array
+func (f fieldFilter) stripValue(value interface{}, fieldPath []string) interface{} {
+	switch v := value.(type) {
+	case bson.D:
+		return f.stripDocument(v, fieldPath)
+	case bson.M:
+		return f.stripMap(v, fieldPath)
+	case bson.A:
+		return bson.A(f.stripArray(v, fieldPath))
+	case []interface{}:
+		return f.stripArray(v, fieldPath)
+	default:
+		return value
+	}
+}
+
+// stripArray copies an array, stripping the ignored fields of its documents
+func (f fieldFilter) stripArray(values []interface{}, fieldPath []string) []interface{} {
+	stripped := make([]interface{}, len(values))
+	for i, value := range values {
+		stripped[i] = f.stripValue(value, fieldPath)
+	}
+	return stripped
+}
+
+// literalPaths returns the ignored paths for $unset in lexical order, leaving out those below another ignored path.
+// It reports false when a pattern has wildcards, which $unset cannot express.
+func (f fieldFilter) literalPaths() ([]string, bool) {
+	unique := make(map[string]bool, len(f))
+	for _, pattern := range f {
+		joined := strings.Join(pattern, ".")
+		if strings.ContainsAny(joined, `*?[\`) {
+			return nil, false
+		}
+		unique[joined] = true
+	}
+
+	paths := make([]string, 0, len(unique))
+	for fieldPath := range unique {
+		if !hasIgnoredParent(fieldPath, unique) {
+			paths = append(paths, fieldPath)
+		}
+	}
+	sort.Strings(paths)
+	return paths, true
+}
+
+// hasIgnoredParent reports whether a parent of a dotted path is among the ignored paths
+func hasIgnoredParent(fieldPath string, ignored map[string]bool) bool {
+	for i := strings.LastIndex(fieldPath, "."); i > 0; i = strings.LastIndex(fieldPath[:i], ".") {
+		if ignored[fieldPath[:i]] {
+			return true
+		}
+	}
+	return false
+}
+
+// documentComparer compares the two versions of a collection's documents without their ignored fields,
+// and records the documents that differ
+type documentComparer struct {
+	ignore   fieldFilter
+	equality Equality
+	diffs    *collectionDiffs
+}
+
+// newDocumentComparer creates the comparer of a collection from the comparison options
+func newDocumentComparer(opts CompareOptions, dbName, collName string) *documentComparer {
+	return &documentComparer{
+		ignore:   ignoredFields(opts.IgnoreFields, dbName, collName),
+		equality: opts.Equality,
+		diffs:    opts.Diffs.forCollection(dbName, collName, opts.Equality),
+	}
+}
+
+// equal reports whether two versions of a document are equal once their ignored fields are left out,
+// and records the document when they differ
+func (c *documentComparer) equal(source, target bson.D) (bool, error) {
+	source, target = c.ignore.strip(source), c.ignore.strip(target)
+	if c.equality.equal(source, target) {
+		return true, nil
+	}
+	return false, c.diffs.different(source, target)
+}
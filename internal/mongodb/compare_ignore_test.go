@@ -0,0 +1,98 @@
+package mongodb
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestParseIgnoreRules(t *testing.T) {
+	rules, err := ParseIgnoreRules([]string{"updatedAt", "meta.*.syncedAt", "shop.orders:audit", "shop.*:_synced*"})
+	require.NoError(t, err)
+	assert.Equal(t, []IgnoreRule{
+		{Path: "updatedAt"},
+		{Path: "meta.*.syncedAt"},
+		{Namespace: "shop.orders", Path: "audit"},
+		{Namespace: "shop.*", Path: "_synced*"},
+	}, rules)
+
+	for spec, wantErr := range map[string]string{
+		"":               "empty path segment",
+		"meta..syncedAt": "empty path segment",
+		"shop.orders:":   "empty path segment",
+		"_id":            "_id cannot be ignored",
+		"_id.part":       "_id cannot be ignored",
+		"tags[":          "syntax error in pattern",
+		"shop[:audit":    "namespace",
+	} {
+		_, err := ParseIgnoreRules([]string{spec})
+		assert.ErrorContains(t, err, wantErr, spec)
+	}
+}
+
+func TestIgnoredFields(t *testing.T) {
+	rules := []IgnoreRule{
+		{Path: "updatedAt"},
+		{Namespace: "shop.orders", Path: "audit"},
+		{Namespace: "shop.*", Path: "syncedAt"},
+	}
+	assert.Equal(t, fieldFilter{{"updatedAt"}, {"audit"}, {"syncedAt"}}, ignoredFields(rules, "shop", "orders"))
+	assert.Equal(t, fieldFilter{{"updatedAt"}, {"syncedAt"}}, ignoredFields(rules, "shop", "users"))
+	assert.Equal(t, fieldFilter{{"updatedAt"}}, ignoredFields(rules, "crm", "orders"))
+}
+
+func TestFieldFilterStrip(t *testing.T) {
+	filter := fieldFilter{{"updatedAt"}, {"meta", "*", "syncedAt"}, {"items", "price"}, {"*"}}
+	doc := bson.D{
+		{Key: "_id", Value: 1},
+		{Key: "updatedAt", Value: 10},
+		{Key: "meta", Value: bson.D{
+			{Key: "source", Value: bson.M{"syncedAt": 1, "name": "crm"}},
+			{Key: "target", Value: "plain"},
+		}},
+		{Key: "items", Value: bson.A{bson.D{{Key: "sku", Value: "a"}, {Key: "price", Value: 3}}, "loose"}},
+	}
+
+	// A single * only matches top-level fields, all of which are ignored except _id
+	assert.Equal(t, bson.D{{Key: "_id", Value: 1}}, filter.strip(doc))
+
+	filter = filter[:3]
+	assert.Equal(t, bson.D{
+		{Key: "_id", Value: 1},
+		{Key: "meta", Value: bson.D{{Key: "source", Value: bson.M{"name": "crm"}}, {Key: "target", Value: "plain"}}},
+		{Key: "items", Value: bson.A{bson.D{{Key: "sku", Value: "a"}}, "loose"}},
+	}, filter.strip(doc))
+	// The document itself is left as it was
+	assert.Equal(t, bson.E{Key: "updatedAt", Value: 10}, doc[1])
+
+	var none fieldFilter
+	assert.Equal(t, doc, none.strip(doc))
+}
+
+func TestFieldFilterLiteralPaths(t *testing.T) {
+	paths, ok := fieldFilter{{"meta", "syncedAt"}, {"updatedAt"}, {"meta"}, {"updatedAt"}, {"metadata", "x"}}.literalPaths()
+	require.True(t, ok)
+	assert.Equal(t, []string{"meta", "metadata.x", "updatedAt"}, paths)
+
+	_, ok = fieldFilter{{"updatedAt"}, {"meta", "*"}}.literalPaths()
+	assert.False(t, ok)
+}
+
+func TestDocumentComparerEqual(t *testing.T) {
+	cmp := &documentComparer{ignore: fieldFilter{{"updatedAt"}}}
+	equal, err := cmp.equal(
+		bson.D{{Key: "_id", Value: 1}, {Key: "name", Value: "a"}, {Key: "updatedAt", Value: 1}},
+		bson.D{{Key: "_id", Value: 1}, {Key: "updatedAt", Value: 2}, {Key: "name", Value: "a"}},
+	)
+	require.NoError(t, err)
+	assert.True(t, equal)
+
+	equal, err = cmp.equal(
+		bson.D{{Key: "_id", Value: 1}, {Key: "name", Value: "a"}},
+		bson.D{{Key: "_id", Value: 1}, {Key: "name", Value: "b"}, {Key: "updatedAt", Value: 2}},
+	)
+	require.NoError(t, err)
+	assert.False(t, equal)
+}
@@ -0,0 +1,214 @@
+package mongodb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// mergeJoin walks _id-sorted cursors of a source and a target collection in lockstep and classifies every _id
+// as missing in the target, extra in the target, different or identical
+type mergeJoin struct {
+	source, target *mongo.Cursor
+	// sourceDoc and targetDoc are the current documents of the cursors, nil once a cursor is exhausted
+	sourceDoc, targetDoc bson.D
+	counts               DocumentProcessingResult
+	cmp                  *documentComparer
+}
+
+// openMergeJoin opens _id-sorted cursors over a range of both collections and reads their first documents.
+// Both cursors use the simple collation, so that string _ids are ordered bytewise on both sides.
+func openMergeJoin(ctx context.Context, sourceColl, targetColl *mongo.Collection, r idRange, batchSize int,
+	cmp *documentComparer) (*mergeJoin, error) {
+	findOptions := options.Find().
+		SetSort(bson.D{{Key: "_id", Value: 1}}).
+		SetBatchSize(int32(max(batchSize, 1))).
+		SetCollation(simpleCollation)
+
+	source, err := sourceColl.Find(ctx, r.filter(), findOptions)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query source collection: %v", err)
+	}
+	target, err := targetColl.Find(ctx, r.filter(), findOptions)
+	if err != nil {
+		source.Close(ctx)
+		return nil, fmt.Errorf("failed to query target collection: %v", err)
+	}
+
+	m := &mergeJoin{source: source, target: target, cmp: cmp}
+	if err := m.advanceSource(ctx); err != nil {
+		m.close(ctx)
+		return nil, err
+	}
+	if err := m.advanceTarget(ctx); err != nil {
+		m.close(ctx)
+		return nil, err
+	}
+	return m, nil
+}
+
+// close closes both cursors
+func (m *mergeJoin) close(ctx context.Context) {
+	m.source.Close(ctx)
+	m.target.Close(ctx)
+}
+
+// advanceSource reads the next source document
+func (m *mergeJoin) advanceSource(ctx context.Context) error {
+	doc, err := nextDocument(ctx, m.source)
+	if err != nil {
+		return fmt.Errorf("source cursor error: %v", err)
+	}
+	m.sourceDoc = doc
+	return nil
+}
+
+// advanceTarget reads the next target document
+func (m *mergeJoin) advanceTarget(ctx context.Context) error {
+	doc, err := nextDocument(ctx, m.target)
+	if err != nil {
+		return fmt.Errorf("target cursor error: %v", err)
+	}
+	m.targetDoc = doc
+	return nil
+}
+
+// nextDocument decodes the next document of a cursor, or returns nil when the cursor is exhausted
+func nextDocument(ctx context.Context, cursor *mongo.Cursor) (bson.D, error) {
+	if !cursor.Next(ctx) {
+		return nil, cursor.Err()
+	}
+	var doc bson.D
+	if err := cursor.Decode(&doc); err != nil {
+		return nil, fmt.Errorf("failed to decode document: %v", err)
+	}
+	return doc, nil
+}
+
+// order compares the _ids of the current documents: negative when only the source has the smaller _id,
+// positive when only the target has it, and zero when both documents have the same _id
+func (m *mergeJoin) order() int {
+	switch {
+	case m.targetDoc == nil:
+		return -1
+	case m.sourceDoc == nil:
+		return 1
+	default:
+		return compareBSONValues(documentID(m.sourceDoc), documentID(m.targetDoc))
+	}
+}
+
+// step classifies the smallest current _id and advances the cursors past it. It returns false once both
+// cursors are exhausted.
+func (m *mergeJoin) step(ctx context.Context) (bool, error) {
+	if m.sourceDoc == nil && m.targetDoc == nil {
+		return false, nil
+	}
+
+	var err error
+	switch order := m.order(); {
+	case order < 0:
+		m.counts.docCount++
+		m.counts.missingInTarget++
+		err = errors.Join(m.cmp.diffs.missing(documentID(m.sourceDoc)), m.advanceSource(ctx))
+	case order > 0:
+		m.counts.extraInTarget++
+		err = errors.Join(m.cmp.diffs.extra(documentID(m.targetDoc)), m.advanceTarget(ctx))
+	default:
+		m.counts.docCount++
+		err = errors.Join(m.compareCurrent(), m.advanceSource(ctx), m.advanceTarget(ctx))
+	}
+	return err == nil, err
+}
+
+// compareCurrent compares the current documents of both cursors, which have the same _id
+func (m *mergeJoin) compareCurrent() error {
+	equal, err := m.cmp.equal(m.sourceDoc, m.targetDoc)
+	if !equal {
+		m.counts.different++
+	}
+	return err
+}
+
+// run walks both cursors to their end, calling progress, when set, after every step
+func (m *mergeJoin) run(ctx context.Context, progress func(*DocumentProcessingResult)) error {
+	for {
+		more, err := m.step(ctx)
+		if err != nil || !more {
+			return err
+		}
+		if progress != nil {
+			progress(&m.counts)
+		}
+	}
+}
+
+// mergeRange compares the documents of a range of both collections with a merge join
+func mergeRange(ctx context.Context, sourceColl, targetColl *mongo.Collection, r idRange, batchSize int,
+	cmp *documentComparer, progress func(*DocumentProcessingResult)) (DocumentProcessingResult, error) {
+	m, err := openMergeJoin(ctx, sourceColl, targetColl, r, batchSize, cmp)
+	if err != nil {
+		return DocumentProcessingResult{}, err
+	}
+	defer m.close(ctx)
+
+	err = m.run(ctx, progress)
+	return m.counts, err
+}
+
+// CompareCollectionMerge compares a collection by walking _id-sorted cursors of the source and the target
+// in lockstep. Unlike looking up every source document, it needs no round trip per document and also
+// counts the documents that only exist in the target.
+func CompareCollectionMerge(
+	ctx context.Context,
+	sourceDB, targetDB *mongo.Database,
+	collName string,
+	opts CompareOptions,
+) (*ComparisonResult, error) {
+	fmt.Printf("  Merge comparison of collection: %s\n", collName)
+
+	result := &ComparisonResult{
+		Database:   sourceDB.Name(),
+		Collection: collName,
+	}
+
+	sourceColl := sourceDB.Collection(collName)
+	targetColl := targetDB.Collection(collName)
+	if err := calculateCollectionCounts(ctx, sourceColl, targetColl, result); err != nil {
+		result.Error = err.Error()
+		return result, err
+	}
+
+	lastProgressTime := time.Now()
+	progress := func(counts *DocumentProcessingResult) {
+		if shouldUpdateProgress(lastProgressTime, 10*time.Second) {
+			updateMergeProgress(collName, counts, result.SourceCount)
+			lastProgressTime = time.Now()
+		}
+	}
+
+	cmp := newDocumentComparer(opts, sourceDB.Name(), collName)
+	counts, err := mergeRange(ctx, sourceColl, targetColl, idRange{}, opts.BatchSize, cmp, progress)
+	result.DiffsOmitted = cmp.diffs.omittedCount()
+	if err != nil {
+		result.Error = fmt.Sprintf("error merging source and target: %v", err)
+		return result, fmt.Errorf("%s", result.Error)
+	}
+
+	result.MissingInTarget = counts.missingInTarget
+	result.ExtraInTarget = counts.extraInTarget
+	result.DifferentDocuments = counts.different
+	return result, nil
+}
+
+// updateMergeProgress prints the progress of a merge comparison
+func updateMergeProgress(collName string, counts *DocumentProcessingResult, sourceCount int64) {
+	fmt.Printf("    Compared %d/%d documents in %s\n", counts.docCount, sourceCount, collName)
+	fmt.Printf("    Missing in target: %d, Extra in target: %d, Different: %d\n",
+		counts.missingInTarget, counts.extraInTarget, counts.different)
+}
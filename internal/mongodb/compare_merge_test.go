@@ -0,0 +1,138 @@
+package mongodb
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// cursorOf returns a cursor over documents already sorted by _id
+func cursorOf(t *testing.T, docs ...bson.D) *mongo.Cursor {
+	documents := make([]interface{}, 0, len(docs))
+	for _, doc := range docs {
+		documents = append(documents, doc)
+	}
+	cursor, err := mongo.NewCursorFromDocuments(documents, nil, nil)
+	require.NoError(t, err)
+	return cursor
+}
+
+func TestMergeJoin(t *testing.T) {
+	ctx := context.Background()
+	doc := func(id interface{}, name string) bson.D {
+		return bson.D{{Key: "_id", Value: id}, {Key: "name", Value: name}}
+	}
+
+	tests := []struct {
+		name     string
+		equality Equality
+		source   []bson.D
+		target   []bson.D
+		want     DocumentProcessingResult
+	}{
+		{name: "Empty collections"},
+		{
+			name:   "Identical",
+			source: []bson.D{doc(int32(1), "a"), doc(int32(2), "b")},
+			target: []bson.D{doc(int32(1), "a"), doc(int32(2), "b")},
+			want:   DocumentProcessingResult{docCount: 2},
+		},
+		{
+			name:   "Interleaved differences",
+			source: []bson.D{doc(int32(1), "a"), doc(int32(2), "b"), doc(int32(4), "d"), doc(int32(6), "f")},
+			target: []bson.D{doc(int32(0), "z"), doc(int32(2), "changed"), doc(int32(3), "c"), doc(int32(4), "d")},
+			want:   DocumentProcessingResult{docCount: 4, missingInTarget: 2, extraInTarget: 2, different: 1},
+		},
+		{
+			name:   "Empty target",
+			source: []bson.D{doc(int32(1), "a"), doc(int32(2), "b")},
+			want:   DocumentProcessingResult{docCount: 2, missingInTarget: 2},
+		},
+		{
+			name:   "Empty source",
+			target: []bson.D{doc(int32(1), "a")},
+			want:   DocumentProcessingResult{extraInTarget: 1},
+		},
+		{
+			name:   "Numbers of different types with the same value are the same _id",
+			source: []bson.D{doc(int32(1), "a"), doc("key", "b")},
+			target: []bson.D{doc(int64(1), "a"), doc("key", "b")},
+			want:   DocumentProcessingResult{docCount: 2},
+		},
+		{
+			// The documents are matched, then differ by the type of their _id
+			name:     "Numbers of different types differ in strict mode",
+			equality: Equality{Mode: EqualityStrict},
+			source:   []bson.D{doc(int32(1), "a"), doc("key", "b")},
+			target:   []bson.D{doc(int64(1), "a"), doc("key", "b")},
+			want:     DocumentProcessingResult{docCount: 2, different: 1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &mergeJoin{source: cursorOf(t, tt.source...), target: cursorOf(t, tt.target...), cmp: &documentComparer{equality: tt.equality}}
+			require.NoError(t, m.advanceSource(ctx))
+			require.NoError(t, m.advanceTarget(ctx))
+			defer m.close(ctx)
+
+			steps := 0
+			require.NoError(t, m.run(ctx, func(*DocumentProcessingResult) { steps++ }))
+			assert.Equal(t, tt.want, m.counts)
+			assert.Equal(t, int(tt.want.docCount+tt.want.extraInTarget), steps)
+		})
+	}
+}
+
+func TestCompareCollectionMerge(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	sourceContainer, sourceConnString := setupMongoContainer(t)
+	defer sourceContainer.Terminate(context.Background())
+
+	targetContainer, targetConnString := setupMongoContainer(t)
+	defer targetContainer.Terminate(context.Background())
+
+	ctx := context.Background()
+	sourceClient, err := mongo.Connect(ctx, options.Client().ApplyURI(sourceConnString))
+	require.NoError(t, err)
+	defer sourceClient.Disconnect(ctx)
+
+	targetClient, err := mongo.Connect(ctx, options.Client().ApplyURI(targetConnString))
+	require.NoError(t, err)
+	defer targetClient.Disconnect(ctx)
+
+	sourceDB := sourceClient.Database("mergedb")
+	targetDB := targetClient.Database("mergedb")
+
+	// String _ids sort bytewise with the simple collation on both sides
+	docs := []interface{}{
+		bson.D{{Key: "_id", Value: 1}, {Key: "name", Value: "one"}},
+		bson.D{{Key: "_id", Value: 2}, {Key: "name", Value: "two"}},
+		bson.D{{Key: "_id", Value: "B"}, {Key: "name", Value: "upper"}},
+		bson.D{{Key: "_id", Value: "a"}, {Key: "name", Value: "lower"}},
+	}
+	_, err = sourceDB.Collection("items").InsertMany(ctx, docs)
+	require.NoError(t, err)
+	_, err = targetDB.Collection("items").InsertMany(ctx, docs[1:])
+	require.NoError(t, err)
+	_, err = targetDB.Collection("items").UpdateByID(ctx, "a", bson.M{"$set": bson.M{"name": "changed"}})
+	require.NoError(t, err)
+	_, err = targetDB.Collection("items").InsertOne(ctx, bson.M{"_id": "C", "name": "only in target"})
+	require.NoError(t, err)
+
+	opts := CompareOptions{BatchSize: 2, Detailed: true, Method: CompareMethodMerge}
+	result, err := CompareCollectionMerge(ctx, sourceDB, targetDB, "items", opts)
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), result.SourceCount)
+	assert.Equal(t, int64(4), result.TargetCount)
+	assert.Equal(t, int64(1), result.MissingInTarget)
+	assert.Equal(t, int64(1), result.ExtraInTarget)
+	assert.Equal(t, int64(1), result.DifferentDocuments)
+}
@@ -0,0 +1,186 @@
+package mongodb
+
+import (
+	"context"
+	"fmt"
+	"math"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// sampleConfidence is the confidence level of the interval of an estimated difference rate
+	sampleConfidence = 0.95
+	// sampleZ is the standard normal quantile of a two-sided interval at sampleConfidence
+	sampleZ = 1.959963984540054
+)
+
+// SampleEstimate is the share of a collection's documents that differ, estimated from a random sample of its
+// source documents
+type SampleEstimate struct {
+	// Size is the number of distinct source documents sampled
+	Size int64 `json:"size"`
+	// Differences is the number of sampled documents missing in the target or different
+	Differences int64 `json:"differences"`
+	// Rate is the share of the sampled documents that differ
+	Rate float64 `json:"rate"`
+	// Lower and Upper bound the share of the collection's documents that differ at the Confidence level
+	Lower      float64 `json:"lower"`
+	Upper      float64 `json:"upper"`
+	Confidence float64 `json:"confidence"`
+}
+
+// newSampleEstimate estimates the difference rate of a collection of population documents from a sample, with
+// a Wilson score interval corrected for sampling without replacement, which is exact once every document is sampled
+func newSampleEstimate(size, differences, population int64) *SampleEstimate {
+	estimate := &SampleEstimate{Size: size, Differences: differences, Confidence: sampleConfidence}
+	if size == 0 {
+		// Nothing is known about a collection without sampled documents, unless it is empty
+		if population > 0 {
+			estimate.Upper = 1
+		}
+		return estimate
+	}
+
+	n := float64(size)
+	p := float64(differences) / n
+	z2 := sampleZ * sampleZ * finitePopulationCorrection(size, population)
+	center := (p + z2/(2*n)) / (1 + z2/n)
+	halfWidth := math.Sqrt(z2*(p*(1-p)/n+z2/(4*n*n))) / (1 + z2/n)
+
+	estimate.Rate = p
+	estimate.Lower = max(center-halfWidth, 0)
+	estimate.Upper = min(center+halfWidth, 1)
+	return estimate
+}
+
+// finitePopulationCorrection returns the factor by which sampling without replacement reduces the variance
+// of the sampled rate
+func finitePopulationCorrection(size, population int64) float64 {
+	if size >= population {
+		return 0
+	}
+	return float64(population-size) / float64(population-1)
+}
+
+// String formats the estimate as percentages
+func (e *SampleEstimate) String() string {
+	return fmt.Sprintf("%.2f%% of %d sampled documents differ, %.0f%% confidence interval %.2f%% to %.2f%%",
+		e.Rate*100, e.Size, e.Confidence*100, e.Lower*100, e.Upper*100)
+}
+
+// sampled reports whether detailed comparisons of regular collections only compare a sample of their documents
+func (o CompareOptions) sampled() bool {
+	return o.SampleSize > 0 || o.SamplePercent > 0
+}
+
+// sampleSize returns the number of documents to sample from a collection of count documents
+func (o CompareOptions) sampleSize(count int64) int64 {
+	if o.SampleSize > 0 {
+		return min(int64(o.SampleSize), count)
+	}
+	return min(int64(math.Ceil(float64(count)*o.SamplePercent/100)), count)
+}
+
+// CompareCollectionSample compares a random sample of a collection's source documents with the target, looking up
+// each by _id, and estimates the share of the collection's documents that differ. The missing and different counts
+// of the result only cover the sample.
+func CompareCollectionSample(
+	ctx context.Context,
+	sourceDB, targetDB *mongo.Database,
+	collName string,
+	opts CompareOptions,
+) (*ComparisonResult, error) {
+	fmt.Printf("  Sampled comparison of collection: %s\n", collName)
+
+	result := &ComparisonResult{
+		Database:   sourceDB.Name(),
+		Collection: collName,
+	}
+
+	sourceColl := sourceDB.Collection(collName)
+	targetColl := targetDB.Collection(collName)
+	if err := calculateCollectionCounts(ctx, sourceColl, targetColl, result); err != nil {
+		result.Error = err.Error()
+		return result, err
+	}
+
+	cmp := newDocumentComparer(opts, sourceDB.Name(), collName)
+	counts, err := compareSample(ctx, sourceColl, targetColl, opts.sampleSize(result.SourceCount), opts.BatchSize, cmp)
+	result.DiffsOmitted = cmp.diffs.omittedCount()
+	if err != nil {
+		result.Error = fmt.Sprintf("error comparing sample: %v", err)
+		return result, fmt.Errorf("%s", result.Error)
+	}
+
+	result.MissingInTarget = counts.missingInTarget
+	result.DifferentDocuments = counts.different
+	result.Sample = newSampleEstimate(counts.docCount, counts.missingInTarget+counts.different, result.SourceCount)
+	fmt.Printf("    %s: %s\n", collName, result.Sample)
+	return result, nil
+}
+
+// compareSample looks up a random sample of source documents on the target. $sample may return a document
+// more than once, so repeated documents are skipped.
+func compareSample(
+	ctx context.Context,
+	sourceColl, targetColl *mongo.Collection,
+	size int64,
+	batchSize int,
+	cmp *documentComparer,
+) (DocumentProcessingResult, error) {
+	var counts DocumentProcessingResult
+	if size == 0 {
+		return counts, nil
+	}
+	cursor, err := sampleDocuments(ctx, sourceColl, size, batchSize)
+	if err != nil {
+		return counts, err
+	}
+	defer cursor.Close(ctx)
+
+	seen := make(map[string]bool, size)
+	for cursor.Next(ctx) {
+		var doc bson.D
+		if err := cursor.Decode(&doc); err != nil {
+			return counts, fmt.Errorf("failed to decode document: %v", err)
+		}
+		if err := compareSampledDocument(ctx, doc, seen, targetColl, cmp, &counts); err != nil {
+			return counts, err
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return counts, fmt.Errorf("cursor error: %v", err)
+	}
+	return counts, nil
+}
+
+// compareSampledDocument looks up a sampled document on the target, unless it was sampled before
+func compareSampledDocument(
+	ctx context.Context,
+	doc bson.D,
+	seen map[string]bool,
+	targetColl *mongo.Collection,
+	cmp *documentComparer,
+	counts *DocumentProcessingResult,
+) error {
+	key := bsonFallbackString(documentID(doc))
+	if seen[key] {
+		return nil
+	}
+	seen[key] = true
+	counts.docCount++
+	return processSourceDocument(ctx, doc, targetColl, cmp, counts)
+}
+
+// sampleDocuments opens a cursor over random documents of a collection
+func sampleDocuments(ctx context.Context, coll *mongo.Collection, size int64, batchSize int) (*mongo.Cursor, error) {
+	pipeline := mongo.Pipeline{{{Key: "$sample", Value: bson.D{{Key: "size", Value: size}}}}}
+	cursor, err := coll.Aggregate(ctx, pipeline, options.Aggregate().SetBatchSize(int32(max(batchSize, 1))).SetAllowDiskUse(true))
+	if err != nil {
+		return nil, fmt.Errorf("failed to sample source collection: %v", err)
+	}
+	return cursor, nil
+}
@@ -0,0 +1,130 @@
+package mongodb
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func TestNewSampleEstimate(t *testing.T) {
+	tests := []struct {
+		name                     string
+		size, differences, total int64
+		rate, lower, upper       float64
+	}{
+		// The Wilson interval does not collapse to zero when no sampled document differs
+		{name: "No differences", size: 100, total: 10000, upper: 0.0366},
+		{name: "Some differences", size: 100, differences: 5, total: 10000, rate: 0.05, lower: 0.0216, upper: 0.1113},
+		// A larger share of the collection narrows the interval
+		{name: "Half of the collection", size: 50, differences: 1, total: 100, rate: 0.02, lower: 0.0055, upper: 0.0704},
+		{name: "Whole collection", size: 1000, differences: 5, total: 1000, rate: 0.005, lower: 0.005, upper: 0.005},
+		{name: "Empty collection", upper: 0},
+		{name: "Nothing sampled", total: 10, upper: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			estimate := newSampleEstimate(tt.size, tt.differences, tt.total)
+			assert.Equal(t, tt.size, estimate.Size)
+			assert.Equal(t, tt.differences, estimate.Differences)
+			assert.Equal(t, 0.95, estimate.Confidence)
+			assert.InDelta(t, tt.rate, estimate.Rate, 0.0001)
+			assert.InDelta(t, tt.lower, estimate.Lower, 0.0001)
+			assert.InDelta(t, tt.upper, estimate.Upper, 0.0001)
+		})
+	}
+}
+
+func TestSampleEstimateString(t *testing.T) {
+	assert.Equal(t, "5.00% of 100 sampled documents differ, 95% confidence interval 2.16% to 11.13%",
+		newSampleEstimate(100, 5, 10000).String())
+}
+
+func TestSampleSize(t *testing.T) {
+	tests := []struct {
+		name  string
+		opts  CompareOptions
+		count int64
+		want  int64
+	}{
+		{name: "Fixed size", opts: CompareOptions{SampleSize: 500}, count: 10000, want: 500},
+		{name: "Fixed size beyond the collection", opts: CompareOptions{SampleSize: 500}, count: 20, want: 20},
+		{name: "Percentage", opts: CompareOptions{SamplePercent: 1.5}, count: 10000, want: 150},
+		{name: "Percentage rounds up", opts: CompareOptions{SamplePercent: 1}, count: 10, want: 1},
+		{name: "Whole collection", opts: CompareOptions{SamplePercent: 100}, count: 10, want: 10},
+		{name: "Empty collection", opts: CompareOptions{SamplePercent: 10}, want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.True(t, tt.opts.sampled())
+			assert.Equal(t, tt.want, tt.opts.sampleSize(tt.count))
+		})
+	}
+	assert.False(t, CompareOptions{}.sampled())
+}
+
+func TestCompareCollectionSample(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	sourceContainer, sourceConnString := setupMongoContainer(t)
+	defer sourceContainer.Terminate(context.Background())
+
+	targetContainer, targetConnString := setupMongoContainer(t)
+	defer targetContainer.Terminate(context.Background())
+
+	ctx := context.Background()
+	sourceClient, err := mongo.Connect(ctx, options.Client().ApplyURI(sourceConnString))
+	require.NoError(t, err)
+	defer sourceClient.Disconnect(ctx)
+
+	targetClient, err := mongo.Connect(ctx, options.Client().ApplyURI(targetConnString))
+	require.NoError(t, err)
+	defer targetClient.Disconnect(ctx)
+
+	sourceDB := sourceClient.Database("sampledb")
+	targetDB := targetClient.Database("sampledb")
+
+	docs := make([]interface{}, 0, 1000)
+	for i := 0; i < 1000; i++ {
+		docs = append(docs, bson.D{{Key: "_id", Value: i}, {Key: "name", Value: fmt.Sprintf("doc %d", i)}})
+	}
+	_, err = sourceDB.Collection("items").InsertMany(ctx, docs)
+	require.NoError(t, err)
+	_, err = targetDB.Collection("items").InsertMany(ctx, docs)
+	require.NoError(t, err)
+
+	targetColl := targetDB.Collection("items")
+	_, err = targetColl.DeleteMany(ctx, bson.M{"_id": bson.M{"$lt": 50}})
+	require.NoError(t, err)
+	_, err = targetColl.UpdateMany(ctx, bson.M{"_id": bson.M{"$gte": 950}}, bson.M{"$set": bson.M{"name": "changed"}})
+	require.NoError(t, err)
+
+	// Sampling every document finds every difference and an exact rate
+	opts := CompareOptions{BatchSize: 100, Detailed: true, SamplePercent: 100}
+	result, err := compareCollection(ctx, sourceDB, targetDB, "items", nil, opts)
+	require.NoError(t, err)
+	assert.Equal(t, int64(50), result.MissingInTarget)
+	assert.Equal(t, int64(50), result.DifferentDocuments)
+	require.NotNil(t, result.Sample)
+	assert.Equal(t, int64(1000), result.Sample.Size)
+	assert.InDelta(t, 0.1, result.Sample.Lower, 0.0001)
+	assert.InDelta(t, 0.1, result.Sample.Upper, 0.0001)
+
+	// A smaller sample bounds the rate of the whole collection
+	opts = CompareOptions{BatchSize: 100, Detailed: true, SampleSize: 200}
+	result, err = compareCollection(ctx, sourceDB, targetDB, "items", nil, opts)
+	require.NoError(t, err)
+	require.NotNil(t, result.Sample)
+	assert.LessOrEqual(t, result.Sample.Size, int64(200))
+	assert.Equal(t, result.MissingInTarget+result.DifferentDocuments, result.Sample.Differences)
+	assert.Less(t, result.Sample.Lower, result.Sample.Upper)
+	assert.Equal(t, int64(1000), result.SourceCount)
+	assert.Equal(t, int64(950), result.TargetCount)
+}
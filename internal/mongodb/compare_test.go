@@ -41,11 +41,12 @@ func setupTestData(t *testing.T, connString string, dbName, collName string) *mo
 	// Create a test collection with documents
 	coll := client.Database(dbName).Collection(collName)
 
-	// Insert documents
+	// Insert documents, with the same timestamps in every database so that they compare equal
+	lastModified := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
 	docs := []interface{}{
-		bson.M{"_id": 1, "name": "Document 1", "value": 100, "lastModified": time.Now()},
-		bson.M{"_id": 2, "name": "Document 2", "value": 200, "lastModified": time.Now()},
-		bson.M{"_id": 3, "name": "Document 3", "value": 300, "lastModified": time.Now()},
+		bson.M{"_id": 1, "name": "Document 1", "value": 100, "lastModified": lastModified},
+		bson.M{"_id": 2, "name": "Document 2", "value": 200, "lastModified": lastModified},
+		bson.M{"_id": 3, "name": "Document 3", "value": 300, "lastModified": lastModified},
 	}
 
 	_, err = coll.InsertMany(ctx, docs)
@@ -169,88 +170,29 @@ func TestCompareCollectionData(t *testing.T) {
 	assert.Equal(t, int64(1), result.MissingInTarget)    // Document 4
 	assert.Equal(t, int64(1), result.DifferentDocuments) // Document 2
 	// Note: MissingInSource field was removed as it's no longer calculated
-}
 
-func TestBsonEqual(t *testing.T) {
-	tests := []struct {
-		name     string
-		a        bson.M
-		b        bson.M
-		expected bool
-	}{
-		{
-			name:     "Empty documents",
-			a:        bson.M{},
-			b:        bson.M{},
-			expected: true,
-		},
-		{
-			name:     "Same simple document",
-			a:        bson.M{"foo": "bar", "num": 42},
-			b:        bson.M{"foo": "bar", "num": 42},
-			expected: true,
-		},
-		{
-			name:     "Different values",
-			a:        bson.M{"foo": "bar", "num": 42},
-			b:        bson.M{"foo": "bar", "num": 43},
-			expected: false,
-		},
-		{
-			name:     "Different keys",
-			a:        bson.M{"foo": "bar", "num": 42},
-			b:        bson.M{"foo": "bar", "number": 42},
-			expected: false,
-		},
-		{
-			name:     "Nested documents equal",
-			a:        bson.M{"foo": "bar", "nested": bson.M{"a": 1, "b": 2}},
-			b:        bson.M{"foo": "bar", "nested": bson.M{"a": 1, "b": 2}},
-			expected: true,
-		},
-		{
-			name:     "Nested documents different",
-			a:        bson.M{"foo": "bar", "nested": bson.M{"a": 1, "b": 2}},
-			b:        bson.M{"foo": "bar", "nested": bson.M{"a": 1, "b": 3}},
-			expected: false,
-		},
-		{
-			name:     "Array equal",
-			a:        bson.M{"foo": "bar", "arr": []interface{}{1, 2, 3}},
-			b:        bson.M{"foo": "bar", "arr": []interface{}{1, 2, 3}},
-			expected: true,
-		},
-		{
-			name:     "Array different values",
-			a:        bson.M{"foo": "bar", "arr": []interface{}{1, 2, 3}},
-			b:        bson.M{"foo": "bar", "arr": []interface{}{1, 2, 4}},
-			expected: false,
-		},
-		{
-			name:     "Array different length",
-			a:        bson.M{"foo": "bar", "arr": []interface{}{1, 2, 3}},
-			b:        bson.M{"foo": "bar", "arr": []interface{}{1, 2}},
-			expected: false,
-		},
-		{
-			name:     "Complex nested structure",
-			a:        bson.M{"foo": "bar", "nested": bson.M{"a": 1, "b": 2, "arr": []interface{}{bson.M{"x": 1}, bson.M{"y": 2}}}},
-			b:        bson.M{"foo": "bar", "nested": bson.M{"a": 1, "b": 2, "arr": []interface{}{bson.M{"x": 1}, bson.M{"y": 2}}}},
-			expected: true,
-		},
-		{
-			name:     "Complex nested structure different",
-			a:        bson.M{"foo": "bar", "nested": bson.M{"a": 1, "b": 2, "arr": []interface{}{bson.M{"x": 1}, bson.M{"y": 2}}}},
-			b:        bson.M{"foo": "bar", "nested": bson.M{"a": 1, "b": 2, "arr": []interface{}{bson.M{"x": 1}, bson.M{"y": 3}}}},
-			expected: false,
-		},
+	// 4. Touch a timestamp in target, which only ignored fields leave out of the comparison
+	_, err = targetColl.UpdateOne(ctx, bson.M{"_id": 3}, bson.M{"$set": bson.M{"lastModified": time.Now()}})
+	assert.NoError(t, err)
+
+	result, err = CompareCollectionData(ctx, sourceDB, targetDB, collName, 100, true)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), result.DifferentDocuments) // Documents 2 and 3
+
+	opts := CompareOptions{BatchSize: 100, Detailed: true, IgnoreFields: []IgnoreRule{{Path: "lastModified"}}}
+	for _, method := range []string{CompareMethodLookup, CompareMethodMerge, CompareMethodHash} {
+		opts.Method = method
+		result, err = compareCollection(ctx, sourceDB, targetDB, collName, nil, opts)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), result.DifferentDocuments, method) // Document 2
 	}
 
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			result := bsonEqual(tt.a, tt.b)
-			assert.Equal(t, tt.expected, result)
-		})
+	opts.IgnoreFields = append(opts.IgnoreFields, IgnoreRule{Namespace: "testdb.*", Path: "value"}, IgnoreRule{Path: "modif*"})
+	for _, method := range []string{CompareMethodLookup, CompareMethodMerge, CompareMethodHash} {
+		opts.Method = method
+		result, err = compareCollection(ctx, sourceDB, targetDB, collName, nil, opts)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(0), result.DifferentDocuments, method)
 	}
 }
 
@@ -413,7 +355,8 @@ func TestCompareCollections(t *testing.T) {
 	assert.NoError(t, err)
 
 	// Test CompareCollections with specified collections
-	results, err := CompareCollections(ctx, sourceClient, targetClient, dbName, []string{collName1, collName2}, nil, 100, true)
+	results, err := CompareCollections(ctx, sourceClient, targetClient, dbName, []string{collName1, collName2}, nil,
+		CompareOptions{BatchSize: 100, Detailed: true})
 	assert.NoError(t, err)
 	assert.Equal(t, 2, len(results))
 
@@ -447,7 +390,8 @@ func TestCompareCollections(t *testing.T) {
 	assert.Equal(t, int64(0), coll2Result.DifferentDocuments)
 
 	// Test CompareCollections with an exclusion list
-	results, err = CompareCollections(ctx, sourceClient, targetClient, dbName, nil, []string{collName2}, 100, true)
+	results, err = CompareCollections(ctx, sourceClient, targetClient, dbName, nil, []string{collName2},
+		CompareOptions{BatchSize: 100, Detailed: true})
 	assert.NoError(t, err)
 	assert.Equal(t, 1, len(results))
 	assert.Equal(t, collName1, results[0].Collection)
@@ -572,7 +516,7 @@ func TestCompareCollectionSet(t *testing.T) {
 	results := []*ComparisonResult{}
 	collections := []string{"coll1", "coll2"}
 
-	results, err = compareCollectionSet(ctx, sourceDB, targetDB, collections, 100, true, results)
+	results, err = compareCollectionSet(ctx, sourceDB, targetDB, collections, CompareOptions{BatchSize: 100, Detailed: true}, results)
 	assert.NoError(t, err)
 	assert.Equal(t, 2, len(results))
 
@@ -604,7 +548,7 @@ func TestCompareCollectionSet(t *testing.T) {
 
 	// Test with count-only
 	results = []*ComparisonResult{}
-	results, err = compareCollectionSet(ctx, sourceDB, targetDB, collections, 100, false, results)
+	results, err = compareCollectionSet(ctx, sourceDB, targetDB, collections, CompareOptions{BatchSize: 100}, results)
 	assert.NoError(t, err)
 	assert.Equal(t, 2, len(results))
 
@@ -0,0 +1,445 @@
+package mongodb
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	gridFSFilesSuffix  = ".files"
+	gridFSChunksSuffix = ".chunks"
+
+	// gridFSUploadDateField is used for incremental copies since GridFS files are immutable once uploaded
+	gridFSUploadDateField = "uploadDate"
+)
+
+// SplitGridFSBuckets separates GridFS buckets from regular collections.
+// A bucket is recognized when both <bucket>.files and <bucket>.chunks are present.
+// It returns the bucket names and the remaining collections in their original order.
+func SplitGridFSBuckets(collections []string) (buckets, others []string) {
+	present := make(map[string]bool, len(collections))
+	for _, collName := range collections {
+		present[collName] = true
+	}
+
+	seen := make(map[string]bool)
+	for _, collName := range collections {
+		bucket, ok := gridFSBucketName(collName)
+		if !ok || !present[bucket+gridFSFilesSuffix] || !present[bucket+gridFSChunksSuffix] {
+			others = append(others, collName)
+			continue
+		}
+		if !seen[bucket] {
+			seen[bucket] = true
+			buckets = append(buckets, bucket)
+		}
+	}
+
+	return buckets, others
+}
+
+// gridFSBucketName returns the bucket name for a GridFS files or chunks collection
+func gridFSBucketName(collName string) (string, bool) {
+	for _, suffix := range []string{gridFSFilesSuffix, gridFSChunksSuffix} {
+		if bucket := strings.TrimSuffix(collName, suffix); bucket != collName && bucket != "" {
+			return bucket, true
+		}
+	}
+	return "", false
+}
+
+// CopyGridFSBucket copies a GridFS bucket file by file.
+// The chunks of each file are copied in order before its files document is written,
+// so a file never becomes visible on the target before all of its data is present.
+func CopyGridFSBucket(
+	ctx context.Context,
+	sourceDB, targetDB *mongo.Database,
+	bucket string,
+	incremental bool,
+	batchSize int,
+	retryAttempts int,
+) error {
+	fmt.Printf("  Copying GridFS bucket: %s\n", bucket)
+
+	filesColl := bucket + gridFSFilesSuffix
+	chunksColl := bucket + gridFSChunksSuffix
+
+	filter, err := prepareFilterWithTarget(ctx, sourceDB, targetDB, filesColl, incremental, gridFSUploadDateField)
+	if err != nil {
+		return err
+	}
+
+	findOptions := options.Find().
+		SetSort(bson.D{{Key: "_id", Value: 1}}).
+		SetBatchSize(int32(batchSize)).
+		SetNoCursorTimeout(true)
+	cursor, err := sourceDB.Collection(filesColl).Find(ctx, filter, findOptions)
+	if err != nil {
+		return fmt.Errorf("failed to query GridFS files collection %s: %w", filesColl, err)
+	}
+	defer cursor.Close(ctx)
+
+	copier := &gridFSCopier{
+		sourceChunks:  sourceDB.Collection(chunksColl),
+		targetChunks:  targetDB.Collection(chunksColl),
+		targetFiles:   targetDB.Collection(filesColl),
+		incremental:   incremental,
+		batchSize:     batchSize,
+		retryAttempts: retryAttempts,
+	}
+	if err := copier.copyFiles(ctx, cursor, bucket); err != nil {
+		return err
+	}
+
+	for _, collName := range []string{filesColl, chunksColl} {
+		if err := CopyCollectionIndexes(context.Background(), sourceDB, targetDB, collName); err != nil {
+			fmt.Printf("  Warning: Failed to copy indexes for collection %s: %v\n", collName, err)
+		}
+	}
+
+	return nil
+}
+
+// gridFSCopier copies GridFS files together with their chunks
+type gridFSCopier struct {
+	sourceChunks  *mongo.Collection
+	targetChunks  *mongo.Collection
+	targetFiles   *mongo.Collection
+	incremental   bool
+	batchSize     int
+	retryAttempts int
+}
+
+// copyFiles copies every file returned by the cursor
+func (c *gridFSCopier) copyFiles(ctx context.Context, cursor *mongo.Cursor, bucket string) error {
+	var fileCount, chunkCount int64
+	lastProgressTime := time.Now()
+
+	for cursor.Next(ctx) {
+		var fileDoc bson.M
+		if err := cursor.Decode(&fileDoc); err != nil {
+			return fmt.Errorf("failed to decode GridFS file document: %w", err)
+		}
+
+		copied, err := c.copyChunks(ctx, fileDoc["_id"])
+		if err != nil {
+			return fmt.Errorf("failed to copy chunks of GridFS file %v: %w", fileDoc["_id"], err)
+		}
+		chunkCount += copied
+
+		// The files document is written last so the file is only visible once complete
+		if err := insertBatch(ctx, c.targetFiles, []interface{}{fileDoc}, c.incremental, c.retryAttempts); err != nil {
+			return fmt.Errorf("failed to write GridFS file document %v: %w", fileDoc["_id"], err)
+		}
+		fileCount++
+
+		if shouldUpdateProgress(lastProgressTime, 10*time.Second) {
+			fmt.Printf("    Copied %d files (%d chunks) of GridFS bucket %s\n", fileCount, chunkCount, bucket)
+			lastProgressTime = time.Now()
+		}
+	}
+
+	if err := cursor.Err(); err != nil {
+		return fmt.Errorf("cursor error: %w", err)
+	}
+
+	fmt.Printf("  Completed copying GridFS bucket: %s (%d files, %d chunks)\n", bucket, fileCount, chunkCount)
+	return nil
+}
+
+// copyChunks copies the chunks of a single file in chunk order
+func (c *gridFSCopier) copyChunks(ctx context.Context, fileID interface{}) (int64, error) {
+	findOptions := options.Find().
+		SetSort(bson.D{{Key: "n", Value: 1}}).
+		SetBatchSize(int32(c.batchSize))
+	cursor, err := c.sourceChunks.Find(ctx, bson.M{"files_id": fileID}, findOptions)
+	if err != nil {
+		return 0, fmt.Errorf("failed to query chunks: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	var batch []interface{}
+	var count int64
+	for cursor.Next(ctx) {
+		var chunk bson.M
+		if err := cursor.Decode(&chunk); err != nil {
+			return count, fmt.Errorf("failed to decode chunk: %w", err)
+		}
+		if batch, err = c.flushChunks(ctx, append(batch, chunk), &count, false); err != nil {
+			return count, err
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return count, fmt.Errorf("cursor error: %w", err)
+	}
+
+	_, err = c.flushChunks(ctx, batch, &count, true)
+	return count, err
+}
+
+// flushChunks inserts the batch of chunks once it is full, or when it holds the last chunks of a file
+func (c *gridFSCopier) flushChunks(ctx context.Context, batch []interface{}, count *int64, last bool) ([]interface{}, error) {
+	if len(batch) == 0 || (!last && len(batch) < c.batchSize) {
+		return batch, nil
+	}
+	if err := insertBatch(ctx, c.targetChunks, batch, c.incremental, c.retryAttempts); err != nil {
+		return batch, err
+	}
+	*count += int64(len(batch))
+	return batch[:0], nil
+}
+
+// gridFSFileDigest summarizes the content of a GridFS file
+type gridFSFileDigest struct {
+	ChunkCount int64
+	Length     int64
+	Hash       string
+}
+
+// CompareGridFSBucket compares a GridFS bucket file by file.
+// Files documents are compared like documents of other collections. Instead of diffing raw chunk documents,
+// it compares the chunk count, length and content digest (MD5 or SHA-256) of each file.
+func CompareGridFSBucket(
+	ctx context.Context,
+	sourceDB, targetDB *mongo.Database,
+	bucket string,
+	opts CompareOptions,
+) (*ComparisonResult, error) {
+	fmt.Printf("  GridFS comparison of bucket: %s (%s)\n", bucket, gridFSHashName(opts.GridFSHash))
+
+	filesColl := bucket + gridFSFilesSuffix
+	result := &ComparisonResult{
+		Database:   sourceDB.Name(),
+		Collection: filesColl,
+		GridFS:     true,
+	}
+
+	if err := calculateCollectionCounts(ctx, sourceDB.Collection(filesColl), targetDB.Collection(filesColl), result); err != nil {
+		result.Error = err.Error()
+		return result, err
+	}
+
+	if err := compareGridFSFiles(ctx, sourceDB, targetDB, bucket, opts, result); err != nil {
+		result.Error = fmt.Sprintf("error comparing GridFS bucket: %v", err)
+		return result, fmt.Errorf("%s", result.Error)
+	}
+
+	return result, nil
+}
+
+// compareGridFSFiles compares every source file with the file of the same _id in the target, then counts the
+// files that only exist in the target
+func compareGridFSFiles(
+	ctx context.Context,
+	sourceDB, targetDB *mongo.Database,
+	bucket string,
+	opts CompareOptions,
+	result *ComparisonResult,
+) error {
+	filesColl := bucket + gridFSFilesSuffix
+	cmp := newDocumentComparer(opts, sourceDB.Name(), filesColl)
+	defer func() { result.DiffsOmitted = cmp.diffs.omittedCount() }()
+
+	findOptions := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}).SetBatchSize(int32(opts.BatchSize))
+	cursor, err := sourceDB.Collection(filesColl).Find(ctx, bson.M{}, findOptions)
+	if err != nil {
+		return fmt.Errorf("failed to query source files collection: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var fileDoc bson.D
+		if err := cursor.Decode(&fileDoc); err != nil {
+			return fmt.Errorf("failed to decode file document: %w", err)
+		}
+		if err := compareGridFSFileDocument(ctx, sourceDB, targetDB, bucket, fileDoc, opts.GridFSHash, cmp, result); err != nil {
+			return err
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return err
+	}
+
+	return countGridFSExtraFiles(ctx, sourceDB, targetDB, filesColl, opts.BatchSize, cmp, result)
+}
+
+// compareGridFSFileDocument looks up a source file in the target and compares their files documents, then their content
+func compareGridFSFileDocument(
+	ctx context.Context,
+	sourceDB, targetDB *mongo.Database,
+	bucket string,
+	fileDoc bson.D,
+	hashName string,
+	cmp *documentComparer,
+	result *ComparisonResult,
+) error {
+	fileID := documentID(fileDoc)
+	var targetDoc bson.D
+	err := targetDB.Collection(bucket+gridFSFilesSuffix).FindOne(ctx, bson.M{"_id": fileID}).Decode(&targetDoc)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		result.MissingInTarget++
+		return cmp.diffs.missing(fileID)
+	} else if err != nil {
+		return fmt.Errorf("error querying target files collection for _id %v: %w", fileID, err)
+	}
+
+	equal, err := cmp.equal(fileDoc, targetDoc)
+	if err != nil {
+		return err
+	}
+	if !equal {
+		result.DifferentDocuments++
+		return nil
+	}
+	return compareGridFSContent(ctx, sourceDB, targetDB, bucket, fileID, hashName, cmp, result)
+}
+
+// compareGridFSContent compares the content of a file on both sides. A file whose content differs is recorded with
+// its digests under a content field.
+func compareGridFSContent(
+	ctx context.Context,
+	sourceDB, targetDB *mongo.Database,
+	bucket string,
+	fileID interface{},
+	hashName string,
+	cmp *documentComparer,
+	result *ComparisonResult,
+) error {
+	chunksColl := bucket + gridFSChunksSuffix
+	sourceDigest, targetDigest, err := compareGridFSFile(ctx, sourceDB.Collection(chunksColl), targetDB.Collection(chunksColl),
+		fileID, hashName)
+	if err != nil || sourceDigest == targetDigest {
+		return err
+	}
+	result.DifferentDocuments++
+	return cmp.diffs.different(sourceDigest.document(fileID, hashName), targetDigest.document(fileID, hashName))
+}
+
+// compareGridFSFile computes the chunk count, length and digest of a file on both sides
+func compareGridFSFile(
+	ctx context.Context,
+	sourceChunks, targetChunks *mongo.Collection,
+	fileID interface{},
+	hashName string,
+) (gridFSFileDigest, gridFSFileDigest, error) {
+	sourceDigest, err := digestGridFSFile(ctx, sourceChunks, fileID, hashName)
+	if err != nil {
+		return sourceDigest, gridFSFileDigest{}, fmt.Errorf("failed to digest source file %v: %w", fileID, err)
+	}
+
+	targetDigest, err := digestGridFSFile(ctx, targetChunks, fileID, hashName)
+	if err != nil {
+		return sourceDigest, targetDigest, fmt.Errorf("failed to digest target file %v: %w", fileID, err)
+	}
+
+	return sourceDigest, targetDigest, nil
+}
+
+// document returns the digest of a file as a document for the diff file, with the digest under a content field
+func (d gridFSFileDigest) document(fileID interface{}, hashName string) bson.D {
+	return bson.D{{Key: "_id", Value: fileID}, {Key: "content", Value: bson.D{
+		{Key: "chunks", Value: d.ChunkCount},
+		{Key: "length", Value: d.Length},
+		{Key: gridFSHashName(hashName), Value: d.Hash},
+	}}}
+}
+
+// countGridFSExtraFiles counts the target files that do not exist in the source
+func countGridFSExtraFiles(
+	ctx context.Context,
+	sourceDB, targetDB *mongo.Database,
+	filesColl string,
+	batchSize int,
+	cmp *documentComparer,
+	result *ComparisonResult,
+) error {
+	findOptions := options.Find().
+		SetSort(bson.D{{Key: "_id", Value: 1}}).
+		SetProjection(bson.M{"_id": 1}).
+		SetBatchSize(int32(batchSize))
+	cursor, err := targetDB.Collection(filesColl).Find(ctx, bson.M{}, findOptions)
+	if err != nil {
+		return fmt.Errorf("failed to query target files collection: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var fileDoc bson.D
+		if err := cursor.Decode(&fileDoc); err != nil {
+			return fmt.Errorf("failed to decode file document: %w", err)
+		}
+		fileID := documentID(fileDoc)
+		count, err := sourceDB.Collection(filesColl).CountDocuments(ctx, bson.M{"_id": fileID}, options.Count().SetLimit(1))
+		if err != nil {
+			return fmt.Errorf("error querying source files collection for _id %v: %w", fileID, err)
+		}
+		if count > 0 {
+			continue
+		}
+		result.ExtraInTarget++
+		if err := cmp.diffs.extra(fileID); err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
+
+// digestGridFSFile streams the chunks of a file in order and computes its digest
+func digestGridFSFile(ctx context.Context, chunks *mongo.Collection, fileID interface{}, hashName string) (gridFSFileDigest, error) {
+	findOptions := options.Find().
+		SetSort(bson.D{{Key: "n", Value: 1}}).
+		SetProjection(bson.M{"data": 1})
+	cursor, err := chunks.Find(ctx, bson.M{"files_id": fileID}, findOptions)
+	if err != nil {
+		return gridFSFileDigest{}, err
+	}
+	defer cursor.Close(ctx)
+
+	hasher := newGridFSHash(hashName)
+	var digest gridFSFileDigest
+	for cursor.Next(ctx) {
+		var chunk struct {
+			Data []byte `bson:"data"`
+		}
+		if err := cursor.Decode(&chunk); err != nil {
+			return digest, fmt.Errorf("failed to decode chunk: %w", err)
+		}
+		hasher.Write(chunk.Data)
+		digest.ChunkCount++
+		digest.Length += int64(len(chunk.Data))
+	}
+	if err := cursor.Err(); err != nil {
+		return digest, err
+	}
+
+	digest.Hash = hex.EncodeToString(hasher.Sum(nil))
+	return digest, nil
+}
+
+// newGridFSHash returns the hash function used to digest GridFS files
+func newGridFSHash(name string) hash.Hash {
+	if strings.EqualFold(name, "md5") {
+		// MD5 matches the legacy md5 field of GridFS files documents
+		return md5.New()
+	}
+	return sha256.New()
+}
+
+// gridFSHashName returns the normalized name of the GridFS digest algorithm
+func gridFSHashName(name string) string {
+	if strings.EqualFold(name, "md5") {
+		return "md5"
+	}
+	return "sha256"
+}
@@ -0,0 +1,167 @@
+package mongodb
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/gridfs"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// TestSplitGridFSBuckets tests recognizing GridFS files/chunks pairs
+func TestSplitGridFSBuckets(t *testing.T) {
+	tests := []struct {
+		name            string
+		collections     []string
+		expectedBuckets []string
+		expectedOthers  []string
+	}{
+		{
+			name:            "Default bucket",
+			collections:     []string{"users", "fs.files", "fs.chunks"},
+			expectedBuckets: []string{"fs"},
+			expectedOthers:  []string{"users"},
+		},
+		{
+			name:            "Multiple buckets",
+			collections:     []string{"images.chunks", "fs.files", "images.files", "fs.chunks"},
+			expectedBuckets: []string{"images", "fs"},
+			expectedOthers:  nil,
+		},
+		{
+			name:            "Files without chunks",
+			collections:     []string{"fs.files", "orders"},
+			expectedBuckets: nil,
+			expectedOthers:  []string{"fs.files", "orders"},
+		},
+		{
+			name:            "Suffix only",
+			collections:     []string{".files", ".chunks"},
+			expectedBuckets: nil,
+			expectedOthers:  []string{".files", ".chunks"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buckets, others := SplitGridFSBuckets(tt.collections)
+			assert.Equal(t, tt.expectedBuckets, buckets)
+			assert.Equal(t, tt.expectedOthers, others)
+		})
+	}
+}
+
+// TestGridFSHash tests selecting the GridFS digest algorithm
+func TestGridFSHash(t *testing.T) {
+	assert.Equal(t, "md5", gridFSHashName("MD5"))
+	assert.Equal(t, "sha256", gridFSHashName("sha256"))
+	assert.Equal(t, "sha256", gridFSHashName(""))
+
+	assert.Equal(t, 16, newGridFSHash("md5").Size())
+	assert.Equal(t, 32, newGridFSHash("sha256").Size())
+}
+
+// TestGridFSCopyAndCompare tests copying and comparing a GridFS bucket
+func TestGridFSCopyAndCompare(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	sourceContainer, sourceConnString := setupMongoContainer(t)
+	defer sourceContainer.Terminate(context.Background())
+
+	targetContainer, targetConnString := setupMongoContainer(t)
+	defer targetContainer.Terminate(context.Background())
+
+	ctx := context.Background()
+	sourceClient, err := mongo.Connect(ctx, options.Client().ApplyURI(sourceConnString))
+	require.NoError(t, err)
+	defer sourceClient.Disconnect(ctx)
+
+	targetClient, err := mongo.Connect(ctx, options.Client().ApplyURI(targetConnString))
+	require.NoError(t, err)
+	defer targetClient.Disconnect(ctx)
+
+	sourceDB := sourceClient.Database("gridfsdb")
+	targetDB := targetClient.Database("gridfsdb")
+
+	// Upload files spanning several chunks
+	bucket, err := gridfs.NewBucket(sourceDB, options.GridFSBucket().SetChunkSizeBytes(1024))
+	require.NoError(t, err)
+	for i, name := range []string{"a.bin", "b.bin", "c.bin"} {
+		content := bytes.Repeat([]byte{byte(i + 1)}, 5000)
+		_, err := bucket.UploadFromStream(name, bytes.NewReader(content))
+		require.NoError(t, err)
+	}
+
+	err = CopyGridFSBucket(ctx, sourceDB, targetDB, "fs", false, 2, 3)
+	require.NoError(t, err)
+
+	chunkCount, err := targetDB.Collection("fs.chunks").CountDocuments(ctx, bson.M{})
+	require.NoError(t, err)
+	assert.Equal(t, int64(15), chunkCount)
+
+	opts := CompareOptions{BatchSize: 10, Detailed: true, GridFSHash: "sha256"}
+	result, err := CompareGridFSBucket(ctx, sourceDB, targetDB, "fs", opts)
+	require.NoError(t, err)
+	assert.True(t, result.GridFS)
+	assert.Equal(t, int64(3), result.SourceCount)
+	assert.Equal(t, int64(0), result.MissingInTarget)
+	assert.Equal(t, int64(0), result.DifferentDocuments)
+
+	// Corrupt one chunk in the target and drop one file document
+	_, err = targetDB.Collection("fs.chunks").UpdateOne(ctx, bson.M{"n": 2}, bson.M{"$set": bson.M{"data": []byte("corrupted")}})
+	require.NoError(t, err)
+	var firstFile bson.M
+	err = targetDB.Collection("fs.files").FindOne(ctx, bson.M{"filename": "c.bin"}).Decode(&firstFile)
+	require.NoError(t, err)
+	_, err = targetDB.Collection("fs.files").DeleteOne(ctx, bson.M{"_id": firstFile["_id"]})
+	require.NoError(t, err)
+
+	result, err = CompareGridFSBucket(ctx, sourceDB, targetDB, "fs", opts)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), result.MissingInTarget)
+	assert.Equal(t, int64(1), result.DifferentDocuments)
+
+	// Files documents are compared like documents, and files only in the target are counted
+	_, err = targetDB.Collection("fs.files").UpdateOne(ctx, bson.M{"filename": "b.bin"},
+		bson.M{"$set": bson.M{"metadata": bson.M{"owner": "target"}}})
+	require.NoError(t, err)
+	targetBucket, err := gridfs.NewBucket(targetDB, options.GridFSBucket().SetChunkSizeBytes(1024))
+	require.NoError(t, err)
+	_, err = targetBucket.UploadFromStream("d.bin", bytes.NewReader([]byte("only in target")))
+	require.NoError(t, err)
+
+	var out bytes.Buffer
+	opts.Diffs = NewDiffWriter(&out, 0)
+	result, err = CompareGridFSBucket(ctx, sourceDB, targetDB, "fs", opts)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), result.MissingInTarget)
+	assert.Equal(t, int64(2), result.DifferentDocuments)
+	assert.Equal(t, int64(1), result.ExtraInTarget)
+	assert.Equal(t, int64(4), opts.Diffs.Written())
+	assert.Contains(t, out.String(), `"path":"metadata"`)
+	assert.Contains(t, out.String(), `"path":"content.sha256"`)
+
+	// Ignored fields are left out of the files documents
+	opts.Diffs = nil
+	opts.IgnoreFields = []IgnoreRule{{Path: "metadata"}}
+	result, err = CompareGridFSBucket(ctx, sourceDB, targetDB, "fs", opts)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), result.DifferentDocuments)
+}
+
+// TestGridFSFileDigestDocument tests the document a file digest is recorded as in the diff file
+func TestGridFSFileDigestDocument(t *testing.T) {
+	digest := gridFSFileDigest{ChunkCount: 2, Length: 1500, Hash: "abc"}
+	assert.Equal(t, bson.D{{Key: "_id", Value: "f1"}, {Key: "content", Value: bson.D{
+		{Key: "chunks", Value: int64(2)},
+		{Key: "length", Value: int64(1500)},
+		{Key: "md5", Value: "abc"},
+	}}}, digest.document("f1", "MD5"))
+}
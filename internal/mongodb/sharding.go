@@ -0,0 +1,298 @@
+package mongodb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ShardingInfo describes how a collection is sharded, as recorded in config.collections
+type ShardingInfo struct {
+	Namespace        string           `bson:"_id"`
+	Key              bson.D           `bson:"key"`
+	Unique           bool             `bson:"unique"`
+	DefaultCollation bson.D           `bson:"defaultCollation,omitempty"`
+	UUID             primitive.Binary `bson:"uuid,omitempty"`
+}
+
+// ChunkInfo describes a single chunk of a sharded collection, as recorded in config.chunks
+type ChunkInfo struct {
+	Min   bson.D `bson:"min"`
+	Max   bson.D `bson:"max"`
+	Shard string `bson:"shard"`
+	// Lastmod is the chunk version, raised by every migration and split of the chunk
+	Lastmod primitive.Timestamp `bson:"lastmod"`
+}
+
+// ShardingOptions controls how sharding is replicated to the target cluster
+type ShardingOptions struct {
+	// HashedInitialChunks is the number of chunks to pre-create for hashed shard keys (0 leaves it to the server)
+	HashedInitialChunks int
+	// PresplitHashed replicates the source chunk boundaries on the target for hashed shard keys
+	PresplitHashed bool
+}
+
+// IsHashed reports whether the shard key contains a hashed field
+func (s *ShardingInfo) IsHashed() bool {
+	return s.HashedField() != ""
+}
+
+// HashedField returns the name of the hashed shard key field, or an empty string for ranged keys
+func (s *ShardingInfo) HashedField() string {
+	for _, elem := range s.Key {
+		if value, ok := elem.Value.(string); ok && value == "hashed" {
+			return elem.Key
+		}
+	}
+	return ""
+}
+
+// GetShardingInfo returns the sharding information for a collection, or nil if the collection is not sharded
+func GetShardingInfo(ctx context.Context, client *mongo.Client, dbName, collName string) (*ShardingInfo, error) {
+	ns := fmt.Sprintf("%s.%s", dbName, collName)
+	filter := bson.M{"_id": ns, "dropped": bson.M{"$ne": true}}
+
+	var info ShardingInfo
+	err := client.Database("config").Collection("collections").FindOne(ctx, filter).Decode(&info)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read sharding info for %s: %w", ns, err)
+	}
+
+	return &info, nil
+}
+
+// GetChunks returns the chunks of a sharded collection sorted by their lower bound
+func GetChunks(ctx context.Context, client *mongo.Client, info *ShardingInfo) ([]ChunkInfo, error) {
+	// MongoDB 5.0+ identifies chunks by collection UUID, older versions by namespace
+	filter := bson.M{"ns": info.Namespace}
+	if len(info.UUID.Data) > 0 {
+		filter = bson.M{"$or": bson.A{bson.M{"uuid": info.UUID}, bson.M{"ns": info.Namespace}}}
+	}
+
+	findOptions := options.Find().SetSort(bson.D{{Key: "min", Value: 1}})
+	cursor, err := client.Database("config").Collection("chunks").Find(ctx, filter, findOptions)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read chunks for %s: %w", info.Namespace, err)
+	}
+	defer cursor.Close(ctx)
+
+	var chunks []ChunkInfo
+	if err := cursor.All(ctx, &chunks); err != nil {
+		return nil, fmt.Errorf("failed to decode chunks for %s: %w", info.Namespace, err)
+	}
+
+	return chunks, nil
+}
+
+// IsMongos reports whether the client is connected to a mongos router
+func IsMongos(ctx context.Context, client *mongo.Client) (bool, error) {
+	var hello bson.M
+	if err := client.Database("admin").RunCommand(ctx, bson.D{{Key: "hello", Value: 1}}).Decode(&hello); err != nil {
+		return false, fmt.Errorf("failed to run hello command: %w", err)
+	}
+
+	msg, _ := hello["msg"].(string)
+	return msg == "isdbgrid", nil
+}
+
+// ReplicateSharding shards the target collection with the same shard key as the source collection.
+// It does nothing when the source collection is not sharded.
+func ReplicateSharding(
+	ctx context.Context,
+	sourceClient, targetClient *mongo.Client,
+	dbName, collName string,
+	opts ShardingOptions,
+) error {
+	info := sourceShardingInfo(ctx, sourceClient, dbName, collName)
+	if info == nil {
+		return nil
+	}
+
+	ready, err := targetNeedsSharding(ctx, targetClient, dbName, collName, info)
+	if err != nil || !ready {
+		return err
+	}
+
+	if err := shardTargetCollection(ctx, targetClient, dbName, collName, info, opts); err != nil {
+		return err
+	}
+
+	if opts.PresplitHashed && info.IsHashed() {
+		return presplitFromSource(ctx, sourceClient, targetClient, info)
+	}
+
+	return nil
+}
+
+// sourceShardingInfo returns the sharding information of the source collection, or nil when it is not sharded.
+// Users without access to the config database can still copy, so detection failures only log a warning.
+func sourceShardingInfo(ctx context.Context, sourceClient *mongo.Client, dbName, collName string) *ShardingInfo {
+	info, err := GetShardingInfo(ctx, sourceClient, dbName, collName)
+	if err != nil {
+		fmt.Printf("  Warning: Failed to detect sharding for %s.%s: %v\n", dbName, collName, err)
+		return nil
+	}
+	if info != nil {
+		fmt.Printf("  Source collection %s is sharded with key %v\n", info.Namespace, info.Key)
+	}
+	return info
+}
+
+// shardTargetCollection enables sharding on the target database and shards the collection with the source's key
+func shardTargetCollection(
+	ctx context.Context,
+	targetClient *mongo.Client,
+	dbName, collName string,
+	info *ShardingInfo,
+	opts ShardingOptions,
+) error {
+	if err := enableSharding(ctx, targetClient, dbName); err != nil {
+		return err
+	}
+
+	if err := createCollectionWithCollation(ctx, targetClient.Database(dbName), collName, info.DefaultCollation); err != nil {
+		return err
+	}
+
+	cmd := buildShardCollectionCommand(info, opts)
+	if err := targetClient.Database("admin").RunCommand(ctx, cmd).Err(); err != nil {
+		return fmt.Errorf("failed to shard target collection %s: %w", info.Namespace, err)
+	}
+	fmt.Printf("  Sharded target collection %s with key %v\n", info.Namespace, info.Key)
+	return nil
+}
+
+// targetNeedsSharding checks whether the target is a mongos and the collection is not sharded there yet
+func targetNeedsSharding(ctx context.Context, targetClient *mongo.Client, dbName, collName string, info *ShardingInfo) (bool, error) {
+	mongos, err := IsMongos(ctx, targetClient)
+	if err != nil {
+		return false, err
+	}
+	if !mongos {
+		fmt.Printf("  Warning: Target is not a mongos, collection %s will not be sharded\n", info.Namespace)
+		return false, nil
+	}
+
+	existing, err := GetShardingInfo(ctx, targetClient, dbName, collName)
+	if err != nil {
+		return false, err
+	}
+	if existing == nil {
+		return true, nil
+	}
+
+	if !sameShardKey(existing.Key, info.Key) {
+		fmt.Printf("  Warning: Target collection %s is already sharded with a different key %v\n", info.Namespace, existing.Key)
+	} else {
+		fmt.Printf("  Target collection %s is already sharded\n", info.Namespace)
+	}
+	return false, nil
+}
+
+// enableSharding enables sharding for a database on the target cluster
+func enableSharding(ctx context.Context, client *mongo.Client, dbName string) error {
+	err := client.Database("admin").RunCommand(ctx, bson.D{{Key: "enableSharding", Value: dbName}}).Err()
+	if err != nil && !isCommandErrorCode(err, 23) { // AlreadyInitialized
+		return fmt.Errorf("failed to enable sharding for database %s: %w", dbName, err)
+	}
+	return nil
+}
+
+// createCollectionWithCollation creates the target collection with the source default collation.
+// Collections without a default collation are created implicitly by shardCollection.
+func createCollectionWithCollation(ctx context.Context, db *mongo.Database, collName string, collation bson.D) error {
+	if len(collation) == 0 {
+		return nil
+	}
+
+	cmd := bson.D{{Key: "create", Value: collName}, {Key: "collation", Value: collation}}
+	err := db.RunCommand(ctx, cmd).Err()
+	if err != nil && !isCommandErrorCode(err, 48) { // NamespaceExists
+		return fmt.Errorf("failed to create collection %s with collation: %w", collName, err)
+	}
+	return nil
+}
+
+// buildShardCollectionCommand builds the shardCollection command for the target cluster
+func buildShardCollectionCommand(info *ShardingInfo, opts ShardingOptions) bson.D {
+	cmd := bson.D{
+		{Key: "shardCollection", Value: info.Namespace},
+		{Key: "key", Value: info.Key},
+	}
+
+	if info.Unique && !info.IsHashed() {
+		cmd = append(cmd, bson.E{Key: "unique", Value: true})
+	}
+
+	// A collection with a non-simple default collation needs a simple collation for its shard key index
+	if len(info.DefaultCollation) > 0 {
+		cmd = append(cmd, bson.E{Key: "collation", Value: bson.D{{Key: "locale", Value: "simple"}}})
+	}
+
+	if info.IsHashed() && opts.HashedInitialChunks > 0 {
+		cmd = append(cmd, bson.E{Key: "numInitialChunks", Value: opts.HashedInitialChunks})
+	}
+
+	return cmd
+}
+
+// presplitFromSource splits the target collection at the chunk boundaries of the source collection
+func presplitFromSource(ctx context.Context, sourceClient, targetClient *mongo.Client, info *ShardingInfo) error {
+	chunks, err := GetChunks(ctx, sourceClient, info)
+	if err != nil {
+		return err
+	}
+
+	points := splitPoints(chunks)
+	admin := targetClient.Database("admin")
+	splitCount := 0
+
+	for _, point := range points {
+		cmd := bson.D{{Key: "split", Value: info.Namespace}, {Key: "middle", Value: point}}
+		if err := admin.RunCommand(ctx, cmd).Err(); err != nil {
+			// The target may already have a chunk boundary at this point
+			fmt.Printf("    Warning: Failed to split %s at %v: %v\n", info.Namespace, point, err)
+			continue
+		}
+		splitCount++
+	}
+
+	fmt.Printf("  Pre-split target collection %s into %d additional chunks\n", info.Namespace, splitCount)
+	return nil
+}
+
+// splitPoints returns the lower bounds of all chunks except the first one
+func splitPoints(chunks []ChunkInfo) []bson.D {
+	if len(chunks) <= 1 {
+		return nil
+	}
+
+	points := make([]bson.D, 0, len(chunks)-1)
+	for _, chunk := range chunks[1:] {
+		points = append(points, chunk.Min)
+	}
+	return points
+}
+
+// isCommandErrorCode reports whether err is a MongoDB command error with the given code
+func isCommandErrorCode(err error, code int32) bool {
+	var cmdErr mongo.CommandError
+	if errors.As(err, &cmdErr) {
+		return cmdErr.Code == code
+	}
+	return false
+}
+
+// sameShardKey reports whether two shard keys have the same fields in the same order with equal values,
+// 1 and 1.0 alike
+func sameShardKey(a, b bson.D) bool {
+	return Equality{KeyOrder: true}.equal(a, b)
+}
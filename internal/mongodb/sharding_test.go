@@ -0,0 +1,266 @@
+package mongodb
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/wait"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// TestShardingInfoHashedField tests detection of hashed shard keys
+func TestShardingInfoHashedField(t *testing.T) {
+	tests := []struct {
+		name     string
+		key      bson.D
+		expected string
+	}{
+		{
+			name:     "Ranged key",
+			key:      bson.D{{Key: "userId", Value: int32(1)}},
+			expected: "",
+		},
+		{
+			name:     "Hashed key",
+			key:      bson.D{{Key: "userId", Value: "hashed"}},
+			expected: "userId",
+		},
+		{
+			name:     "Compound key with hashed field",
+			key:      bson.D{{Key: "region", Value: int32(1)}, {Key: "userId", Value: "hashed"}},
+			expected: "userId",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info := &ShardingInfo{Key: tt.key}
+			assert.Equal(t, tt.expected, info.HashedField())
+			assert.Equal(t, tt.expected != "", info.IsHashed())
+		})
+	}
+}
+
+// TestBuildShardCollectionCommand tests the shardCollection command built for the target
+func TestBuildShardCollectionCommand(t *testing.T) {
+	tests := []struct {
+		name     string
+		info     *ShardingInfo
+		opts     ShardingOptions
+		expected bson.D
+	}{
+		{
+			name: "Ranged unique key",
+			info: &ShardingInfo{
+				Namespace: "db.users",
+				Key:       bson.D{{Key: "email", Value: int32(1)}},
+				Unique:    true,
+			},
+			expected: bson.D{
+				{Key: "shardCollection", Value: "db.users"},
+				{Key: "key", Value: bson.D{{Key: "email", Value: int32(1)}}},
+				{Key: "unique", Value: true},
+			},
+		},
+		{
+			name: "Hashed key with initial chunks",
+			info: &ShardingInfo{
+				Namespace: "db.events",
+				Key:       bson.D{{Key: "_id", Value: "hashed"}},
+			},
+			opts: ShardingOptions{HashedInitialChunks: 8},
+			expected: bson.D{
+				{Key: "shardCollection", Value: "db.events"},
+				{Key: "key", Value: bson.D{{Key: "_id", Value: "hashed"}}},
+				{Key: "numInitialChunks", Value: 8},
+			},
+		},
+		{
+			name: "Ranged key ignores initial chunks",
+			info: &ShardingInfo{
+				Namespace: "db.orders",
+				Key:       bson.D{{Key: "customerId", Value: int32(1)}},
+			},
+			opts: ShardingOptions{HashedInitialChunks: 8},
+			expected: bson.D{
+				{Key: "shardCollection", Value: "db.orders"},
+				{Key: "key", Value: bson.D{{Key: "customerId", Value: int32(1)}}},
+			},
+		},
+		{
+			name: "Default collation requires simple shard key collation",
+			info: &ShardingInfo{
+				Namespace:        "db.names",
+				Key:              bson.D{{Key: "name", Value: int32(1)}},
+				DefaultCollation: bson.D{{Key: "locale", Value: "fr"}},
+			},
+			expected: bson.D{
+				{Key: "shardCollection", Value: "db.names"},
+				{Key: "key", Value: bson.D{{Key: "name", Value: int32(1)}}},
+				{Key: "collation", Value: bson.D{{Key: "locale", Value: "simple"}}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, buildShardCollectionCommand(tt.info, tt.opts))
+		})
+	}
+}
+
+// TestSplitPoints tests computing split points from source chunks
+func TestSplitPoints(t *testing.T) {
+	chunks := []ChunkInfo{
+		{Min: bson.D{{Key: "_id", Value: primitive.MinKey{}}}, Max: bson.D{{Key: "_id", Value: int64(-100)}}, Shard: "shard01"},
+		{Min: bson.D{{Key: "_id", Value: int64(-100)}}, Max: bson.D{{Key: "_id", Value: int64(100)}}, Shard: "shard02"},
+		{Min: bson.D{{Key: "_id", Value: int64(100)}}, Max: bson.D{{Key: "_id", Value: primitive.MaxKey{}}}, Shard: "shard01"},
+	}
+
+	points := splitPoints(chunks)
+	assert.Equal(t, []bson.D{
+		{{Key: "_id", Value: int64(-100)}},
+		{{Key: "_id", Value: int64(100)}},
+	}, points)
+
+	assert.Nil(t, splitPoints(chunks[:1]))
+	assert.Nil(t, splitPoints(nil))
+}
+
+// TestIsCommandErrorCode tests matching command error codes
+func TestIsCommandErrorCode(t *testing.T) {
+	assert.True(t, isCommandErrorCode(mongo.CommandError{Code: 48}, 48))
+	assert.False(t, isCommandErrorCode(mongo.CommandError{Code: 23}, 48))
+	assert.False(t, isCommandErrorCode(assert.AnError, 48))
+}
+
+// TestSameShardKey tests comparing shard keys by field order and value
+func TestSameShardKey(t *testing.T) {
+	a := bson.D{{Key: "a", Value: int32(1)}, {Key: "b", Value: "hashed"}}
+	assert.True(t, sameShardKey(a, bson.D{{Key: "a", Value: float64(1)}, {Key: "b", Value: "hashed"}}))
+	assert.False(t, sameShardKey(a, bson.D{{Key: "b", Value: "hashed"}, {Key: "a", Value: int32(1)}}))
+	assert.False(t, sameShardKey(a, bson.D{{Key: "a", Value: int32(1)}}))
+	assert.False(t, sameShardKey(a, bson.D{{Key: "a", Value: "1"}, {Key: "b", Value: "hashed"}}))
+}
+
+// shardedClusterScript starts a config server, a single shard and a mongos in one container
+const shardedClusterScript = `set -e
+mkdir -p /data/config /data/shard
+mongod --configsvr --replSet config --port 27019 --bind_ip_all --dbpath /data/config --fork --logpath /tmp/config.log
+mongod --shardsvr --replSet shard01 --port 27018 --bind_ip_all --dbpath /data/shard --fork --logpath /tmp/shard.log
+mongosh --quiet --port 27019 --eval 'rs.initiate({_id: "config", configsvr: true, members: [{_id: 0, host: "localhost:27019"}]})'
+mongosh --quiet --port 27018 --eval 'rs.initiate({_id: "shard01", members: [{_id: 0, host: "localhost:27018"}]})'
+mongos --configdb config/localhost:27019 --port 27017 --bind_ip_all --fork --logpath /tmp/mongos.log
+until mongosh --quiet --port 27017 --eval 'sh.addShard("shard01/localhost:27018")'; do sleep 1; done
+echo "sharded cluster ready"
+tail -f /tmp/mongos.log
+`
+
+// setupShardedCluster starts a sharded cluster and returns the connection string of its mongos
+func setupShardedCluster(t *testing.T) (testcontainers.Container, string) {
+	ctx := context.Background()
+	container, err := testcontainers.GenericContainer(ctx, testcontainers.GenericContainerRequest{
+		ContainerRequest: testcontainers.ContainerRequest{
+			Image:        "mongo:8.0",
+			ExposedPorts: []string{"27017/tcp"},
+			Entrypoint:   []string{"bash", "-c", shardedClusterScript},
+			WaitingFor:   wait.ForLog("sharded cluster ready").WithStartupTimeout(3 * time.Minute),
+		},
+		Started: true,
+	})
+	if err != nil {
+		t.Fatalf("Failed to start sharded cluster container: %v", err)
+	}
+
+	host, err := container.Host(ctx)
+	if err != nil {
+		t.Fatalf("Failed to get container host: %v", err)
+	}
+	port, err := container.MappedPort(ctx, "27017/tcp")
+	if err != nil {
+		t.Fatalf("Failed to get mongos port: %v", err)
+	}
+
+	return container, fmt.Sprintf("mongodb://%s:%s", host, port.Port())
+}
+
+// TestReplicateSharding tests sharding target collections like ranged and hashed presplit source collections
+func TestReplicateSharding(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	sourceContainer, sourceConnString := setupShardedCluster(t)
+	defer sourceContainer.Terminate(context.Background())
+
+	targetContainer, targetConnString := setupShardedCluster(t)
+	defer targetContainer.Terminate(context.Background())
+
+	ctx := context.Background()
+	sourceClient, err := mongo.Connect(ctx, options.Client().ApplyURI(sourceConnString))
+	require.NoError(t, err)
+	defer sourceClient.Disconnect(ctx)
+
+	targetClient, err := mongo.Connect(ctx, options.Client().ApplyURI(targetConnString))
+	require.NoError(t, err)
+	defer targetClient.Disconnect(ctx)
+
+	admin := sourceClient.Database("admin")
+	require.NoError(t, admin.RunCommand(ctx, bson.D{{Key: "enableSharding", Value: "sharddb"}}).Err())
+
+	// A ranged collection with a unique key, split at two points
+	rangedKey := bson.D{{Key: "region", Value: int32(1)}}
+	require.NoError(t, admin.RunCommand(ctx, bson.D{
+		{Key: "shardCollection", Value: "sharddb.ranged"}, {Key: "key", Value: rangedKey}, {Key: "unique", Value: true},
+	}).Err())
+	for _, point := range []string{"m", "t"} {
+		require.NoError(t, admin.RunCommand(ctx, bson.D{
+			{Key: "split", Value: "sharddb.ranged"}, {Key: "middle", Value: bson.D{{Key: "region", Value: point}}},
+		}).Err())
+	}
+
+	// A hashed collection, split into more chunks than it starts with
+	hashedKey := bson.D{{Key: "userId", Value: "hashed"}}
+	require.NoError(t, admin.RunCommand(ctx, bson.D{{Key: "shardCollection", Value: "sharddb.hashed"}, {Key: "key", Value: hashedKey}}).Err())
+	for _, point := range []int64{-1 << 60, 1 << 60} {
+		require.NoError(t, admin.RunCommand(ctx, bson.D{
+			{Key: "split", Value: "sharddb.hashed"}, {Key: "middle", Value: bson.D{{Key: "userId", Value: point}}},
+		}).Err())
+	}
+
+	opts := ShardingOptions{PresplitHashed: true}
+	require.NoError(t, ReplicateSharding(ctx, sourceClient, targetClient, "sharddb", "ranged", opts))
+	require.NoError(t, ReplicateSharding(ctx, sourceClient, targetClient, "sharddb", "hashed", opts))
+	// A collection already sharded on the target is left alone
+	require.NoError(t, ReplicateSharding(ctx, sourceClient, targetClient, "sharddb", "hashed", opts))
+
+	ranged, err := GetShardingInfo(ctx, targetClient, "sharddb", "ranged")
+	require.NoError(t, err)
+	require.NotNil(t, ranged)
+	assert.True(t, sameShardKey(rangedKey, ranged.Key))
+	assert.True(t, ranged.Unique)
+	// Ranged collections are not presplit, their chunks are created as documents are copied
+	chunks, err := GetChunks(ctx, targetClient, ranged)
+	require.NoError(t, err)
+	assert.Len(t, chunks, 1)
+
+	hashed, err := GetShardingInfo(ctx, targetClient, "sharddb", "hashed")
+	require.NoError(t, err)
+	require.NotNil(t, hashed)
+	assert.True(t, sameShardKey(hashedKey, hashed.Key))
+	sourceHashed, err := GetShardingInfo(ctx, sourceClient, "sharddb", "hashed")
+	require.NoError(t, err)
+	sourceChunks, err := GetChunks(ctx, sourceClient, sourceHashed)
+	require.NoError(t, err)
+	chunks, err = GetChunks(ctx, targetClient, hashed)
+	require.NoError(t, err)
+	assert.Equal(t, splitPoints(sourceChunks), splitPoints(chunks))
+}
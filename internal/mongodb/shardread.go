@@ -0,0 +1,402 @@
+package mongodb
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// hashedKeyField is the temporary field holding the hashed shard key value of a document read from a shard
+const hashedKeyField = "__nmongoHashedShardKey"
+
+// ShardDescriptor describes a shard of a sharded cluster, as recorded in config.shards
+type ShardDescriptor struct {
+	ID   string `bson:"_id"`
+	Host string `bson:"host"`
+}
+
+// ReplicaSet returns the replica set name and member hosts of the shard
+func (s ShardDescriptor) ReplicaSet() (replicaSet string, hosts []string) {
+	return parseShardHost(s.Host)
+}
+
+// ListShards returns the shards of the cluster the client is connected to
+func ListShards(ctx context.Context, client *mongo.Client) ([]ShardDescriptor, error) {
+	cursor, err := client.Database("config").Collection("shards").Find(ctx, bson.M{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read shards: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	var shards []ShardDescriptor
+	if err := cursor.All(ctx, &shards); err != nil {
+		return nil, fmt.Errorf("failed to decode shards: %w", err)
+	}
+
+	return shards, nil
+}
+
+// ConnectToShards connects directly to the replica set of every shard of the cluster, with the credentials of
+// the cluster unless shard credentials are given. The returned map is keyed by shard ID.
+func ConnectToShards(ctx context.Context, client *Client, socketTimeoutSeconds int,
+	credentials ShardCredentials) (map[string]*Client, error) {
+	shards, err := ListShards(ctx, client.client)
+	if err != nil {
+		return nil, err
+	}
+	if len(shards) == 0 {
+		return nil, fmt.Errorf("no shards found, source is not a sharded cluster")
+	}
+
+	shardClients := make(map[string]*Client, len(shards))
+	for _, shard := range shards {
+		replicaSet, hosts := shard.ReplicaSet()
+		shardClient, err := NewShardClient(ctx, client.uri, client.caCertFile, socketTimeoutSeconds, replicaSet, hosts, credentials)
+		if err != nil {
+			DisconnectShards(ctx, shardClients)
+			return nil, fmt.Errorf("failed to connect to shard %s: %w", shard.ID, err)
+		}
+		shardClients[shard.ID] = shardClient
+	}
+
+	return shardClients, nil
+}
+
+// DisconnectShards closes all shard connections
+func DisconnectShards(ctx context.Context, shardClients map[string]*Client) {
+	for _, shardClient := range shardClients {
+		shardClient.Disconnect(ctx)
+	}
+}
+
+// parseShardHost parses a shard host string in the form "replicaSet/host1:port,host2:port"
+func parseShardHost(host string) (replicaSet string, hosts []string) {
+	if idx := strings.Index(host, "/"); idx >= 0 {
+		replicaSet = host[:idx]
+		host = host[idx+1:]
+	}
+
+	for _, h := range strings.Split(host, ",") {
+		if h = strings.TrimSpace(h); h != "" {
+			hosts = append(hosts, h)
+		}
+	}
+	return replicaSet, hosts
+}
+
+// chunkOwnership decides which shard owns a document according to the chunk ranges of a collection
+type chunkOwnership struct {
+	keyFields   []string
+	hashedField string
+	chunks      []ChunkInfo
+}
+
+// newChunkOwnership creates a chunk ownership lookup for a sharded collection
+func newChunkOwnership(info *ShardingInfo, chunks []ChunkInfo) *chunkOwnership {
+	keyFields := make([]string, 0, len(info.Key))
+	for _, elem := range info.Key {
+		keyFields = append(keyFields, elem.Key)
+	}
+
+	return &chunkOwnership{
+		keyFields:   keyFields,
+		hashedField: info.HashedField(),
+		chunks:      chunks,
+	}
+}
+
+// owner returns the shard owning a document, or an empty string if no chunk covers it
+func (o *chunkOwnership) owner(doc bson.M) string {
+	key := o.shardKeyValues(doc)
+
+	// Find the last chunk whose lower bound is less than or equal to the key
+	idx := sort.Search(len(o.chunks), func(i int) bool {
+		return compareKeyTuple(boundValues(o.chunks[i].Min), key) > 0
+	}) - 1
+	if idx < 0 {
+		return ""
+	}
+
+	chunk := o.chunks[idx]
+	if compareKeyTuple(key, boundValues(chunk.Max)) >= 0 {
+		return ""
+	}
+	return chunk.Shard
+}
+
+// shardKeyValues extracts the shard key values of a document in key order
+func (o *chunkOwnership) shardKeyValues(doc bson.M) []interface{} {
+	values := make([]interface{}, 0, len(o.keyFields))
+	for _, field := range o.keyFields {
+		if field == o.hashedField {
+			values = append(values, doc[hashedKeyField])
+			continue
+		}
+		// Documents missing a shard key field are treated as having null
+		value, _ := lookupPath(doc, field)
+		values = append(values, value)
+	}
+	return values
+}
+
+// boundValues returns the values of a chunk bound in key order
+func boundValues(bound bson.D) []interface{} {
+	values := make([]interface{}, 0, len(bound))
+	for _, elem := range bound {
+		values = append(values, elem.Value)
+	}
+	return values
+}
+
+// compareKeyTuple compares two shard key tuples lexicographically
+func compareKeyTuple(a, b []interface{}) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if c := compareBSONValues(a[i], b[i]); c != 0 {
+			return c
+		}
+	}
+	return len(a) - len(b)
+}
+
+// CopyCollectionFromShards copies a sharded collection by reading every shard directly and in parallel.
+// Documents not owned by the shard they are read from (orphans) are skipped. The copy fails when chunks were
+// migrated or split meanwhile, since the documents of a migrated chunk may be gone from both shards' cursors.
+// Collections that are not sharded are copied through the router with CopyCollection.
+func CopyCollectionFromShards(
+	ctx context.Context,
+	sourceDB, targetDB *mongo.Database,
+	shardClients map[string]*Client,
+	collName string,
+	incremental bool,
+	batchSize int,
+	lastModifiedField string,
+	retryAttempts int,
+) error {
+	info, err := GetShardingInfo(ctx, sourceDB.Client(), sourceDB.Name(), collName)
+	if err != nil {
+		return err
+	}
+	if info == nil {
+		return CopyCollection(ctx, sourceDB, targetDB, collName, incremental, batchSize, lastModifiedField, retryAttempts)
+	}
+
+	fmt.Printf("  Copying sharded collection %s from %d shards in parallel\n", collName, len(shardClients))
+
+	chunks, err := GetChunks(ctx, sourceDB.Client(), info)
+	if err != nil {
+		return err
+	}
+	ownership := newChunkOwnership(info, chunks)
+
+	filter, err := prepareFilterWithTarget(ctx, sourceDB, targetDB, collName, incremental, lastModifiedField)
+	if err != nil {
+		return err
+	}
+
+	reader := &shardReader{
+		dbName:        sourceDB.Name(),
+		collName:      collName,
+		targetColl:    targetDB.Collection(collName),
+		filter:        filter,
+		ownership:     ownership,
+		incremental:   incremental,
+		batchSize:     batchSize,
+		retryAttempts: retryAttempts,
+	}
+	if err := reader.copyShards(ctx, sourceDB.Client(), info, shardClients, chunks); err != nil {
+		return err
+	}
+
+	if err := CopyCollectionIndexes(context.Background(), sourceDB, targetDB, collName); err != nil {
+		fmt.Printf("  Warning: Failed to copy indexes for collection %s: %v\n", collName, err)
+	}
+
+	return nil
+}
+
+// shardsOwningChunks returns the shard clients of all shards that own at least one chunk. It fails when a shard
+// owning chunks has no client, since the documents of its chunks would not be copied.
+func shardsOwningChunks(shardClients map[string]*Client, chunks []ChunkInfo) (map[string]*Client, error) {
+	owning := make(map[string]*Client)
+	for _, chunk := range chunks {
+		shardClient, ok := shardClients[chunk.Shard]
+		if !ok {
+			return nil, fmt.Errorf("shard %s owns chunks but has no connection, it may have been added during the copy",
+				chunk.Shard)
+		}
+		owning[chunk.Shard] = shardClient
+	}
+	return owning, nil
+}
+
+// chunkVersion returns the collection version of a set of chunks, the highest version of its chunks
+func chunkVersion(chunks []ChunkInfo) primitive.Timestamp {
+	var version primitive.Timestamp
+	for _, chunk := range chunks {
+		if chunk.Lastmod.After(version) {
+			version = chunk.Lastmod
+		}
+	}
+	return version
+}
+
+// checkChunksUnchanged fails when the chunks of a collection changed since they were read. The range deleter may
+// remove a migrated chunk from the donor before its cursor reads it, while the recipient's copy is skipped as orphans.
+func checkChunksUnchanged(ctx context.Context, client *mongo.Client, info *ShardingInfo, chunks []ChunkInfo) error {
+	current, err := GetChunks(ctx, client, info)
+	if err != nil {
+		return err
+	}
+	if len(current) != len(chunks) || chunkVersion(current) != chunkVersion(chunks) {
+		return fmt.Errorf("chunks of %s were migrated or split during the copy, "+
+			"disable balancing with sh.disableBalancing(\"%s\") and copy the collection again", info.Namespace, info.Namespace)
+	}
+	return nil
+}
+
+// shardReader copies the documents of a sharded collection shard by shard
+type shardReader struct {
+	dbName        string
+	collName      string
+	targetColl    *mongo.Collection
+	filter        bson.M
+	ownership     *chunkOwnership
+	incremental   bool
+	batchSize     int
+	retryAttempts int
+}
+
+// copyShards copies the documents of the shards owning chunks, then checks that the chunks did not change meanwhile
+func (r *shardReader) copyShards(ctx context.Context, sourceClient *mongo.Client, info *ShardingInfo,
+	shardClients map[string]*Client, chunks []ChunkInfo) error {
+	owning, err := shardsOwningChunks(shardClients, chunks)
+	if err != nil {
+		return err
+	}
+	if err := r.copyAll(ctx, owning); err != nil {
+		return err
+	}
+	return checkChunksUnchanged(ctx, sourceClient, info, chunks)
+}
+
+// copyAll copies the documents of every shard in parallel and waits for all of them.
+// The first failing shard stops the others, and its error is returned.
+func (r *shardReader) copyAll(ctx context.Context, shardClients map[string]*Client) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	var once sync.Once
+	var firstErr error
+	for shardID, shardClient := range shardClients {
+		wg.Add(1)
+		go func(shardID string, shardClient *Client) {
+			defer wg.Done()
+			if err := r.copyShard(ctx, shardID, shardClient); err != nil {
+				once.Do(func() {
+					firstErr = fmt.Errorf("shard %s: %w", shardID, err)
+					cancel()
+				})
+			}
+		}(shardID, shardClient)
+	}
+
+	wg.Wait()
+	return firstErr
+}
+
+// copyShard copies the documents owned by a single shard
+func (r *shardReader) copyShard(ctx context.Context, shardID string, shardClient *Client) error {
+	coll := shardClient.GetDatabase(r.dbName).Collection(r.collName)
+	cursor, err := r.openCursor(ctx, coll)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	copied, orphans, err := r.copyOwnedDocuments(ctx, cursor, shardID)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("    Shard %s: completed copying %s (%d documents, %d orphans skipped)\n", shardID, r.collName, copied, orphans)
+	return nil
+}
+
+// copyOwnedDocuments inserts the documents of the cursor owned by the shard and counts the orphans it skips
+func (r *shardReader) copyOwnedDocuments(ctx context.Context, cursor *mongo.Cursor, shardID string) (copied, orphans int64, err error) {
+	batch := make([]interface{}, 0, r.batchSize)
+	lastProgressTime := time.Now()
+
+	for cursor.Next(ctx) {
+		var doc bson.M
+		if err := cursor.Decode(&doc); err != nil {
+			return copied, orphans, fmt.Errorf("failed to decode document: %w", err)
+		}
+
+		if r.ownership.owner(doc) != shardID {
+			orphans++
+			continue
+		}
+		delete(doc, hashedKeyField)
+		if batch, err = r.flushBatch(ctx, append(batch, doc), &copied, false); err != nil {
+			return copied, orphans, err
+		}
+
+		if shouldUpdateProgress(lastProgressTime, 10*time.Second) {
+			fmt.Printf("    Shard %s: copied %d documents to %s (orphans skipped: %d)\n", shardID, copied, r.collName, orphans)
+			lastProgressTime = time.Now()
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return copied, orphans, fmt.Errorf("cursor error: %w", err)
+	}
+
+	_, err = r.flushBatch(ctx, batch, &copied, true)
+	return copied, orphans, err
+}
+
+// flushBatch inserts the batch once it is full, or when it holds the last documents of the shard
+func (r *shardReader) flushBatch(ctx context.Context, batch []interface{}, copied *int64, last bool) ([]interface{}, error) {
+	if len(batch) == 0 || (!last && len(batch) < r.batchSize) {
+		return batch, nil
+	}
+	if err := insertBatch(ctx, r.targetColl, batch, r.incremental, r.retryAttempts); err != nil {
+		return batch, err
+	}
+	*copied += int64(len(batch))
+	return batch[:0], nil
+}
+
+// openCursor opens a cursor on the shard, computing the hashed shard key on the server when needed
+func (r *shardReader) openCursor(ctx context.Context, coll *mongo.Collection) (*mongo.Cursor, error) {
+	if r.ownership.hashedField == "" {
+		return createCursor(ctx, coll, r.filter, r.batchSize)
+	}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: r.filter}},
+		{{Key: "$addFields", Value: bson.M{
+			hashedKeyField: bson.M{"$toHashedIndexKey": "$" + r.ownership.hashedField},
+		}}},
+	}
+
+	var cursor *mongo.Cursor
+	err := RetryWithBackoff(ctx, 5, "Create cursor for shard query", func() error {
+		var aggErr error
+		cursor, aggErr = coll.Aggregate(ctx, pipeline, options.Aggregate().SetBatchSize(int32(r.batchSize)))
+		if aggErr != nil {
+			return fmt.Errorf("failed to query shard collection: %w", aggErr)
+		}
+		return nil
+	})
+	return cursor, err
+}
@@ -0,0 +1,132 @@
+package mongodb
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// TestParseShardHost tests parsing shard host strings from config.shards
+func TestParseShardHost(t *testing.T) {
+	tests := []struct {
+		name       string
+		host       string
+		replicaSet string
+		hosts      []string
+	}{
+		{"Replica set shard", "shard01/host1:27018,host2:27018", "shard01", []string{"host1:27018", "host2:27018"}},
+		{"Single host without replica set", "host1:27018", "", []string{"host1:27018"}},
+		{"Trailing comma", "rs/host1:27018,", "rs", []string{"host1:27018"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			replicaSet, hosts := ShardDescriptor{ID: "s", Host: tt.host}.ReplicaSet()
+			assert.Equal(t, tt.replicaSet, replicaSet)
+			assert.Equal(t, tt.hosts, hosts)
+		})
+	}
+}
+
+// TestChunkOwnershipRanged tests chunk ownership for a ranged compound shard key
+func TestChunkOwnershipRanged(t *testing.T) {
+	info := &ShardingInfo{Key: bson.D{{Key: "region", Value: int32(1)}, {Key: "user.id", Value: int32(1)}}}
+	chunks := []ChunkInfo{
+		{
+			Min:   bson.D{{Key: "region", Value: primitive.MinKey{}}, {Key: "user.id", Value: primitive.MinKey{}}},
+			Max:   bson.D{{Key: "region", Value: "eu"}, {Key: "user.id", Value: int32(100)}},
+			Shard: "shard01",
+		},
+		{
+			Min:   bson.D{{Key: "region", Value: "eu"}, {Key: "user.id", Value: int32(100)}},
+			Max:   bson.D{{Key: "region", Value: "us"}, {Key: "user.id", Value: primitive.MinKey{}}},
+			Shard: "shard02",
+		},
+		{
+			Min:   bson.D{{Key: "region", Value: "us"}, {Key: "user.id", Value: primitive.MinKey{}}},
+			Max:   bson.D{{Key: "region", Value: primitive.MaxKey{}}, {Key: "user.id", Value: primitive.MaxKey{}}},
+			Shard: "shard01",
+		},
+	}
+	ownership := newChunkOwnership(info, chunks)
+
+	tests := []struct {
+		name     string
+		doc      bson.M
+		expected string
+	}{
+		{"Below first split", bson.M{"region": "eu", "user": bson.M{"id": int32(99)}}, "shard01"},
+		{"At split point", bson.M{"region": "eu", "user": bson.M{"id": int32(100)}}, "shard02"},
+		{"Middle chunk", bson.M{"region": "fr", "user": bson.M{"id": int32(1)}}, "shard02"},
+		{"Last chunk", bson.M{"region": "us", "user": bson.M{"id": int64(5)}}, "shard01"},
+		{"Missing key field is null", bson.M{"other": 1}, "shard01"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, ownership.owner(tt.doc))
+		})
+	}
+}
+
+// TestChunkOwnershipHashed tests chunk ownership using the server-computed hashed key
+func TestChunkOwnershipHashed(t *testing.T) {
+	info := &ShardingInfo{Key: bson.D{{Key: "_id", Value: "hashed"}}}
+	chunks := []ChunkInfo{
+		{Min: bson.D{{Key: "_id", Value: primitive.MinKey{}}}, Max: bson.D{{Key: "_id", Value: int64(0)}}, Shard: "shard01"},
+		{Min: bson.D{{Key: "_id", Value: int64(0)}}, Max: bson.D{{Key: "_id", Value: primitive.MaxKey{}}}, Shard: "shard02"},
+	}
+	ownership := newChunkOwnership(info, chunks)
+
+	assert.Equal(t, "shard01", ownership.owner(bson.M{"_id": int32(5), hashedKeyField: int64(-42)}))
+	assert.Equal(t, "shard02", ownership.owner(bson.M{"_id": int32(5), hashedKeyField: int64(42)}))
+}
+
+// TestChunkOwnershipNoChunks tests that documents are not owned when no chunk covers them
+func TestChunkOwnershipNoChunks(t *testing.T) {
+	info := &ShardingInfo{Key: bson.D{{Key: "x", Value: int32(1)}}}
+	chunks := []ChunkInfo{
+		{Min: bson.D{{Key: "x", Value: int32(10)}}, Max: bson.D{{Key: "x", Value: int32(20)}}, Shard: "shard01"},
+	}
+	ownership := newChunkOwnership(info, chunks)
+
+	assert.Equal(t, "", ownership.owner(bson.M{"x": int32(5)}))
+	assert.Equal(t, "shard01", ownership.owner(bson.M{"x": int32(15)}))
+	assert.Equal(t, "", ownership.owner(bson.M{"x": int32(20)}))
+	assert.Equal(t, "", newChunkOwnership(info, nil).owner(bson.M{"x": int32(15)}))
+}
+
+// TestShardsOwningChunks tests selecting shards that own chunks of a collection
+func TestShardsOwningChunks(t *testing.T) {
+	shardClients := map[string]*Client{"shard01": {}, "shard02": {}, "shard03": {}}
+	chunks := []ChunkInfo{{Shard: "shard01"}, {Shard: "shard03"}, {Shard: "shard01"}}
+
+	owning, err := shardsOwningChunks(shardClients, chunks)
+	require.NoError(t, err)
+	assert.Len(t, owning, 2)
+	assert.Contains(t, owning, "shard01")
+	assert.Contains(t, owning, "shard03")
+
+	// A shard owning chunks without a connection would leave its documents uncopied
+	chunks = append(chunks, ChunkInfo{Shard: "shard04"})
+	_, err = shardsOwningChunks(shardClients, chunks)
+	assert.ErrorContains(t, err, "shard shard04")
+}
+
+// TestChunkVersion tests finding the collection version of a set of chunks
+func TestChunkVersion(t *testing.T) {
+	chunks := []ChunkInfo{
+		{Shard: "shard01", Lastmod: primitive.Timestamp{T: 3, I: 1}},
+		{Shard: "shard02", Lastmod: primitive.Timestamp{T: 4, I: 0}},
+		{Shard: "shard01", Lastmod: primitive.Timestamp{T: 3, I: 7}},
+	}
+	assert.Equal(t, primitive.Timestamp{T: 4, I: 0}, chunkVersion(chunks))
+	assert.Equal(t, primitive.Timestamp{}, chunkVersion(nil))
+
+	// A migration raises the major version of the moved chunk
+	chunks[0].Lastmod = primitive.Timestamp{T: 5, I: 0}
+	assert.Equal(t, primitive.Timestamp{T: 5, I: 0}, chunkVersion(chunks))
+}
@@ -0,0 +1,376 @@
+package mongodb
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Server error codes returned when a snapshot read falls outside the snapshot history window
+const (
+	errCodeSnapshotTooOld      = 239
+	errCodeSnapshotUnavailable = 246
+)
+
+// resumeTokenTimestampType is the key string type byte that starts a resume token holding a cluster time
+const resumeTokenTimestampType = 130
+
+// SnapshotCopier copies collections as they were at a single cluster time.
+// Collections are read with readConcern "snapshot" pinned to the cluster time recorded when the copier is created.
+// When the snapshot history window is too short for a collection, the collection is copied from the current data
+// instead, and the changes made since the recorded cluster time are applied afterwards from a change stream to every
+// copied collection so they all end up at the same, later, cluster time.
+type SnapshotCopier struct {
+	source        *mongo.Client
+	clusterTime   primitive.Timestamp
+	batchSize     int
+	retryAttempts int
+
+	// copied lists the copied collections per database, fellBack is set once a snapshot was unavailable
+	copied   map[string][]string
+	fellBack bool
+}
+
+// NewSnapshotCopier records the current cluster time of the source and returns a copier reading at that time
+func NewSnapshotCopier(ctx context.Context, source *Client, batchSize, retryAttempts int) (*SnapshotCopier, error) {
+	clusterTime, err := CurrentClusterTime(ctx, source.client)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SnapshotCopier{
+		source:        source.client,
+		clusterTime:   clusterTime,
+		batchSize:     batchSize,
+		retryAttempts: retryAttempts,
+		copied:        make(map[string][]string),
+	}, nil
+}
+
+// ClusterTime returns the cluster time all collections are copied at
+func (c *SnapshotCopier) ClusterTime() primitive.Timestamp {
+	return c.clusterTime
+}
+
+// CurrentClusterTime returns the latest operation time known to the server.
+// It requires a replica set or sharded cluster since standalone servers do not track cluster time.
+func CurrentClusterTime(ctx context.Context, client *mongo.Client) (primitive.Timestamp, error) {
+	sess, err := client.StartSession()
+	if err != nil {
+		return primitive.Timestamp{}, fmt.Errorf("failed to start session: %w", err)
+	}
+	defer sess.EndSession(ctx)
+
+	sessCtx := mongo.NewSessionContext(ctx, sess)
+	if err := client.Database("admin").RunCommand(sessCtx, bson.D{{Key: "hello", Value: 1}}).Err(); err != nil {
+		return primitive.Timestamp{}, fmt.Errorf("failed to read cluster time: %w", err)
+	}
+
+	operationTime := sess.OperationTime()
+	if operationTime == nil {
+		return primitive.Timestamp{}, fmt.Errorf("server did not report a cluster time, " +
+			"consistent copies require a replica set or sharded cluster")
+	}
+	return *operationTime, nil
+}
+
+// CopyCollection copies a collection as of the copier's cluster time.
+// If the snapshot is no longer available, the collection is copied from the current data and
+// scheduled for change stream catch-up.
+func (c *SnapshotCopier) CopyCollection(
+	ctx context.Context,
+	sourceDB, targetDB *mongo.Database,
+	collName string,
+	incremental bool,
+	lastModifiedField string,
+) error {
+	fmt.Printf("  Copying collection %s at cluster time %d.%d\n", collName, c.clusterTime.T, c.clusterTime.I)
+	dbName := sourceDB.Name()
+	c.copied[dbName] = append(c.copied[dbName], collName)
+
+	filter, err := prepareFilterWithTarget(ctx, sourceDB, targetDB, collName, incremental, lastModifiedField)
+	if err != nil {
+		return err
+	}
+
+	err = c.copyAtClusterTime(ctx, sourceDB, targetDB, collName, filter, incremental)
+	if isSnapshotTooOld(err) {
+		fmt.Printf("  Warning: Snapshot at cluster time %d.%d is no longer available for %s, "+
+			"copying current data and catching up from the change stream\n", c.clusterTime.T, c.clusterTime.I, collName)
+		err = c.copyForCatchUp(ctx, sourceDB, targetDB, collName, filter)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := CopyCollectionIndexes(context.Background(), sourceDB, targetDB, collName); err != nil {
+		fmt.Printf("  Warning: Failed to copy indexes for collection %s: %v\n", collName, err)
+	}
+	return nil
+}
+
+// copyAtClusterTime reads the collection as it was at the cluster time
+func (c *SnapshotCopier) copyAtClusterTime(
+	ctx context.Context,
+	sourceDB, targetDB *mongo.Database,
+	collName string,
+	filter bson.M,
+	incremental bool,
+) error {
+	cursor, err := createSnapshotCursor(ctx, sourceDB, collName, filter, c.batchSize, c.clusterTime)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	return processBatches(ctx, cursor, targetDB.Collection(collName), collName, incremental, c.batchSize, c.retryAttempts)
+}
+
+// createSnapshotCursor queries a collection as it was at a cluster time. The find command carries the snapshot
+// read concern with its atClusterTime itself, so the time does not depend on the session the driver uses.
+func createSnapshotCursor(ctx context.Context, sourceDB *mongo.Database, collName string, filter bson.M, batchSize int,
+	clusterTime primitive.Timestamp) (*mongo.Cursor, error) {
+	command := snapshotFindCommand(collName, filter, batchSize, clusterTime)
+
+	var cursor *mongo.Cursor
+	operation := "Create snapshot cursor for collection query"
+	err := RetryWithBackoff(ctx, 5, operation, func() error {
+		var err error
+		cursor, err = sourceDB.RunCommandCursor(ctx, command)
+		if err != nil {
+			return fmt.Errorf("failed to query source collection at cluster time %d.%d: %w", clusterTime.T, clusterTime.I, err)
+		}
+		return nil
+	})
+	return cursor, err
+}
+
+// snapshotFindCommand builds a find command reading a collection with readConcern "snapshot" at a cluster time
+func snapshotFindCommand(collName string, filter bson.M, batchSize int, clusterTime primitive.Timestamp) bson.D {
+	if filter == nil {
+		filter = bson.M{}
+	}
+	return bson.D{
+		{Key: "find", Value: collName},
+		{Key: "filter", Value: filter},
+		{Key: "batchSize", Value: int32(batchSize)},
+		{Key: "noCursorTimeout", Value: true},
+		{Key: "readConcern", Value: bson.D{{Key: "level", Value: "snapshot"}, {Key: "atClusterTime", Value: clusterTime}}},
+	}
+}
+
+// copyForCatchUp copies the current data of a collection with upserts, since part of it may already have been
+// copied from the snapshot, and requires a change stream catch-up once all collections are copied
+func (c *SnapshotCopier) copyForCatchUp(ctx context.Context, sourceDB, targetDB *mongo.Database, collName string, filter bson.M) error {
+	c.fellBack = true
+
+	cursor, err := createCursor(ctx, sourceDB.Collection(collName), filter, c.batchSize)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	return processBatches(ctx, cursor, targetDB.Collection(collName), collName, true, c.batchSize, c.retryAttempts)
+}
+
+// isSnapshotTooOld reports whether an error means the requested snapshot is outside the history window
+func isSnapshotTooOld(err error) bool {
+	var serverErr mongo.ServerError
+	if !errors.As(err, &serverErr) {
+		return false
+	}
+	return serverErr.HasErrorCode(errCodeSnapshotTooOld) || serverErr.HasErrorCode(errCodeSnapshotUnavailable)
+}
+
+// CatchUp applies the changes made since the copier's cluster time to all copied collections when any collection
+// could not be read from the snapshot. Changes are applied up to the source's current cluster time, at which point
+// the copied collections are consistent with each other again.
+func (c *SnapshotCopier) CatchUp(ctx context.Context, target *Client) error {
+	if !c.fellBack {
+		return nil
+	}
+
+	endTime, err := CurrentClusterTime(ctx, c.source)
+	if err != nil {
+		return err
+	}
+
+	dbNames := make([]string, 0, len(c.copied))
+	for dbName := range c.copied {
+		dbNames = append(dbNames, dbName)
+	}
+	sort.Strings(dbNames)
+
+	for _, dbName := range dbNames {
+		fmt.Printf("Catching up %d collections in database %s from cluster time %d.%d to %d.%d\n",
+			len(c.copied[dbName]), dbName, c.clusterTime.T, c.clusterTime.I, endTime.T, endTime.I)
+
+		catchUp := &changeStreamCatchUp{
+			sourceDB:      c.source.Database(dbName),
+			targetDB:      target.GetDatabase(dbName),
+			collections:   c.copied[dbName],
+			startTime:     c.clusterTime,
+			endTime:       endTime,
+			retryAttempts: c.retryAttempts,
+		}
+		if err := catchUp.run(ctx); err != nil {
+			return fmt.Errorf("failed to catch up database %s: %w", dbName, err)
+		}
+	}
+
+	return nil
+}
+
+// changeEvent is the subset of a change stream event needed to replay it on the target
+type changeEvent struct {
+	OperationType string              `bson:"operationType"`
+	ClusterTime   primitive.Timestamp `bson:"clusterTime"`
+	DocumentKey   bson.M              `bson:"documentKey"`
+	FullDocument  bson.M              `bson:"fullDocument"`
+	Namespace     struct {
+		Collection string `bson:"coll"`
+	} `bson:"ns"`
+}
+
+// changeStreamCatchUp replays the change stream of a database between two cluster times
+type changeStreamCatchUp struct {
+	sourceDB      *mongo.Database
+	targetDB      *mongo.Database
+	collections   []string
+	startTime     primitive.Timestamp
+	endTime       primitive.Timestamp
+	retryAttempts int
+}
+
+// run watches the database from the start time and applies events until the end time is reached
+func (u *changeStreamCatchUp) run(ctx context.Context) error {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"ns.coll": bson.M{"$in": u.collections}}}},
+	}
+	startTime := u.startTime
+	streamOptions := options.ChangeStream().
+		SetStartAtOperationTime(&startTime).
+		SetFullDocument(options.UpdateLookup).
+		SetMaxAwaitTime(time.Second)
+
+	stream, err := u.sourceDB.Watch(ctx, pipeline, streamOptions)
+	if err != nil {
+		return fmt.Errorf("failed to open change stream: %w", err)
+	}
+	defer stream.Close(ctx)
+
+	var applied int64
+	for {
+		done, err := u.step(ctx, stream, &applied)
+		if err != nil {
+			return err
+		}
+		if done {
+			break
+		}
+	}
+
+	fmt.Printf("  Applied %d change events to database %s\n", applied, u.targetDB.Name())
+	return nil
+}
+
+// step applies the next available change event and reports whether the end time has been reached
+func (u *changeStreamCatchUp) step(ctx context.Context, stream *mongo.ChangeStream, applied *int64) (bool, error) {
+	if !stream.TryNext(ctx) {
+		if err := stream.Err(); err != nil {
+			return false, fmt.Errorf("change stream error: %w", err)
+		}
+		return u.reachedEnd(stream.ResumeToken())
+	}
+
+	var event changeEvent
+	if err := stream.Decode(&event); err != nil {
+		return false, fmt.Errorf("failed to decode change event: %w", err)
+	}
+	if primitive.CompareTimestamp(event.ClusterTime, u.endTime) > 0 {
+		return true, nil
+	}
+	if err := u.apply(ctx, &event); err != nil {
+		return false, err
+	}
+	*applied++
+	return false, nil
+}
+
+// reachedEnd reports whether the change stream has moved past the end time without further events.
+// A stream without a resume token yet is read further. A resume token whose cluster time cannot be decoded
+// fails the catch-up, since it cannot tell whether the events up to the end time were applied.
+func (u *changeStreamCatchUp) reachedEnd(token bson.Raw) (bool, error) {
+	if token == nil {
+		return false, nil
+	}
+	tokenTime, ok := ResumeTokenTime(token)
+	if !ok {
+		return false, fmt.Errorf("failed to read the cluster time of change stream resume token %s", token)
+	}
+	return primitive.CompareTimestamp(tokenTime, u.endTime) >= 0, nil
+}
+
+// apply replays a single change event on the target
+func (u *changeStreamCatchUp) apply(ctx context.Context, event *changeEvent) error {
+	targetColl := u.targetDB.Collection(event.Namespace.Collection)
+	filter := bson.M{"_id": event.DocumentKey["_id"]}
+
+	switch event.OperationType {
+	case "insert", "update", "replace":
+		// The looked up document is missing when it was deleted after the event
+		if event.FullDocument == nil {
+			return u.deleteDocument(ctx, targetColl, filter)
+		}
+		operation := fmt.Sprintf("Replay %s on %s", event.OperationType, targetColl.Name())
+		return RetryWithBackoff(ctx, u.retryAttempts, operation, func() error {
+			_, err := targetColl.ReplaceOne(ctx, filter, event.FullDocument, options.Replace().SetUpsert(true))
+			return err
+		})
+	case "delete":
+		return u.deleteDocument(ctx, targetColl, filter)
+	default:
+		fmt.Printf("  Warning: Skipping %s event on collection %s\n", event.OperationType, event.Namespace.Collection)
+		return nil
+	}
+}
+
+// deleteDocument deletes a document on the target
+func (u *changeStreamCatchUp) deleteDocument(ctx context.Context, targetColl *mongo.Collection, filter bson.M) error {
+	operation := fmt.Sprintf("Replay delete on %s", targetColl.Name())
+	return RetryWithBackoff(ctx, u.retryAttempts, operation, func() error {
+		_, err := targetColl.DeleteOne(ctx, filter)
+		return err
+	})
+}
+
+// ResumeTokenTime extracts the cluster time encoded at the start of a resume token's _data field
+func ResumeTokenTime(token bson.Raw) (primitive.Timestamp, bool) {
+	if token == nil {
+		return primitive.Timestamp{}, false
+	}
+
+	data, ok := token.Lookup("_data").StringValueOK()
+	if !ok {
+		return primitive.Timestamp{}, false
+	}
+
+	raw, err := hex.DecodeString(data)
+	if err != nil || len(raw) < 9 || raw[0] != resumeTokenTimestampType {
+		return primitive.Timestamp{}, false
+	}
+
+	return primitive.Timestamp{
+		T: binary.BigEndian.Uint32(raw[1:5]),
+		I: binary.BigEndian.Uint32(raw[5:9]),
+	}, true
+}
@@ -0,0 +1,198 @@
+package mongodb
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	tcmongodb "github.com/testcontainers/testcontainers-go/modules/mongodb"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// TestResumeTokenTime tests decoding the cluster time of a change stream resume token
+func TestResumeTokenTime(t *testing.T) {
+	tokenWithData := func(data string) bson.Raw {
+		raw, err := bson.Marshal(bson.M{"_data": data})
+		require.NoError(t, err)
+		return raw
+	}
+
+	tests := []struct {
+		name     string
+		token    bson.Raw
+		expected primitive.Timestamp
+		ok       bool
+	}{
+		{
+			name:     "Event token",
+			token:    tokenWithData("82" + "65A1B2C3" + "00000007" + "2B022C0100296E5A1004"),
+			expected: primitive.Timestamp{T: 0x65A1B2C3, I: 7},
+			ok:       true,
+		},
+		{
+			name:     "High water mark token",
+			token:    tokenWithData(fmt.Sprintf("82%08X%08X0104", 1700000000, 1)),
+			expected: primitive.Timestamp{T: 1700000000, I: 1},
+			ok:       true,
+		},
+		{name: "Nil token", token: nil},
+		{name: "Not hex", token: tokenWithData("zz")},
+		{name: "Too short", token: tokenWithData("8201")},
+		{name: "Unexpected type", token: tokenWithData("01" + hex.EncodeToString(make([]byte, 8)))},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts, ok := ResumeTokenTime(tt.token)
+			assert.Equal(t, tt.ok, ok)
+			assert.Equal(t, tt.expected, ts)
+		})
+	}
+}
+
+// TestCatchUpReachedEnd tests deciding from the resume token whether the catch-up reached its end time
+func TestCatchUpReachedEnd(t *testing.T) {
+	tokenWithData := func(data string) bson.Raw {
+		raw, err := bson.Marshal(bson.M{"_data": data})
+		require.NoError(t, err)
+		return raw
+	}
+	catchUp := &changeStreamCatchUp{endTime: primitive.Timestamp{T: 1700000000, I: 5}}
+
+	tests := []struct {
+		name     string
+		token    bson.Raw
+		expected bool
+		err      bool
+	}{
+		{name: "Before end time", token: tokenWithData(fmt.Sprintf("82%08X%08X0104", 1700000000, 4))},
+		{name: "At end time", token: tokenWithData(fmt.Sprintf("82%08X%08X0104", 1700000000, 5)), expected: true},
+		{name: "Past end time", token: tokenWithData(fmt.Sprintf("82%08X%08X0104", 1700000001, 0)), expected: true},
+		{name: "No token yet", token: nil},
+		{name: "Undecodable token", token: tokenWithData("zz"), err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			done, err := catchUp.reachedEnd(tt.token)
+			if tt.err {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tt.expected, done)
+		})
+	}
+}
+
+// TestSnapshotFindCommand tests pinning the cluster time in the read concern of the find command
+func TestSnapshotFindCommand(t *testing.T) {
+	clusterTime := primitive.Timestamp{T: 1700000000, I: 3}
+	command := snapshotFindCommand("orders", bson.M{"status": "paid"}, 500, clusterTime)
+	assert.Equal(t, bson.D{
+		{Key: "find", Value: "orders"},
+		{Key: "filter", Value: bson.M{"status": "paid"}},
+		{Key: "batchSize", Value: int32(500)},
+		{Key: "noCursorTimeout", Value: true},
+		{Key: "readConcern", Value: bson.D{{Key: "level", Value: "snapshot"}, {Key: "atClusterTime", Value: clusterTime}}},
+	}, command)
+
+	// A missing filter selects every document
+	command = snapshotFindCommand("orders", nil, 500, clusterTime)
+	assert.Equal(t, bson.M{}, command[1].Value)
+}
+
+// TestIsSnapshotTooOld tests recognizing errors for snapshots outside the history window
+func TestIsSnapshotTooOld(t *testing.T) {
+	assert.True(t, isSnapshotTooOld(mongo.CommandError{Code: errCodeSnapshotTooOld}))
+	assert.True(t, isSnapshotTooOld(fmt.Errorf("cursor error: %w", mongo.CommandError{Code: errCodeSnapshotUnavailable})))
+	assert.False(t, isSnapshotTooOld(mongo.CommandError{Code: 11000}))
+	assert.False(t, isSnapshotTooOld(fmt.Errorf("network error")))
+	assert.False(t, isSnapshotTooOld(nil))
+}
+
+// TestSnapshotCopy tests copying at a recorded cluster time and catching up from the change stream
+func TestSnapshotCopy(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	ctx := context.Background()
+	container, err := tcmongodb.Run(ctx, "mongo:8.0", tcmongodb.WithReplicaSet("rs0"))
+	require.NoError(t, err)
+	defer container.Terminate(ctx)
+
+	connString, err := container.ConnectionString(ctx)
+	require.NoError(t, err)
+
+	client, err := NewClient(ctx, connString, "")
+	require.NoError(t, err)
+	defer client.Disconnect(ctx)
+
+	sourceDB := client.GetDatabase("snapshot_source")
+	targetDB := client.GetDatabase("snapshot_target")
+
+	for i := 0; i < 10; i++ {
+		_, err := sourceDB.Collection("orders").InsertOne(ctx, bson.M{"_id": i, "status": "new"})
+		require.NoError(t, err)
+	}
+
+	copier, err := NewSnapshotCopier(ctx, client, 3, 3)
+	require.NoError(t, err)
+
+	// Changes after the recorded cluster time must not be visible to the copy
+	_, err = sourceDB.Collection("orders").InsertOne(ctx, bson.M{"_id": 100, "status": "new"})
+	require.NoError(t, err)
+	_, err = sourceDB.Collection("orders").UpdateOne(ctx, bson.M{"_id": 1}, bson.M{"$set": bson.M{"status": "paid"}})
+	require.NoError(t, err)
+	_, err = sourceDB.Collection("orders").DeleteOne(ctx, bson.M{"_id": 2})
+	require.NoError(t, err)
+
+	err = copier.CopyCollection(ctx, sourceDB, targetDB, "orders", false, "")
+	require.NoError(t, err)
+
+	count, err := targetDB.Collection("orders").CountDocuments(ctx, bson.M{})
+	require.NoError(t, err)
+	assert.Equal(t, int64(10), count)
+
+	var order bson.M
+	require.NoError(t, targetDB.Collection("orders").FindOne(ctx, bson.M{"_id": 1}).Decode(&order))
+	assert.Equal(t, "new", order["status"])
+
+	// Without a fallback, catching up leaves the target at the snapshot
+	require.NoError(t, copier.CatchUp(ctx, client))
+	count, err = targetDB.Collection("orders").CountDocuments(ctx, bson.M{})
+	require.NoError(t, err)
+	assert.Equal(t, int64(10), count)
+
+	// Replaying the change stream from the snapshot brings the target to the current state.
+	// The target database has a different name here, so the catch-up is run directly instead of through CatchUp.
+	err = (&changeStreamCatchUp{
+		sourceDB:      sourceDB,
+		targetDB:      targetDB,
+		collections:   []string{"orders"},
+		startTime:     copier.ClusterTime(),
+		endTime:       mustClusterTime(t, client),
+		retryAttempts: 3,
+	}).run(ctx)
+	require.NoError(t, err)
+
+	count, err = targetDB.Collection("orders").CountDocuments(ctx, bson.M{})
+	require.NoError(t, err)
+	assert.Equal(t, int64(10), count)
+	require.NoError(t, targetDB.Collection("orders").FindOne(ctx, bson.M{"_id": 1}).Decode(&order))
+	assert.Equal(t, "paid", order["status"])
+	assert.ErrorIs(t, targetDB.Collection("orders").FindOne(ctx, bson.M{"_id": 2}).Err(), mongo.ErrNoDocuments)
+	assert.NoError(t, targetDB.Collection("orders").FindOne(ctx, bson.M{"_id": 100}).Err())
+}
+
+func mustClusterTime(t *testing.T, client *Client) primitive.Timestamp {
+	ts, err := CurrentClusterTime(context.Background(), client.client)
+	require.NoError(t, err)
+	return ts
+}
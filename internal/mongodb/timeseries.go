@@ -0,0 +1,467 @@
+package mongodb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// timeSeriesBucketsPrefix is the prefix of the internal bucket collections backing time-series collections
+const timeSeriesBucketsPrefix = "system.buckets."
+
+// TimeSeriesSpec holds the options a time-series collection was created with
+type TimeSeriesSpec struct {
+	TimeField             string `bson:"timeField"`
+	MetaField             string `bson:"metaField,omitempty"`
+	Granularity           string `bson:"granularity,omitempty"`
+	BucketMaxSpanSeconds  int64  `bson:"bucketMaxSpanSeconds,omitempty"`
+	BucketRoundingSeconds int64  `bson:"bucketRoundingSeconds,omitempty"`
+	ExpireAfterSeconds    *int64 `bson:"-"`
+}
+
+// GetTimeSeriesSpecs returns the time-series collections of a database keyed by collection name.
+// Servers without time-series support simply return an empty map.
+func GetTimeSeriesSpecs(ctx context.Context, db *mongo.Database) (map[string]*TimeSeriesSpec, error) {
+	specs, err := db.ListCollectionSpecifications(ctx, bson.M{"type": "timeseries"})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list time-series collections for database %s: %w", db.Name(), err)
+	}
+
+	result := make(map[string]*TimeSeriesSpec, len(specs))
+	for _, spec := range specs {
+		tsSpec, err := parseTimeSeriesSpec(spec.Options)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse time-series options of %s.%s: %w", db.Name(), spec.Name, err)
+		}
+		result[spec.Name] = tsSpec
+	}
+
+	return result, nil
+}
+
+// parseTimeSeriesSpec extracts the time-series options from the options of a collection specification
+func parseTimeSeriesSpec(raw bson.Raw) (*TimeSeriesSpec, error) {
+	var collOptions struct {
+		TimeSeries         *TimeSeriesSpec `bson:"timeseries"`
+		ExpireAfterSeconds *int64          `bson:"expireAfterSeconds"`
+	}
+	if err := bson.Unmarshal(raw, &collOptions); err != nil {
+		return nil, err
+	}
+	if collOptions.TimeSeries == nil || collOptions.TimeSeries.TimeField == "" {
+		return nil, fmt.Errorf("missing timeseries.timeField")
+	}
+
+	collOptions.TimeSeries.ExpireAfterSeconds = collOptions.ExpireAfterSeconds
+	return collOptions.TimeSeries, nil
+}
+
+// createOptions returns the options needed to create a collection with the same time-series settings
+func (s *TimeSeriesSpec) createOptions() *options.CreateCollectionOptions {
+	tsOptions := options.TimeSeries().SetTimeField(s.TimeField)
+	if s.MetaField != "" {
+		tsOptions.SetMetaField(s.MetaField)
+	}
+
+	// The server reports the bucket span implied by a granularity, but refuses both being set on creation
+	if s.Granularity != "" {
+		tsOptions.SetGranularity(s.Granularity)
+	} else if s.BucketMaxSpanSeconds > 0 {
+		tsOptions.SetBucketMaxSpan(time.Duration(s.BucketMaxSpanSeconds) * time.Second)
+		tsOptions.SetBucketRounding(time.Duration(s.BucketRoundingSeconds) * time.Second)
+	}
+
+	createOptions := options.CreateCollection().SetTimeSeriesOptions(tsOptions)
+	if s.ExpireAfterSeconds != nil {
+		createOptions.SetExpireAfterSeconds(*s.ExpireAfterSeconds)
+	}
+	return createOptions
+}
+
+// EnsureTimeSeriesCollection creates a time-series collection on the target with the given options.
+// An existing time-series collection is left untouched; an existing regular collection is an error
+// because measurements cannot be converted into buckets after the fact.
+func EnsureTimeSeriesCollection(ctx context.Context, targetDB *mongo.Database, collName string, spec *TimeSeriesSpec) error {
+	existing, err := targetDB.ListCollectionSpecifications(ctx, bson.M{"name": collName})
+	if err != nil {
+		return fmt.Errorf("failed to check target collection %s: %w", collName, err)
+	}
+	if len(existing) > 0 {
+		if existing[0].Type != "timeseries" {
+			return fmt.Errorf("target collection %s already exists and is not a time-series collection", collName)
+		}
+		return nil
+	}
+
+	if err := targetDB.CreateCollection(ctx, collName, spec.createOptions()); err != nil {
+		return fmt.Errorf("failed to create time-series collection %s: %w", collName, err)
+	}
+
+	fmt.Printf("  Created time-series collection %s (timeField: %s, metaField: %s)\n", collName, spec.TimeField, spec.MetaField)
+	return nil
+}
+
+// CopyTimeSeriesCollection copies a time-series collection measurement by measurement.
+// The target is created with the source's time-series options and measurements are written with plain
+// inserts, since time-series collections do not support replacing measurements by _id.
+// In incremental mode only measurements newer than the latest measurement on the target are copied; otherwise
+// the target must be empty, since measurements have no unique _id and would be inserted a second time.
+func CopyTimeSeriesCollection(
+	ctx context.Context,
+	sourceDB, targetDB *mongo.Database,
+	collName string,
+	spec *TimeSeriesSpec,
+	incremental bool,
+	batchSize int,
+	retryAttempts int,
+) error {
+	fmt.Printf("  Copying time-series collection: %s\n", collName)
+
+	if err := EnsureTimeSeriesCollection(ctx, targetDB, collName, spec); err != nil {
+		return err
+	}
+
+	targetColl := targetDB.Collection(collName)
+	filter, err := timeSeriesFilter(ctx, targetColl, spec, incremental)
+	if err != nil {
+		return err
+	}
+
+	cursor, err := createCursor(ctx, sourceDB.Collection(collName), filter, batchSize)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	if err := copyMeasurements(ctx, cursor, targetColl, batchSize, retryAttempts); err != nil {
+		return err
+	}
+
+	if err := CopyCollectionIndexes(context.Background(), sourceDB, targetDB, collName); err != nil {
+		fmt.Printf("  Warning: Failed to copy indexes for collection %s: %v\n", collName, err)
+	}
+
+	return nil
+}
+
+// copyMeasurements inserts the measurements of the cursor in batches
+func copyMeasurements(ctx context.Context, cursor *mongo.Cursor, targetColl *mongo.Collection, batchSize, retryAttempts int) error {
+	batch := make([]interface{}, 0, batchSize)
+	var count int
+	for cursor.Next(ctx) {
+		var doc bson.M
+		if err := cursor.Decode(&doc); err != nil {
+			return fmt.Errorf("failed to decode measurement: %w", err)
+		}
+		var err error
+		if batch, err = flushMeasurements(ctx, targetColl, append(batch, doc), &count, batchSize, retryAttempts); err != nil {
+			return err
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return fmt.Errorf("cursor error: %w", err)
+	}
+	if _, err := flushMeasurements(ctx, targetColl, batch, &count, len(batch), retryAttempts); err != nil {
+		return err
+	}
+
+	fmt.Printf("  Completed copying collection: %s (%d measurements)\n", targetColl.Name(), count)
+	return nil
+}
+
+// flushMeasurements inserts the batch once it holds batchSize measurements
+func flushMeasurements(ctx context.Context, targetColl *mongo.Collection, batch []interface{}, count *int,
+	batchSize, retryAttempts int) ([]interface{}, error) {
+	if len(batch) == 0 || len(batch) < batchSize {
+		return batch, nil
+	}
+	if err := insertMeasurements(ctx, targetColl, batch, retryAttempts); err != nil {
+		return batch, err
+	}
+	*count += len(batch)
+	fmt.Printf("    Copied %d measurements to %s (total: %d)\n", len(batch), targetColl.Name(), *count)
+	return batch[:0], nil
+}
+
+// insertMeasurements inserts a batch of measurements. Time-series collections do not reject a second measurement
+// with the same _id, so a retry only inserts the measurements the failed attempt did not write.
+func insertMeasurements(ctx context.Context, targetColl *mongo.Collection, batch []interface{}, retryAttempts int) error {
+	pending := batch
+	retry := false
+	return RetryWithBackoff(ctx, retryAttempts, fmt.Sprintf("Insert %d measurements", len(batch)), func() error {
+		if retry {
+			var err error
+			if pending, err = missingMeasurements(ctx, targetColl, pending); err != nil || len(pending) == 0 {
+				return err
+			}
+		}
+		retry = true
+		_, err := targetColl.InsertMany(ctx, pending, options.InsertMany().SetOrdered(false))
+		return err
+	})
+}
+
+// missingMeasurements returns the measurements of a batch whose _id is not in the collection
+func missingMeasurements(ctx context.Context, coll *mongo.Collection, batch []interface{}) ([]interface{}, error) {
+	ids := make(bson.A, 0, len(batch))
+	for _, doc := range batch {
+		ids = append(ids, doc.(bson.M)["_id"])
+	}
+	findOptions := options.Find().SetProjection(bson.M{"_id": 1})
+	cursor, err := coll.Find(ctx, bson.M{"_id": bson.M{"$in": ids}}, findOptions)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up inserted measurements: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	existing := make(map[string]bool)
+	for cursor.Next(ctx) {
+		existing[rawValueKey(cursor.Current.Lookup("_id"))] = true
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, fmt.Errorf("failed to look up inserted measurements: %w", err)
+	}
+	return unwrittenMeasurements(batch, existing)
+}
+
+// unwrittenMeasurements returns the measurements of a batch whose _id is not among the existing keys
+func unwrittenMeasurements(batch []interface{}, existing map[string]bool) ([]interface{}, error) {
+	pending := make([]interface{}, 0, len(batch))
+	for _, doc := range batch {
+		valueType, data, err := bson.MarshalValue(doc.(bson.M)["_id"])
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode measurement _id: %w", err)
+		}
+		if !existing[rawValueKey(bson.RawValue{Type: valueType, Value: data})] {
+			pending = append(pending, doc)
+		}
+	}
+	return pending, nil
+}
+
+// rawValueKey returns a map key identifying a BSON value by its type and encoding
+func rawValueKey(value bson.RawValue) string {
+	return string(rune(value.Type)) + string(value.Value)
+}
+
+// timeSeriesFilter returns the filter selecting the measurements to copy
+func timeSeriesFilter(ctx context.Context, targetColl *mongo.Collection, spec *TimeSeriesSpec, incremental bool) (bson.M, error) {
+	if !incremental {
+		return bson.M{}, checkTimeSeriesTargetEmpty(ctx, targetColl)
+	}
+
+	latest, found, err := latestMeasurementTime(ctx, targetColl, spec.TimeField)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		fmt.Printf("  No measurements found on target, will copy all measurements\n")
+		return bson.M{}, nil
+	}
+
+	fmt.Printf("  Copying measurements with %s after %v\n", spec.TimeField, latest)
+	return bson.M{spec.TimeField: bson.M{"$gt": latest}}, nil
+}
+
+// checkTimeSeriesTargetEmpty fails when the target of a full copy already holds measurements
+func checkTimeSeriesTargetEmpty(ctx context.Context, targetColl *mongo.Collection) error {
+	count, err := targetColl.CountDocuments(ctx, bson.M{}, options.Count().SetLimit(1))
+	if err != nil {
+		return fmt.Errorf("failed to count measurements of target collection %s: %w", targetColl.Name(), err)
+	}
+	if count > 0 {
+		return fmt.Errorf("target time-series collection %s already has measurements, which a full copy would insert again; "+
+			"copy with --incremental or drop the target collection", targetColl.Name())
+	}
+	return nil
+}
+
+// latestMeasurementTime returns the largest time field value of a time-series collection
+func latestMeasurementTime(ctx context.Context, coll *mongo.Collection, timeField string) (interface{}, bool, error) {
+	findOptions := options.FindOne().
+		SetSort(bson.D{{Key: timeField, Value: -1}}).
+		SetProjection(bson.M{timeField: 1})
+
+	var doc bson.M
+	err := coll.FindOne(ctx, bson.M{}, findOptions).Decode(&doc)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, false, nil
+	} else if err != nil {
+		return nil, false, fmt.Errorf("failed to find latest measurement of %s: %w", coll.Name(), err)
+	}
+
+	return doc[timeField], true, nil
+}
+
+// CompareTimeSeriesData compares a time-series collection measurement by measurement.
+// Measurements are looked up on the target in batches restricted to the time range of the batch,
+// which lets the server skip buckets instead of scanning the collection for every _id.
+func CompareTimeSeriesData(
+	ctx context.Context,
+	sourceDB, targetDB *mongo.Database,
+	collName string,
+	spec *TimeSeriesSpec,
+	opts CompareOptions,
+) (*ComparisonResult, error) {
+	fmt.Printf("  Detailed comparison of time-series collection: %s\n", collName)
+
+	result := &ComparisonResult{
+		Database:   sourceDB.Name(),
+		Collection: collName,
+	}
+
+	sourceColl := sourceDB.Collection(collName)
+	targetColl := targetDB.Collection(collName)
+	if err := calculateCollectionCounts(ctx, sourceColl, targetColl, result); err != nil {
+		result.Error = err.Error()
+		return result, err
+	}
+
+	cmp := newDocumentComparer(opts, sourceDB.Name(), collName)
+	err := compareMeasurements(ctx, sourceColl, targetColl, spec, opts.BatchSize, cmp, result)
+	result.DiffsOmitted = cmp.diffs.omittedCount()
+	if err != nil {
+		result.Error = fmt.Sprintf("error comparing measurements: %v", err)
+		return result, fmt.Errorf("%s", result.Error)
+	}
+
+	return result, nil
+}
+
+// compareMeasurements reads source measurements in batches and compares each batch with the target
+func compareMeasurements(
+	ctx context.Context,
+	sourceColl, targetColl *mongo.Collection,
+	spec *TimeSeriesSpec,
+	batchSize int,
+	cmp *documentComparer,
+	result *ComparisonResult,
+) error {
+	cursor, err := createSourceCursor(ctx, sourceColl, batchSize)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	batch := make([]bson.D, 0, batchSize)
+	for cursor.Next(ctx) {
+		var doc bson.D
+		if err := cursor.Decode(&doc); err != nil {
+			return fmt.Errorf("failed to decode measurement: %w", err)
+		}
+		batch = append(batch, doc)
+
+		if len(batch) >= batchSize {
+			if err := compareMeasurementBatch(ctx, targetColl, spec.TimeField, batch, cmp, result); err != nil {
+				return err
+			}
+			batch = batch[:0]
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return fmt.Errorf("cursor error: %w", err)
+	}
+
+	return compareMeasurementBatch(ctx, targetColl, spec.TimeField, batch, cmp, result)
+}
+
+// compareMeasurementBatch looks up a batch of source measurements on the target with a single query
+func compareMeasurementBatch(
+	ctx context.Context,
+	targetColl *mongo.Collection,
+	timeField string,
+	batch []bson.D,
+	cmp *documentComparer,
+	result *ComparisonResult,
+) error {
+	if len(batch) == 0 {
+		return nil
+	}
+
+	targetDocs, err := findMeasurements(ctx, targetColl, timeField, batch)
+	if err != nil {
+		return err
+	}
+
+	for _, doc := range batch {
+		if err := compareMeasurement(doc, targetDocs, cmp, result); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// compareMeasurement compares a source measurement with the target measurement of the same _id
+func compareMeasurement(doc bson.D, targetDocs map[string]bson.D, cmp *documentComparer, result *ComparisonResult) error {
+	id, hasID := lookupField(doc, "_id")
+	if !hasID {
+		return nil
+	}
+
+	targetDoc, found := targetDocs[bsonFallbackString(id)]
+	if !found {
+		result.MissingInTarget++
+		return cmp.diffs.missing(id)
+	}
+	equal, err := cmp.equal(doc, targetDoc)
+	if !equal {
+		result.DifferentDocuments++
+	}
+	return err
+}
+
+// findMeasurements fetches the target measurements matching the _ids and time range of a batch, keyed by _id
+func findMeasurements(ctx context.Context, targetColl *mongo.Collection, timeField string, batch []bson.D) (map[string]bson.D, error) {
+	filter := measurementBatchFilter(timeField, batch)
+	cursor, err := targetColl.Find(ctx, filter)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query target measurements: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	docs := make(map[string]bson.D, len(batch))
+	for cursor.Next(ctx) {
+		var doc bson.D
+		if err := cursor.Decode(&doc); err != nil {
+			return nil, fmt.Errorf("failed to decode target measurement: %w", err)
+		}
+		docs[bsonFallbackString(documentID(doc))] = doc
+	}
+	return docs, cursor.Err()
+}
+
+// measurementBatchFilter selects the measurements with the _ids of a batch within the batch's time range,
+// so the lookup can use the time field to prune buckets
+func measurementBatchFilter(timeField string, batch []bson.D) bson.M {
+	ids := make([]interface{}, 0, len(batch))
+	var minTime, maxTime interface{}
+	for _, doc := range batch {
+		if id, ok := lookupField(doc, "_id"); ok {
+			ids = append(ids, id)
+		}
+		t, _ := lookupField(doc, timeField)
+		if minTime == nil || compareBSONValues(t, minTime) < 0 {
+			minTime = t
+		}
+		if maxTime == nil || compareBSONValues(t, maxTime) > 0 {
+			maxTime = t
+		}
+	}
+
+	return bson.M{
+		"_id":     bson.M{"$in": ids},
+		timeField: bson.M{"$gte": minTime, "$lte": maxTime},
+	}
+}
+
+// TimeSeriesCollectionName returns the time-series collection backed by a system.buckets collection
+func TimeSeriesCollectionName(collName string) (string, bool) {
+	name, ok := strings.CutPrefix(collName, timeSeriesBucketsPrefix)
+	return name, ok && name != ""
+}
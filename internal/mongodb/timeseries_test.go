@@ -0,0 +1,224 @@
+package mongodb
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// TestParseTimeSeriesSpec tests reading time-series options from a collection specification
+func TestParseTimeSeriesSpec(t *testing.T) {
+	tests := []struct {
+		name     string
+		options  bson.M
+		expected *TimeSeriesSpec
+		wantErr  bool
+	}{
+		{
+			name: "Granularity with meta field",
+			options: bson.M{
+				"timeseries": bson.M{
+					"timeField":            "ts",
+					"metaField":            "sensor",
+					"granularity":          "minutes",
+					"bucketMaxSpanSeconds": int32(86400),
+				},
+			},
+			expected: &TimeSeriesSpec{TimeField: "ts", MetaField: "sensor", Granularity: "minutes", BucketMaxSpanSeconds: 86400},
+		},
+		{
+			name: "Custom bucketing with expiry",
+			options: bson.M{
+				"timeseries": bson.M{
+					"timeField":             "ts",
+					"bucketMaxSpanSeconds":  int32(600),
+					"bucketRoundingSeconds": int32(600),
+				},
+				"expireAfterSeconds": int64(3600),
+			},
+			expected: &TimeSeriesSpec{
+				TimeField: "ts", BucketMaxSpanSeconds: 600, BucketRoundingSeconds: 600, ExpireAfterSeconds: int64Ptr(3600),
+			},
+		},
+		{
+			name:    "Not a time-series collection",
+			options: bson.M{"capped": true},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			raw, err := bson.Marshal(tt.options)
+			require.NoError(t, err)
+
+			spec, err := parseTimeSeriesSpec(raw)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, spec)
+		})
+	}
+}
+
+// TestTimeSeriesCreateOptions tests building collection options from a time-series spec
+func TestTimeSeriesCreateOptions(t *testing.T) {
+	t.Run("Granularity takes precedence over bucket span", func(t *testing.T) {
+		spec := &TimeSeriesSpec{TimeField: "ts", MetaField: "sensor", Granularity: "hours", BucketMaxSpanSeconds: 2592000}
+		opts := spec.createOptions()
+
+		require.NotNil(t, opts.TimeSeriesOptions)
+		assert.Equal(t, "ts", opts.TimeSeriesOptions.TimeField)
+		assert.Equal(t, "sensor", *opts.TimeSeriesOptions.MetaField)
+		assert.Equal(t, "hours", *opts.TimeSeriesOptions.Granularity)
+		assert.Nil(t, opts.TimeSeriesOptions.BucketMaxSpan)
+		assert.Nil(t, opts.ExpireAfterSeconds)
+	})
+
+	t.Run("Custom bucketing", func(t *testing.T) {
+		spec := &TimeSeriesSpec{TimeField: "ts", BucketMaxSpanSeconds: 600, BucketRoundingSeconds: 600, ExpireAfterSeconds: int64Ptr(60)}
+		opts := spec.createOptions()
+
+		assert.Nil(t, opts.TimeSeriesOptions.MetaField)
+		assert.Nil(t, opts.TimeSeriesOptions.Granularity)
+		assert.Equal(t, 10*time.Minute, *opts.TimeSeriesOptions.BucketMaxSpan)
+		assert.Equal(t, 10*time.Minute, *opts.TimeSeriesOptions.BucketRounding)
+		assert.Equal(t, int64(60), *opts.ExpireAfterSeconds)
+	})
+}
+
+// TestTimeSeriesCollectionName tests mapping bucket collections to their time-series collection
+func TestTimeSeriesCollectionName(t *testing.T) {
+	name, ok := TimeSeriesCollectionName("system.buckets.metrics")
+	assert.True(t, ok)
+	assert.Equal(t, "metrics", name)
+
+	_, ok = TimeSeriesCollectionName("system.buckets.")
+	assert.False(t, ok)
+
+	_, ok = TimeSeriesCollectionName("metrics")
+	assert.False(t, ok)
+
+	assert.True(t, isSystemCollection("system.buckets.metrics"))
+	assert.False(t, isSystemCollection("metrics"))
+}
+
+// TestTimeSeriesCopyAndCompare tests copying and comparing a time-series collection
+func TestTimeSeriesCopyAndCompare(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	sourceContainer, sourceConnString := setupMongoContainer(t)
+	defer sourceContainer.Terminate(context.Background())
+
+	targetContainer, targetConnString := setupMongoContainer(t)
+	defer targetContainer.Terminate(context.Background())
+
+	ctx := context.Background()
+	sourceClient, err := mongo.Connect(ctx, options.Client().ApplyURI(sourceConnString))
+	require.NoError(t, err)
+	defer sourceClient.Disconnect(ctx)
+
+	targetClient, err := mongo.Connect(ctx, options.Client().ApplyURI(targetConnString))
+	require.NoError(t, err)
+	defer targetClient.Disconnect(ctx)
+
+	sourceDB := sourceClient.Database("tsdb")
+	targetDB := targetClient.Database("tsdb")
+
+	tsOptions := options.TimeSeries().SetTimeField("ts").SetMetaField("sensor").SetGranularity("minutes")
+	err = sourceDB.CreateCollection(ctx, "metrics", options.CreateCollection().SetTimeSeriesOptions(tsOptions))
+	require.NoError(t, err)
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	docs := make([]interface{}, 0, 50)
+	for i := 0; i < 50; i++ {
+		docs = append(docs, bson.M{"ts": start.Add(time.Duration(i) * time.Minute), "sensor": i % 3, "value": i})
+	}
+	_, err = sourceDB.Collection("metrics").InsertMany(ctx, docs)
+	require.NoError(t, err)
+
+	specs, err := GetTimeSeriesSpecs(ctx, sourceDB)
+	require.NoError(t, err)
+	require.Contains(t, specs, "metrics")
+
+	err = CopyTimeSeriesCollection(ctx, sourceDB, targetDB, "metrics", specs["metrics"], false, 20, 3)
+	require.NoError(t, err)
+
+	targetSpecs, err := GetTimeSeriesSpecs(ctx, targetDB)
+	require.NoError(t, err)
+	require.Contains(t, targetSpecs, "metrics")
+	assert.Equal(t, "sensor", targetSpecs["metrics"].MetaField)
+	assert.Equal(t, "minutes", targetSpecs["metrics"].Granularity)
+
+	result, err := CompareTimeSeriesData(ctx, sourceDB, targetDB, "metrics", specs["metrics"], CompareOptions{BatchSize: 15, Detailed: true})
+	require.NoError(t, err)
+	assert.Equal(t, int64(50), result.TargetCount)
+	assert.Equal(t, int64(0), result.MissingInTarget)
+	assert.Equal(t, int64(0), result.DifferentDocuments)
+
+	// A second full copy would insert every measurement again, so it is refused
+	err = CopyTimeSeriesCollection(ctx, sourceDB, targetDB, "metrics", specs["metrics"], false, 20, 3)
+	assert.ErrorContains(t, err, "already has measurements")
+	count, err := targetDB.Collection("metrics").CountDocuments(ctx, bson.M{})
+	require.NoError(t, err)
+	assert.Equal(t, int64(50), count)
+
+	// Incremental copy only picks up newer measurements
+	_, err = sourceDB.Collection("metrics").InsertOne(ctx, bson.M{"ts": start.Add(time.Hour), "sensor": 1, "value": 100})
+	require.NoError(t, err)
+	err = CopyTimeSeriesCollection(ctx, sourceDB, targetDB, "metrics", specs["metrics"], true, 20, 3)
+	require.NoError(t, err)
+
+	count, err = targetDB.Collection("metrics").CountDocuments(ctx, bson.M{})
+	require.NoError(t, err)
+	assert.Equal(t, int64(51), count)
+
+	// Modify one measurement on the target and delete another
+	_, err = targetDB.Collection("metrics").UpdateMany(ctx, bson.M{"sensor": 2, "value": 2}, bson.M{"$set": bson.M{"value": -1}})
+	require.NoError(t, err)
+	_, err = targetDB.Collection("metrics").DeleteMany(ctx, bson.M{"sensor": 0, "value": 0})
+	require.NoError(t, err)
+
+	result, err = CompareTimeSeriesData(ctx, sourceDB, targetDB, "metrics", specs["metrics"], CompareOptions{BatchSize: 15, Detailed: true})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), result.MissingInTarget)
+	assert.Equal(t, int64(1), result.DifferentDocuments)
+}
+
+func TestUnwrittenMeasurements(t *testing.T) {
+	written := primitive.NewObjectID()
+	pending := primitive.NewObjectID()
+	batch := []interface{}{bson.M{"_id": written, "value": 1}, bson.M{"_id": pending, "value": 2}, bson.M{"_id": int32(7)}}
+
+	valueType, data, err := bson.MarshalValue(written)
+	require.NoError(t, err)
+	existing := map[string]bool{rawValueKey(bson.RawValue{Type: valueType, Value: data}): true}
+
+	// Only the measurements the failed attempt did not write are inserted again
+	unwritten, err := unwrittenMeasurements(batch, existing)
+	require.NoError(t, err)
+	assert.Equal(t, batch[1:], unwritten)
+
+	// Values of different types never share a key
+	valueType, data, err = bson.MarshalValue(int64(7))
+	require.NoError(t, err)
+	existing[rawValueKey(bson.RawValue{Type: valueType, Value: data})] = true
+	unwritten, err = unwrittenMeasurements(batch, existing)
+	require.NoError(t, err)
+	assert.Len(t, unwritten, 2)
+}
+
+func int64Ptr(v int64) *int64 {
+	return &v
+}
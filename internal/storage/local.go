@@ -0,0 +1,137 @@
+package storage
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// localBackend stores files on the local file system
+type localBackend struct{}
+
+// writeFile writes a file through a buffered temporary file that is synced to disk and then replaces the target
+func (localBackend) writeFile(location string, write func(w io.Writer) error) error {
+	tmpPath := location + ".tmp"
+	file, err := os.Create(tmpPath)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", tmpPath, err)
+	}
+
+	buffered := bufio.NewWriterSize(file, 1<<20)
+	if err := write(buffered); err != nil {
+		file.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := buffered.Flush(); err != nil {
+		file.Close()
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to write %s: %w", tmpPath, err)
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to sync %s: %w", tmpPath, err)
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to close %s: %w", tmpPath, err)
+	}
+
+	if err := os.Rename(tmpPath, location); err != nil {
+		return fmt.Errorf("failed to move %s into place: %w", location, err)
+	}
+	return nil
+}
+
+// create creates a file that must not exist yet
+func (localBackend) create(location string, data []byte) error {
+	file, err := os.OpenFile(location, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		os.Remove(location)
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		os.Remove(location)
+		return err
+	}
+	return file.Close()
+}
+
+func (localBackend) open(location string) (io.ReadCloser, error) {
+	return os.Open(location)
+}
+
+func (localBackend) stat(location string) (Entry, error) {
+	info, err := os.Stat(location)
+	if err != nil {
+		return Entry{}, err
+	}
+	return entryOf(info), nil
+}
+
+func (localBackend) readDir(location string) ([]Entry, error) {
+	dirEntries, err := os.ReadDir(location)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]Entry, 0, len(dirEntries))
+	for _, dirEntry := range dirEntries {
+		info, err := dirEntry.Info()
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entryOf(info))
+	}
+	return entries, nil
+}
+
+// files lists the files below a directory with their paths relative to it
+func (localBackend) files(location string) ([]Entry, error) {
+	var files []Entry
+	err := filepath.WalkDir(location, func(path string, dirEntry fs.DirEntry, err error) error {
+		if err != nil || dirEntry.IsDir() {
+			return err
+		}
+		info, err := dirEntry.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(location, path)
+		if err != nil {
+			return err
+		}
+		files = append(files, Entry{Name: filepath.ToSlash(rel), Size: info.Size()})
+		return nil
+	})
+	return files, err
+}
+
+// entryOf describes a file; like in object storage, directories have no size
+func entryOf(info fs.FileInfo) Entry {
+	if info.IsDir() {
+		return Entry{Name: info.Name(), IsDir: true}
+	}
+	return Entry{Name: info.Name(), Size: info.Size()}
+}
+
+func (localBackend) remove(location string) error {
+	return os.Remove(location)
+}
+
+func (localBackend) removeAll(location string) error {
+	return os.RemoveAll(location)
+}
+
+func (localBackend) mkdirAll(location string) error {
+	return os.MkdirAll(location, 0755)
+}
@@ -0,0 +1,201 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	// LockRefreshInterval is how often a held lock records that its holder is still running
+	LockRefreshInterval = time.Minute
+	// LockStaleAfter is how long after its last refresh a lock is considered abandoned
+	LockStaleAfter = 10 * LockRefreshInterval
+)
+
+// LockInfo identifies the process holding a lock. It is the content of the lock file.
+type LockInfo struct {
+	PID         int       `json:"pid"`
+	Host        string    `json:"host"`
+	AcquiredAt  time.Time `json:"acquiredAt"`
+	RefreshedAt time.Time `json:"refreshedAt"`
+}
+
+// LockedError is returned when a lock is held by another running process
+type LockedError struct {
+	Location string
+	Holder   LockInfo
+}
+
+func (e *LockedError) Error() string {
+	return fmt.Sprintf("%s is held by process %d on %s since %s", e.Location, e.Holder.PID, e.Holder.Host,
+		e.Holder.AcquiredAt.Format(time.RFC3339))
+}
+
+// Lock is an advisory lock held through a lock file. The lock file is refreshed while the lock is held,
+// so a lock left behind by a process that crashed, on any host, becomes stale after LockStaleAfter.
+// Locks of a process that is no longer running on the same host are stale immediately.
+type Lock struct {
+	location string
+	info     LockInfo
+	mu       sync.Mutex
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// AcquireLock creates the lock file at location, taking over a stale lock.
+// A lock held by a running process yields a *LockedError.
+func AcquireLock(location string) (*Lock, error) {
+	host, err := os.Hostname()
+	if err != nil {
+		return nil, fmt.Errorf("failed to determine host name: %w", err)
+	}
+	now := time.Now().UTC()
+	lock := &Lock{location: location, info: LockInfo{PID: os.Getpid(), Host: host, AcquiredAt: now, RefreshedAt: now}}
+
+	err = lock.create()
+	if errors.Is(err, fs.ErrExist) {
+		err = lock.takeOverStale(now)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	lock.stop = make(chan struct{})
+	lock.done = make(chan struct{})
+	go lock.refreshLoop()
+	return lock, nil
+}
+
+// Release stops refreshing the lock and removes the lock file, unless another process took it over
+func (l *Lock) Release() error {
+	close(l.stop)
+	<-l.done
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	holder, err := ReadLock(l.location)
+	if errors.Is(err, fs.ErrNotExist) || (err == nil && !sameHolder(holder, l.info)) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return Remove(l.location)
+}
+
+// ReadLock reads the holder of a lock file
+func ReadLock(location string) (LockInfo, error) {
+	data, err := ReadFile(location)
+	if err != nil {
+		return LockInfo{}, err
+	}
+	var info LockInfo
+	if err := json.Unmarshal(data, &info); err != nil {
+		return LockInfo{}, fmt.Errorf("failed to parse lock file %s: %w", location, err)
+	}
+	return info, nil
+}
+
+// IsStale reports whether the holder of a lock is no longer running
+func (info LockInfo) IsStale(now time.Time) bool {
+	if now.Sub(info.RefreshedAt) > LockStaleAfter {
+		return true
+	}
+	host, err := os.Hostname()
+	return err == nil && info.Host == host && !processRunning(info.PID)
+}
+
+// create writes the lock file if no lock exists
+func (l *Lock) create() error {
+	data, err := json.Marshal(l.info)
+	if err != nil {
+		return err
+	}
+	return Create(l.location, data)
+}
+
+// takeOverStale replaces the existing lock file if its holder is gone
+func (l *Lock) takeOverStale(now time.Time) error {
+	holder, err := ReadLock(l.location)
+	if errors.Is(err, fs.ErrNotExist) {
+		// The holder released the lock in the meantime
+		return l.create()
+	}
+	if err != nil {
+		return err
+	}
+	if !holder.IsStale(now) {
+		return &LockedError{Location: l.location, Holder: holder}
+	}
+
+	fmt.Printf("Taking over stale lock %s of process %d on %s\n", l.location, holder.PID, holder.Host)
+	if err := Remove(l.location); err != nil {
+		return fmt.Errorf("failed to remove stale lock: %w", err)
+	}
+	err = l.create()
+	if errors.Is(err, fs.ErrExist) {
+		// Another process took over the stale lock first
+		if holder, readErr := ReadLock(l.location); readErr == nil {
+			return &LockedError{Location: l.location, Holder: holder}
+		}
+	}
+	return err
+}
+
+// refreshLoop records that the holder is still running until the lock is released
+func (l *Lock) refreshLoop() {
+	defer close(l.done)
+	ticker := time.NewTicker(LockRefreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C:
+			if err := l.refresh(); err != nil {
+				fmt.Printf("Warning: failed to refresh lock %s: %v\n", l.location, err)
+			}
+		}
+	}
+}
+
+// refresh updates the refresh time of the lock file while it is still held by this lock
+func (l *Lock) refresh() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	holder, err := ReadLock(l.location)
+	if err != nil {
+		return err
+	}
+	if !sameHolder(holder, l.info) {
+		return fmt.Errorf("lock was taken over by process %d on %s", holder.PID, holder.Host)
+	}
+
+	l.info.RefreshedAt = time.Now().UTC()
+	data, err := json.Marshal(l.info)
+	if err != nil {
+		return err
+	}
+	return WriteBytes(l.location, data)
+}
+
+// sameHolder reports whether two lock records belong to the same acquisition of a lock
+func sameHolder(a, b LockInfo) bool {
+	return a.PID == b.PID && a.Host == b.Host && a.AcquiredAt.Equal(b.AcquiredAt)
+}
+
+// processRunning reports whether a process of this host is running. Where that cannot be determined,
+// the process is assumed to be running.
+func processRunning(pid int) bool {
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	return !errors.Is(process.Signal(syscall.Signal(0)), os.ErrProcessDone)
+}
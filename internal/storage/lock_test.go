@@ -0,0 +1,101 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"os/exec"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeLock writes a lock file as left by another process
+func writeLock(t *testing.T, location string, info LockInfo) {
+	data, err := json.Marshal(info)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(location, data, 0644))
+}
+
+// finishedPID returns the PID of a process that is no longer running
+func finishedPID(t *testing.T) int {
+	cmd := exec.Command("true")
+	require.NoError(t, cmd.Run())
+	return cmd.Process.Pid
+}
+
+func TestAcquireLock(t *testing.T) {
+	location := Join(t.TempDir(), "dump-state.json.lock")
+
+	lock, err := AcquireLock(location)
+	require.NoError(t, err)
+	holder, err := ReadLock(location)
+	require.NoError(t, err)
+	assert.Equal(t, os.Getpid(), holder.PID)
+
+	// A lock held by a running process cannot be acquired
+	_, err = AcquireLock(location)
+	var locked *LockedError
+	require.True(t, errors.As(err, &locked))
+	assert.Equal(t, os.Getpid(), locked.Holder.PID)
+
+	require.NoError(t, lock.refresh())
+	refreshed, err := ReadLock(location)
+	require.NoError(t, err)
+	assert.False(t, refreshed.RefreshedAt.Before(holder.RefreshedAt))
+
+	require.NoError(t, lock.Release())
+	assert.NoFileExists(t, location)
+}
+
+func TestAcquireStaleLock(t *testing.T) {
+	host, err := os.Hostname()
+	require.NoError(t, err)
+	now := time.Now().UTC()
+
+	tests := []struct {
+		name   string
+		holder LockInfo
+		stale  bool
+	}{
+		{name: "FinishedProcess", holder: LockInfo{PID: finishedPID(t), Host: host, AcquiredAt: now, RefreshedAt: now}, stale: true},
+		{name: "NotRefreshed", holder: LockInfo{PID: 1, Host: "other", RefreshedAt: now.Add(-LockStaleAfter - time.Minute)}, stale: true},
+		{name: "OtherHost", holder: LockInfo{PID: 1, Host: "other", AcquiredAt: now, RefreshedAt: now}},
+		{name: "RunningProcess", holder: LockInfo{PID: os.Getpid(), Host: host, AcquiredAt: now, RefreshedAt: now}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			location := Join(t.TempDir(), "restore-state.json.lock")
+			writeLock(t, location, tt.holder)
+			assert.Equal(t, tt.stale, tt.holder.IsStale(now))
+
+			lock, err := AcquireLock(location)
+			if !tt.stale {
+				var locked *LockedError
+				assert.True(t, errors.As(err, &locked))
+				return
+			}
+			require.NoError(t, err)
+			require.NoError(t, lock.Release())
+		})
+	}
+}
+
+func TestReleaseTakenOverLock(t *testing.T) {
+	location := Join(t.TempDir(), "dump-state.json.lock")
+	lock, err := AcquireLock(location)
+	require.NoError(t, err)
+
+	// Once another process took the lock over, the lock is neither refreshed nor removed
+	other := LockInfo{PID: 1, Host: "other", AcquiredAt: time.Now().UTC(), RefreshedAt: time.Now().UTC()}
+	writeLock(t, location, other)
+	assert.ErrorContains(t, lock.refresh(), "taken over by process 1 on other")
+	require.NoError(t, lock.Release())
+
+	holder, err := ReadLock(location)
+	require.NoError(t, err)
+	assert.Equal(t, "other", holder.Host)
+}
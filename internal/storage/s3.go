@@ -0,0 +1,469 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultPartSize is the size of the parts of multipart uploads
+	DefaultPartSize = 16 << 20
+	// minPartSize is the smallest part S3 accepts, except for the last part of an upload
+	minPartSize = 5 << 20
+	// s3Attempts is the number of attempts of a request failing with a network error or a transient status
+	s3Attempts = 4
+	// defaultS3Region is used when neither the configuration nor the environment names a region
+	defaultS3Region = "us-east-1"
+)
+
+// S3Config configures access to S3-compatible object storage.
+// Empty fields fall back to the standard AWS environment variables.
+type S3Config struct {
+	// Endpoint is the URL of the storage service, such as http://localhost:9000 for MinIO.
+	// Buckets of custom endpoints are addressed in the path, AWS buckets in the host name.
+	Endpoint        string
+	Region          string
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	// PartSize is the size of the parts of multipart uploads, at least 5 MiB
+	PartSize int
+}
+
+var (
+	s3Mu      sync.Mutex
+	s3Config  S3Config
+	s3Current *s3Client
+)
+
+// ConfigureS3 sets the configuration used for s3:// locations
+func ConfigureS3(cfg S3Config) {
+	s3Mu.Lock()
+	defer s3Mu.Unlock()
+	s3Config = cfg
+	s3Current = nil
+}
+
+// currentS3Backend returns the client for the configured object storage, creating it on first use
+func currentS3Backend() (*s3Client, error) {
+	s3Mu.Lock()
+	defer s3Mu.Unlock()
+	if s3Current == nil {
+		client, err := newS3Client(s3Config)
+		if err != nil {
+			return nil, err
+		}
+		s3Current = client
+	}
+	return s3Current, nil
+}
+
+// s3Client implements the storage operations with the S3 REST API
+type s3Client struct {
+	endpoint    *url.URL
+	pathStyle   bool
+	region      string
+	credentials credentials
+	partSize    int
+	http        *http.Client
+}
+
+// newS3Client creates a client from a configuration completed with the AWS environment variables
+func newS3Client(cfg S3Config) (*s3Client, error) {
+	region := firstNonEmpty(cfg.Region, os.Getenv("AWS_REGION"), os.Getenv("AWS_DEFAULT_REGION"), defaultS3Region)
+	creds := credentials{
+		accessKeyID:     firstNonEmpty(cfg.AccessKeyID, os.Getenv("AWS_ACCESS_KEY_ID")),
+		secretAccessKey: firstNonEmpty(cfg.SecretAccessKey, os.Getenv("AWS_SECRET_ACCESS_KEY")),
+		sessionToken:    firstNonEmpty(cfg.SessionToken, os.Getenv("AWS_SESSION_TOKEN")),
+	}
+	if creds.accessKeyID == "" || creds.secretAccessKey == "" {
+		return nil, errors.New("no S3 credentials: set AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY")
+	}
+
+	endpoint, pathStyle, err := s3Endpoint(cfg.Endpoint, region)
+	if err != nil {
+		return nil, err
+	}
+
+	partSize := cfg.PartSize
+	if partSize == 0 {
+		partSize = DefaultPartSize
+	}
+	if partSize < minPartSize {
+		return nil, fmt.Errorf("S3 part size must be at least %d bytes", minPartSize)
+	}
+
+	return &s3Client{
+		endpoint:    endpoint,
+		pathStyle:   pathStyle,
+		region:      region,
+		credentials: creds,
+		partSize:    partSize,
+		http:        &http.Client{},
+	}, nil
+}
+
+// s3Endpoint returns the endpoint of the storage service and whether buckets are addressed in its path,
+// which is the case for custom endpoints
+func s3Endpoint(configured, region string) (*url.URL, bool, error) {
+	endpoint := firstNonEmpty(configured, os.Getenv("AWS_ENDPOINT_URL_S3"), os.Getenv("AWS_ENDPOINT_URL"))
+	pathStyle := endpoint != ""
+	if endpoint == "" {
+		endpoint = "https://s3." + region + ".amazonaws.com"
+	}
+
+	endpointURL, err := url.Parse(endpoint)
+	if err != nil || endpointURL.Host == "" {
+		return nil, false, fmt.Errorf("invalid S3 endpoint %q", endpoint)
+	}
+	return endpointURL, pathStyle, nil
+}
+
+// s3Error is an error response of the storage service
+type s3Error struct {
+	StatusCode int
+	Code       string `xml:"Code"`
+	Message    string `xml:"Message"`
+}
+
+func (e *s3Error) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("S3 request failed with status %d", e.StatusCode)
+	}
+	return fmt.Sprintf("S3 request failed with status %d: %s: %s", e.StatusCode, e.Code, e.Message)
+}
+
+// s3Request is a request to the storage service
+type s3Request struct {
+	method string
+	bucket string
+	key    string
+	query  url.Values
+	header http.Header
+	body   []byte
+}
+
+// do sends a request, retrying network errors and transient statuses. Responses with any other
+// non-success status become an *s3Error; the body of successful responses is left to the caller.
+func (c *s3Client) do(ctx context.Context, req s3Request) (*http.Response, error) {
+	var lastErr error
+	for attempt := 1; attempt <= s3Attempts; attempt++ {
+		resp, err := c.send(ctx, req)
+		if err == nil {
+			if resp.StatusCode < 300 {
+				return resp, nil
+			}
+			err = responseError(resp)
+			if !retryableStatus(resp.StatusCode) {
+				return nil, err
+			}
+		}
+		lastErr = err
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(time.Duration(attempt) * 500 * time.Millisecond):
+		}
+	}
+	return nil, lastErr
+}
+
+// send signs and sends one attempt of a request
+func (c *s3Client) send(ctx context.Context, req s3Request) (*http.Response, error) {
+	httpReq, err := http.NewRequestWithContext(ctx, req.method, c.requestURL(req.bucket, req.key, req.query), bytes.NewReader(req.body))
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range req.header {
+		httpReq.Header[name] = values
+	}
+	signRequest(httpReq, c.credentials, c.region, hashHex(req.body), time.Now())
+	return c.http.Do(httpReq)
+}
+
+// requestURL returns the URL of an object, or of a bucket when key is empty
+func (c *s3Client) requestURL(bucket, key string, query url.Values) string {
+	u := *c.endpoint
+	objectPath := "/" + key
+	if c.pathStyle {
+		objectPath = "/" + bucket
+		if key != "" {
+			objectPath += "/" + key
+		}
+	} else {
+		u.Host = bucket + "." + u.Host
+	}
+	u.Path = strings.TrimSuffix(c.endpoint.Path, "/") + objectPath
+	u.RawPath = uriEncode(u.Path, false)
+	u.RawQuery = canonicalQuery(query)
+	return u.String()
+}
+
+// responseError reads the error of a failed response
+func responseError(resp *http.Response) error {
+	defer resp.Body.Close()
+	s3Err := &s3Error{}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	_ = xml.Unmarshal(body, s3Err)
+	s3Err.StatusCode = resp.StatusCode
+	return s3Err
+}
+
+// retryableStatus reports whether a request failing with a status may succeed when sent again
+func retryableStatus(status int) bool {
+	return status == http.StatusTooManyRequests || status >= http.StatusInternalServerError
+}
+
+// isNotFound reports whether an error is a response for a missing object
+func isNotFound(err error) bool {
+	var s3Err *s3Error
+	return errors.As(err, &s3Err) && s3Err.StatusCode == http.StatusNotFound
+}
+
+// writeFile streams a file to an object. Nothing is stored when write fails.
+func (c *s3Client) writeFile(location string, write func(w io.Writer) error) error {
+	bucket, key := splitS3Location(location)
+	upload := &uploadWriter{ctx: context.Background(), client: c, bucket: bucket, key: key}
+	if err := write(upload); err != nil {
+		upload.abort()
+		return err
+	}
+	if err := upload.finish(); err != nil {
+		upload.abort()
+		return fmt.Errorf("failed to upload %s: %w", location, err)
+	}
+	return nil
+}
+
+// create stores an object only if none exists yet, with a conditional write
+func (c *s3Client) create(location string, data []byte) error {
+	bucket, key := splitS3Location(location)
+	resp, err := c.do(context.Background(), s3Request{
+		method: http.MethodPut, bucket: bucket, key: key, body: data, header: http.Header{"If-None-Match": {"*"}},
+	})
+	var s3Err *s3Error
+	if errors.As(err, &s3Err) && (s3Err.StatusCode == http.StatusPreconditionFailed || s3Err.StatusCode == http.StatusConflict) {
+		return &fs.PathError{Op: "create", Path: location, Err: fs.ErrExist}
+	}
+	if err != nil {
+		return fmt.Errorf("failed to upload %s: %w", location, err)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (c *s3Client) open(location string) (io.ReadCloser, error) {
+	bucket, key := splitS3Location(location)
+	resp, err := c.do(context.Background(), s3Request{method: http.MethodGet, bucket: bucket, key: key})
+	if isNotFound(err) {
+		return nil, notExist("open", location)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to download %s: %w", location, err)
+	}
+	return resp.Body, nil
+}
+
+// stat describes an object, or a directory when objects exist below the location
+func (c *s3Client) stat(location string) (Entry, error) {
+	bucket, key := splitS3Location(location)
+	ctx := context.Background()
+	if key != "" {
+		resp, err := c.do(ctx, s3Request{method: http.MethodHead, bucket: bucket, key: key})
+		if err == nil {
+			resp.Body.Close()
+			return Entry{Name: Base(location), Size: resp.ContentLength}, nil
+		}
+		if !isNotFound(err) {
+			return Entry{}, fmt.Errorf("failed to stat %s: %w", location, err)
+		}
+	}
+
+	page, err := c.listPage(ctx, bucket, dirPrefix(key), "/", "", 1)
+	if err != nil {
+		return Entry{}, fmt.Errorf("failed to stat %s: %w", location, err)
+	}
+	if len(page.Contents) == 0 && len(page.CommonPrefixes) == 0 {
+		return Entry{}, notExist("stat", location)
+	}
+	return Entry{Name: Base(location), IsDir: true}, nil
+}
+
+func (c *s3Client) readDir(location string) ([]Entry, error) {
+	bucket, key := splitS3Location(location)
+	prefix := dirPrefix(key)
+	objects, prefixes, err := c.list(context.Background(), bucket, prefix, "/")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s: %w", location, err)
+	}
+	if len(objects) == 0 && len(prefixes) == 0 {
+		return nil, notExist("readdir", location)
+	}
+
+	entries := make([]Entry, 0, len(objects)+len(prefixes))
+	for _, object := range objects {
+		entries = append(entries, Entry{Name: strings.TrimPrefix(object.Key, prefix), Size: object.Size})
+	}
+	for _, dir := range prefixes {
+		entries = append(entries, Entry{Name: strings.TrimSuffix(strings.TrimPrefix(dir, prefix), "/"), IsDir: true})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+	return entries, nil
+}
+
+// files lists the objects below a location with their keys relative to it
+func (c *s3Client) files(location string) ([]Entry, error) {
+	bucket, key := splitS3Location(location)
+	prefix := dirPrefix(key)
+	objects, _, err := c.list(context.Background(), bucket, prefix, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s: %w", location, err)
+	}
+
+	files := make([]Entry, 0, len(objects))
+	for _, object := range objects {
+		files = append(files, Entry{Name: strings.TrimPrefix(object.Key, prefix), Size: object.Size})
+	}
+	return files, nil
+}
+
+func (c *s3Client) remove(location string) error {
+	bucket, key := splitS3Location(location)
+	resp, err := c.do(context.Background(), s3Request{method: http.MethodDelete, bucket: bucket, key: key})
+	if err != nil {
+		return fmt.Errorf("failed to delete %s: %w", location, err)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (c *s3Client) removeAll(location string) error {
+	files, err := c.files(location)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if err := c.remove(Join(location, file.Name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *s3Client) mkdirAll(string) error {
+	return nil
+}
+
+// listBucketResult is a page of a ListObjectsV2 response
+type listBucketResult struct {
+	Contents []struct {
+		Key  string `xml:"Key"`
+		Size int64  `xml:"Size"`
+	} `xml:"Contents"`
+	CommonPrefixes []struct {
+		Prefix string `xml:"Prefix"`
+	} `xml:"CommonPrefixes"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+// listedObject is an object of a listing
+type listedObject struct {
+	Key  string
+	Size int64
+}
+
+// list lists all objects under a prefix and, with a delimiter, the prefixes they are grouped in
+func (c *s3Client) list(ctx context.Context, bucket, prefix, delimiter string) ([]listedObject, []string, error) {
+	var objects []listedObject
+	var prefixes []string
+	token := ""
+	for {
+		page, err := c.listPage(ctx, bucket, prefix, delimiter, token, 0)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, object := range page.Contents {
+			objects = append(objects, listedObject{Key: object.Key, Size: object.Size})
+		}
+		for _, common := range page.CommonPrefixes {
+			prefixes = append(prefixes, common.Prefix)
+		}
+		if !page.IsTruncated || page.NextContinuationToken == "" {
+			return objects, prefixes, nil
+		}
+		token = page.NextContinuationToken
+	}
+}
+
+// listPage requests one page of a listing; maxKeys 0 leaves the page size to the service
+func (c *s3Client) listPage(ctx context.Context, bucket, prefix, delimiter, token string, maxKeys int) (*listBucketResult, error) {
+	query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+	if delimiter != "" {
+		query.Set("delimiter", delimiter)
+	}
+	if token != "" {
+		query.Set("continuation-token", token)
+	}
+	if maxKeys > 0 {
+		query.Set("max-keys", strconv.Itoa(maxKeys))
+	}
+
+	resp, err := c.do(ctx, s3Request{method: http.MethodGet, bucket: bucket, query: query})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	page := &listBucketResult{}
+	if err := xml.NewDecoder(resp.Body).Decode(page); err != nil {
+		return nil, fmt.Errorf("failed to decode listing: %w", err)
+	}
+	return page, nil
+}
+
+// splitS3Location splits an s3://bucket/key location
+func splitS3Location(location string) (string, string) {
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(location, s3Scheme), "/")
+	return bucket, strings.Trim(key, "/")
+}
+
+// s3Location returns the location of a key in a bucket
+func s3Location(bucket, key string) string {
+	key = strings.Trim(key, "/")
+	if key == "" || key == "." {
+		return s3Scheme + bucket
+	}
+	return s3Scheme + bucket + "/" + key
+}
+
+// dirPrefix returns the prefix of the keys below a directory key
+func dirPrefix(key string) string {
+	if key == "" {
+		return ""
+	}
+	return key + "/"
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,293 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/wait"
+)
+
+// fakeS3 is an in-memory S3 service implementing the requests the storage package sends
+type fakeS3 struct {
+	mu       sync.Mutex
+	objects  map[string][]byte
+	uploads  map[string]map[int][]byte
+	requests []string
+}
+
+func newFakeS3() *fakeS3 {
+	return &fakeS3{objects: map[string][]byte{}, uploads: map[string]map[int][]byte{}}
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if !strings.HasPrefix(r.Header.Get("Authorization"), sigV4Algorithm+" ") {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	body, _ := io.ReadAll(r.Body)
+	query := r.URL.Query()
+	f.requests = append(f.requests, r.Method+" "+r.URL.Path+"?"+r.URL.RawQuery)
+
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	switch {
+	case query.Has("list-type"):
+		f.list(w, bucket, query)
+	case query.Has("uploads"):
+		id := strconv.Itoa(len(f.uploads) + 1)
+		f.uploads[id] = map[int][]byte{}
+		fmt.Fprintf(w, "<InitiateMultipartUploadResult><UploadId>%s</UploadId></InitiateMultipartUploadResult>", id)
+	case query.Has("partNumber"):
+		number, _ := strconv.Atoi(query.Get("partNumber"))
+		f.uploads[query.Get("uploadId")][number] = body
+		sum := md5.Sum(body)
+		w.Header().Set("ETag", `"`+hex.EncodeToString(sum[:])+`"`)
+	case query.Has("uploadId") && r.Method == http.MethodPost:
+		var complete completeMultipartUpload
+		_ = xml.Unmarshal(body, &complete)
+		var data []byte
+		for _, part := range complete.Parts {
+			data = append(data, f.uploads[query.Get("uploadId")][part.PartNumber]...)
+		}
+		f.objects[bucket+"/"+key] = data
+		delete(f.uploads, query.Get("uploadId"))
+		fmt.Fprint(w, "<CompleteMultipartUploadResult></CompleteMultipartUploadResult>")
+	case query.Has("uploadId"):
+		delete(f.uploads, query.Get("uploadId"))
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		f.object(w, r, bucket+"/"+key, body)
+	}
+}
+
+func (f *fakeS3) object(w http.ResponseWriter, r *http.Request, name string, body []byte) {
+	switch r.Method {
+	case http.MethodPut:
+		if _, exists := f.objects[name]; exists && r.Header.Get("If-None-Match") == "*" {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			return
+		}
+		f.objects[name] = body
+	case http.MethodDelete:
+		delete(f.objects, name)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		data, ok := f.objects[name]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, "<Error><Code>NoSuchKey</Code><Message>The specified key does not exist.</Message></Error>")
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		if r.Method == http.MethodGet {
+			_, _ = w.Write(data)
+		}
+	}
+}
+
+func (f *fakeS3) list(w http.ResponseWriter, bucket string, query map[string][]string) {
+	prefix, delimiter := "", ""
+	if values := query["prefix"]; len(values) > 0 {
+		prefix = values[0]
+	}
+	if values := query["delimiter"]; len(values) > 0 {
+		delimiter = values[0]
+	}
+
+	var names []string
+	for name := range f.objects {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var result listBucketResult
+	seen := map[string]bool{}
+	for _, name := range names {
+		key, ok := strings.CutPrefix(name, bucket+"/")
+		if !ok || !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		rest := strings.TrimPrefix(key, prefix)
+		if i := strings.Index(rest, delimiter); delimiter != "" && i >= 0 {
+			common := prefix + rest[:i+1]
+			if !seen[common] {
+				seen[common] = true
+				result.CommonPrefixes = append(result.CommonPrefixes, struct {
+					Prefix string `xml:"Prefix"`
+				}{common})
+			}
+			continue
+		}
+		result.Contents = append(result.Contents, struct {
+			Key  string `xml:"Key"`
+			Size int64  `xml:"Size"`
+		}{key, int64(len(f.objects[name]))})
+	}
+	data, _ := xml.Marshal(struct {
+		XMLName xml.Name `xml:"ListBucketResult"`
+		listBucketResult
+	}{listBucketResult: result})
+	_, _ = w.Write(data)
+}
+
+// useS3 configures the storage package for an endpoint for the duration of a test
+func useS3(t *testing.T, cfg S3Config) {
+	ConfigureS3(cfg)
+	t.Cleanup(func() { ConfigureS3(S3Config{}) })
+}
+
+func TestS3Storage(t *testing.T) {
+	fake := newFakeS3()
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	useS3(t, S3Config{Endpoint: server.URL, AccessKeyID: "key", SecretAccessKey: "secret", PartSize: minPartSize})
+
+	testRemoteStorage(t, "s3://dumps/nightly")
+
+	// Large files are uploaded in parts of the configured size
+	assert.Contains(t, fake.requests, "PUT /dumps/nightly/app/large.bson?partNumber=3&uploadId=1")
+	assert.Empty(t, fake.uploads)
+}
+
+func TestS3StorageAbortsFailedUploads(t *testing.T) {
+	fake := newFakeS3()
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	useS3(t, S3Config{Endpoint: server.URL, AccessKeyID: "key", SecretAccessKey: "secret", PartSize: minPartSize})
+
+	err := WriteFile("s3://dumps/app/orders.bson", func(w io.Writer) error {
+		if _, err := w.Write(make([]byte, minPartSize+1)); err != nil {
+			return err
+		}
+		return errors.New("interrupted")
+	})
+	assert.ErrorContains(t, err, "interrupted")
+	assert.Empty(t, fake.uploads)
+	assert.Empty(t, fake.objects)
+}
+
+func TestNewS3Client(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "")
+	t.Setenv("AWS_REGION", "eu-west-1")
+	t.Setenv("AWS_ENDPOINT_URL_S3", "")
+	t.Setenv("AWS_ENDPOINT_URL", "")
+
+	_, err := newS3Client(S3Config{})
+	assert.ErrorContains(t, err, "no S3 credentials")
+
+	t.Setenv("AWS_ACCESS_KEY_ID", "key")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	client, err := newS3Client(S3Config{})
+	require.NoError(t, err)
+	assert.Equal(t, "eu-west-1", client.region)
+	assert.Equal(t, "https://dumps.s3.eu-west-1.amazonaws.com/app/orders%20v2.bson",
+		client.requestURL("dumps", "app/orders v2.bson", nil))
+
+	client, err = newS3Client(S3Config{Endpoint: "http://localhost:9000", Region: "local"})
+	require.NoError(t, err)
+	assert.Equal(t, "local", client.region)
+	assert.Equal(t, "http://localhost:9000/dumps?list-type=2", client.requestURL("dumps", "", map[string][]string{"list-type": {"2"}}))
+
+	_, err = newS3Client(S3Config{PartSize: 1 << 20})
+	assert.ErrorContains(t, err, "part size")
+}
+
+// TestMinIOStorage runs the object storage operations against a MinIO server
+func TestMinIOStorage(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	ctx := context.Background()
+	container, err := testcontainers.GenericContainer(ctx, testcontainers.GenericContainerRequest{
+		ContainerRequest: testcontainers.ContainerRequest{
+			Image:        "minio/minio:latest",
+			Cmd:          []string{"server", "/data"},
+			ExposedPorts: []string{"9000/tcp"},
+			Env:          map[string]string{"MINIO_ROOT_USER": "minioadmin", "MINIO_ROOT_PASSWORD": "minioadmin"},
+			WaitingFor:   wait.ForHTTP("/minio/health/live").WithPort("9000/tcp"),
+		},
+		Started: true,
+	})
+	require.NoError(t, err)
+	defer container.Terminate(ctx)
+
+	endpoint, err := container.PortEndpoint(ctx, "9000/tcp", "http")
+	require.NoError(t, err)
+	useS3(t, S3Config{Endpoint: endpoint, AccessKeyID: "minioadmin", SecretAccessKey: "minioadmin", PartSize: minPartSize})
+
+	client, err := currentS3Backend()
+	require.NoError(t, err)
+	resp, err := client.do(ctx, s3Request{method: http.MethodPut, bucket: "dumps"})
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	testRemoteStorage(t, "s3://dumps/nightly")
+}
+
+// testRemoteStorage exercises the storage operations below a location in object storage
+func testRemoteStorage(t *testing.T, root string) {
+	large := bytes.Repeat([]byte("0123456789abcdef"), (2*minPartSize+minPartSize/2)/16)
+	require.NoError(t, WriteBytes(Join(root, "app", "large.bson"), large))
+	require.NoError(t, WriteBytes(Join(root, "app", "orders v2.bson"), []byte("orders")))
+	require.NoError(t, WriteBytes(Join(root, "chain.json"), []byte("{}")))
+	require.NoError(t, MkdirAll(Join(root, "app")))
+
+	data, err := ReadFile(Join(root, "app", "large.bson"))
+	require.NoError(t, err)
+	assert.True(t, bytes.Equal(large, data))
+
+	entry, err := Stat(Join(root, "app", "orders v2.bson"))
+	require.NoError(t, err)
+	assert.Equal(t, Entry{Name: "orders v2.bson", Size: 6}, entry)
+	entry, err = Stat(Join(root, "app"))
+	require.NoError(t, err)
+	assert.True(t, entry.IsDir)
+
+	entries, err := ReadDir(root)
+	require.NoError(t, err)
+	assert.Equal(t, []Entry{{Name: "app", IsDir: true}, {Name: "chain.json", Size: 2}}, entries)
+
+	size, err := Size(root)
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(large)+6+2), size)
+
+	_, err = ReadFile(Join(root, "missing.json"))
+	assert.True(t, errors.Is(err, fs.ErrNotExist))
+	_, err = ReadDir(Join(root, "missing"))
+	assert.True(t, errors.Is(err, fs.ErrNotExist))
+	exists, err := Exists(Join(root, "missing"))
+	require.NoError(t, err)
+	assert.False(t, exists)
+
+	require.NoError(t, Create(Join(root, "dump-state.json.lock"), []byte("{}")))
+	err = Create(Join(root, "dump-state.json.lock"), []byte("{}"))
+	assert.True(t, errors.Is(err, fs.ErrExist))
+	require.NoError(t, Remove(Join(root, "dump-state.json.lock")))
+
+	require.NoError(t, RemoveAll(Join(root, "app")))
+	require.NoError(t, Remove(Join(root, "chain.json")))
+	exists, err = Exists(root)
+	require.NoError(t, err)
+	assert.False(t, exists)
+}
@@ -0,0 +1,210 @@
+// Package storage reads and writes the files of dumps on the local file system or in S3-compatible object storage.
+// Locations are local paths or s3://bucket/prefix URLs, and every function of the package accepts either.
+// Directories of object storage are key prefixes: they exist as long as they hold an object.
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// s3Scheme starts the locations of objects in S3-compatible object storage
+const s3Scheme = "s3://"
+
+// Entry is a file or directory of a location
+type Entry struct {
+	// Name is the base name in directory listings and the slash-separated relative path in Files
+	Name  string
+	IsDir bool
+	Size  int64
+}
+
+// backend implements the operations of the package for one kind of location
+type backend interface {
+	writeFile(location string, write func(w io.Writer) error) error
+	create(location string, data []byte) error
+	open(location string) (io.ReadCloser, error)
+	stat(location string) (Entry, error)
+	readDir(location string) ([]Entry, error)
+	files(location string) ([]Entry, error)
+	remove(location string) error
+	removeAll(location string) error
+	mkdirAll(location string) error
+}
+
+// IsRemote reports whether a location is in object storage
+func IsRemote(location string) bool {
+	return strings.HasPrefix(location, s3Scheme)
+}
+
+// Join joins path elements to a location
+func Join(location string, elem ...string) string {
+	if !IsRemote(location) {
+		return filepath.Join(append([]string{location}, elem...)...)
+	}
+	bucket, key := splitS3Location(location)
+	return s3Location(bucket, path.Join(append([]string{key}, elem...)...))
+}
+
+// Base returns the last element of a location
+func Base(location string) string {
+	if IsRemote(location) {
+		return path.Base(location)
+	}
+	return filepath.Base(location)
+}
+
+// Rel returns the slash-separated path of a location relative to root
+func Rel(root, location string) (string, error) {
+	if !IsRemote(root) {
+		rel, err := filepath.Rel(root, location)
+		return filepath.ToSlash(rel), err
+	}
+
+	prefix := strings.TrimSuffix(root, "/") + "/"
+	if !strings.HasPrefix(location, prefix) {
+		return "", fmt.Errorf("%s is not inside %s", location, root)
+	}
+	return strings.TrimPrefix(location, prefix), nil
+}
+
+// WriteFile writes a file through write. The file only replaces an existing one once write succeeds,
+// so an interrupted write never leaves a truncated file behind.
+func WriteFile(location string, write func(w io.Writer) error) error {
+	b, err := backendFor(location)
+	if err != nil {
+		return err
+	}
+	return b.writeFile(location, write)
+}
+
+// WriteBytes writes data to a file, replacing it once complete
+func WriteBytes(location string, data []byte) error {
+	return WriteFile(location, func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+}
+
+// Create writes a file that must not exist yet. An existing file yields an error wrapping fs.ErrExist,
+// so only one of several processes creating the same file succeeds.
+func Create(location string, data []byte) error {
+	b, err := backendFor(location)
+	if err != nil {
+		return err
+	}
+	return b.create(location, data)
+}
+
+// Open opens a file for streaming reads. A missing file yields an error wrapping fs.ErrNotExist.
+func Open(location string) (io.ReadCloser, error) {
+	b, err := backendFor(location)
+	if err != nil {
+		return nil, err
+	}
+	return b.open(location)
+}
+
+// ReadFile reads a whole file. A missing file yields an error wrapping fs.ErrNotExist.
+func ReadFile(location string) ([]byte, error) {
+	file, err := Open(location)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return io.ReadAll(file)
+}
+
+// Stat describes a file or directory. A missing location yields an error wrapping fs.ErrNotExist.
+func Stat(location string) (Entry, error) {
+	b, err := backendFor(location)
+	if err != nil {
+		return Entry{}, err
+	}
+	return b.stat(location)
+}
+
+// Exists reports whether a file or directory exists
+func Exists(location string) (bool, error) {
+	_, err := Stat(location)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// ReadDir lists the files and directories of a directory sorted by name.
+// A missing directory yields an error wrapping fs.ErrNotExist.
+func ReadDir(location string) ([]Entry, error) {
+	b, err := backendFor(location)
+	if err != nil {
+		return nil, err
+	}
+	return b.readDir(location)
+}
+
+// Size returns the total size of the files below a directory; a missing directory has no size
+func Size(location string) (int64, error) {
+	b, err := backendFor(location)
+	if err != nil {
+		return 0, err
+	}
+	files, err := b.files(location)
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, nil
+	}
+
+	var size int64
+	for _, file := range files {
+		size += file.Size
+	}
+	return size, err
+}
+
+// Remove removes a file. Removing a missing file is not an error.
+func Remove(location string) error {
+	b, err := backendFor(location)
+	if err != nil {
+		return err
+	}
+	if err := b.remove(location); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// RemoveAll removes a directory and everything below it
+func RemoveAll(location string) error {
+	b, err := backendFor(location)
+	if err != nil {
+		return err
+	}
+	return b.removeAll(location)
+}
+
+// MkdirAll creates a directory and its parents; object storage needs no directories
+func MkdirAll(location string) error {
+	b, err := backendFor(location)
+	if err != nil {
+		return err
+	}
+	return b.mkdirAll(location)
+}
+
+// backendFor returns the backend handling a location
+func backendFor(location string) (backend, error) {
+	if !IsRemote(location) {
+		return localBackend{}, nil
+	}
+	return currentS3Backend()
+}
+
+// notExist returns the error for a missing location
+func notExist(op, location string) error {
+	return &fs.PathError{Op: op, Path: location, Err: fs.ErrNotExist}
+}
@@ -0,0 +1,99 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLocations(t *testing.T) {
+	assert.True(t, IsRemote("s3://dumps/nightly"))
+	assert.False(t, IsRemote("/var/dumps"))
+
+	assert.Equal(t, "s3://dumps/nightly/app/orders.bson", Join("s3://dumps/nightly", "app", "orders.bson"))
+	assert.Equal(t, "s3://dumps/app", Join("s3://dumps/", "app"))
+	assert.Equal(t, "s3://dumps", Join("s3://dumps"))
+	assert.Equal(t, filepath.Join("dumps", "app"), Join("dumps", "app"))
+
+	assert.Equal(t, "orders.bson", Base("s3://dumps/app/orders.bson"))
+	assert.Equal(t, "orders.bson", Base(filepath.Join("dumps", "orders.bson")))
+
+	rel, err := Rel("s3://dumps/nightly", "s3://dumps/nightly/app/orders.bson")
+	require.NoError(t, err)
+	assert.Equal(t, "app/orders.bson", rel)
+	_, err = Rel("s3://dumps/nightly", "s3://dumps/other/orders.bson")
+	assert.Error(t, err)
+
+	bucket, key := splitS3Location("s3://dumps/nightly/app/")
+	assert.Equal(t, "dumps", bucket)
+	assert.Equal(t, "nightly/app", key)
+}
+
+func TestWriteFileAtomically(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "coll.bson")
+
+	t.Run("Success", func(t *testing.T) {
+		err := WriteFile(path, func(w io.Writer) error {
+			_, err := w.Write([]byte("data"))
+			return err
+		})
+		require.NoError(t, err)
+
+		content, err := os.ReadFile(path)
+		require.NoError(t, err)
+		assert.Equal(t, "data", string(content))
+		assert.NoFileExists(t, path+".tmp")
+	})
+
+	t.Run("FailureKeepsPreviousFile", func(t *testing.T) {
+		err := WriteFile(path, func(w io.Writer) error {
+			w.Write([]byte("partial"))
+			return errors.New("cursor failed")
+		})
+		assert.Error(t, err)
+
+		content, err := os.ReadFile(path)
+		require.NoError(t, err)
+		assert.Equal(t, "data", string(content))
+		assert.NoFileExists(t, path+".tmp")
+	})
+}
+
+func TestLocalStorage(t *testing.T) {
+	root := t.TempDir()
+	dir := Join(root, "app")
+	require.NoError(t, MkdirAll(dir))
+	require.NoError(t, WriteBytes(Join(dir, "orders.bson"), []byte("orders")))
+	require.NoError(t, MkdirAll(Join(dir, "indexes")))
+
+	data, err := ReadFile(Join(dir, "orders.bson"))
+	require.NoError(t, err)
+	assert.Equal(t, []byte("orders"), data)
+	assert.NoFileExists(t, Join(dir, "orders.bson.tmp"))
+
+	entries, err := ReadDir(dir)
+	require.NoError(t, err)
+	assert.Equal(t, []Entry{{Name: "indexes", IsDir: true}, {Name: "orders.bson", Size: 6}}, entries)
+
+	size, err := Size(root)
+	require.NoError(t, err)
+	assert.Equal(t, int64(6), size)
+
+	_, err = ReadFile(Join(dir, "missing.bson"))
+	assert.True(t, errors.Is(err, fs.ErrNotExist))
+	exists, err := Exists(Join(dir, "missing.bson"))
+	require.NoError(t, err)
+	assert.False(t, exists)
+	assert.NoError(t, Remove(Join(dir, "missing.bson")))
+
+	require.NoError(t, RemoveAll(dir))
+	_, err = os.Stat(dir)
+	assert.True(t, os.IsNotExist(err))
+}
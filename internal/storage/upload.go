@@ -0,0 +1,158 @@
+package storage
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// uploadWriter streams an object to S3. Data is buffered into parts that are uploaded as they fill,
+// so memory use is bounded by the part size; objects smaller than a part are stored with a single request.
+type uploadWriter struct {
+	ctx      context.Context
+	client   *s3Client
+	bucket   string
+	key      string
+	buffer   []byte
+	uploadID string
+	parts    []completedPart
+}
+
+// completedPart is an uploaded part of a multipart upload
+type completedPart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+// completeMultipartUpload is the body of a CompleteMultipartUpload request
+type completeMultipartUpload struct {
+	XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+	Parts   []completedPart `xml:"Part"`
+}
+
+// Write buffers data and uploads every part that fills up
+func (w *uploadWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		if w.buffer == nil {
+			w.buffer = make([]byte, 0, w.client.partSize)
+		}
+		n := min(len(p), w.client.partSize-len(w.buffer))
+		w.buffer = append(w.buffer, p[:n]...)
+		p = p[n:]
+		written += n
+
+		if len(w.buffer) == w.client.partSize {
+			if err := w.uploadPart(); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+// finish stores the remaining data: a small object with a single request, or the last part of a multipart upload
+func (w *uploadWriter) finish() error {
+	if w.uploadID == "" {
+		resp, err := w.client.do(w.ctx, s3Request{method: http.MethodPut, bucket: w.bucket, key: w.key, body: w.buffer})
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		return nil
+	}
+
+	if len(w.buffer) > 0 {
+		if err := w.uploadPart(); err != nil {
+			return err
+		}
+	}
+	return w.complete()
+}
+
+// complete assembles the uploaded parts into the object
+func (w *uploadWriter) complete() error {
+	body, err := xml.Marshal(completeMultipartUpload{Parts: w.parts})
+	if err != nil {
+		return err
+	}
+	resp, err := w.client.do(w.ctx, s3Request{
+		method: http.MethodPost, bucket: w.bucket, key: w.key, query: url.Values{"uploadId": {w.uploadID}}, body: body,
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// Completing an upload can fail after the service already answered 200, with the error in the body
+	s3Err := &s3Error{}
+	if err := xml.NewDecoder(resp.Body).Decode(s3Err); err == nil && s3Err.Code != "" {
+		s3Err.StatusCode = resp.StatusCode
+		return s3Err
+	}
+	w.uploadID = ""
+	return nil
+}
+
+// abort discards the parts of an unfinished upload
+func (w *uploadWriter) abort() {
+	if w.uploadID == "" {
+		return
+	}
+	resp, err := w.client.do(w.ctx, s3Request{
+		method: http.MethodDelete, bucket: w.bucket, key: w.key, query: url.Values{"uploadId": {w.uploadID}},
+	})
+	if err == nil {
+		resp.Body.Close()
+	}
+	w.uploadID = ""
+}
+
+// uploadPart uploads the buffered data as the next part, starting the multipart upload with the first part
+func (w *uploadWriter) uploadPart() error {
+	if w.uploadID == "" {
+		uploadID, err := w.createUpload()
+		if err != nil {
+			return err
+		}
+		w.uploadID = uploadID
+	}
+
+	number := len(w.parts) + 1
+	resp, err := w.client.do(w.ctx, s3Request{
+		method: http.MethodPut, bucket: w.bucket, key: w.key, body: w.buffer,
+		query: url.Values{"partNumber": {strconv.Itoa(number)}, "uploadId": {w.uploadID}},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to upload part %d: %w", number, err)
+	}
+	resp.Body.Close()
+
+	w.parts = append(w.parts, completedPart{PartNumber: number, ETag: resp.Header.Get("ETag")})
+	w.buffer = w.buffer[:0]
+	return nil
+}
+
+// createUpload starts a multipart upload and returns its ID
+func (w *uploadWriter) createUpload() (string, error) {
+	resp, err := w.client.do(w.ctx, s3Request{method: http.MethodPost, bucket: w.bucket, key: w.key, query: url.Values{"uploads": {""}}})
+	if err != nil {
+		return "", fmt.Errorf("failed to start multipart upload: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		UploadID string `xml:"UploadId"`
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("failed to decode multipart upload: %w", err)
+	}
+	if result.UploadID == "" {
+		return "", errors.New("multipart upload has no ID")
+	}
+	return result.UploadID, nil
+}